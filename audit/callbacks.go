@@ -0,0 +1,316 @@
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+	"inventory-control-hub/database"
+	"inventory-control-hub/models"
+	"reflect"
+	"strings"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+// skippedTables change all the time without anybody editing data, or are the audit log itself
+var skippedTables = map[string]bool{
+	"audit_logs":         true,
+	"refresh_tokens":     true,
+	"document_sequences": true,
+	"idempotency_keys":   true,
+}
+
+// skippedColumns are touched on every use, a change to only them is not worth an entry
+var skippedColumns = map[string]map[string]bool{
+	"api_keys": {"last_used_at": true},
+}
+
+const rowsBeforeKey = "audit:rows_before"
+
+// Register adds callbacks to db that write an AuditLog entry for every row created, updated or
+// deleted through GORM, inside the same transaction as the change. Raw SQL and many2many join
+// tables are not audited, Record writes entries for those.
+func Register(db *gorm.DB) error {
+	if err := db.Callback().Create().After("gorm:create").Before("gorm:commit_or_rollback_transaction").
+		Register("audit:after_create", afterCreate); err != nil {
+		return err
+	}
+	if err := db.Callback().Update().Before("gorm:update").
+		Register("audit:before_update", loadRowsBefore); err != nil {
+		return err
+	}
+	if err := db.Callback().Update().After("gorm:update").Before("gorm:commit_or_rollback_transaction").
+		Register("audit:after_update", afterUpdate); err != nil {
+		return err
+	}
+	if err := db.Callback().Delete().Before("gorm:delete").
+		Register("audit:before_delete", loadRowsBefore); err != nil {
+		return err
+	}
+	return db.Callback().Delete().After("gorm:delete").Before("gorm:commit_or_rollback_transaction").
+		Register("audit:after_delete", afterDelete)
+}
+
+func audited(db *gorm.DB) bool {
+	return db.Error == nil && db.Statement.Schema != nil && db.Statement.Schema.PrioritizedPrimaryField != nil &&
+		!skippedTables[db.Statement.Table]
+}
+
+func afterCreate(db *gorm.DB) {
+	if !audited(db) || db.Statement.RowsAffected == 0 {
+		return
+	}
+	var entries []models.AuditLog
+	for _, row := range rowsOf(db.Statement.ReflectValue) {
+		changes := map[string]map[string]interface{}{}
+		for _, field := range auditedFields(db) {
+			changes[field.Name] = map[string]interface{}{"New": fieldValue(db, field, row)}
+		}
+		entries = append(entries, entry(db, row, "create", changes))
+	}
+	writeEntries(db, entries)
+}
+
+// loadRowsBefore keeps the rows an update or delete is about to change, to diff against afterwards
+func loadRowsBefore(db *gorm.DB) {
+	if !audited(db) {
+		return
+	}
+	stmt := db.Statement
+	query := newSession(db)
+	conditions := false
+	if where, ok := stmt.Clauses["WHERE"].Expression.(clause.Where); ok && len(where.Exprs) > 0 {
+		query = query.Clauses(where)
+		conditions = true
+	}
+	// Model(&product).Updates(...) and Delete(&product) only name the row through its primary key
+	var ids []interface{}
+	for _, row := range rowsOf(stmt.ReflectValue) {
+		if id, isZero := stmt.Schema.PrioritizedPrimaryField.ValueOf(stmt.Context, row); !isZero {
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) > 0 {
+		query = query.Where(clause.IN{Column: clause.Column{Table: stmt.Table, Name: stmt.Schema.PrioritizedPrimaryField.DBName}, Values: ids})
+		conditions = true
+	}
+	if !conditions {
+		return
+	}
+	if stmt.Unscoped {
+		query = query.Unscoped()
+	}
+
+	rows := reflect.New(reflect.SliceOf(stmt.Schema.ModelType))
+	if err := query.Table(stmt.Table).Find(rows.Interface()).Error; err != nil {
+		db.AddError(fmt.Errorf("audit: %w", err))
+		return
+	}
+	db.InstanceSet(rowsBeforeKey, rows.Elem())
+}
+
+func rowsBefore(db *gorm.DB) (reflect.Value, bool) {
+	value, ok := db.InstanceGet(rowsBeforeKey)
+	if !ok {
+		return reflect.Value{}, false
+	}
+	rows := value.(reflect.Value)
+	return rows, rows.Len() > 0
+}
+
+func afterUpdate(db *gorm.DB) {
+	rows, ok := rowsBefore(db)
+	if !audited(db) || !ok || db.Statement.RowsAffected == 0 {
+		return
+	}
+	stmt := db.Statement
+	primaryKey := stmt.Schema.PrioritizedPrimaryField
+
+	ids := make([]interface{}, rows.Len())
+	for i := range ids {
+		ids[i], _ = primaryKey.ValueOf(stmt.Context, rows.Index(i))
+	}
+	after := reflect.New(reflect.SliceOf(stmt.Schema.ModelType))
+	if err := newSession(db).Unscoped().Table(stmt.Table).Where(clause.IN{Column: clause.Column{Name: primaryKey.DBName}, Values: ids}).
+		Find(after.Interface()).Error; err != nil {
+		db.AddError(fmt.Errorf("audit: %w", err))
+		return
+	}
+	afterByID := map[interface{}]reflect.Value{}
+	for _, row := range rowsOf(after.Elem()) {
+		id, _ := primaryKey.ValueOf(stmt.Context, row)
+		afterByID[id] = row
+	}
+
+	var entries []models.AuditLog
+	for _, before := range rowsOf(rows) {
+		id, _ := primaryKey.ValueOf(stmt.Context, before)
+		row, ok := afterByID[id]
+		if !ok {
+			continue
+		}
+		changes := map[string]map[string]interface{}{}
+		for _, field := range auditedFields(db) {
+			oldValue, _ := field.ValueOf(stmt.Context, before)
+			newValue, _ := field.ValueOf(stmt.Context, row)
+			if !reflect.DeepEqual(oldValue, newValue) {
+				changes[field.Name] = map[string]interface{}{"Old": fieldValue(db, field, before), "New": fieldValue(db, field, row)}
+			}
+		}
+		if len(changes) > 0 {
+			entries = append(entries, entry(db, row, "update", changes))
+		}
+	}
+	writeEntries(db, entries)
+}
+
+func afterDelete(db *gorm.DB) {
+	rows, ok := rowsBefore(db)
+	if !audited(db) || !ok || db.Statement.RowsAffected == 0 {
+		return
+	}
+	var entries []models.AuditLog
+	for _, row := range rowsOf(rows) {
+		changes := map[string]map[string]interface{}{}
+		for _, field := range auditedFields(db) {
+			changes[field.Name] = map[string]interface{}{"Old": fieldValue(db, field, row)}
+		}
+		entries = append(entries, entry(db, row, "delete", changes))
+	}
+	writeEntries(db, entries)
+}
+
+// Record writes an entry for a change the callbacks can't see, like adding or removing rows of
+// a many2many join table, inside db's transaction. changes is a diff like the callbacks write,
+// e.g. {"Roles":{"Old":["sales"],"New":[]}}.
+func Record(db *gorm.DB, entity string, entityID uint, action string, changes map[string]map[string]interface{}) error {
+	info := FromContext(db.Statement.Context)
+	if info.UserID == nil {
+		info.Actor = "system"
+	}
+	tenantID, ok := database.TenantID(db.Statement.Context)
+	if !ok {
+		return database.ErrNoTenant
+	}
+	diff, err := json.Marshal(changes)
+	if err != nil {
+		return err
+	}
+	return newSession(db).Create(&models.AuditLog{
+		TenantID:  &tenantID,
+		UserID:    info.UserID,
+		Actor:     info.Actor,
+		Entity:    entity,
+		EntityID:  fmt.Sprint(entityID),
+		Action:    action,
+		Changes:   diff,
+		RequestID: info.RequestID,
+		ClientIP:  info.ClientIP,
+	}).Error
+}
+
+// newSession is a clean query on the connection of db, so it runs inside db's transaction
+func newSession(db *gorm.DB) *gorm.DB {
+	return db.Session(&gorm.Session{NewDB: true, SkipHooks: true})
+}
+
+// rowsOf lists the structs in value, a struct or a slice or array of structs or pointers to them
+func rowsOf(value reflect.Value) []reflect.Value {
+	value = reflect.Indirect(value)
+	switch value.Kind() {
+	case reflect.Struct:
+		return []reflect.Value{value}
+	case reflect.Slice, reflect.Array:
+		rows := make([]reflect.Value, 0, value.Len())
+		for i := 0; i < value.Len(); i++ {
+			if row := reflect.Indirect(value.Index(i)); row.Kind() == reflect.Struct {
+				rows = append(rows, row)
+			}
+		}
+		return rows
+	}
+	return nil
+}
+
+// auditedFields are the columns of the statement's model, associations are audited as rows of
+// their own. The tenant is implied by who can read the log.
+func auditedFields(db *gorm.DB) []*schema.Field {
+	skipped := skippedColumns[db.Statement.Table]
+	var fields []*schema.Field
+	for _, field := range db.Statement.Schema.Fields {
+		if field.DBName != "" && field.Name != "TenantID" && !skipped[field.DBName] {
+			fields = append(fields, field)
+		}
+	}
+	return fields
+}
+
+// fieldValue is the value of a field for the log, secrets hidden from the API (passwords, key
+// hashes) stay hidden here and are never written to the log
+func fieldValue(db *gorm.DB, field *schema.Field, row reflect.Value) interface{} {
+	value, isZero := field.ValueOf(db.Statement.Context, row)
+	if field.Tag.Get("json") == "-" {
+		if isZero {
+			return nil
+		}
+		return "[hidden]"
+	}
+	return value
+}
+
+func entry(db *gorm.DB, row reflect.Value, action string, changes map[string]map[string]interface{}) models.AuditLog {
+	info := FromContext(db.Statement.Context)
+	if info.UserID == nil {
+		info.Actor = "system"
+	}
+	var ids []string
+	for _, field := range db.Statement.Schema.PrimaryFields {
+		id, _ := field.ValueOf(db.Statement.Context, row)
+		ids = append(ids, fmt.Sprint(reflect.Indirect(reflect.ValueOf(id))))
+	}
+	diff, _ := json.Marshal(changes)
+	return models.AuditLog{
+		TenantID:  rowTenant(db, row),
+		UserID:    info.UserID,
+		Actor:     info.Actor,
+		Entity:    db.Statement.Table,
+		EntityID:  strings.Join(ids, ","),
+		Action:    action,
+		Changes:   diff,
+		RequestID: info.RequestID,
+		ClientIP:  info.ClientIP,
+	}
+}
+
+// rowTenant is the tenant a changed row belongs to, the request's tenant for rows that belong to none
+func rowTenant(db *gorm.DB, row reflect.Value) *uint {
+	if field := db.Statement.Schema.LookUpField("TenantID"); field != nil {
+		value, isZero := field.ValueOf(db.Statement.Context, row)
+		if !isZero {
+			tenantID := uint(reflect.Indirect(reflect.ValueOf(value)).Uint())
+			return &tenantID
+		}
+	}
+	if tenantID, ok := database.TenantID(db.Statement.Context); ok {
+		return &tenantID
+	}
+	return nil
+}
+
+// writeEntries saves the entries, leaving out changes to rows of no tenant made outside a
+// request, like creating the default tenant
+func writeEntries(db *gorm.DB, entries []models.AuditLog) {
+	var tenantEntries []models.AuditLog
+	for _, entry := range entries {
+		if entry.TenantID != nil {
+			tenantEntries = append(tenantEntries, entry)
+		}
+	}
+	if len(tenantEntries) == 0 {
+		return
+	}
+	if err := newSession(db).Create(&tenantEntries).Error; err != nil {
+		db.AddError(fmt.Errorf("audit: %w", err))
+	}
+}
@@ -0,0 +1,120 @@
+package audit
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// Info is who made a request and from where, written to every audit log entry of it
+type Info struct {
+	UserID    *uint
+	Actor     string // email of the signed-in user
+	RequestID string
+	ClientIP  string
+}
+
+type contextKey int
+
+const infoKey contextKey = iota
+
+// FromContext is the audit info of the request ctx belongs to, empty for changes made outside
+// of a request, e.g. seeding on start
+func FromContext(ctx context.Context) Info {
+	info, _ := ctx.Value(infoKey).(Info)
+	return info
+}
+
+// WithActor records the signed-in user on the audit info of ctx
+func WithActor(ctx context.Context, userID uint, email string) context.Context {
+	info := FromContext(ctx)
+	info.UserID = &userID
+	info.Actor = email
+	return context.WithValue(ctx, infoKey, info)
+}
+
+// Middleware gives every request an ID, taken from X-Request-ID or generated and echoed back,
+// and puts it with the client IP on the request context
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestID := r.Header.Get("X-Request-ID")
+		if requestID == "" || len(requestID) > 64 {
+			requestID = newRequestID()
+		}
+		w.Header().Set("X-Request-ID", requestID)
+
+		info := Info{RequestID: requestID, ClientIP: clientIP(r)}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), infoKey, info)))
+	})
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// clientIP is the peer address of the request. Behind a trusted proxy, TRUSTED_PROXIES in .env
+// (addresses or CIDR ranges, separated by commas), it is the last address in X-Forwarded-For
+// not added by a trusted proxy. Anybody else could send any X-Forwarded-For they like.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	forwarded := r.Header.Values("X-Forwarded-For")
+	proxies := trustedProxies()
+	if len(forwarded) == 0 || !isTrusted(host, proxies) {
+		return host
+	}
+	addresses := strings.Split(strings.Join(forwarded, ","), ",")
+	for i := len(addresses) - 1; i >= 0; i-- {
+		host = strings.TrimSpace(addresses[i])
+		if !isTrusted(host, proxies) {
+			break
+		}
+	}
+	return host
+}
+
+// trustedProxies are the networks of TRUSTED_PROXIES, single addresses become /32 or /128
+func trustedProxies() []*net.IPNet {
+	var proxies []*net.IPNet
+	for _, entry := range strings.Split(os.Getenv("TRUSTED_PROXIES"), ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			if ip := net.ParseIP(entry); ip != nil {
+				bits := 8 * len(ip.To16())
+				if ip.To4() != nil {
+					ip, bits = ip.To4(), 32
+				}
+				proxies = append(proxies, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			}
+			continue
+		}
+		if _, network, err := net.ParseCIDR(entry); err == nil {
+			proxies = append(proxies, network)
+		}
+	}
+	return proxies
+}
+
+func isTrusted(address string, proxies []*net.IPNet) bool {
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return false
+	}
+	for _, network := range proxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
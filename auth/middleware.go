@@ -0,0 +1,99 @@
+package auth
+
+import (
+	"context"
+	"inventory-control-hub/audit"
+	"inventory-control-hub/database"
+	"inventory-control-hub/models"
+	"inventory-control-hub/utils"
+	"net/http"
+	"strings"
+	"time"
+)
+
+type contextKey int
+
+const userKey contextKey = iota
+
+// publicPaths can be reached without signing in
+var publicPaths = map[string]bool{
+	"/":             true,
+	"/auth/login":   true,
+	"/auth/refresh": true,
+}
+
+// requestTenant is the tenant named by the X-Tenant header, the default tenant without one
+func requestTenant(r *http.Request) (models.Tenant, error) {
+	slug := r.Header.Get("X-Tenant")
+	if slug == "" {
+		slug = database.DefaultTenantSlug
+	}
+	var tenant models.Tenant
+	err := database.AllTenants().Where("slug = ?", slug).First(&tenant).Error
+	return tenant, err
+}
+
+// Middleware rejects requests without a valid access token (Authorization: Bearer ...) or
+// API key (X-API-Key: ...) with 401 and puts the signed-in user and their tenant on the request
+// context. Signing in happens in the tenant named by X-Tenant, afterwards the credentials
+// decide the tenant and X-Tenant, when sent, has to match it.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tenant, err := requestTenant(r)
+		if err != nil {
+			utils.RespondWithError(w, http.StatusBadRequest, "Unknown tenant")
+			return
+		}
+		if publicPaths[r.URL.Path] {
+			next.ServeHTTP(w, r.WithContext(database.WithTenant(r.Context(), tenant.ID)))
+			return
+		}
+
+		var user models.User
+		var tenantID uint
+		if key := r.Header.Get("X-API-Key"); key != "" {
+			// the key is looked up before its tenant is known
+			var apiKey models.APIKey
+			if database.AllTenants().Where("key_hash = ? AND revoked_at IS NULL", HashToken(key)).First(&apiKey).Error != nil {
+				utils.RespondWithError(w, http.StatusUnauthorized, "Invalid API key")
+				return
+			}
+			tenantID = *apiKey.TenantID
+			db := database.DB.WithContext(database.WithTenant(r.Context(), tenantID))
+			if db.Preload("Roles.Permissions").First(&user, apiKey.UserID).Error != nil || !user.Active {
+				utils.RespondWithError(w, http.StatusUnauthorized, "Invalid API key")
+				return
+			}
+			db.Model(&apiKey).UpdateColumn("last_used_at", time.Now())
+		} else {
+			token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !ok || token == "" {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				utils.RespondWithError(w, http.StatusUnauthorized, "Authentication required")
+				return
+			}
+			var userID uint
+			userID, tenantID, err = ParseAccessToken(token)
+			if err != nil || database.DB.WithContext(database.WithTenant(r.Context(), tenantID)).
+				Preload("Roles.Permissions").First(&user, userID).Error != nil || !user.Active {
+				w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+				utils.RespondWithError(w, http.StatusUnauthorized, "Invalid or expired token")
+				return
+			}
+		}
+		if r.Header.Get("X-Tenant") != "" && tenantID != tenant.ID {
+			utils.RespondWithError(w, http.StatusForbidden, "Your credentials belong to another tenant")
+			return
+		}
+
+		ctx := database.WithTenant(r.Context(), tenantID)
+		ctx = audit.WithActor(ctx, user.ID, user.Email)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(ctx, userKey, &user)))
+	})
+}
+
+// CurrentUser is the user the request was authenticated as, nil on public paths
+func CurrentUser(r *http.Request) *models.User {
+	user, _ := r.Context().Value(userKey).(*models.User)
+	return user
+}
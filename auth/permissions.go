@@ -0,0 +1,117 @@
+package auth
+
+import (
+	"inventory-control-hub/models"
+	"inventory-control-hub/utils"
+	"net/http"
+)
+
+// Permissions checked on the routes, see routes.SetupRouter
+const (
+	AllPermissions = "*"
+
+	ProductsRead    = "products:read"
+	ProductsWrite   = "products:write"
+	ProductsDelete  = "products:delete"
+	CustomersRead   = "customers:read"
+	CustomersWrite  = "customers:write"
+	PricingRead     = "pricing:read"
+	PricingWrite    = "pricing:write"
+	PurchasingRead  = "purchasing:read"
+	PurchasingWrite = "purchasing:write"
+	ReceivingWrite  = "receiving:write"
+	SalesRead       = "sales:read"
+	SalesWrite      = "sales:write"
+	WarehouseRead   = "warehouse:read"
+	WarehouseWrite  = "warehouse:write"
+	ReportsRead     = "reports:read"
+	UsersManage     = "users:manage"
+	AuditRead       = "audit:read"
+	TenantsManage   = "tenants:manage"
+)
+
+// Permission describes one permission for the management endpoints
+type Permission struct {
+	Name        string
+	Description string
+}
+
+// Permissions is every permission a role can be given
+var Permissions = []Permission{
+	{AllPermissions, "Everything, including permissions added later"},
+	{ProductsRead, "View products, stock, lots, serial numbers and kits"},
+	{ProductsWrite, "Create and change products and kits, archive products"},
+	{ProductsDelete, "Delete and restore products"},
+	{CustomersRead, "View customers"},
+	{CustomersWrite, "Create, change and delete customers"},
+	{PricingRead, "View price lists, promotions and tax"},
+	{PricingWrite, "Manage price lists, promotions and tax"},
+	{PurchasingRead, "View purchase orders, supplier bills, returns and credits"},
+	{PurchasingWrite, "Place and change purchase orders, book supplier bills, returns and credits"},
+	{ReceivingWrite, "Receive purchase orders into stock"},
+	{SalesRead, "View sales orders, invoices, payments, returns and notifications"},
+	{SalesWrite, "Place and change sales orders, invoice, take payments and returns"},
+	{WarehouseRead, "View bins, pick tasks, shipments and assembly orders"},
+	{WarehouseWrite, "Move stock between bins, pick, pack, ship, assemble kits and release quarantine"},
+	{ReportsRead, "View reports"},
+	{UsersManage, "Manage users and roles"},
+	{AuditRead, "View the audit log of who changed what"},
+	{TenantsManage, "Create and rename tenants, only in the default tenant"},
+}
+
+// DefaultRoles are created on the first start, admin holds every permission
+var DefaultRoles = []models.Role{
+	role("admin", "Full access", AllPermissions),
+	role("purchaser", "Buys stock from suppliers", ProductsRead, PricingRead, PurchasingRead, PurchasingWrite, ReceivingWrite, WarehouseRead, ReportsRead),
+	role("sales", "Sells to customers", ProductsRead, CustomersRead, CustomersWrite, PricingRead, SalesRead, SalesWrite, WarehouseRead, ReportsRead),
+	role("warehouse", "Receives, stores, picks and ships goods", ProductsRead, PurchasingRead, ReceivingWrite, SalesRead, WarehouseRead, WarehouseWrite),
+	role("viewer", "Read-only access", ProductsRead, CustomersRead, PricingRead, PurchasingRead, SalesRead, WarehouseRead, ReportsRead),
+}
+
+func role(name string, description string, permissions ...string) models.Role {
+	role := models.Role{Name: name, Description: description}
+	for _, permission := range permissions {
+		role.Permissions = append(role.Permissions, models.RolePermission{Permission: permission})
+	}
+	return role
+}
+
+// IsPermission reports whether name is in Permissions
+func IsPermission(name string) bool {
+	for _, permission := range Permissions {
+		if permission.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// HasPermission reports whether any of the user's roles grants the permission
+func HasPermission(user *models.User, permission string) bool {
+	if user == nil {
+		return false
+	}
+	for _, role := range user.Roles {
+		for _, granted := range role.Permissions {
+			if granted.Permission == permission || granted.Permission == AllPermissions {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Require only lets users holding permission through to the handler, others get 403 naming the
+// missing permission. It runs after Middleware has authenticated the user.
+func Require(permission string, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !HasPermission(CurrentUser(r), permission) {
+			utils.RespondWithJSON(w, http.StatusForbidden, map[string]string{
+				"error":      "Missing permission " + permission,
+				"permission": permission,
+			})
+			return
+		}
+		handler(w, r)
+	}
+}
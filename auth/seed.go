@@ -0,0 +1,95 @@
+package auth
+
+import (
+	"context"
+	"inventory-control-hub/database"
+	"inventory-control-hub/models"
+	"log"
+	"os"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// CreateDefaultRoles creates DefaultRoles in the tenant of db. Users created before roles
+// existed could do everything, they become admins so nobody loses access.
+func CreateDefaultRoles(db *gorm.DB) error {
+	for _, defaultRole := range DefaultRoles {
+		role := models.Role{Name: defaultRole.Name, Description: defaultRole.Description}
+		for _, permission := range defaultRole.Permissions {
+			role.Permissions = append(role.Permissions, models.RolePermission{Permission: permission.Permission})
+		}
+		if err := db.Create(&role).Error; err != nil {
+			return err
+		}
+	}
+
+	var admin models.Role
+	if err := db.Where("name = ?", "admin").First(&admin).Error; err != nil {
+		return err
+	}
+	var users []models.User
+	if err := db.Find(&users).Error; err != nil {
+		return err
+	}
+	for i := range users {
+		if err := db.Model(&users[i]).Association("Roles").Append(&admin); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CreateAdmin creates a user with the admin role in the tenant of db
+func CreateAdmin(db *gorm.DB, email string, password string) (models.User, error) {
+	hash, err := HashPassword(password)
+	if err != nil {
+		return models.User{}, err
+	}
+	var admin models.Role
+	if err := db.Where("name = ?", "admin").First(&admin).Error; err != nil {
+		return models.User{}, err
+	}
+	user := models.User{Email: strings.ToLower(strings.TrimSpace(email)), Name: "Administrator", PasswordHash: hash, Active: true, Roles: []models.Role{admin}}
+	return user, db.Create(&user).Error
+}
+
+// SeedRoles creates DefaultRoles in every tenant without roles, on start
+func SeedRoles() {
+	var tenants []models.Tenant
+	database.AllTenants().Find(&tenants)
+	for _, tenant := range tenants {
+		db := database.DB.WithContext(database.WithTenant(context.Background(), tenant.ID))
+		var count int64
+		db.Model(&models.Role{}).Count(&count)
+		if count > 0 {
+			continue
+		}
+		if db.Transaction(CreateDefaultRoles) != nil {
+			log.Fatal("Failed to create the roles of tenant " + tenant.Slug)
+		}
+	}
+}
+
+// SeedAdmin creates the first user of the default tenant, an admin, from ADMIN_EMAIL and
+// ADMIN_PASSWORD in .env while it has no users yet, so a fresh install can sign in at all
+func SeedAdmin() {
+	email, password := strings.ToLower(strings.TrimSpace(os.Getenv("ADMIN_EMAIL"))), os.Getenv("ADMIN_PASSWORD")
+	if email == "" || password == "" {
+		return
+	}
+	var tenant models.Tenant
+	if database.AllTenants().Where("slug = ?", database.DefaultTenantSlug).First(&tenant).Error != nil {
+		return
+	}
+	db := database.DB.WithContext(database.WithTenant(context.Background(), tenant.ID))
+	var count int64
+	db.Model(&models.User{}).Count(&count)
+	if count > 0 {
+		return
+	}
+	if _, err := CreateAdmin(db, email, password); err != nil {
+		log.Fatal("Failed to create the admin user")
+	}
+	log.Println("Created admin user " + email)
+}
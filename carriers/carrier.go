@@ -0,0 +1,66 @@
+// Package carriers connects shipments to shipping carriers. Each carrier integration implements
+// Carrier and is registered at startup, shipments then ask it for rates and labels by name.
+package carriers
+
+import (
+	"errors"
+	"sort"
+)
+
+var ErrUnknownServiceLevel = errors.New("unknown service level")
+
+// Parcel is what a carrier needs to know about one box, weight in kg and dimensions in cm
+type Parcel struct {
+	Weight float64
+	Length float64
+	Width  float64
+	Height float64
+}
+
+// Request describes the shipment a rate or label is asked for
+type Request struct {
+	ShipmentNumber string
+	Parcels        []Parcel
+}
+
+type Rate struct {
+	Carrier       string
+	ServiceLevel  string
+	Cost          float64
+	EstimatedDays int
+}
+
+// Label is a bought shipping label, Data is the printable PDF
+type Label struct {
+	TrackingNumber string
+	Cost           float64
+	Data           []byte
+}
+
+type Carrier interface {
+	Name() string
+	Rates(request Request) ([]Rate, error)
+	CreateLabel(request Request, serviceLevel string) (Label, error)
+}
+
+var registry = map[string]Carrier{}
+
+// Register makes a carrier available to shipments under its name
+func Register(carrier Carrier) {
+	registry[carrier.Name()] = carrier
+}
+
+func Get(name string) (Carrier, bool) {
+	carrier, ok := registry[name]
+	return carrier, ok
+}
+
+// Names lists the registered carriers in alphabetical order
+func Names() []string {
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
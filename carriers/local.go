@@ -0,0 +1,73 @@
+package carriers
+
+import (
+	"fmt"
+	"inventory-control-hub/utils"
+	"math"
+	"strings"
+)
+
+// Local is a fake carrier that answers without calling anyone, for development and testing.
+// Prices follow a simple per kg tariff on the billable weight.
+type Local struct{}
+
+type localService struct {
+	base          float64
+	perKg         float64
+	estimatedDays int
+}
+
+var localServices = map[string]localService{
+	"standard": {base: 4.5, perKg: 1.1, estimatedDays: 3},
+	"express":  {base: 9.9, perKg: 2.2, estimatedDays: 1},
+}
+
+func (Local) Name() string { return "local" }
+
+// billableWeight charges bulky parcels by volume, 5000 cm³ count as 1 kg
+func billableWeight(parcel Parcel) float64 {
+	return math.Max(parcel.Weight, parcel.Length*parcel.Width*parcel.Height/5000)
+}
+
+func (carrier Local) price(request Request, service localService) float64 {
+	cost := 0.0
+	for _, parcel := range request.Parcels {
+		cost += service.base + service.perKg*billableWeight(parcel)
+	}
+	return utils.RoundMoney(cost)
+}
+
+func (carrier Local) Rates(request Request) ([]Rate, error) {
+	var rates []Rate
+	for _, serviceLevel := range []string{"standard", "express"} {
+		service := localServices[serviceLevel]
+		rates = append(rates, Rate{
+			Carrier:       carrier.Name(),
+			ServiceLevel:  serviceLevel,
+			Cost:          carrier.price(request, service),
+			EstimatedDays: service.estimatedDays,
+		})
+	}
+	return rates, nil
+}
+
+func (carrier Local) CreateLabel(request Request, serviceLevel string) (Label, error) {
+	service, ok := localServices[serviceLevel]
+	if !ok {
+		return Label{}, ErrUnknownServiceLevel
+	}
+	label := Label{
+		TrackingNumber: fmt.Sprintf("LOC%s%s", strings.ToUpper(serviceLevel[:2]), strings.ReplaceAll(request.ShipmentNumber, "-", "")),
+		Cost:           carrier.price(request, service),
+	}
+
+	doc := utils.NewPDFDocument()
+	y := utils.PDFPageHeight - 60
+	doc.Text(50, y, 18, true, "LOCAL CARRIER - "+strings.ToUpper(serviceLevel))
+	y -= 30
+	doc.Text(50, y, 14, true, label.TrackingNumber)
+	y -= 20
+	doc.Text(50, y, 10, false, fmt.Sprintf("Shipment %s, %d parcel(s)", request.ShipmentNumber, len(request.Parcels)))
+	label.Data = doc.Bytes()
+	return label, nil
+}
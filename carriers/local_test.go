@@ -0,0 +1,87 @@
+package carriers
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestLocalRates(t *testing.T) {
+	tests := []struct {
+		name     string
+		parcels  []Parcel
+		standard float64
+		express  float64
+	}{
+		{"no parcels", nil, 0, 0},
+		{"charged by weight", []Parcel{{Weight: 2, Length: 10, Width: 10, Height: 10}}, 6.7, 14.3},
+		{"bulky parcel charged by volume", []Parcel{{Weight: 1, Length: 50, Width: 40, Height: 30}}, 17.7, 36.3},
+		{"several parcels", []Parcel{{Weight: 2}, {Weight: 3}}, 14.5, 30.8},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rates, err := Local{}.Rates(Request{ShipmentNumber: "SHP-1", Parcels: test.parcels})
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := map[string]float64{"standard": test.standard, "express": test.express}
+			if len(rates) != len(want) {
+				t.Fatalf("got %d rates, want %d", len(rates), len(want))
+			}
+			for _, rate := range rates {
+				if rate.Carrier != "local" {
+					t.Errorf("rate carrier = %q, want local", rate.Carrier)
+				}
+				if rate.Cost != want[rate.ServiceLevel] {
+					t.Errorf("%s cost = %v, want %v", rate.ServiceLevel, rate.Cost, want[rate.ServiceLevel])
+				}
+			}
+		})
+	}
+}
+
+func TestLocalCreateLabel(t *testing.T) {
+	request := Request{ShipmentNumber: "SHP-000042", Parcels: []Parcel{{Weight: 2}}}
+
+	label, err := Local{}.CreateLabel(request, "express")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if label.TrackingNumber != "LOCEXSHP000042" {
+		t.Errorf("tracking number = %q, want LOCEXSHP000042", label.TrackingNumber)
+	}
+	if label.Cost != 14.3 {
+		t.Errorf("cost = %v, want 14.3", label.Cost)
+	}
+	if !bytes.HasPrefix(label.Data, []byte("%PDF")) {
+		t.Error("label is not a PDF")
+	}
+	if !bytes.Contains(label.Data, []byte(label.TrackingNumber)) {
+		t.Error("label does not print the tracking number")
+	}
+
+	rates, _ := Local{}.Rates(request)
+	for _, rate := range rates {
+		if rate.ServiceLevel == "express" && rate.Cost != label.Cost {
+			t.Errorf("label costs %v, the express rate quoted %v", label.Cost, rate.Cost)
+		}
+	}
+
+	if _, err := (Local{}).CreateLabel(request, "overnight"); !errors.Is(err, ErrUnknownServiceLevel) {
+		t.Errorf("unknown service level error = %v, want ErrUnknownServiceLevel", err)
+	}
+}
+
+func TestRegistry(t *testing.T) {
+	Register(Local{})
+	carrier, ok := Get("local")
+	if !ok || carrier.Name() != "local" {
+		t.Fatalf("Get(local) = %v, %v", carrier, ok)
+	}
+	if _, ok := Get("unknown"); ok {
+		t.Error("Get(unknown) found a carrier")
+	}
+	if names := Names(); len(names) != 1 || names[0] != "local" {
+		t.Errorf("Names() = %v, want [local]", names)
+	}
+}
@@ -0,0 +1,54 @@
+package controllers
+
+import (
+	"inventory-control-hub/database"
+	"inventory-control-hub/models"
+	"inventory-control-hub/utils"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// GetAuditLogs lists recorded changes, newest first,
+// GET /audit?entity=products&id=1&actor=admin@example.com&from=2025-06-01&to=2025-06-30.
+// actor is a user ID or email, from and to are dates and include the whole day.
+func GetAuditLogs(w http.ResponseWriter, r *http.Request) {
+	query := database.For(r).Order("id DESC")
+	params := r.URL.Query()
+	if entity := params.Get("entity"); entity != "" {
+		query = query.Where("entity = ?", entity)
+	}
+	if id := params.Get("id"); id != "" {
+		query = query.Where("entity_id = ?", id)
+	}
+	if actor := params.Get("actor"); actor != "" {
+		if userID, err := strconv.ParseUint(actor, 10, 64); err == nil {
+			query = query.Where("user_id = ?", userID)
+		} else {
+			query = query.Where("actor = ?", actor)
+		}
+	}
+	for _, bound := range []string{"from", "to"} {
+		value := params.Get(bound)
+		if value == "" {
+			continue
+		}
+		day, err := time.ParseInLocation("2006-01-02", value, time.Local)
+		if err != nil {
+			utils.RespondWithError(w, http.StatusBadRequest, bound+" must be a date like 2025-06-30")
+			return
+		}
+		if bound == "from" {
+			query = query.Where("created_at >= ?", day)
+		} else {
+			query = query.Where("created_at < ?", day.AddDate(0, 0, 1))
+		}
+	}
+
+	var entries []models.AuditLog
+	if query.Find(&entries).Error != nil {
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to fetch audit log")
+		return
+	}
+	utils.RespondWithJSON(w, http.StatusOK, entries)
+}
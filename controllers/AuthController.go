@@ -0,0 +1,350 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"inventory-control-hub/auth"
+	"inventory-control-hub/database"
+	"inventory-control-hub/models"
+	"inventory-control-hub/utils"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// dummyPasswordHash is checked against when the email is unknown, so a failed login takes
+// as long whether the account exists or not
+var dummyPasswordHash, _ = auth.HashPassword("not a real password")
+
+type tokenPair struct {
+	AccessToken  string
+	RefreshToken string
+	TokenType    string
+	ExpiresIn    int // seconds until the access token expires
+}
+
+// issueTokens signs an access token and stores a new refresh token for the user, inside tx
+func issueTokens(tx *gorm.DB, user models.User) (tokenPair, error) {
+	now := time.Now()
+	accessToken, err := auth.IssueAccessToken(user.ID, *user.TenantID, now)
+	if err != nil {
+		return tokenPair{}, err
+	}
+	refreshToken, err := auth.NewOpaqueToken("")
+	if err != nil {
+		return tokenPair{}, err
+	}
+	if err := tx.Create(&models.RefreshToken{
+		UserID:    &user.ID,
+		TokenHash: auth.HashToken(refreshToken),
+		ExpiresAt: now.Add(auth.RefreshTokenTTL()),
+	}).Error; err != nil {
+		return tokenPair{}, err
+	}
+	return tokenPair{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int(auth.AccessTokenTTL().Seconds()),
+	}, nil
+}
+
+// loginRequest is the body of Login
+type loginRequest struct {
+	Email    string `validate:"required"`
+	Password string `validate:"required"`
+}
+
+// refreshTokenRequest is the body of RefreshTokens and Logout
+type refreshTokenRequest struct {
+	RefreshToken string `validate:"required"`
+}
+
+// Login exchanges email and password for tokens, POST /auth/login with {"Email":"...","Password":"..."}.
+// Users of other tenants than the default one send its slug in X-Tenant.
+func Login(w http.ResponseWriter, r *http.Request) {
+	var request loginRequest
+	if !utils.DecodeJSON(w, r, &request) {
+		return
+	}
+
+	var user models.User
+	found := database.For(r).Where("email = ?", strings.ToLower(strings.TrimSpace(request.Email))).First(&user).Error == nil
+	hash := dummyPasswordHash
+	if found {
+		hash = user.PasswordHash
+	}
+	if !auth.CheckPassword(hash, request.Password) || !found || !user.Active {
+		utils.RespondWithError(w, http.StatusUnauthorized, "Invalid email or password")
+		return
+	}
+
+	tokens, err := issueTokens(database.For(r), user)
+	if err != nil {
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to issue tokens")
+		return
+	}
+	utils.RespondWithJSON(w, http.StatusOK, tokens)
+}
+
+// RefreshTokens trades a refresh token for a new token pair, POST /auth/refresh with {"RefreshToken":"..."}.
+// The old refresh token stops working. Presenting one that was already used revokes all of the
+// user's refresh tokens, it has most likely been stolen.
+func RefreshTokens(w http.ResponseWriter, r *http.Request) {
+	var request refreshTokenRequest
+	if !utils.DecodeJSON(w, r, &request) {
+		return
+	}
+
+	tx := database.For(r).Begin()
+
+	var refreshToken models.RefreshToken
+	if tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("token_hash = ?", auth.HashToken(request.RefreshToken)).First(&refreshToken).Error != nil {
+		tx.Rollback()
+		utils.RespondWithError(w, http.StatusUnauthorized, "Invalid refresh token")
+		return
+	}
+	now := time.Now()
+	if refreshToken.RevokedAt != nil {
+		tx.Model(&models.RefreshToken{}).Where("user_id = ? AND revoked_at IS NULL", refreshToken.UserID).Update("revoked_at", now)
+		tx.Commit()
+		utils.RespondWithError(w, http.StatusUnauthorized, "Refresh token was already used, please sign in again")
+		return
+	}
+	var user models.User
+	if now.After(refreshToken.ExpiresAt) || tx.First(&user, refreshToken.UserID).Error != nil || !user.Active {
+		tx.Rollback()
+		utils.RespondWithError(w, http.StatusUnauthorized, "Refresh token has expired, please sign in again")
+		return
+	}
+
+	if tx.Model(&refreshToken).Update("revoked_at", now).Error != nil {
+		tx.Rollback()
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to refresh tokens")
+		return
+	}
+	tokens, err := issueTokens(tx, user)
+	if err != nil {
+		tx.Rollback()
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to issue tokens")
+		return
+	}
+
+	tx.Commit()
+	utils.RespondWithJSON(w, http.StatusOK, tokens)
+}
+
+// Logout revokes a refresh token of the signed-in user, POST /auth/logout with {"RefreshToken":"..."}.
+// Access tokens already issued stay valid until they expire.
+func Logout(w http.ResponseWriter, r *http.Request) {
+	var request refreshTokenRequest
+	if !utils.DecodeJSON(w, r, &request) {
+		return
+	}
+	user := auth.CurrentUser(r)
+	if database.For(r).Model(&models.RefreshToken{}).
+		Where("token_hash = ? AND user_id = ? AND revoked_at IS NULL", auth.HashToken(request.RefreshToken), user.ID).
+		Update("revoked_at", time.Now()).Error != nil {
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to sign out")
+		return
+	}
+	utils.RespondWithJSON(w, http.StatusOK, "Signed out successfully")
+}
+
+// GetCurrentUser returns who the request is authenticated as, GET /auth/me
+func GetCurrentUser(w http.ResponseWriter, r *http.Request) {
+	utils.RespondWithJSON(w, http.StatusOK, auth.CurrentUser(r))
+}
+
+func GetUsers(w http.ResponseWriter, r *http.Request) {
+	var users []models.User
+	if database.For(r).Preload("Roles").Order("id").Find(&users).Error != nil {
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to fetch users")
+		return
+	}
+	utils.RespondWithJSON(w, http.StatusOK, users)
+}
+
+// userRequest is the body of AddUser
+type userRequest struct {
+	Email    string `validate:"required,maxlen=255" pattern:"^[^@\\s]+@[^@\\s]+$"`
+	Name     string
+	Password string `validate:"required"`
+	Roles    []string
+}
+
+func (request userRequest) Validate(errs *utils.FieldErrors) {
+	validatePassword(errs, "Password", request.Password)
+}
+
+// userUpdateRequest is the body of UpdateUser, an empty Name or Password is left as it is
+type userUpdateRequest struct {
+	Name     string
+	Password string
+	Active   *bool
+}
+
+func (request userUpdateRequest) Validate(errs *utils.FieldErrors) {
+	validatePassword(errs, "Password", request.Password)
+}
+
+// validatePassword checks a new password is long enough, an empty one is left to required
+func validatePassword(errs *utils.FieldErrors, field string, password string) {
+	if password != "" && len(password) < auth.MinPasswordLength {
+		errs.Add(field, "minlen", fmt.Sprintf("must have at least %d characters", auth.MinPasswordLength))
+	}
+}
+
+// AddUser creates a user who can sign in, POST /add-user with {"Email":"...","Name":"...","Password":"...","Roles":["sales"]}
+func AddUser(w http.ResponseWriter, r *http.Request) {
+	var request userRequest
+	if !utils.DecodeJSON(w, r, &request) {
+		return
+	}
+	email := strings.ToLower(strings.TrimSpace(request.Email))
+	var count int64
+	database.For(r).Model(&models.User{}).Where("email = ?", email).Count(&count)
+	if count > 0 {
+		utils.RespondWithError(w, http.StatusConflict, "A user with this email already exists")
+		return
+	}
+	roles, err := findRoles(database.For(r), request.Roles)
+	if err != nil {
+		utils.RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	hash, err := auth.HashPassword(request.Password)
+	if err != nil {
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to hash password")
+		return
+	}
+	user := models.User{Email: email, Name: request.Name, PasswordHash: hash, Active: true, Roles: roles}
+	if database.For(r).Create(&user).Error != nil {
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to create user")
+		return
+	}
+	utils.RespondWithJSON(w, http.StatusOK, user)
+}
+
+// UpdateUser changes a user's name or password or (de)activates them, PUT /update-user/{id} with
+// {"Name":"...","Password":"...","Active":false}. Deactivating or changing the password signs the user out everywhere.
+func UpdateUser(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	var request userUpdateRequest
+	if !utils.DecodeJSON(w, r, &request) {
+		return
+	}
+
+	var user models.User
+	if database.For(r).First(&user, id).Error != nil {
+		utils.RespondWithError(w, http.StatusNotFound, "User not found")
+		return
+	}
+
+	signOut := false
+	if request.Name != "" {
+		user.Name = request.Name
+	}
+	if request.Password != "" {
+		hash, err := auth.HashPassword(request.Password)
+		if err != nil {
+			utils.RespondWithError(w, http.StatusInternalServerError, "Failed to hash password")
+			return
+		}
+		user.PasswordHash = hash
+		signOut = true
+	}
+	if request.Active != nil {
+		if !*request.Active && user.ID == auth.CurrentUser(r).ID {
+			utils.RespondWithError(w, http.StatusConflict, "You cannot deactivate yourself")
+			return
+		}
+		signOut = signOut || (user.Active && !*request.Active)
+		user.Active = *request.Active
+	}
+
+	tx := database.For(r).Begin()
+	if tx.Model(&user).Updates(map[string]interface{}{"name": user.Name, "password_hash": user.PasswordHash, "active": user.Active}).Error != nil {
+		tx.Rollback()
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to update user")
+		return
+	}
+	if signOut && tx.Model(&models.RefreshToken{}).Where("user_id = ? AND revoked_at IS NULL", user.ID).Update("revoked_at", time.Now()).Error != nil {
+		tx.Rollback()
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to revoke refresh tokens")
+		return
+	}
+	tx.Commit()
+	utils.RespondWithJSON(w, http.StatusOK, user)
+}
+
+// GetAPIKeys lists the signed-in user's API keys, GET /api-keys
+func GetAPIKeys(w http.ResponseWriter, r *http.Request) {
+	var apiKeys []models.APIKey
+	if database.For(r).Where("user_id = ?", auth.CurrentUser(r).ID).Order("id").Find(&apiKeys).Error != nil {
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to fetch API keys")
+		return
+	}
+	utils.RespondWithJSON(w, http.StatusOK, apiKeys)
+}
+
+// apiKeyRequest is the body of CreateAPIKey
+type apiKeyRequest struct {
+	Name string `validate:"required"`
+}
+
+// CreateAPIKey issues an API key acting as the signed-in user, POST /api-keys with {"Name":"warehouse scanner"}.
+// The key is only returned this once, send it as X-API-Key.
+func CreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	var request apiKeyRequest
+	if !utils.DecodeJSON(w, r, &request) {
+		return
+	}
+
+	key, err := auth.NewOpaqueToken("ich_")
+	if err != nil {
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to generate API key")
+		return
+	}
+	user := auth.CurrentUser(r)
+	apiKey := models.APIKey{UserID: &user.ID, Name: request.Name, Prefix: key[:12], KeyHash: auth.HashToken(key)}
+	if database.For(r).Create(&apiKey).Error != nil {
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to create API key")
+		return
+	}
+	utils.RespondWithJSON(w, http.StatusOK, struct {
+		models.APIKey
+		Key string
+	}{apiKey, key})
+}
+
+// RevokeAPIKey stops an API key of the signed-in user from working, DELETE /api-key/{id}
+func RevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	var apiKey models.APIKey
+	err := database.For(r).Where("user_id = ?", auth.CurrentUser(r).ID).First(&apiKey, id).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		utils.RespondWithError(w, http.StatusNotFound, "API key not found")
+		return
+	}
+	if err != nil {
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to fetch API key")
+		return
+	}
+	if apiKey.RevokedAt == nil {
+		now := time.Now()
+		apiKey.RevokedAt = &now
+		if database.For(r).Model(&apiKey).Update("revoked_at", apiKey.RevokedAt).Error != nil {
+			utils.RespondWithError(w, http.StatusInternalServerError, "Failed to revoke API key")
+			return
+		}
+	}
+	utils.RespondWithJSON(w, http.StatusOK, apiKey)
+}
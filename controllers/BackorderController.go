@@ -0,0 +1,176 @@
+package controllers
+
+import (
+	"fmt"
+	"inventory-control-hub/database"
+	"inventory-control-hub/models"
+	"inventory-control-hub/utils"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// backorderQueue is the order waiting sales orders get stock in, highest priority first, then the oldest
+const backorderQueue = "priority DESC, order_date, id"
+
+// shortOfStock is how many of quantity units the available stock of a product can't cover
+func shortOfStock(db *gorm.DB, product models.Product, quantity int) (int, error) {
+	available, err := salesAvailable(db, product)
+	return max(quantity-max(available, 0), 0), err
+}
+
+// allocateBackorders hands available stock of a product to its backordered sales orders, queue order,
+// inside tx after stock came in or was released. Every allocation is recorded and the customer notified.
+// Stock of a component also goes to the backorders of the virtual kits built from it.
+func allocateBackorders(tx *gorm.DB, product *models.Product, referenceType string, referenceID uint) error {
+	available, err := salesAvailable(tx, *product)
+	if err != nil || available <= 0 {
+		return err
+	}
+	var salesOrders []models.SalesOrder
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("product_id = ? AND backordered_quantity > 0", product.ID).
+		Order(backorderQueue).Find(&salesOrders).Error; err != nil {
+		return err
+	}
+	for _, salesOrder := range salesOrders {
+		quantity := min(salesOrder.BackorderedQuantity, available)
+		if quantity <= 0 {
+			break
+		}
+		if err := reserveStock(tx, product, quantity, salesOrder.ID); err != nil {
+			return err
+		}
+		available -= quantity
+
+		var open int64
+		tx.Model(&models.PickTask{}).Where("sales_order_id = ? AND status = ?", salesOrder.ID, "open").Count(&open)
+		salesOrder.BackorderedQuantity -= quantity
+		salesOrder.Status = salesOrderStatus(salesOrder, open > 0)
+		if err := tx.Model(&salesOrder).Updates(map[string]interface{}{
+			"backordered_quantity": salesOrder.BackorderedQuantity,
+			"status":               salesOrder.Status,
+		}).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Create(&models.BackorderAllocation{
+			SalesOrderID:  &salesOrder.ID,
+			ProductID:     &product.ID,
+			Quantity:      quantity,
+			Remaining:     salesOrder.BackorderedQuantity,
+			ReferenceType: referenceType,
+			ReferenceID:   referenceID,
+		}).Error; err != nil {
+			return err
+		}
+
+		message := fmt.Sprintf("%d units of %s were allocated to sales order %d", quantity, salesOrder.ProductName, salesOrder.ID)
+		if salesOrder.BackorderedQuantity > 0 {
+			message += fmt.Sprintf(", %d units are still backordered", salesOrder.BackorderedQuantity)
+		} else {
+			message += ", the order is complete and can be picked"
+		}
+		if err := tx.Create(&models.Notification{
+			CustomerID:   salesOrder.CustomerID,
+			SalesOrderID: &salesOrder.ID,
+			Event:        "backorder_allocated",
+			Message:      message,
+		}).Error; err != nil {
+			return err
+		}
+	}
+
+	kitIDs, err := virtualKitsUsing(tx, product.ID)
+	if err != nil {
+		return err
+	}
+	for _, kitID := range kitIDs {
+		var kit models.Product
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Unscoped().First(&kit, kitID).Error; err != nil {
+			return err
+		}
+		if err := allocateBackorders(tx, &kit, referenceType, referenceID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetProductBackorders lists the sales orders waiting for a product in the order they get stock,
+// GET /product/{id}/backorders
+func GetProductBackorders(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	var product models.Product
+	if database.For(r).Unscoped().First(&product, id).Error != nil {
+		utils.RespondWithError(w, http.StatusNotFound, "Product not found")
+		return
+	}
+
+	var salesOrders []models.SalesOrder
+	if database.For(r).Preload("Customer").
+		Where("product_id = ? AND backordered_quantity > 0", product.ID).
+		Order(backorderQueue).Find(&salesOrders).Error != nil {
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to fetch backorders")
+		return
+	}
+	utils.RespondWithJSON(w, http.StatusOK, salesOrders)
+}
+
+// GetBackorderAllocations lists stock handed to backorders, GET /backorder-allocations?sales_order=&product=
+func GetBackorderAllocations(w http.ResponseWriter, r *http.Request) {
+	var allocations []models.BackorderAllocation
+	query := database.For(r).Order("id")
+	if salesOrder := r.URL.Query().Get("sales_order"); salesOrder != "" {
+		query = query.Where("sales_order_id = ?", salesOrder)
+	}
+	if product := r.URL.Query().Get("product"); product != "" {
+		query = query.Where("product_id = ?", product)
+	}
+	if query.Find(&allocations).Error != nil {
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to fetch backorder allocations")
+		return
+	}
+	utils.RespondWithJSON(w, http.StatusOK, allocations)
+}
+
+// GetNotifications lists notifications, newest first, GET /notifications?customer=&unread=true
+func GetNotifications(w http.ResponseWriter, r *http.Request) {
+	var notifications []models.Notification
+	query := database.For(r).Order("id DESC")
+	if customer := r.URL.Query().Get("customer"); customer != "" {
+		query = query.Where("customer_id = ?", customer)
+	}
+	if r.URL.Query().Get("unread") == "true" {
+		query = query.Where("read_at IS NULL")
+	}
+	if query.Find(&notifications).Error != nil {
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to fetch notifications")
+		return
+	}
+	utils.RespondWithJSON(w, http.StatusOK, notifications)
+}
+
+// MarkNotificationRead marks a notification as read, POST /notification/{id}/read
+func MarkNotificationRead(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	var notification models.Notification
+	if database.For(r).First(&notification, id).Error != nil {
+		utils.RespondWithError(w, http.StatusNotFound, "Notification not found")
+		return
+	}
+	if notification.ReadAt == nil {
+		now := time.Now()
+		notification.ReadAt = &now
+		if database.For(r).Model(&notification).Update("read_at", notification.ReadAt).Error != nil {
+			utils.RespondWithError(w, http.StatusInternalServerError, "Failed to update notification")
+			return
+		}
+	}
+	utils.RespondWithJSON(w, http.StatusOK, notification)
+}
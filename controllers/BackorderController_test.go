@@ -0,0 +1,62 @@
+package controllers_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+// Backordered sales orders wait highest priority first, then the oldest, and arriving stock goes
+// to them in that order. Editing an order keeps its place.
+func TestBackorderQueue(t *testing.T) {
+	router, acme := setUp(t)
+	product := create(t, router, acme, "POST", "/add-product", `{"Name":"desk","SKU":"DESK","Price":100}`)
+	a := create(t, router, acme, "POST", "/add-sales-order", fmt.Sprintf(`{"ProductID":%d,"Quantity":2,"AllowBackorder":true}`, product))
+	b := create(t, router, acme, "POST", "/add-sales-order", fmt.Sprintf(`{"ProductID":%d,"Quantity":2,"AllowBackorder":true,"Priority":5}`, product))
+	c := create(t, router, acme, "POST", "/add-sales-order", fmt.Sprintf(`{"ProductID":%d,"Quantity":1,"AllowBackorder":true}`, product))
+
+	tests := []struct {
+		name            string
+		do              func() int
+		wantCode        int
+		wantQueue       []uint
+		wantBackordered []int
+	}{
+		{"order without backorder", func() int {
+			return acme.Do(router, "POST", "/add-sales-order", fmt.Sprintf(`{"ProductID":%d,"Quantity":2}`, product)).Code
+		}, http.StatusBadRequest, []uint{b, a, c}, []int{2, 2, 1}},
+		{"edit the oldest", func() int {
+			return acme.Do(router, "PUT", fmt.Sprintf("/update-sales-order/%d", a), `{"Quantity":3,"AllowBackorder":true}`).Code
+		}, http.StatusOK, []uint{b, a, c}, []int{2, 3, 1}},
+		{"stock arrives", func() int {
+			return acme.Do(router, "POST", "/add-purchase-order", fmt.Sprintf(`{"ProductID":%d,"Quantity":4,"Supplier":"wood co","UnitCost":10}`, product)).Code
+		}, http.StatusOK, []uint{a, c}, []int{1, 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if code := tt.do(); code != tt.wantCode {
+				t.Errorf("answered %d, want %d", code, tt.wantCode)
+			}
+			var backorders []struct {
+				ID                  uint
+				BackorderedQuantity int
+			}
+			json.Unmarshal(acme.Do(router, "GET", fmt.Sprintf("/product/%d/backorders", product), "").Body.Bytes(), &backorders)
+			var queue []uint
+			var backordered []int
+			for _, salesOrder := range backorders {
+				queue = append(queue, salesOrder.ID)
+				backordered = append(backordered, salesOrder.BackorderedQuantity)
+			}
+			if !reflect.DeepEqual(queue, tt.wantQueue) || !reflect.DeepEqual(backordered, tt.wantBackordered) {
+				t.Errorf("queue is %v waiting for %v, want %v waiting for %v", queue, backordered, tt.wantQueue, tt.wantBackordered)
+			}
+		})
+	}
+
+	if got := stock(t, acme, product); got.Quantity != 4 || got.ReservedQuantity != 4 {
+		t.Errorf("stock %d reserved %d, want 4 and 4", got.Quantity, got.ReservedQuantity)
+	}
+}
@@ -0,0 +1,567 @@
+package controllers
+
+import (
+	"errors"
+	"inventory-control-hub/database"
+	"inventory-control-hub/models"
+	"inventory-control-hub/utils"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	errBinNotFound       = errors.New("bin not found")
+	errBinStockShort     = errors.New("not enough stock in bin")
+	errUnlocatedTooSmall = errors.New("not enough unlocated stock")
+)
+
+func respondWithBinError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, errBinNotFound):
+		utils.RespondWithError(w, http.StatusNotFound, "Bin not found")
+	case errors.Is(err, errBinStockShort):
+		utils.RespondWithError(w, http.StatusConflict, "Not enough stock of the product in the bin")
+	case errors.Is(err, errUnlocatedTooSmall):
+		utils.RespondWithError(w, http.StatusConflict, "Not enough unlocated stock of the product to put away")
+	default:
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to update bin stock")
+	}
+}
+
+// walkingPath orders bins the way a picker walks the warehouse
+const walkingPath = "bins.pick_sequence, bins.zone, bins.aisle, bins.rack, bins.shelf, bins.id"
+
+// reversingReasons are stock movements that undo an earlier one on the same reference,
+// the units go back into the bins they were taken from
+var reversingReasons = map[string]bool{"purchase_restored": true}
+
+// reservedReasons are stock movements of reserved units, the reservation already took them out of the bins
+var reservedReasons = map[string]bool{"shipment": true, "short_pick": true}
+
+// locatedQuantity is how much of a product sits in bins. Of the rest of Product.Quantity the
+// reserved units are waiting to be picked or shipped, the others are unlocated.
+func locatedQuantity(db *gorm.DB, productID uint) (int, error) {
+	var located int64
+	err := db.Model(&models.BinStock{}).Where("product_id = ?", productID).
+		Select("COALESCE(SUM(quantity), 0)").Scan(&located).Error
+	return int(located), err
+}
+
+// changeBinStock adds (or with a negative change removes) units of a product in a bin
+func changeBinStock(tx *gorm.DB, binID uint, productID uint, change int) error {
+	var binStock models.BinStock
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("bin_id = ? AND product_id = ?", binID, productID).First(&binStock).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		binStock = models.BinStock{BinID: &binID, ProductID: &productID}
+		err = tx.Create(&binStock).Error
+	}
+	if err != nil {
+		return err
+	}
+	if binStock.Quantity+change < 0 {
+		return errBinStockShort
+	}
+	return tx.Model(&binStock).Update("quantity", binStock.Quantity+change).Error
+}
+
+// recordBinMovement writes the bin level history next to the stock ledger
+func recordBinMovement(tx *gorm.DB, productID uint, fromBinID *uint, toBinID *uint, quantity int, referenceType string, referenceID uint) error {
+	return tx.Create(&models.BinMovement{
+		ProductID:     &productID,
+		FromBinID:     fromBinID,
+		ToBinID:       toBinID,
+		Quantity:      quantity,
+		ReferenceType: referenceType,
+		ReferenceID:   referenceID,
+	}).Error
+}
+
+// takeFromBins removes units leaving stock from the bins along the walking path, the pick list
+// of a sales order is made of these moves. Units the bins don't cover come out of unlocated stock.
+func takeFromBins(tx *gorm.DB, productID uint, quantity int, referenceType string, referenceID uint) error {
+	var binStocks []models.BinStock
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Joins("JOIN bins ON bins.id = bin_stocks.bin_id").
+		Where("bin_stocks.product_id = ? AND bin_stocks.quantity > 0", productID).
+		Order(walkingPath).Find(&binStocks).Error; err != nil {
+		return err
+	}
+	for _, binStock := range binStocks {
+		if quantity == 0 {
+			break
+		}
+		take := min(quantity, binStock.Quantity)
+		if err := tx.Model(&binStock).Update("quantity", binStock.Quantity-take).Error; err != nil {
+			return err
+		}
+		if err := recordBinMovement(tx, productID, binStock.BinID, nil, take, referenceType, referenceID); err != nil {
+			return err
+		}
+		quantity -= take
+	}
+	return nil
+}
+
+// takenFromBins is what a reference still holds out of each bin, by bin id
+func takenFromBins(db *gorm.DB, referenceType string, referenceID uint) (map[uint]int, []uint, error) {
+	var movements []models.BinMovement
+	if err := db.Where("reference_type = ? AND reference_id = ?", referenceType, referenceID).
+		Order("id").Find(&movements).Error; err != nil {
+		return nil, nil, err
+	}
+	taken := map[uint]int{}
+	var binIDs []uint
+	for _, movement := range movements {
+		if movement.FromBinID != nil {
+			if _, ok := taken[*movement.FromBinID]; !ok {
+				binIDs = append(binIDs, *movement.FromBinID)
+			}
+			taken[*movement.FromBinID] += movement.Quantity
+		}
+		if movement.ToBinID != nil {
+			taken[*movement.ToBinID] -= movement.Quantity
+		}
+	}
+	return taken, binIDs, nil
+}
+
+// returnToBins puts units coming back on a reference into the bins the reference took them from,
+// anything beyond that stays unlocated
+func returnToBins(tx *gorm.DB, productID uint, quantity int, referenceType string, referenceID uint) error {
+	taken, binIDs, err := takenFromBins(tx, referenceType, referenceID)
+	if err != nil {
+		return err
+	}
+	for i := len(binIDs) - 1; i >= 0 && quantity > 0; i-- {
+		binID := binIDs[i]
+		back := min(quantity, taken[binID])
+		if back <= 0 {
+			continue
+		}
+		if err := changeBinStock(tx, binID, productID, back); err != nil {
+			return err
+		}
+		if err := recordBinMovement(tx, productID, nil, &binID, back, referenceType, referenceID); err != nil {
+			return err
+		}
+		quantity -= back
+	}
+	return nil
+}
+
+// putAway moves unlocated units of a product into a bin
+func putAway(tx *gorm.DB, product models.Product, binID uint, quantity int, referenceType string, referenceID uint) error {
+	if tx.First(&models.Bin{}, binID).Error != nil {
+		return errBinNotFound
+	}
+	located, err := locatedQuantity(tx, product.ID)
+	if err != nil {
+		return err
+	}
+	if quantity > availableQuantity(product)-located {
+		return errUnlocatedTooSmall
+	}
+	if err := changeBinStock(tx, binID, product.ID, quantity); err != nil {
+		return err
+	}
+	return recordBinMovement(tx, product.ID, nil, &binID, quantity, referenceType, referenceID)
+}
+
+// suggestBin picks where received goods should go: a bin already holding the product, otherwise the
+// first empty bin on the walking path. Nil when every bin is taken by other products.
+func suggestBin(db *gorm.DB, productID uint) (*models.Bin, error) {
+	var bin models.Bin
+	err := db.Joins("JOIN bin_stocks ON bin_stocks.bin_id = bins.id").
+		Where("bin_stocks.product_id = ? AND bin_stocks.quantity > 0", productID).
+		Order(walkingPath).First(&bin).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		err = db.Where("NOT EXISTS (SELECT 1 FROM bin_stocks WHERE bin_stocks.bin_id = bins.id AND bin_stocks.quantity > 0)").
+			Order(walkingPath).First(&bin).Error
+	}
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &bin, nil
+}
+
+func GetBins(w http.ResponseWriter, r *http.Request) {
+	var bins []models.Bin
+	query := database.For(r).Order(walkingPath)
+	if zone := r.URL.Query().Get("zone"); zone != "" {
+		query = query.Where("zone = ?", zone)
+	}
+	if query.Find(&bins).Error != nil {
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to fetch bins")
+		return
+	}
+	utils.RespondWithJSON(w, http.StatusOK, bins)
+}
+
+func GetBinById(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	var bin models.Bin
+	if database.For(r).Preload("Stock", "quantity > 0").Preload("Stock.Product", withDeleted).First(&bin, id).Error != nil {
+		utils.RespondWithError(w, http.StatusNotFound, "Bin not found")
+		return
+	}
+	utils.RespondWithJSON(w, http.StatusOK, bin)
+}
+
+// binCode builds a code like A-03-02-1 from the location parts that are set
+func binCode(bin models.Bin) string {
+	var parts []string
+	for _, part := range []string{bin.Zone, bin.Aisle, bin.Rack, bin.Shelf} {
+		if part != "" {
+			parts = append(parts, part)
+		}
+	}
+	return strings.Join(parts, "-")
+}
+
+func binCodeTaken(db *gorm.DB, code string, exceptID uint) bool {
+	var count int64
+	db.Model(&models.Bin{}).Where("code = ? AND id <> ?", code, exceptID).Count(&count)
+	return count > 0
+}
+
+// binRequest is the body of AddBin
+type binRequest struct {
+	Code         string `validate:"maxlen=64"` // built from the parts when left out
+	Zone         string `validate:"required,maxlen=16"`
+	Aisle        string `validate:"maxlen=16"`
+	Rack         string `validate:"maxlen=16"`
+	Shelf        string `validate:"maxlen=16"`
+	PickSequence int
+}
+
+// binUpdateRequest is the body of UpdateBin, fields left out keep their value and an empty
+// Code is built again from the parts
+type binUpdateRequest struct {
+	Code         *string `validate:"maxlen=64"`
+	Zone         *string `validate:"maxlen=16"`
+	Aisle        *string `validate:"maxlen=16"`
+	Rack         *string `validate:"maxlen=16"`
+	Shelf        *string `validate:"maxlen=16"`
+	PickSequence *int
+}
+
+func (request binUpdateRequest) Validate(errs *utils.FieldErrors) {
+	if request.Zone != nil && strings.TrimSpace(*request.Zone) == "" {
+		errs.Add("Zone", "required", "cannot be blank")
+	}
+}
+
+func AddBin(w http.ResponseWriter, r *http.Request) {
+	var request binRequest
+	if !utils.DecodeJSON(w, r, &request) {
+		return
+	}
+	bin := models.Bin{
+		Code:         request.Code,
+		Zone:         request.Zone,
+		Aisle:        request.Aisle,
+		Rack:         request.Rack,
+		Shelf:        request.Shelf,
+		PickSequence: request.PickSequence,
+	}
+	if bin.Code == "" {
+		bin.Code = binCode(bin)
+	}
+	if binCodeTaken(database.For(r), bin.Code, 0) {
+		utils.RespondWithError(w, http.StatusConflict, "A bin with this code already exists")
+		return
+	}
+
+	if database.For(r).Create(&bin).Error != nil {
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to add bin")
+		return
+	}
+	utils.RespondWithJSON(w, http.StatusOK, bin)
+}
+
+func UpdateBin(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	var bin models.Bin
+	if database.For(r).First(&bin, id).Error != nil {
+		utils.RespondWithError(w, http.StatusNotFound, "Bin not found")
+		return
+	}
+
+	var newBin binUpdateRequest
+	if !utils.DecodeJSON(w, r, &newBin) {
+		return
+	}
+	if newBin.Zone != nil {
+		bin.Zone = *newBin.Zone
+	}
+	if newBin.Aisle != nil {
+		bin.Aisle = *newBin.Aisle
+	}
+	if newBin.Rack != nil {
+		bin.Rack = *newBin.Rack
+	}
+	if newBin.Shelf != nil {
+		bin.Shelf = *newBin.Shelf
+	}
+	if newBin.PickSequence != nil {
+		bin.PickSequence = *newBin.PickSequence
+	}
+	if newBin.Code != nil {
+		bin.Code = *newBin.Code
+		if bin.Code == "" {
+			bin.Code = binCode(bin)
+		}
+	}
+	if binCodeTaken(database.For(r), bin.Code, bin.ID) {
+		utils.RespondWithError(w, http.StatusConflict, "A bin with this code already exists")
+		return
+	}
+
+	if database.For(r).Save(&bin).Error != nil {
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to update bin")
+		return
+	}
+	utils.RespondWithJSON(w, http.StatusOK, bin)
+}
+
+func DeleteBin(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	var bin models.Bin
+	if database.For(r).First(&bin, id).Error != nil {
+		utils.RespondWithError(w, http.StatusNotFound, "Bin not found")
+		return
+	}
+
+	var count int64
+	database.For(r).Model(&models.BinStock{}).Where("bin_id = ? AND quantity > 0", bin.ID).Count(&count)
+	if count > 0 {
+		utils.RespondWithError(w, http.StatusConflict, "Cannot delete a bin that still holds stock, move it out first")
+		return
+	}
+
+	tx := database.For(r).Begin()
+	if tx.Where("bin_id = ?", bin.ID).Delete(&models.BinStock{}).Error != nil || tx.Delete(&bin).Error != nil {
+		tx.Rollback()
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to delete bin")
+		return
+	}
+	tx.Commit()
+	utils.RespondWithJSON(w, http.StatusOK, map[string]string{"message": "Bin deleted successfully"})
+}
+
+type productBins struct {
+	ProductID    uint
+	Quantity     int
+	Reserved     int // taken out of the bins for sales orders, waiting to be picked or shipped
+	Located      int
+	Unlocated    int
+	Bins         []models.BinStock
+	SuggestedBin *models.Bin // where to put unlocated stock away
+}
+
+func loadProductBins(db *gorm.DB, product models.Product) (productBins, error) {
+	result := productBins{ProductID: product.ID, Quantity: product.Quantity, Reserved: product.ReservedQuantity}
+	if err := db.Preload("Bin").Joins("JOIN bins ON bins.id = bin_stocks.bin_id").
+		Where("bin_stocks.product_id = ? AND bin_stocks.quantity > 0", product.ID).
+		Order(walkingPath).Find(&result.Bins).Error; err != nil {
+		return result, err
+	}
+	for _, binStock := range result.Bins {
+		result.Located += binStock.Quantity
+	}
+	result.Unlocated = availableQuantity(product) - result.Located
+	bin, err := suggestBin(db, product.ID)
+	result.SuggestedBin = bin
+	return result, err
+}
+
+// GetProductBins shows where a product is stored and where to put away what isn't, GET /product/{id}/bins
+func GetProductBins(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	var product models.Product
+	if database.For(r).First(&product, id).Error != nil {
+		utils.RespondWithError(w, http.StatusNotFound, "Product not found")
+		return
+	}
+	result, err := loadProductBins(database.For(r), product)
+	if err != nil {
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to fetch bin stock")
+		return
+	}
+	utils.RespondWithJSON(w, http.StatusOK, result)
+}
+
+// binMoveRequest is the body of MoveBinStock
+type binMoveRequest struct {
+	ProductID uint `validate:"required"`
+	FromBinID *uint
+	ToBinID   *uint
+	Quantity  int `validate:"min=1"`
+}
+
+func (request binMoveRequest) Validate(errs *utils.FieldErrors) {
+	if request.FromBinID == nil && request.ToBinID == nil {
+		errs.Add("ToBinID", "required", "is required without a FromBinID")
+	}
+	if request.FromBinID != nil && request.ToBinID != nil && *request.FromBinID == *request.ToBinID {
+		errs.Add("ToBinID", "differ", "must differ from FromBinID")
+	}
+}
+
+// MoveBinStock moves units between bins, POST /bin-move with
+// {"ProductID":1,"FromBinID":2,"ToBinID":5,"Quantity":4}. Leave out FromBinID to put away
+// unlocated stock, leave out ToBinID to take units off the shelves back to unlocated.
+func MoveBinStock(w http.ResponseWriter, r *http.Request) {
+	var request binMoveRequest
+	if !utils.DecodeJSON(w, r, &request) {
+		return
+	}
+
+	tx := database.For(r).Begin()
+
+	var product models.Product
+	if tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&product, request.ProductID).Error != nil {
+		tx.Rollback()
+		utils.RespondWithError(w, http.StatusNotFound, "Product not found")
+		return
+	}
+
+	var err error
+	if request.FromBinID == nil {
+		err = putAway(tx, product, *request.ToBinID, request.Quantity, "bin_move", 0)
+	} else {
+		if tx.First(&models.Bin{}, *request.FromBinID).Error != nil {
+			err = errBinNotFound
+		}
+		if err == nil && request.ToBinID != nil && tx.First(&models.Bin{}, *request.ToBinID).Error != nil {
+			err = errBinNotFound
+		}
+		if err == nil {
+			err = changeBinStock(tx, *request.FromBinID, product.ID, -request.Quantity)
+		}
+		if err == nil && request.ToBinID != nil {
+			err = changeBinStock(tx, *request.ToBinID, product.ID, request.Quantity)
+		}
+		if err == nil {
+			err = recordBinMovement(tx, product.ID, request.FromBinID, request.ToBinID, request.Quantity, "bin_move", 0)
+		}
+	}
+	if err != nil {
+		tx.Rollback()
+		respondWithBinError(w, err)
+		return
+	}
+	tx.Commit()
+
+	result, err := loadProductBins(database.For(r), product)
+	if err != nil {
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to fetch bin stock")
+		return
+	}
+	utils.RespondWithJSON(w, http.StatusOK, result)
+}
+
+type pickListLine struct {
+	BinID    uint
+	BinCode  string
+	Zone     string
+	Aisle    string
+	Rack     string
+	Shelf    string
+	Quantity int
+}
+
+type pickList struct {
+	SalesOrderID uint
+	ProductID    uint
+	ProductName  string
+	ProductSKU   string
+	Quantity     int
+	Lines        []pickListLine // in walking order
+	Unlocated    int            // units not taken from a bin, pick them from the receiving area
+}
+
+// buildPickList lists the bins holding the units of a sales order that are still to be picked, in walking order
+func buildPickList(db *gorm.DB, salesOrder models.SalesOrder) (pickList, error) {
+	list := pickList{
+		SalesOrderID: salesOrder.ID,
+		ProductID:    *salesOrder.ProductID,
+		ProductName:  salesOrder.ProductName,
+		ProductSKU:   salesOrder.ProductSKU,
+		Quantity:     salesOrder.Quantity - salesOrder.BackorderedQuantity - salesOrder.PickedQuantity - salesOrder.ShortQuantity,
+		Lines:        []pickListLine{},
+	}
+	taken, binIDs, err := takenFromBins(db, "sales_order", salesOrder.ID)
+	if err != nil || len(binIDs) == 0 {
+		list.Unlocated = list.Quantity
+		return list, err
+	}
+
+	// what confirmed pick tasks found in a bin, or found missing, is not picked from it again
+	var done []struct {
+		BinID    uint
+		Quantity int
+	}
+	if err := db.Model(&models.PickTaskLine{}).
+		Joins("JOIN pick_tasks ON pick_tasks.id = pick_task_lines.pick_task_id").
+		Where("pick_tasks.sales_order_id = ? AND pick_tasks.status <> ? AND pick_task_lines.bin_id IS NOT NULL", salesOrder.ID, "open").
+		Select("pick_task_lines.bin_id, SUM(pick_task_lines.picked_quantity + pick_task_lines.short_quantity) AS quantity").
+		Group("pick_task_lines.bin_id").Scan(&done).Error; err != nil {
+		return list, err
+	}
+	for _, bin := range done {
+		taken[bin.BinID] -= bin.Quantity
+	}
+
+	var bins []models.Bin
+	if err := db.Where("id IN ?", binIDs).Order(walkingPath).Find(&bins).Error; err != nil {
+		return list, err
+	}
+	located := 0
+	for _, bin := range bins {
+		if taken[bin.ID] <= 0 {
+			continue
+		}
+		list.Lines = append(list.Lines, pickListLine{
+			BinID:    bin.ID,
+			BinCode:  bin.Code,
+			Zone:     bin.Zone,
+			Aisle:    bin.Aisle,
+			Rack:     bin.Rack,
+			Shelf:    bin.Shelf,
+			Quantity: taken[bin.ID],
+		})
+		located += taken[bin.ID]
+	}
+	list.Unlocated = max(list.Quantity-located, 0)
+	return list, nil
+}
+
+// GetPickList previews which bins a picker would visit for a sales order, GET /sales-order/{id}/pick-list
+func GetPickList(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	var salesOrder models.SalesOrder
+	if database.For(r).First(&salesOrder, id).Error != nil {
+		utils.RespondWithError(w, http.StatusNotFound, "Sales order not found")
+		return
+	}
+	list, err := buildPickList(database.For(r), salesOrder)
+	if err != nil {
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to build pick list")
+		return
+	}
+	utils.RespondWithJSON(w, http.StatusOK, list)
+}
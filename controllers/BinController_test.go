@@ -0,0 +1,59 @@
+package controllers_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+// Stock is put away into bins, moved between them and taken off the shelves, never more than
+// there is where it comes from.
+func TestMoveBinStock(t *testing.T) {
+	router, acme := setUp(t)
+	product := create(t, router, acme, "POST", "/add-product", `{"Name":"desk","SKU":"DESK","Price":100,"Quantity":10}`)
+	a := create(t, router, acme, "POST", "/add-bin", `{"Zone":"A","Aisle":"1"}`)
+	b := create(t, router, acme, "POST", "/add-bin", `{"Zone":"B","Aisle":"1"}`)
+
+	tests := []struct {
+		name          string
+		body          string
+		wantCode      int
+		wantA, wantB  int
+		wantUnlocated int
+	}{
+		{"put away", fmt.Sprintf(`{"ProductID":%d,"ToBinID":%d,"Quantity":6}`, product, a), http.StatusOK, 6, 0, 4},
+		{"put away more than unlocated", fmt.Sprintf(`{"ProductID":%d,"ToBinID":%d,"Quantity":5}`, product, b), http.StatusConflict, 6, 0, 4},
+		{"move between bins", fmt.Sprintf(`{"ProductID":%d,"FromBinID":%d,"ToBinID":%d,"Quantity":4}`, product, a, b), http.StatusOK, 2, 4, 4},
+		{"move more than the bin holds", fmt.Sprintf(`{"ProductID":%d,"FromBinID":%d,"ToBinID":%d,"Quantity":3}`, product, a, b), http.StatusConflict, 2, 4, 4},
+		{"move to a missing bin", fmt.Sprintf(`{"ProductID":%d,"FromBinID":%d,"ToBinID":999,"Quantity":1}`, product, a), http.StatusNotFound, 2, 4, 4},
+		{"take off the shelf", fmt.Sprintf(`{"ProductID":%d,"FromBinID":%d,"Quantity":1}`, product, b), http.StatusOK, 2, 3, 5},
+		{"same bin", fmt.Sprintf(`{"ProductID":%d,"FromBinID":%d,"ToBinID":%d,"Quantity":1}`, product, a, a), http.StatusBadRequest, 2, 3, 5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if w := acme.Do(router, "POST", "/bin-move", tt.body); w.Code != tt.wantCode {
+				t.Errorf("answered %d, want %d: %s", w.Code, tt.wantCode, w.Body)
+			}
+			var bins struct {
+				Unlocated int
+				Bins      []struct {
+					BinID    uint
+					Quantity int
+				}
+			}
+			json.Unmarshal(acme.Do(router, "GET", fmt.Sprintf("/product/%d/bins", product), "").Body.Bytes(), &bins)
+			held := map[uint]int{}
+			for _, binStock := range bins.Bins {
+				held[binStock.BinID] = binStock.Quantity
+			}
+			if held[a] != tt.wantA || held[b] != tt.wantB || bins.Unlocated != tt.wantUnlocated {
+				t.Errorf("bins hold %d and %d with %d unlocated, want %d and %d with %d", held[a], held[b], bins.Unlocated, tt.wantA, tt.wantB, tt.wantUnlocated)
+			}
+		})
+	}
+
+	if w := acme.Do(router, "DELETE", fmt.Sprintf("/delete-bin/%d", b), ""); w.Code != http.StatusConflict {
+		t.Errorf("deleting a bin with stock answered %d, want %d", w.Code, http.StatusConflict)
+	}
+}
@@ -0,0 +1,133 @@
+package controllers
+
+import (
+	"inventory-control-hub/database"
+	"inventory-control-hub/models"
+	"inventory-control-hub/utils"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+func GetCustomers(w http.ResponseWriter, r *http.Request) {
+	var customers []models.Customer
+	if database.For(r).Preload("PriceList").Find(&customers).Error != nil {
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to fetch customers")
+		return
+	}
+	utils.RespondWithJSON(w, http.StatusOK, customers)
+}
+
+func GetCustomerById(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	var customer models.Customer
+	if database.For(r).Preload("PriceList").First(&customer, id).Error != nil {
+		utils.RespondWithError(w, http.StatusNotFound, "Customer not found")
+		return
+	}
+	utils.RespondWithJSON(w, http.StatusOK, customer)
+}
+
+// customerRequest is the body of AddCustomer
+type customerRequest struct {
+	Name        string `validate:"required,maxlen=255"`
+	Email       string `validate:"maxlen=255" pattern:"^[^@\\s]+@[^@\\s]+$"`
+	Phone       string `validate:"maxlen=50"`
+	PriceListID *uint
+}
+
+// customerUpdateRequest is the body of UpdateCustomer, fields left out keep their value
+type customerUpdateRequest struct {
+	Name        string `validate:"maxlen=255"`
+	Email       string `validate:"maxlen=255" pattern:"^[^@\\s]+@[^@\\s]+$"`
+	Phone       string `validate:"maxlen=50"`
+	PriceListID *uint
+}
+
+func AddCustomer(w http.ResponseWriter, r *http.Request) {
+	var request customerRequest
+	if !utils.DecodeJSON(w, r, &request) {
+		return
+	}
+	if request.PriceListID != nil && database.For(r).First(&models.PriceList{}, *request.PriceListID).Error != nil {
+		utils.RespondWithError(w, http.StatusNotFound, "Price list not found")
+		return
+	}
+
+	customer := models.Customer{Name: request.Name, Email: request.Email, Phone: request.Phone, PriceListID: request.PriceListID}
+	if database.For(r).Create(&customer).Error != nil {
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to add customer")
+		return
+	}
+	database.For(r).Preload("PriceList").First(&customer, customer.ID)
+	utils.RespondWithJSON(w, http.StatusOK, customer)
+}
+
+func UpdateCustomer(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	var customer models.Customer
+	if database.For(r).First(&customer, id).Error != nil {
+		utils.RespondWithError(w, http.StatusNotFound, "Customer not found")
+		return
+	}
+
+	var updatedData customerUpdateRequest
+	if !utils.DecodeJSON(w, r, &updatedData) {
+		return
+	}
+
+	if updatedData.Name != "" {
+		customer.Name = updatedData.Name
+	}
+	if updatedData.Email != "" {
+		customer.Email = updatedData.Email
+	}
+	if updatedData.Phone != "" {
+		customer.Phone = updatedData.Phone
+	}
+	if updatedData.PriceListID != nil {
+		if database.For(r).First(&models.PriceList{}, *updatedData.PriceListID).Error != nil {
+			utils.RespondWithError(w, http.StatusNotFound, "Price list not found")
+			return
+		}
+		customer.PriceListID = updatedData.PriceListID
+	}
+
+	if database.For(r).Save(&customer).Error != nil {
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to update customer")
+		return
+	}
+	database.For(r).Preload("PriceList").First(&customer, customer.ID)
+	utils.RespondWithJSON(w, http.StatusOK, customer)
+}
+
+func DeleteCustomer(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	var customer models.Customer
+	if database.For(r).First(&customer, id).Error != nil {
+		utils.RespondWithError(w, http.StatusNotFound, "Customer not found")
+		return
+	}
+
+	var count int64
+	// deleted orders still point at the customer, they can be restored
+	database.For(r).Unscoped().Model(&models.SalesOrder{}).Where("customer_id = ?", customer.ID).Count(&count)
+	if count > 0 {
+		utils.RespondWithError(w, http.StatusConflict, "Cannot delete customer because sales orders exist")
+		return
+	}
+	database.For(r).Model(&models.Payment{}).Where("customer_id = ?", customer.ID).Count(&count)
+	if count > 0 {
+		utils.RespondWithError(w, http.StatusConflict, "Cannot delete customer because payments exist")
+		return
+	}
+
+	if database.For(r).Delete(&customer).Error != nil {
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to delete customer")
+		return
+	}
+	utils.RespondWithJSON(w, http.StatusOK, map[string]string{"message": "Customer deleted successfully"})
+}
@@ -0,0 +1,37 @@
+package controllers_test
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+// A customer with orders, deleted ones included, or payments can't be deleted.
+func TestDeleteCustomerInUse(t *testing.T) {
+	router, acme := setUp(t)
+	product := create(t, router, acme, "POST", "/add-product", `{"Name":"desk","SKU":"DESK","Price":100,"Quantity":5}`)
+
+	tests := []struct {
+		name     string
+		setUp    func(customer uint)
+		wantCode int
+	}{
+		{"unused", func(customer uint) {}, http.StatusOK},
+		{"deleted order", func(customer uint) {
+			salesOrder := create(t, router, acme, "POST", "/add-sales-order", fmt.Sprintf(`{"ProductID":%d,"CustomerID":%d,"Quantity":1}`, product, customer))
+			create(t, router, acme, "DELETE", fmt.Sprintf("/delete-sales-order/%d", salesOrder), "")
+		}, http.StatusConflict},
+		{"payment", func(customer uint) {
+			create(t, router, acme, "POST", "/add-payment", fmt.Sprintf(`{"CustomerID":%d,"Amount":50,"Method":"cash"}`, customer))
+		}, http.StatusConflict},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			customer := create(t, router, acme, "POST", "/add-customer", fmt.Sprintf(`{"Name":"buyer %d","Email":"buyer%d@acme.test"}`, i, i))
+			tt.setUp(customer)
+			if w := acme.Do(router, "DELETE", fmt.Sprintf("/delete-customer/%d", customer), ""); w.Code != tt.wantCode {
+				t.Errorf("answered %d, want %d: %s", w.Code, tt.wantCode, w.Body)
+			}
+		})
+	}
+}
@@ -0,0 +1,268 @@
+package controllers
+
+import (
+	"fmt"
+	"inventory-control-hub/database"
+	"inventory-control-hub/models"
+	"inventory-control-hub/utils"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// nextDocumentNumber takes the next number of a document sequence. It must run inside the
+// transaction that creates the document: the sequence row stays locked until commit, and a
+// rollback gives the number back, so numbers are gap-free.
+func nextDocumentNumber(tx *gorm.DB, name string) (uint, error) {
+	// the first document of a type starts the sequence, when two are created at once the
+	// second insert does nothing and waits for the first one's row lock below
+	if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&models.DocumentSequence{Name: name, NextNumber: 1}).Error; err != nil {
+		return 0, err
+	}
+	var sequence models.DocumentSequence
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("name = ?", name).First(&sequence).Error; err != nil {
+		return 0, err
+	}
+
+	number := sequence.NextNumber
+	if err := tx.Model(&sequence).Update("next_number", number+1).Error; err != nil {
+		return 0, err
+	}
+	return number, nil
+}
+
+// companyName is printed on generated documents, the name of the request's tenant
+func companyName(r *http.Request) string {
+	tenantID, _ := database.TenantID(r.Context())
+	var tenant models.Tenant
+	database.For(r).First(&tenant, tenantID)
+	return tenant.Name
+}
+
+// invoiceStatus works out the status from what has been paid and the due date.
+// Overdue depends on the clock, so it is refreshed whenever an invoice is read.
+func invoiceStatus(invoice models.Invoice, now time.Time) string {
+	balance := utils.RoundMoney(invoice.GrossAmount - invoice.AmountPaid)
+	switch {
+	case balance <= 0:
+		return "paid"
+	case now.After(invoice.DueDate):
+		return "overdue"
+	case invoice.AmountPaid > 0:
+		return "partially_paid"
+	default:
+		return "open"
+	}
+}
+
+func invoiceDueDays() int {
+	if days, err := strconv.Atoi(os.Getenv("INVOICE_DUE_DAYS")); err == nil && days >= 0 {
+		return days
+	}
+	return 30
+}
+
+// invoiceRequest is the optional body of CreateInvoice
+type invoiceRequest struct {
+	DueInDays *int `validate:"min=0"`
+}
+
+// CreateInvoice generates the invoice for a sales order, POST /sales-order/{id}/invoice.
+// The body is optional: {"DueInDays": 15} overrides INVOICE_DUE_DAYS.
+func CreateInvoice(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	var request invoiceRequest
+	if !utils.DecodeOptionalJSON(w, r, &request) {
+		return
+	}
+	dueDays := invoiceDueDays()
+	if request.DueInDays != nil {
+		dueDays = *request.DueInDays
+	}
+
+	var salesOrder models.SalesOrder
+	if database.For(r).Preload("Product", withDeleted).First(&salesOrder, id).Error != nil {
+		utils.RespondWithError(w, http.StatusNotFound, "Sales order not found")
+		return
+	}
+
+	var existing models.Invoice
+	if database.For(r).Where("sales_order_id = ?", salesOrder.ID).First(&existing).Error == nil {
+		utils.RespondWithError(w, http.StatusConflict, "Invoice already generated for this sales order")
+		return
+	}
+
+	// the invoice shows the product as it was sold, not as it is named today
+	description := salesOrder.ProductName
+	if description == "" {
+		description = salesOrder.Product.Name
+	}
+
+	issueDate := time.Now()
+	line := models.InvoiceLine{
+		ProductID:      salesOrder.ProductID,
+		Description:    description,
+		Quantity:       salesOrder.Quantity,
+		UnitPrice:      salesOrder.UnitPrice,
+		DiscountAmount: salesOrder.DiscountAmount,
+		NetAmount:      salesOrder.NetAmount,
+		TaxRate:        salesOrder.TaxRate,
+		TaxAmount:      salesOrder.TaxAmount,
+		GrossAmount:    salesOrder.GrossAmount,
+	}
+	invoice := models.Invoice{
+		SalesOrderID: &salesOrder.ID,
+		CustomerID:   salesOrder.CustomerID,
+		IssueDate:    issueDate,
+		DueDate:      issueDate.AddDate(0, 0, dueDays),
+		Status:       "open",
+		Lines:        []models.InvoiceLine{line},
+	}
+	for _, l := range invoice.Lines {
+		invoice.NetAmount += l.NetAmount
+		invoice.DiscountAmount += l.DiscountAmount
+		invoice.TaxAmount += l.TaxAmount
+		invoice.GrossAmount += l.GrossAmount
+	}
+	invoice.NetAmount = utils.RoundMoney(invoice.NetAmount)
+	invoice.DiscountAmount = utils.RoundMoney(invoice.DiscountAmount)
+	invoice.TaxAmount = utils.RoundMoney(invoice.TaxAmount)
+	invoice.GrossAmount = utils.RoundMoney(invoice.GrossAmount)
+
+	tx := database.For(r).Begin()
+
+	number, err := nextDocumentNumber(tx, "invoice")
+	if err != nil {
+		tx.Rollback()
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to allocate invoice number")
+		return
+	}
+	invoice.Number = fmt.Sprintf("INV-%06d", number)
+
+	if tx.Create(&invoice).Error != nil {
+		tx.Rollback()
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to create invoice")
+		return
+	}
+
+	tx.Commit()
+
+	var fullInvoice models.Invoice
+	if database.For(r).Preload("Lines").Preload("Customer").First(&fullInvoice, invoice.ID).Error != nil {
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to fetch full invoice")
+		return
+	}
+	utils.RespondWithJSON(w, http.StatusOK, fullInvoice)
+}
+
+func GetInvoices(w http.ResponseWriter, r *http.Request) {
+	var invoices []models.Invoice
+	if database.For(r).Preload("Lines").Preload("Customer").Order("id").Find(&invoices).Error != nil {
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to fetch invoices")
+		return
+	}
+	now := time.Now()
+	for i := range invoices {
+		invoices[i].Status = invoiceStatus(invoices[i], now)
+	}
+	utils.RespondWithJSON(w, http.StatusOK, invoices)
+}
+
+// GetInvoiceById returns the invoice as JSON, or as a PDF with ?format=pdf or Accept: application/pdf
+func GetInvoiceById(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	var invoice models.Invoice
+	if database.For(r).Preload("Lines").Preload("Customer").First(&invoice, id).Error != nil {
+		utils.RespondWithError(w, http.StatusNotFound, "Invoice not found")
+		return
+	}
+	invoice.Status = invoiceStatus(invoice, time.Now())
+
+	if r.URL.Query().Get("format") == "pdf" || strings.Contains(r.Header.Get("Accept"), "application/pdf") {
+		utils.RespondWithPDF(w, invoice.Number+".pdf", renderInvoicePDF(companyName(r), invoice))
+		return
+	}
+	utils.RespondWithJSON(w, http.StatusOK, invoice)
+}
+
+func renderInvoicePDF(company string, invoice models.Invoice) []byte {
+	doc := utils.NewPDFDocument()
+	left, right := 50.0, utils.PDFPageWidth-50
+	y := utils.PDFPageHeight - 60
+
+	doc.Text(left, y, 18, true, company)
+	doc.Text(right-120, y, 18, true, "INVOICE")
+	y -= 30
+	doc.Text(left, y, 10, false, "Invoice number: "+invoice.Number)
+	y -= 14
+	doc.Text(left, y, 10, false, "Issue date: "+invoice.IssueDate.Format("02 Jan 2006"))
+	y -= 14
+	doc.Text(left, y, 10, false, "Due date: "+invoice.DueDate.Format("02 Jan 2006"))
+
+	y -= 30
+	doc.Text(left, y, 11, true, "Bill to")
+	y -= 14
+	if invoice.Customer != nil {
+		doc.Text(left, y, 10, false, invoice.Customer.Name)
+		if invoice.Customer.Email != "" {
+			y -= 14
+			doc.Text(left, y, 10, false, invoice.Customer.Email)
+		}
+	} else {
+		doc.Text(left, y, 10, false, "Walk-in customer")
+	}
+
+	columns := []float64{left, 270, 320, 390, 450, 500}
+	y -= 36
+	for i, heading := range []string{"Description", "Qty", "Unit price", "Discount", "Tax", "Total"} {
+		doc.Text(columns[i], y, 10, true, heading)
+	}
+	y -= 6
+	doc.Line(left, y, right, y)
+	for _, line := range invoice.Lines {
+		y -= 16
+		if y < 80 {
+			doc.AddPage()
+			y = utils.PDFPageHeight - 60
+		}
+		doc.Text(columns[0], y, 10, false, line.Description)
+		doc.Text(columns[1], y, 10, false, strconv.Itoa(line.Quantity))
+		doc.Text(columns[2], y, 10, false, formatMoney(line.UnitPrice))
+		doc.Text(columns[3], y, 10, false, formatMoney(line.DiscountAmount))
+		doc.Text(columns[4], y, 10, false, fmt.Sprintf("%s (%g%%)", formatMoney(line.TaxAmount), line.TaxRate))
+		doc.Text(columns[5], y, 10, false, formatMoney(line.GrossAmount))
+	}
+	y -= 8
+	doc.Line(left, y, right, y)
+
+	totals := []struct {
+		label  string
+		amount float64
+	}{
+		{"Net", invoice.NetAmount},
+		{"Tax", invoice.TaxAmount},
+		{"Total", invoice.GrossAmount},
+		{"Paid", invoice.AmountPaid},
+		{"Balance due", utils.RoundMoney(invoice.GrossAmount - invoice.AmountPaid)},
+	}
+	for i, total := range totals {
+		y -= 16
+		bold := i == len(totals)-1
+		doc.Text(390, y, 10, bold, total.label)
+		doc.Text(columns[5], y, 10, bold, formatMoney(total.amount))
+	}
+
+	return doc.Bytes()
+}
+
+func formatMoney(amount float64) string {
+	return strconv.FormatFloat(amount, 'f', 2, 64)
+}
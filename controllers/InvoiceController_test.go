@@ -0,0 +1,25 @@
+package controllers_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+)
+
+// The first invoice starts the sequence, the next ones take it over.
+func TestInvoiceNumbers(t *testing.T) {
+	router, acme := setUp(t)
+	product := create(t, router, acme, "POST", "/add-product", `{"Name":"desk","SKU":"DESK","Price":100,"Quantity":5}`)
+
+	var numbers []string
+	for i := 0; i < 3; i++ {
+		salesOrder := create(t, router, acme, "POST", "/add-sales-order", fmt.Sprintf(`{"ProductID":%d,"Quantity":1}`, product))
+		w := acme.Do(router, "POST", fmt.Sprintf("/sales-order/%d/invoice", salesOrder), `{}`)
+		var invoice struct{ Number string }
+		json.Unmarshal(w.Body.Bytes(), &invoice)
+		numbers = append(numbers, invoice.Number)
+	}
+	if want := []string{"INV-000001", "INV-000002", "INV-000003"}; fmt.Sprint(numbers) != fmt.Sprint(want) {
+		t.Errorf("numbers %v, want %v", numbers, want)
+	}
+}
@@ -0,0 +1,437 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"inventory-control-hub/database"
+	"inventory-control-hub/models"
+	"inventory-control-hub/utils"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	errNotAKit          = errors.New("product has no bill of materials")
+	errComponentsShort  = errors.New("not enough component stock")
+	errKitStockShort    = errors.New("not enough kit stock")
+	errInvalidQuantity  = errors.New("quantity must be greater than 0")
+	errComponentMissing = errors.New("component not found")
+)
+
+func respondWithKitError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, errNotAKit):
+		utils.RespondWithError(w, http.StatusNotFound, "Product has no bill of materials")
+	case errors.Is(err, errComponentsShort):
+		utils.RespondWithError(w, http.StatusConflict, "Not enough component stock to assemble the kits")
+	case errors.Is(err, errKitStockShort):
+		utils.RespondWithError(w, http.StatusConflict, "Not enough kit stock to disassemble")
+	case errors.Is(err, errInvalidQuantity):
+		utils.RespondWithError(w, http.StatusBadRequest, "Quantity must be greater than 0")
+	case errors.Is(err, errComponentMissing):
+		utils.RespondWithError(w, http.StatusNotFound, "Component product not found")
+	default:
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to update kit stock")
+	}
+}
+
+// loadBOM returns the bill of materials of a kit product, nil when the product isn't a kit
+func loadBOM(db *gorm.DB, productID uint) (*models.BillOfMaterials, error) {
+	var bom models.BillOfMaterials
+	err := db.Preload("Components", func(db *gorm.DB) *gorm.DB { return db.Order("id") }).
+		Where("product_id = ?", productID).First(&bom).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &bom, nil
+}
+
+// virtualBOM returns the bill of materials of a virtual kit, nil for any other product
+func virtualBOM(db *gorm.DB, productID uint) (*models.BillOfMaterials, error) {
+	bom, err := loadBOM(db, productID)
+	if err != nil || bom == nil || !bom.Virtual {
+		return nil, err
+	}
+	return bom, nil
+}
+
+// buildableKits is how many kits the available component stock is enough for, none once a
+// component was deleted
+func buildableKits(db *gorm.DB, bom models.BillOfMaterials) (int, error) {
+	buildable := -1
+	for _, component := range bom.Components {
+		var product models.Product
+		if err := db.Unscoped().First(&product, component.ComponentID).Error; err != nil {
+			return 0, err
+		}
+		kits := 0
+		if !product.DeletedAt.Valid {
+			kits = max(availableQuantity(product), 0) / component.Quantity
+		}
+		if buildable < 0 || kits < buildable {
+			buildable = kits
+		}
+	}
+	return max(buildable, 0), nil
+}
+
+// salesAvailable is what can be promised to new sales orders. Virtual kits add what their
+// components can still build to the kits in stock.
+func salesAvailable(db *gorm.DB, product models.Product) (int, error) {
+	available := availableQuantity(product)
+	bom, err := virtualBOM(db, product.ID)
+	if err != nil || bom == nil {
+		return available, err
+	}
+	buildable, err := buildableKits(db, *bom)
+	return available + buildable, err
+}
+
+// assembleKits turns component stock into quantity kits of product and records the assembly order,
+// inside tx. A virtual kit sale passes its sales order.
+func assembleKits(tx *gorm.DB, product *models.Product, bom models.BillOfMaterials, quantity int, salesOrderID *uint, note string) (models.AssemblyOrder, error) {
+	if quantity <= 0 {
+		return models.AssemblyOrder{}, errInvalidQuantity
+	}
+	components, err := lockComponents(tx, bom)
+	if err != nil {
+		return models.AssemblyOrder{}, err
+	}
+	for i, component := range bom.Components {
+		if components[i].DeletedAt.Valid || availableQuantity(components[i]) < component.Quantity*quantity {
+			return models.AssemblyOrder{}, errComponentsShort
+		}
+	}
+
+	assemblyOrder, err := createAssemblyOrder(tx, "assembly", "ASM", product.ID, bom, quantity, salesOrderID, note)
+	if err != nil {
+		return assemblyOrder, err
+	}
+	for i, component := range bom.Components {
+		if err := moveStock(tx, &components[i], -component.Quantity*quantity, "assembly", "assembly_order", assemblyOrder.ID); err != nil {
+			return assemblyOrder, err
+		}
+	}
+	return assemblyOrder, moveStock(tx, product, quantity, "assembly", "assembly_order", assemblyOrder.ID)
+}
+
+// disassembleKits breaks quantity kits of product back into their components and records the
+// disassembly order, inside tx. The recovered components go to backorders waiting for them.
+func disassembleKits(tx *gorm.DB, product *models.Product, bom models.BillOfMaterials, quantity int, salesOrderID *uint, note string) (models.AssemblyOrder, error) {
+	if quantity <= 0 {
+		return models.AssemblyOrder{}, errInvalidQuantity
+	}
+	if availableQuantity(*product) < quantity {
+		return models.AssemblyOrder{}, errKitStockShort
+	}
+	components, err := lockComponents(tx, bom)
+	if err != nil {
+		return models.AssemblyOrder{}, err
+	}
+
+	assemblyOrder, err := createAssemblyOrder(tx, "disassembly", "DIS", product.ID, bom, quantity, salesOrderID, note)
+	if err != nil {
+		return assemblyOrder, err
+	}
+	if err := moveStock(tx, product, -quantity, "disassembly", "assembly_order", assemblyOrder.ID); err != nil {
+		return assemblyOrder, err
+	}
+	for i, component := range bom.Components {
+		if err := moveStock(tx, &components[i], component.Quantity*quantity, "disassembly", "assembly_order", assemblyOrder.ID); err != nil {
+			return assemblyOrder, err
+		}
+		if err := allocateBackorders(tx, &components[i], "assembly_order", assemblyOrder.ID); err != nil {
+			return assemblyOrder, err
+		}
+	}
+	return assemblyOrder, nil
+}
+
+// lockComponents locks the component products of a kit, in the order of bom.Components
+func lockComponents(tx *gorm.DB, bom models.BillOfMaterials) ([]models.Product, error) {
+	components := make([]models.Product, len(bom.Components))
+	for i, component := range bom.Components {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Unscoped().First(&components[i], component.ComponentID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil, errComponentMissing
+			}
+			return nil, err
+		}
+	}
+	return components, nil
+}
+
+func createAssemblyOrder(tx *gorm.DB, orderType string, prefix string, productID uint, bom models.BillOfMaterials, quantity int, salesOrderID *uint, note string) (models.AssemblyOrder, error) {
+	number, err := nextDocumentNumber(tx, orderType+"_order")
+	if err != nil {
+		return models.AssemblyOrder{}, err
+	}
+	assemblyOrder := models.AssemblyOrder{
+		Number:       fmt.Sprintf("%s-%06d", prefix, number),
+		Type:         orderType,
+		ProductID:    &productID,
+		Quantity:     quantity,
+		SalesOrderID: salesOrderID,
+		Note:         note,
+	}
+	for _, component := range bom.Components {
+		assemblyOrder.Lines = append(assemblyOrder.Lines, models.AssemblyLine{ComponentID: component.ComponentID, Quantity: component.Quantity * quantity})
+	}
+	return assemblyOrder, tx.Create(&assemblyOrder).Error
+}
+
+// virtualKitsUsing lists the virtual kit products a product is a component of
+func virtualKitsUsing(db *gorm.DB, productID uint) ([]uint, error) {
+	var kitIDs []uint
+	err := db.Model(&models.BillOfMaterials{}).
+		Joins("JOIN bom_components ON bom_components.bill_of_materials_id = bill_of_materials.id").
+		Where("bom_components.component_id = ? AND bill_of_materials.is_virtual = ?", productID, true).
+		Order("bill_of_materials.product_id").
+		Pluck("bill_of_materials.product_id", &kitIDs).Error
+	return kitIDs, err
+}
+
+type kitAvailability struct {
+	models.BillOfMaterials
+	InStock   int // kits built and not reserved
+	Buildable int // kits the available component stock is enough for
+}
+
+func GetBOMs(w http.ResponseWriter, r *http.Request) {
+	var boms []models.BillOfMaterials
+	if database.For(r).Preload("Components").Order("id").Find(&boms).Error != nil {
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to fetch bills of materials")
+		return
+	}
+	utils.RespondWithJSON(w, http.StatusOK, boms)
+}
+
+// GetProductBOM shows a kit's components and how many kits can be sold, GET /product/{id}/bom
+func GetProductBOM(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	var product models.Product
+	if database.For(r).First(&product, id).Error != nil {
+		utils.RespondWithError(w, http.StatusNotFound, "Product not found")
+		return
+	}
+	bom, err := loadBOM(database.For(r).Preload("Components.Component"), product.ID)
+	if err != nil {
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to fetch bill of materials")
+		return
+	}
+	if bom == nil {
+		respondWithKitError(w, errNotAKit)
+		return
+	}
+
+	buildable, err := buildableKits(database.For(r), *bom)
+	if err != nil {
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to check component stock")
+		return
+	}
+	utils.RespondWithJSON(w, http.StatusOK, kitAvailability{BillOfMaterials: *bom, InStock: availableQuantity(product), Buildable: buildable})
+}
+
+// bomRequest is the body of SetProductBOM
+type bomRequest struct {
+	Virtual    bool
+	Components []struct {
+		ComponentID *uint `validate:"required"`
+		Quantity    int   `validate:"min=1"`
+	} `validate:"required"`
+}
+
+func (request bomRequest) Validate(errs *utils.FieldErrors) {
+	seen := map[uint]bool{}
+	for i, component := range request.Components {
+		if component.ComponentID == nil {
+			continue
+		}
+		if seen[*component.ComponentID] {
+			errs.Add(fmt.Sprintf("Components[%d].ComponentID", i), "unique", "can be listed only once")
+		}
+		seen[*component.ComponentID] = true
+	}
+}
+
+// SetProductBOM defines or replaces the components of a kit, PUT /product/{id}/bom with
+// {"Virtual":true,"Components":[{"ComponentID":2,"Quantity":1},{"ComponentID":3,"Quantity":4}]}.
+// Components can't be kits themselves and units can't be tracked on either side.
+func SetProductBOM(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	var request bomRequest
+	if !utils.DecodeJSON(w, r, &request) {
+		return
+	}
+
+	var product models.Product
+	if database.For(r).First(&product, id).Error != nil {
+		utils.RespondWithError(w, http.StatusNotFound, "Product not found")
+		return
+	}
+	if tracksUnits(product) {
+		utils.RespondWithError(w, http.StatusBadRequest, "Lot-tracked and serialized products cannot be kits")
+		return
+	}
+	var usedAsComponent int64
+	database.For(r).Model(&models.BOMComponent{}).Where("component_id = ?", product.ID).Count(&usedAsComponent)
+	if usedAsComponent > 0 {
+		utils.RespondWithError(w, http.StatusConflict, "Product is a component of another kit and cannot be a kit itself")
+		return
+	}
+
+	var components []models.BOMComponent
+	for _, component := range request.Components {
+		if *component.ComponentID == product.ID {
+			utils.RespondWithError(w, http.StatusBadRequest, "A kit cannot contain itself")
+			return
+		}
+
+		var componentProduct models.Product
+		if database.For(r).First(&componentProduct, component.ComponentID).Error != nil {
+			respondWithKitError(w, errComponentMissing)
+			return
+		}
+		if tracksUnits(componentProduct) {
+			utils.RespondWithError(w, http.StatusBadRequest, "Lot-tracked and serialized products cannot be components")
+			return
+		}
+		if bom, err := loadBOM(database.For(r), componentProduct.ID); err != nil || bom != nil {
+			utils.RespondWithError(w, http.StatusBadRequest, fmt.Sprintf("Component %d is a kit itself", componentProduct.ID))
+			return
+		}
+		components = append(components, models.BOMComponent{ComponentID: component.ComponentID, Quantity: component.Quantity})
+	}
+
+	tx := database.For(r).Begin()
+
+	existing, err := loadBOM(tx, product.ID)
+	if err == nil && existing != nil {
+		err = tx.Select("Components").Delete(existing).Error
+	}
+	if err != nil {
+		tx.Rollback()
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to replace bill of materials")
+		return
+	}
+
+	bom := models.BillOfMaterials{ProductID: &product.ID, Virtual: request.Virtual, Components: components}
+	if tx.Create(&bom).Error != nil {
+		tx.Rollback()
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to save bill of materials")
+		return
+	}
+
+	tx.Commit()
+	utils.RespondWithJSON(w, http.StatusOK, bom)
+}
+
+// DeleteProductBOM turns a kit back into a plain product, kits in stock stay in stock, DELETE /product/{id}/bom
+func DeleteProductBOM(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	var bom models.BillOfMaterials
+	if database.For(r).Where("product_id = ?", id).First(&bom).Error != nil {
+		respondWithKitError(w, errNotAKit)
+		return
+	}
+	if database.For(r).Select("Components").Delete(&bom).Error != nil {
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to delete bill of materials")
+		return
+	}
+	utils.RespondWithJSON(w, http.StatusOK, "Bill of materials deleted successfully")
+}
+
+func GetAssemblyOrders(w http.ResponseWriter, r *http.Request) {
+	var assemblyOrders []models.AssemblyOrder
+	query := database.For(r).Preload("Lines").Order("id")
+	if product := r.URL.Query().Get("product"); product != "" {
+		query = query.Where("product_id = ?", product)
+	}
+	if orderType := r.URL.Query().Get("type"); orderType != "" {
+		query = query.Where("type = ?", orderType)
+	}
+	if salesOrder := r.URL.Query().Get("sales_order"); salesOrder != "" {
+		query = query.Where("sales_order_id = ?", salesOrder)
+	}
+	if query.Find(&assemblyOrders).Error != nil {
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to fetch assembly orders")
+		return
+	}
+	utils.RespondWithJSON(w, http.StatusOK, assemblyOrders)
+}
+
+func GetAssemblyOrderById(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	var assemblyOrder models.AssemblyOrder
+	if database.For(r).Preload("Lines").First(&assemblyOrder, id).Error != nil {
+		utils.RespondWithError(w, http.StatusNotFound, "Assembly order not found")
+		return
+	}
+	utils.RespondWithJSON(w, http.StatusOK, assemblyOrder)
+}
+
+// CreateAssemblyOrder builds kits from components, POST /assembly-order with {"ProductID":1,"Quantity":5}
+func CreateAssemblyOrder(w http.ResponseWriter, r *http.Request) {
+	convertKits(w, r, assembleKits)
+}
+
+// CreateDisassemblyOrder breaks kits back into components, POST /disassembly-order with {"ProductID":1,"Quantity":2}
+func CreateDisassemblyOrder(w http.ResponseWriter, r *http.Request) {
+	convertKits(w, r, disassembleKits)
+}
+
+// assemblyRequest is the body of CreateAssemblyOrder and CreateDisassemblyOrder
+type assemblyRequest struct {
+	ProductID *uint `validate:"required"`
+	Quantity  int   `validate:"min=1"`
+	Note      string
+}
+
+// convertKits runs an assembly or disassembly in one transaction, either all stock moves happen or none
+func convertKits(w http.ResponseWriter, r *http.Request, convert func(*gorm.DB, *models.Product, models.BillOfMaterials, int, *uint, string) (models.AssemblyOrder, error)) {
+	var request assemblyRequest
+	if !utils.DecodeJSON(w, r, &request) {
+		return
+	}
+
+	tx := database.For(r).Begin()
+
+	var product models.Product
+	if tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&product, request.ProductID).Error != nil {
+		tx.Rollback()
+		utils.RespondWithError(w, http.StatusNotFound, "Product not found")
+		return
+	}
+	bom, err := loadBOM(tx, product.ID)
+	if err == nil && bom == nil {
+		err = errNotAKit
+	}
+	if err != nil {
+		tx.Rollback()
+		respondWithKitError(w, err)
+		return
+	}
+
+	assemblyOrder, err := convert(tx, &product, *bom, request.Quantity, nil, request.Note)
+	if err == nil && assemblyOrder.Type == "assembly" {
+		err = allocateBackorders(tx, &product, "assembly_order", assemblyOrder.ID)
+	}
+	if err != nil {
+		tx.Rollback()
+		respondWithKitError(w, err)
+		return
+	}
+
+	tx.Commit()
+	utils.RespondWithJSON(w, http.StatusOK, assemblyOrder)
+}
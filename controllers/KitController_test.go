@@ -0,0 +1,68 @@
+package controllers_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+// Kits are assembled from and broken back into their components in one go, a virtual kit is
+// assembled when it is sold and taken apart again when the sale goes.
+func TestKitStock(t *testing.T) {
+	router, acme := setUp(t)
+	legs := create(t, router, acme, "POST", "/add-product", `{"Name":"leg","SKU":"LEG","Price":10,"Quantity":10}`)
+	tops := create(t, router, acme, "POST", "/add-product", `{"Name":"top","SKU":"TOP","Price":50,"Quantity":3}`)
+	kit := create(t, router, acme, "POST", "/add-product", `{"Name":"table kit","SKU":"TABLE","Price":90}`)
+	bom := fmt.Sprintf(`"Components":[{"ComponentID":%d,"Quantity":2},{"ComponentID":%d,"Quantity":1}]`, legs, tops)
+	create(t, router, acme, "PUT", fmt.Sprintf("/product/%d/bom", kit), "{"+bom+"}")
+	var salesOrder uint
+
+	tests := []struct {
+		name                   string
+		do                     func() int
+		wantCode               int
+		wantLegs, wantTops     int
+		wantKits, wantReserved int
+	}{
+		{"assemble", func() int {
+			return acme.Do(router, "POST", "/assembly-order", fmt.Sprintf(`{"ProductID":%d,"Quantity":2}`, kit)).Code
+		}, http.StatusOK, 6, 1, 2, 0},
+		{"assemble past the tops", func() int {
+			return acme.Do(router, "POST", "/assembly-order", fmt.Sprintf(`{"ProductID":%d,"Quantity":2}`, kit)).Code
+		}, http.StatusConflict, 6, 1, 2, 0},
+		{"disassemble", func() int {
+			return acme.Do(router, "POST", "/disassembly-order", fmt.Sprintf(`{"ProductID":%d,"Quantity":1}`, kit)).Code
+		}, http.StatusOK, 8, 2, 1, 0},
+		{"disassemble more than built", func() int {
+			return acme.Do(router, "POST", "/disassembly-order", fmt.Sprintf(`{"ProductID":%d,"Quantity":2}`, kit)).Code
+		}, http.StatusConflict, 8, 2, 1, 0},
+		{"sell as a virtual kit", func() int {
+			create(t, router, acme, "PUT", fmt.Sprintf("/product/%d/bom", kit), `{"Virtual":true,`+bom+"}")
+			w := acme.Do(router, "POST", "/add-sales-order", fmt.Sprintf(`{"ProductID":%d,"Quantity":3}`, kit))
+			var order struct{ ID uint }
+			json.Unmarshal(w.Body.Bytes(), &order)
+			salesOrder = order.ID
+			return w.Code
+		}, http.StatusOK, 4, 0, 3, 3},
+		{"sell past the components", func() int {
+			return acme.Do(router, "POST", "/add-sales-order", fmt.Sprintf(`{"ProductID":%d,"Quantity":1}`, kit)).Code
+		}, http.StatusBadRequest, 4, 0, 3, 3},
+		{"delete the sale", func() int {
+			return acme.Do(router, "DELETE", fmt.Sprintf("/delete-sales-order/%d", salesOrder), "").Code
+		}, http.StatusOK, 10, 3, 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if code := tt.do(); code != tt.wantCode {
+				t.Errorf("answered %d, want %d", code, tt.wantCode)
+			}
+			got := []int{stock(t, acme, legs).Quantity, stock(t, acme, tops).Quantity, stock(t, acme, kit).Quantity, stock(t, acme, kit).ReservedQuantity}
+			want := []int{tt.wantLegs, tt.wantTops, tt.wantKits, tt.wantReserved}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("legs, tops, kits and reserved kits are %v, want %v", got, want)
+			}
+		})
+	}
+}
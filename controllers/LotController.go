@@ -0,0 +1,318 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"inventory-control-hub/database"
+	"inventory-control-hub/models"
+	"inventory-control-hub/utils"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	errLotNumberRequired = errors.New("lot number required")
+	errLotNotFound       = errors.New("lot not found")
+	errLotExpired        = errors.New("lot expired")
+	errLotInsufficient   = errors.New("not enough stock in lots")
+)
+
+func respondWithLotError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, errLotNumberRequired):
+		utils.RespondWithError(w, http.StatusBadRequest, "LotNumber is required for lot-tracked products")
+	case errors.Is(err, errLotNotFound):
+		utils.RespondWithError(w, http.StatusNotFound, "Lot not found for this product")
+	case errors.Is(err, errLotExpired):
+		utils.RespondWithError(w, http.StatusConflict, "Lot has expired and cannot be sold")
+	case errors.Is(err, errLotInsufficient):
+		utils.RespondWithError(w, http.StatusBadRequest, "Not enough unexpired stock in lots")
+	default:
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to update lots")
+	}
+}
+
+// receiveLot books received goods into their lot, creating it on first receipt.
+// Receiving the same lot number again tops it up.
+func receiveLot(tx *gorm.DB, product models.Product, receipt *models.PurchaseReceipt) error {
+	if receipt.LotNumber == "" {
+		return errLotNumberRequired
+	}
+
+	var lot models.Lot
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("product_id = ? AND lot_number = ?", product.ID, receipt.LotNumber).First(&lot).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		lot = models.Lot{
+			ProductID:       &product.ID,
+			LotNumber:       receipt.LotNumber,
+			ManufactureDate: receipt.ManufactureDate,
+			ExpiryDate:      receipt.ExpiryDate,
+		}
+		err = tx.Omit("Product").Create(&lot).Error
+	}
+	if err != nil {
+		return err
+	}
+
+	lot.ReceivedQuantity += receipt.Quantity
+	lot.Quantity += receipt.Quantity
+	if err := tx.Model(&lot).Updates(map[string]interface{}{"received_quantity": lot.ReceivedQuantity, "quantity": lot.Quantity}).Error; err != nil {
+		return err
+	}
+	receipt.LotID = &lot.ID
+	return nil
+}
+
+// allocateLots takes a sales order's units out of lots inside tx. Requested allocations are
+// honoured as given, otherwise units come first-expired-first-out; expired lots are never used.
+func allocateLots(tx *gorm.DB, salesOrder models.SalesOrder, requested []models.LotAllocation, now time.Time) error {
+	var allocations []models.LotAllocation
+
+	if len(requested) > 0 {
+		total := 0
+		for _, allocation := range requested {
+			if allocation.LotID == nil || allocation.Quantity <= 0 {
+				return errLotNotFound
+			}
+			var lot models.Lot
+			if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+				Where("product_id = ?", salesOrder.ProductID).First(&lot, *allocation.LotID).Error; err != nil {
+				if errors.Is(err, gorm.ErrRecordNotFound) {
+					return errLotNotFound
+				}
+				return err
+			}
+			if lot.ExpiryDate != nil && lot.ExpiryDate.Before(now) {
+				return errLotExpired
+			}
+			if allocation.Quantity > lot.Quantity {
+				return errLotInsufficient
+			}
+			total += allocation.Quantity
+			allocations = append(allocations, models.LotAllocation{LotID: &lot.ID, Quantity: allocation.Quantity})
+		}
+		if total != salesOrder.Quantity {
+			return errLotInsufficient
+		}
+	} else {
+		var lots []models.Lot
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("product_id = ? AND quantity > 0 AND (expiry_date IS NULL OR expiry_date >= ?)", salesOrder.ProductID, now).
+			Order("CASE WHEN expiry_date IS NULL THEN 1 ELSE 0 END, expiry_date, id").
+			Find(&lots).Error; err != nil {
+			return err
+		}
+		remaining := salesOrder.Quantity
+		for _, lot := range lots {
+			if remaining == 0 {
+				break
+			}
+			take := min(remaining, lot.Quantity)
+			allocations = append(allocations, models.LotAllocation{LotID: &lot.ID, Quantity: take})
+			remaining -= take
+		}
+		if remaining > 0 {
+			return errLotInsufficient
+		}
+	}
+
+	for _, allocation := range allocations {
+		allocation.SalesOrderID = &salesOrder.ID
+		if err := tx.Create(&allocation).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&models.Lot{}).Where("id = ?", *allocation.LotID).
+			UpdateColumn("quantity", gorm.Expr("quantity - ?", allocation.Quantity)).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// releaseLots puts a sales order's units back into the lots they came from and drops the allocations
+func releaseLots(tx *gorm.DB, salesOrderID uint) error {
+	var allocations []models.LotAllocation
+	if err := tx.Where("sales_order_id = ?", salesOrderID).Find(&allocations).Error; err != nil {
+		return err
+	}
+	for _, allocation := range allocations {
+		if err := tx.Model(&models.Lot{}).Where("id = ?", *allocation.LotID).
+			UpdateColumn("quantity", gorm.Expr("quantity + ?", allocation.Quantity-allocation.ReturnedQuantity)).Error; err != nil {
+			return err
+		}
+	}
+	return tx.Where("sales_order_id = ?", salesOrderID).Delete(&models.LotAllocation{}).Error
+}
+
+// restockLots puts returned units back into the lots the order was allocated from
+func restockLots(tx *gorm.DB, salesOrderID uint, quantity int) error {
+	var allocations []models.LotAllocation
+	if err := tx.Where("sales_order_id = ? AND returned_quantity < quantity", salesOrderID).Order("id").Find(&allocations).Error; err != nil {
+		return err
+	}
+	for _, allocation := range allocations {
+		if quantity == 0 {
+			break
+		}
+		back := min(quantity, allocation.Quantity-allocation.ReturnedQuantity)
+		if err := tx.Model(&allocation).UpdateColumn("returned_quantity", allocation.ReturnedQuantity+back).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&models.Lot{}).Where("id = ?", *allocation.LotID).
+			UpdateColumn("quantity", gorm.Expr("quantity + ?", back)).Error; err != nil {
+			return err
+		}
+		quantity -= back
+	}
+	return nil
+}
+
+// moveReceiptLots adds (sign 1) or takes back out (sign -1) what a purchase order's receipts
+// booked into lots, used when the order is deleted or restored
+func moveReceiptLots(tx *gorm.DB, purchaseOrderID uint, sign int) error {
+	var receipts []models.PurchaseReceipt
+	if err := tx.Where("purchase_order_id = ? AND lot_id IS NOT NULL", purchaseOrderID).Find(&receipts).Error; err != nil {
+		return err
+	}
+	for _, receipt := range receipts {
+		if err := tx.Model(&models.Lot{}).Where("id = ?", *receipt.LotID).
+			UpdateColumn("quantity", gorm.Expr("quantity + ?", sign*receipt.Quantity)).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseWithin reads a window like "30d", "2w" or a plain number of days
+func parseWithin(value string) (time.Duration, error) {
+	unit := 24 * time.Hour
+	switch {
+	case strings.HasSuffix(value, "d"):
+		value = strings.TrimSuffix(value, "d")
+	case strings.HasSuffix(value, "w"):
+		value = strings.TrimSuffix(value, "w")
+		unit *= 7
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid window %q", value)
+	}
+	return time.Duration(n) * unit, nil
+}
+
+func GetLots(w http.ResponseWriter, r *http.Request) {
+	var lots []models.Lot
+	query := database.For(r).Order("id")
+	if product := r.URL.Query().Get("product"); product != "" {
+		query = query.Where("product_id = ?", product)
+	}
+	if query.Find(&lots).Error != nil {
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to fetch lots")
+		return
+	}
+	utils.RespondWithJSON(w, http.StatusOK, lots)
+}
+
+func GetLotById(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	var lot models.Lot
+	if database.For(r).Preload("Product", withDeleted).First(&lot, id).Error != nil {
+		utils.RespondWithError(w, http.StatusNotFound, "Lot not found")
+		return
+	}
+	utils.RespondWithJSON(w, http.StatusOK, lot)
+}
+
+// GetExpiringLots lists lots with stock left that expire within the window, GET /lots/expiring?within=30d.
+// Lots that already expired are included, they need to be written off.
+func GetExpiringLots(w http.ResponseWriter, r *http.Request) {
+	within := 30 * 24 * time.Hour
+	if value := r.URL.Query().Get("within"); value != "" {
+		parsed, err := parseWithin(value)
+		if err != nil {
+			utils.RespondWithError(w, http.StatusBadRequest, "within must look like 30d, 2w or a number of days")
+			return
+		}
+		within = parsed
+	}
+
+	var lots []models.Lot
+	if database.For(r).Preload("Product", withDeleted).
+		Where("quantity > 0 AND expiry_date IS NOT NULL AND expiry_date <= ?", time.Now().Add(within)).
+		Order("expiry_date").Find(&lots).Error != nil {
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to fetch lots")
+		return
+	}
+	utils.RespondWithJSON(w, http.StatusOK, lots)
+}
+
+type lotShipment struct {
+	SalesOrderID     uint
+	OrderDate        time.Time
+	CustomerID       *uint
+	CustomerName     string
+	CustomerEmail    string
+	CustomerPhone    string
+	Quantity         int
+	ReturnedQuantity int
+}
+
+type lotTrace struct {
+	Lot       models.Lot
+	Receipts  []models.PurchaseReceipt
+	Customers []lotShipment
+}
+
+// GetLotTrace is for recalls, GET /lot/{id}/trace lists where the lot came from and every
+// customer who received units of it
+func GetLotTrace(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	var trace lotTrace
+	if database.For(r).Preload("Product", withDeleted).First(&trace.Lot, id).Error != nil {
+		utils.RespondWithError(w, http.StatusNotFound, "Lot not found")
+		return
+	}
+	if database.For(r).Where("lot_id = ?", trace.Lot.ID).Order("received_date").Find(&trace.Receipts).Error != nil {
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to fetch receipts")
+		return
+	}
+
+	var allocations []models.LotAllocation
+	if database.For(r).Where("lot_id = ?", trace.Lot.ID).Order("id").Find(&allocations).Error != nil {
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to fetch lot allocations")
+		return
+	}
+
+	trace.Customers = []lotShipment{}
+	for _, allocation := range allocations {
+		var salesOrder models.SalesOrder
+		if database.For(r).Preload("Customer").First(&salesOrder, *allocation.SalesOrderID).Error != nil {
+			continue
+		}
+		shipment := lotShipment{
+			SalesOrderID:     salesOrder.ID,
+			OrderDate:        salesOrder.OrderDate,
+			CustomerID:       salesOrder.CustomerID,
+			CustomerName:     "Walk-in customer",
+			Quantity:         allocation.Quantity,
+			ReturnedQuantity: allocation.ReturnedQuantity,
+		}
+		if salesOrder.Customer != nil {
+			shipment.CustomerName = salesOrder.Customer.Name
+			shipment.CustomerEmail = salesOrder.Customer.Email
+			shipment.CustomerPhone = salesOrder.Customer.Phone
+		}
+		trace.Customers = append(trace.Customers, shipment)
+	}
+
+	utils.RespondWithJSON(w, http.StatusOK, trace)
+}
@@ -0,0 +1,210 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"inventory-control-hub/database"
+	"inventory-control-hub/models"
+	"inventory-control-hub/utils"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// paymentRequest is the body of AddPayment
+type paymentRequest struct {
+	CustomerID  *uint
+	Amount      float64
+	Method      string `validate:"required,enum=cash|card|bank_transfer|cheque|other"`
+	Reference   string `validate:"maxlen=255"`
+	PaymentDate time.Time
+	Allocations []allocationRequest
+}
+
+func (request paymentRequest) Validate(errs *utils.FieldErrors) {
+	if request.Amount <= 0 {
+		errs.Add("Amount", "min", "must be greater than 0")
+	}
+}
+
+// allocationRequest applies part of a payment to an invoice
+type allocationRequest struct {
+	InvoiceID *uint `validate:"required"`
+	Amount    float64
+}
+
+func (request allocationRequest) Validate(errs *utils.FieldErrors) {
+	if request.Amount <= 0 {
+		errs.Add("Amount", "min", "must be greater than 0")
+	}
+}
+
+// allocationError carries a message and status for the response, so the caller doesn't need a switch
+type allocationError struct {
+	code    int
+	message string
+}
+
+func (e *allocationError) Error() string { return e.message }
+
+// applyAllocations applies parts of a payment to invoices inside tx. Invoices are locked
+// first so two payments against the same invoice can't both see the old balance.
+func applyAllocations(tx *gorm.DB, payment *models.Payment, allocations []allocationRequest) error {
+	total := 0.0
+	for _, allocation := range allocations {
+		total += allocation.Amount
+	}
+	if utils.RoundMoney(total) > utils.RoundMoney(payment.UnallocatedAmount) {
+		return &allocationError{http.StatusBadRequest, "Allocations exceed the unallocated amount of the payment"}
+	}
+
+	now := time.Now()
+	for _, allocation := range allocations {
+		var invoice models.Invoice
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&invoice, *allocation.InvoiceID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return &allocationError{http.StatusNotFound, fmt.Sprintf("Invoice %d not found", *allocation.InvoiceID)}
+			}
+			return err
+		}
+		if payment.CustomerID != nil && (invoice.CustomerID == nil || *invoice.CustomerID != *payment.CustomerID) {
+			return &allocationError{http.StatusConflict, fmt.Sprintf("Invoice %s belongs to a different customer", invoice.Number)}
+		}
+
+		balance := utils.RoundMoney(invoice.GrossAmount - invoice.AmountPaid)
+		if utils.RoundMoney(allocation.Amount) > balance {
+			return &allocationError{http.StatusConflict, fmt.Sprintf("Allocation exceeds the balance of invoice %s (%s)", invoice.Number, formatMoney(balance))}
+		}
+
+		invoice.AmountPaid = utils.RoundMoney(invoice.AmountPaid + allocation.Amount)
+		invoice.Status = invoiceStatus(invoice, now)
+		if err := tx.Model(&invoice).Updates(map[string]interface{}{"amount_paid": invoice.AmountPaid, "status": invoice.Status}).Error; err != nil {
+			return err
+		}
+
+		record := models.PaymentAllocation{PaymentID: &payment.ID, InvoiceID: allocation.InvoiceID, Amount: utils.RoundMoney(allocation.Amount)}
+		if err := tx.Create(&record).Error; err != nil {
+			return err
+		}
+	}
+
+	payment.UnallocatedAmount = utils.RoundMoney(payment.UnallocatedAmount - total)
+	return tx.Model(payment).Update("unallocated_amount", payment.UnallocatedAmount).Error
+}
+
+func respondWithAllocationError(w http.ResponseWriter, err error) {
+	var allocErr *allocationError
+	if errors.As(err, &allocErr) {
+		utils.RespondWithError(w, allocErr.code, allocErr.message)
+		return
+	}
+	utils.RespondWithError(w, http.StatusInternalServerError, "Failed to apply payment")
+}
+
+func GetPayments(w http.ResponseWriter, r *http.Request) {
+	var payments []models.Payment
+	query := database.For(r).Preload("Customer").Preload("Allocations")
+	if customer := r.URL.Query().Get("customer"); customer != "" {
+		query = query.Where("customer_id = ?", customer)
+	}
+	if query.Order("payment_date").Find(&payments).Error != nil {
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to fetch payments")
+		return
+	}
+	utils.RespondWithJSON(w, http.StatusOK, payments)
+}
+
+func GetPaymentById(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	var payment models.Payment
+	if database.For(r).Preload("Customer").Preload("Allocations.Invoice").First(&payment, id).Error != nil {
+		utils.RespondWithError(w, http.StatusNotFound, "Payment not found")
+		return
+	}
+	utils.RespondWithJSON(w, http.StatusOK, payment)
+}
+
+// AddPayment records a payment and applies its Allocations, e.g.
+// {"CustomerID":1,"Amount":500,"Method":"bank_transfer","Allocations":[{"InvoiceID":3,"Amount":300}]}
+func AddPayment(w http.ResponseWriter, r *http.Request) {
+	var request paymentRequest
+	if !utils.DecodeJSON(w, r, &request) {
+		return
+	}
+	if request.CustomerID != nil && database.For(r).First(&models.Customer{}, *request.CustomerID).Error != nil {
+		utils.RespondWithError(w, http.StatusNotFound, "Customer not found")
+		return
+	}
+	payment := models.Payment{
+		CustomerID:  request.CustomerID,
+		Amount:      utils.RoundMoney(request.Amount),
+		Method:      request.Method,
+		Reference:   request.Reference,
+		PaymentDate: request.PaymentDate,
+	}
+	if payment.PaymentDate.IsZero() {
+		payment.PaymentDate = time.Now()
+	}
+	payment.UnallocatedAmount = payment.Amount
+	allocations := request.Allocations
+
+	tx := database.For(r).Begin()
+	if tx.Create(&payment).Error != nil {
+		tx.Rollback()
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to record payment")
+		return
+	}
+	if err := applyAllocations(tx, &payment, allocations); err != nil {
+		tx.Rollback()
+		respondWithAllocationError(w, err)
+		return
+	}
+	tx.Commit()
+
+	var fullPayment models.Payment
+	if database.For(r).Preload("Customer").Preload("Allocations.Invoice").First(&fullPayment, payment.ID).Error != nil {
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to fetch full payment")
+		return
+	}
+	utils.RespondWithJSON(w, http.StatusOK, fullPayment)
+}
+
+// AllocatePayment applies the unallocated part of an earlier payment, body is a list of allocations
+func AllocatePayment(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	var allocations []allocationRequest
+	if !utils.DecodeJSON(w, r, &allocations) {
+		return
+	}
+	if len(allocations) == 0 {
+		utils.RespondWithError(w, http.StatusBadRequest, "Invalid input, expected a list of allocations")
+		return
+	}
+
+	tx := database.For(r).Begin()
+
+	var payment models.Payment
+	if tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&payment, id).Error != nil {
+		tx.Rollback()
+		utils.RespondWithError(w, http.StatusNotFound, "Payment not found")
+		return
+	}
+	if err := applyAllocations(tx, &payment, allocations); err != nil {
+		tx.Rollback()
+		respondWithAllocationError(w, err)
+		return
+	}
+	tx.Commit()
+
+	var fullPayment models.Payment
+	if database.For(r).Preload("Customer").Preload("Allocations.Invoice").First(&fullPayment, payment.ID).Error != nil {
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to fetch full payment")
+		return
+	}
+	utils.RespondWithJSON(w, http.StatusOK, fullPayment)
+}
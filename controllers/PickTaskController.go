@@ -0,0 +1,293 @@
+package controllers
+
+import (
+	"fmt"
+	"inventory-control-hub/database"
+	"inventory-control-hub/models"
+	"inventory-control-hub/utils"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// salesOrderStatus works out how far a sales order got through picking, packing and shipping
+func salesOrderStatus(salesOrder models.SalesOrder, picking bool) string {
+	toShip := salesOrder.Quantity - salesOrder.ShortQuantity
+	switch {
+	case salesOrder.ShippedQuantity > 0 && salesOrder.ShippedQuantity >= toShip:
+		return "shipped"
+	case salesOrder.ShippedQuantity > 0:
+		return "partially_shipped"
+	case salesOrder.PackedQuantity > 0 && salesOrder.PackedQuantity >= toShip:
+		return "packed"
+	case salesOrder.PackedQuantity > 0:
+		return "partially_packed"
+	case picking:
+		return "picking"
+	case salesOrder.PickedQuantity >= toShip && salesOrder.ShortQuantity > 0:
+		return "short_picked"
+	case salesOrder.PickedQuantity >= toShip:
+		return "picked"
+	case salesOrder.PickedQuantity > 0:
+		return "partially_picked"
+	case salesOrder.BackorderedQuantity > 0:
+		return "backordered"
+	default:
+		return "confirmed"
+	}
+}
+
+// hasPickTasks reports whether picking started on the order, its quantity is fixed from then on
+func hasPickTasks(db *gorm.DB, salesOrderID uint) bool {
+	var count int64
+	db.Model(&models.PickTask{}).Where("sales_order_id = ?", salesOrderID).Count(&count)
+	return count > 0
+}
+
+func GetPickTasks(w http.ResponseWriter, r *http.Request) {
+	var pickTasks []models.PickTask
+	query := database.For(r).Preload("Lines").Order("id")
+	if salesOrder := r.URL.Query().Get("sales_order"); salesOrder != "" {
+		query = query.Where("sales_order_id = ?", salesOrder)
+	}
+	if status := r.URL.Query().Get("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+	if query.Find(&pickTasks).Error != nil {
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to fetch pick tasks")
+		return
+	}
+	utils.RespondWithJSON(w, http.StatusOK, pickTasks)
+}
+
+func GetPickTaskById(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	var pickTask models.PickTask
+	if database.For(r).Preload("Lines").First(&pickTask, id).Error != nil {
+		utils.RespondWithError(w, http.StatusNotFound, "Pick task not found")
+		return
+	}
+	utils.RespondWithJSON(w, http.StatusOK, pickTask)
+}
+
+// CreatePickTask turns the pick list of a sales order into a task for a picker,
+// POST /sales-order/{id}/pick-list. An order has at most one open task.
+func CreatePickTask(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	tx := database.For(r).Begin()
+
+	var salesOrder models.SalesOrder
+	if tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&salesOrder, id).Error != nil {
+		tx.Rollback()
+		utils.RespondWithError(w, http.StatusNotFound, "Sales order not found")
+		return
+	}
+
+	var open int64
+	tx.Model(&models.PickTask{}).Where("sales_order_id = ? AND status = ?", salesOrder.ID, "open").Count(&open)
+	if open > 0 {
+		tx.Rollback()
+		utils.RespondWithError(w, http.StatusConflict, "The sales order already has an open pick task, confirm it first")
+		return
+	}
+
+	list, err := buildPickList(tx, salesOrder)
+	if err != nil {
+		tx.Rollback()
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to build pick list")
+		return
+	}
+	if list.Quantity <= 0 {
+		tx.Rollback()
+		utils.RespondWithError(w, http.StatusConflict, "Nothing is left to pick on this sales order")
+		return
+	}
+
+	number, err := nextDocumentNumber(tx, "pick_task")
+	if err != nil {
+		tx.Rollback()
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to allocate pick task number")
+		return
+	}
+
+	pickTask := models.PickTask{
+		Number:       fmt.Sprintf("PICK-%06d", number),
+		SalesOrderID: &salesOrder.ID,
+		Status:       "open",
+		Quantity:     list.Quantity,
+	}
+	for _, line := range list.Lines {
+		pickTask.Lines = append(pickTask.Lines, models.PickTaskLine{BinID: &line.BinID, BinCode: line.BinCode, Quantity: line.Quantity})
+	}
+	if list.Unlocated > 0 {
+		pickTask.Lines = append(pickTask.Lines, models.PickTaskLine{BinCode: "receiving area", Quantity: list.Unlocated})
+	}
+	if tx.Create(&pickTask).Error != nil {
+		tx.Rollback()
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to create pick task")
+		return
+	}
+
+	salesOrder.Status = salesOrderStatus(salesOrder, true)
+	if tx.Model(&salesOrder).Update("status", salesOrder.Status).Error != nil {
+		tx.Rollback()
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to update sales order")
+		return
+	}
+
+	tx.Commit()
+	utils.RespondWithJSON(w, http.StatusOK, pickTask)
+}
+
+// replaceShortPicked deals with units that were missing from their bins. They are written off, which
+// ends their reservation, and replacements are reserved from other stock along the walking path so
+// they show up on the next pick list. Lot-tracked and serialized units can't be swapped blindly and
+// stock may have run out, such units stay short. Returns how many units could not be replaced.
+func replaceShortPicked(tx *gorm.DB, salesOrder models.SalesOrder, short int) (int, error) {
+	var product models.Product
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Unscoped().First(&product, salesOrder.ProductID).Error; err != nil {
+		return 0, err
+	}
+	if err := addToStock(tx, &product, "reserved_quantity", -short); err != nil {
+		return 0, err
+	}
+	if err := moveStock(tx, &product, -short, "short_pick", "sales_order", salesOrder.ID); err != nil {
+		return 0, err
+	}
+	if tracksUnits(product) {
+		return short, nil
+	}
+	available, err := salesAvailable(tx, product)
+	if err != nil {
+		return 0, err
+	}
+	replaced := min(short, max(available, 0))
+	if replaced > 0 {
+		if err := reserveStock(tx, &product, replaced, salesOrder.ID); err != nil {
+			return 0, err
+		}
+	}
+	return short - replaced, nil
+}
+
+// pickConfirmRequest is the body of ConfirmPickTask
+type pickConfirmRequest struct {
+	Lines []struct {
+		ID             uint `validate:"required"`
+		PickedQuantity int  `validate:"min=0"`
+	}
+}
+
+// ConfirmPickTask books what the picker found, POST /pick-task/{id}/confirm with
+// {"Lines":[{"ID":4,"PickedQuantity":1}]}. Lines left out were picked in full.
+func ConfirmPickTask(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	var request pickConfirmRequest
+	// an empty body confirms every line as picked in full
+	if !utils.DecodeOptionalJSON(w, r, &request) {
+		return
+	}
+
+	tx := database.For(r).Begin()
+
+	var pickTask models.PickTask
+	if tx.Clauses(clause.Locking{Strength: "UPDATE"}).Preload("Lines").First(&pickTask, id).Error != nil {
+		tx.Rollback()
+		utils.RespondWithError(w, http.StatusNotFound, "Pick task not found")
+		return
+	}
+	if pickTask.Status != "open" {
+		tx.Rollback()
+		utils.RespondWithError(w, http.StatusConflict, "Pick task has already been confirmed")
+		return
+	}
+
+	picked := map[uint]int{}
+	for _, line := range request.Lines {
+		picked[line.ID] = line.PickedQuantity
+	}
+	for i := range pickTask.Lines {
+		line := &pickTask.Lines[i]
+		line.PickedQuantity = line.Quantity
+		if quantity, ok := picked[line.ID]; ok {
+			if quantity < 0 || quantity > line.Quantity {
+				tx.Rollback()
+				utils.RespondWithError(w, http.StatusBadRequest, fmt.Sprintf("Picked quantity of line %d must be between 0 and %d", line.ID, line.Quantity))
+				return
+			}
+			line.PickedQuantity = quantity
+			delete(picked, line.ID)
+		}
+		line.ShortQuantity = line.Quantity - line.PickedQuantity
+		pickTask.PickedQuantity += line.PickedQuantity
+		pickTask.ShortQuantity += line.ShortQuantity
+	}
+	if len(picked) > 0 {
+		tx.Rollback()
+		utils.RespondWithError(w, http.StatusBadRequest, "Lines must belong to the pick task")
+		return
+	}
+
+	var salesOrder models.SalesOrder
+	if tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&salesOrder, pickTask.SalesOrderID).Error != nil {
+		tx.Rollback()
+		utils.RespondWithError(w, http.StatusNotFound, "Sales order not found")
+		return
+	}
+
+	unreplaced := 0
+	if pickTask.ShortQuantity > 0 {
+		var err error
+		if unreplaced, err = replaceShortPicked(tx, salesOrder, pickTask.ShortQuantity); err != nil {
+			tx.Rollback()
+			utils.RespondWithError(w, http.StatusInternalServerError, "Failed to replace short picked units")
+			return
+		}
+	}
+
+	now := time.Now()
+	pickTask.Status = "picked"
+	if pickTask.ShortQuantity > 0 {
+		pickTask.Status = "short"
+	}
+	pickTask.ConfirmedAt = &now
+	for _, line := range pickTask.Lines {
+		if tx.Model(&line).Updates(map[string]interface{}{"picked_quantity": line.PickedQuantity, "short_quantity": line.ShortQuantity}).Error != nil {
+			tx.Rollback()
+			utils.RespondWithError(w, http.StatusInternalServerError, "Failed to confirm pick task")
+			return
+		}
+	}
+	if tx.Model(&pickTask).Updates(map[string]interface{}{
+		"status":          pickTask.Status,
+		"picked_quantity": pickTask.PickedQuantity,
+		"short_quantity":  pickTask.ShortQuantity,
+		"confirmed_at":    pickTask.ConfirmedAt,
+	}).Error != nil {
+		tx.Rollback()
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to confirm pick task")
+		return
+	}
+
+	salesOrder.PickedQuantity += pickTask.PickedQuantity
+	salesOrder.ShortQuantity += unreplaced
+	salesOrder.Status = salesOrderStatus(salesOrder, false)
+	if tx.Model(&salesOrder).Updates(map[string]interface{}{
+		"picked_quantity": salesOrder.PickedQuantity,
+		"short_quantity":  salesOrder.ShortQuantity,
+		"status":          salesOrder.Status,
+	}).Error != nil {
+		tx.Rollback()
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to update sales order")
+		return
+	}
+
+	tx.Commit()
+	utils.RespondWithJSON(w, http.StatusOK, pickTask)
+}
@@ -0,0 +1,72 @@
+package controllers_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+// Units missing from their bin are written off and picked again from other stock, only picked
+// units can be packed.
+func TestPickAndPack(t *testing.T) {
+	router, acme := setUp(t)
+	product := create(t, router, acme, "POST", "/add-product", `{"Name":"desk","SKU":"DESK","Price":100,"Quantity":5}`)
+	bin := create(t, router, acme, "POST", "/add-bin", `{"Zone":"A","Aisle":"1"}`)
+	create(t, router, acme, "POST", "/bin-move", fmt.Sprintf(`{"ProductID":%d,"ToBinID":%d,"Quantity":5}`, product, bin))
+	salesOrder := create(t, router, acme, "POST", "/add-sales-order", fmt.Sprintf(`{"ProductID":%d,"Quantity":3}`, product))
+
+	var pickTask struct {
+		ID    uint
+		Lines []struct{ ID uint }
+	}
+	tests := []struct {
+		name         string
+		do           func() int
+		wantCode     int
+		wantStatus   string
+		wantQuantity int
+		wantReserved int
+	}{
+		{"pick list", func() int {
+			w := acme.Do(router, "POST", fmt.Sprintf("/sales-order/%d/pick-list", salesOrder), `{}`)
+			json.Unmarshal(w.Body.Bytes(), &pickTask)
+			return w.Code
+		}, http.StatusOK, "picking", 5, 3},
+		{"second open pick list", func() int {
+			return acme.Do(router, "POST", fmt.Sprintf("/sales-order/%d/pick-list", salesOrder), `{}`).Code
+		}, http.StatusConflict, "picking", 5, 3},
+		{"picked more than asked", func() int {
+			return acme.Do(router, "POST", fmt.Sprintf("/pick-task/%d/confirm", pickTask.ID), fmt.Sprintf(`{"Lines":[{"ID":%d,"PickedQuantity":4}]}`, pickTask.Lines[0].ID)).Code
+		}, http.StatusBadRequest, "picking", 5, 3},
+		{"one unit missing", func() int {
+			return acme.Do(router, "POST", fmt.Sprintf("/pick-task/%d/confirm", pickTask.ID), fmt.Sprintf(`{"Lines":[{"ID":%d,"PickedQuantity":2}]}`, pickTask.Lines[0].ID)).Code
+		}, http.StatusOK, "partially_picked", 4, 3},
+		{"pack more than picked", func() int {
+			return acme.Do(router, "POST", fmt.Sprintf("/sales-order/%d/pack", salesOrder), `{"Parcels":[{"Quantity":3,"Weight":1}]}`).Code
+		}, http.StatusConflict, "partially_picked", 4, 3},
+		{"pick the replacement", func() int {
+			w := acme.Do(router, "POST", fmt.Sprintf("/sales-order/%d/pick-list", salesOrder), `{}`)
+			json.Unmarshal(w.Body.Bytes(), &pickTask)
+			return acme.Do(router, "POST", fmt.Sprintf("/pick-task/%d/confirm", pickTask.ID), `{}`).Code
+		}, http.StatusOK, "picked", 4, 3},
+		{"pack", func() int {
+			return acme.Do(router, "POST", fmt.Sprintf("/sales-order/%d/pack", salesOrder), `{"Parcels":[{"Quantity":2,"Weight":1},{"Quantity":1,"Weight":1}]}`).Code
+		}, http.StatusOK, "packed", 4, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if code := tt.do(); code != tt.wantCode {
+				t.Errorf("answered %d, want %d", code, tt.wantCode)
+			}
+			var order struct{ Status string }
+			json.Unmarshal(acme.Do(router, "GET", fmt.Sprintf("/sales-order/%d", salesOrder), "").Body.Bytes(), &order)
+			if order.Status != tt.wantStatus {
+				t.Errorf("order is %q, want %q", order.Status, tt.wantStatus)
+			}
+			if got := stock(t, acme, product); got.Quantity != tt.wantQuantity || got.ReservedQuantity != tt.wantReserved {
+				t.Errorf("stock %d reserved %d, want %d and %d", got.Quantity, got.ReservedQuantity, tt.wantQuantity, tt.wantReserved)
+			}
+		})
+	}
+}
@@ -0,0 +1,262 @@
+package controllers
+
+import (
+	"errors"
+	"inventory-control-hub/database"
+	"inventory-control-hub/models"
+	"inventory-control-hub/utils"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+)
+
+var errCustomerNotFound = errors.New("customer not found")
+
+// priceQuote is what a product costs for a customer at a quantity
+type priceQuote struct {
+	ProductID        uint
+	CustomerID       *uint
+	Quantity         int
+	UnitPrice        float64
+	LineAmount       float64
+	PriceIncludesTax bool
+	PriceListID      *uint // nil when the product's own price was used
+	PriceListItemID  *uint
+}
+
+// quotePrice resolves the unit price for a sale. It is used by the quote endpoint and by
+// sales order creation so both always agree. The customer's price list wins over Product.Price
+// when it has an item for the product whose MinQuantity is met and whose validity window
+// covers `at`; if several qualify (quantity breaks, promotions) the lowest price is used.
+func quotePrice(db *gorm.DB, product models.Product, customerID *uint, quantity int, at time.Time) (priceQuote, error) {
+	quote := priceQuote{
+		ProductID:        product.ID,
+		CustomerID:       customerID,
+		Quantity:         quantity,
+		UnitPrice:        product.Price,
+		PriceIncludesTax: product.PriceIncludesTax,
+	}
+
+	if customerID != nil {
+		var customer models.Customer
+		if err := db.First(&customer, *customerID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return quote, errCustomerNotFound
+			}
+			return quote, err
+		}
+
+		if customer.PriceListID != nil {
+			var item models.PriceListItem
+			err := db.Where("price_list_id = ? AND product_id = ? AND min_quantity <= ?", *customer.PriceListID, product.ID, quantity).
+				Where("valid_from IS NULL OR valid_from <= ?", at).
+				Where("valid_to IS NULL OR valid_to >= ?", at).
+				Order("price ASC").
+				First(&item).Error
+			if err == nil {
+				quote.UnitPrice = item.Price
+				quote.PriceListID = item.PriceListID
+				quote.PriceListItemID = &item.ID
+			} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+				return quote, err
+			}
+		}
+	}
+
+	quote.LineAmount = utils.RoundMoney(quote.UnitPrice * float64(quantity))
+	return quote, nil
+}
+
+// GetProductPrice quotes a product: GET /product/{id}/price?customer=&qty=
+func GetProductPrice(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	var product models.Product
+	if database.For(r).First(&product, id).Error != nil {
+		utils.RespondWithError(w, http.StatusNotFound, "Product not found")
+		return
+	}
+
+	quantity := 1
+	if qty := r.URL.Query().Get("qty"); qty != "" {
+		parsed, err := strconv.Atoi(qty)
+		if err != nil || parsed <= 0 {
+			utils.RespondWithError(w, http.StatusBadRequest, "qty must be a positive number")
+			return
+		}
+		quantity = parsed
+	}
+
+	var customerID *uint
+	if customer := r.URL.Query().Get("customer"); customer != "" {
+		parsed, err := strconv.ParseUint(customer, 10, 64)
+		if err != nil {
+			utils.RespondWithError(w, http.StatusBadRequest, "customer must be a customer id")
+			return
+		}
+		cid := uint(parsed)
+		customerID = &cid
+	}
+
+	quote, err := quotePrice(database.For(r), product, customerID, quantity, time.Now())
+	if err != nil {
+		respondWithPriceError(w, err)
+		return
+	}
+	utils.RespondWithJSON(w, http.StatusOK, quote)
+}
+
+func respondWithPriceError(w http.ResponseWriter, err error) {
+	if errors.Is(err, errCustomerNotFound) {
+		utils.RespondWithError(w, http.StatusNotFound, "Customer not found")
+		return
+	}
+	utils.RespondWithError(w, http.StatusInternalServerError, "Failed to resolve price")
+}
+
+func GetPriceLists(w http.ResponseWriter, r *http.Request) {
+	var priceLists []models.PriceList
+	if database.For(r).Find(&priceLists).Error != nil {
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to fetch price lists")
+		return
+	}
+	utils.RespondWithJSON(w, http.StatusOK, priceLists)
+}
+
+func GetPriceListById(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	var priceList models.PriceList
+	if database.For(r).Preload("Items", func(db *gorm.DB) *gorm.DB {
+		return db.Order("product_id, min_quantity")
+	}).Preload("Items.Product").First(&priceList, id).Error != nil {
+		utils.RespondWithError(w, http.StatusNotFound, "Price list not found")
+		return
+	}
+	utils.RespondWithJSON(w, http.StatusOK, priceList)
+}
+
+// priceListRequest is the body of AddPriceList, items are added through
+// /price-list/{id}/add-item
+type priceListRequest struct {
+	Name        string `validate:"required,maxlen=100"`
+	Description string
+}
+
+// priceListItemRequest is the body of AddPriceListItem
+type priceListItemRequest struct {
+	ProductID   *uint   `validate:"required"`
+	MinQuantity int     `validate:"min=0"` // 1 when left out
+	Price       float64 `validate:"min=0"`
+	ValidFrom   *time.Time
+	ValidTo     *time.Time
+}
+
+func (request priceListItemRequest) Validate(errs *utils.FieldErrors) {
+	validateWindow(errs, request.ValidFrom, request.ValidTo)
+}
+
+func AddPriceList(w http.ResponseWriter, r *http.Request) {
+	var request priceListRequest
+	if !utils.DecodeJSON(w, r, &request) {
+		return
+	}
+	priceList := models.PriceList{Name: request.Name, Description: request.Description}
+
+	var existing models.PriceList
+	if database.For(r).Where("name = ?", priceList.Name).First(&existing).Error == nil {
+		utils.RespondWithError(w, http.StatusConflict, "This price list already exists")
+		return
+	}
+
+	if database.For(r).Create(&priceList).Error != nil {
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to add price list")
+		return
+	}
+	utils.RespondWithJSON(w, http.StatusOK, priceList)
+}
+
+func DeletePriceList(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	var priceList models.PriceList
+	if database.For(r).First(&priceList, id).Error != nil {
+		utils.RespondWithError(w, http.StatusNotFound, "Price list not found")
+		return
+	}
+
+	var count int64
+	database.For(r).Model(&models.Customer{}).Where("price_list_id = ?", priceList.ID).Count(&count)
+	if count > 0 {
+		utils.RespondWithError(w, http.StatusConflict, "Cannot delete price list because customers are assigned to it")
+		return
+	}
+
+	tx := database.For(r).Begin()
+	if tx.Where("price_list_id = ?", priceList.ID).Delete(&models.PriceListItem{}).Error != nil {
+		tx.Rollback()
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to delete price list items")
+		return
+	}
+	if tx.Delete(&priceList).Error != nil {
+		tx.Rollback()
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to delete price list")
+		return
+	}
+	tx.Commit()
+	utils.RespondWithJSON(w, http.StatusOK, map[string]string{"message": "Price list deleted successfully"})
+}
+
+func AddPriceListItem(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	var priceList models.PriceList
+	if database.For(r).First(&priceList, id).Error != nil {
+		utils.RespondWithError(w, http.StatusNotFound, "Price list not found")
+		return
+	}
+
+	var request priceListItemRequest
+	if !utils.DecodeJSON(w, r, &request) {
+		return
+	}
+	item := models.PriceListItem{
+		PriceListID: &priceList.ID,
+		ProductID:   request.ProductID,
+		MinQuantity: max(request.MinQuantity, 1),
+		Price:       request.Price,
+		ValidFrom:   request.ValidFrom,
+		ValidTo:     request.ValidTo,
+	}
+
+	var product models.Product
+	if database.For(r).First(&product, item.ProductID).Error != nil {
+		utils.RespondWithError(w, http.StatusNotFound, "Product not found")
+		return
+	}
+
+	if database.For(r).Omit("Product").Create(&item).Error != nil {
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to add price list item")
+		return
+	}
+	database.For(r).Preload("Product", withDeleted).First(&item, item.ID)
+	utils.RespondWithJSON(w, http.StatusOK, item)
+}
+
+func DeletePriceListItem(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	var item models.PriceListItem
+	if database.For(r).First(&item, id).Error != nil {
+		utils.RespondWithError(w, http.StatusNotFound, "Price list item not found")
+		return
+	}
+	if database.For(r).Delete(&item).Error != nil {
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to delete price list item")
+		return
+	}
+	utils.RespondWithJSON(w, http.StatusOK, map[string]string{"message": "Price list item deleted successfully"})
+}
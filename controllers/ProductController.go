@@ -2,14 +2,48 @@ package controllers
 
 import (
 	"encoding/json"
+	"errors"
 	"inventory-control-hub/database"
 	"inventory-control-hub/models"
 	"inventory-control-hub/utils"
 	"net/http"
+	"strings"
 
 	"github.com/gorilla/mux"
+	"gorm.io/gorm"
 )
 
+// includeDeleted reports whether soft-deleted rows were asked for with ?include_deleted=true
+func includeDeleted(r *http.Request) bool {
+	return r.URL.Query().Get("include_deleted") == "true"
+}
+
+// respondWithVersionError maps database.LockVersion errors to a response
+func respondWithVersionError(w http.ResponseWriter, err error) {
+	if errors.Is(err, database.ErrVersionChanged) {
+		utils.PreconditionFailed(w)
+		return
+	}
+	utils.RespondWithError(w, http.StatusInternalServerError, "Failed to lock the record")
+}
+
+// skuTaken reports whether another product, deleted ones included, already uses the SKU
+func skuTaken(db *gorm.DB, sku string, exceptID uint) bool {
+	var count int64
+	db.Unscoped().Model(&models.Product{}).Where("sku = ? AND id <> ?", sku, exceptID).Count(&count)
+	return count > 0
+}
+
+// tracksUnits reports whether stock is kept per lot or per serial, such stock can't be set by hand
+func tracksUnits(product models.Product) bool {
+	return product.LotTracked || product.Serialized
+}
+
+// withDeleted is a Preload condition that keeps soft-deleted rows, so old orders still show their product
+func withDeleted(db *gorm.DB) *gorm.DB {
+	return db.Unscoped()
+}
+
 func HomeRoute(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode("Welcome to inventory hub's home route...")
 }
@@ -19,7 +53,11 @@ func GetProduct(w http.ResponseWriter, r *http.Request) {
 	// r *http.Request represents the incoming request from the user contains method(get, post ..), header, params
 
 	var products []models.Product // here product is a variable which is slice type [], which contains Product like struct which is located in models package.
-	result := database.DB.Find(&products)
+	query := database.For(r)
+	if includeDeleted(r) {
+		query = query.Unscoped()
+	}
+	result := query.Find(&products)
 
 	if result.Error != nil {
 		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to fetch product")
@@ -39,7 +77,11 @@ func GetProductById(w http.ResponseWriter, r *http.Request) {
 
 	var product models.Product
 
-	result := database.DB.First(&product, id) // first record with matching id
+	query := database.For(r)
+	if includeDeleted(r) {
+		query = query.Unscoped()
+	}
+	result := query.First(&product, id) // first record with matching id
 	if result.Error != nil {
 		// w.WriteHeader(http.StatusNotFound)
 		// json.NewEncoder(w).Encode(map[string]string{"error": "product not found"})
@@ -53,39 +95,101 @@ func GetProductById(w http.ResponseWriter, r *http.Request) {
 	// w.Header().Set("Content-Type", "application/json")
 	// json.NewEncoder(w).Encode(product)
 
+	utils.SetETag(w, product.Version)
 	utils.RespondWithJSON(w, http.StatusOK, product)
 }
+
+// productRequest is the body of AddProduct
+type productRequest struct {
+	Name             string `validate:"required,maxlen=255"`
+	SKU              string `validate:"maxlen=64" pattern:"^\\S+$"`
+	Description      string
+	Price            float64 `validate:"min=0"`
+	Quantity         int     `validate:"min=0"` // opening stock
+	TaxClassID       *uint
+	PriceIncludesTax bool
+	LotTracked       bool
+	Serialized       bool
+}
+
+// productUpdateRequest is the body of UpdateProduct. Fields left out keep their value, the
+// pointers tell a false or 0 that was sent from one that wasn't, a null TaxClassID clears it.
+type productUpdateRequest struct {
+	Name             string `validate:"maxlen=255"`
+	SKU              string `validate:"maxlen=64" pattern:"^\\S+$"`
+	Description      string
+	Price            *float64 `validate:"min=0"`
+	Quantity         *int     `validate:"min=0"` // a stock count
+	TaxClassID       utils.Optional[uint]
+	PriceIncludesTax *bool
+	LotTracked       *bool
+	Serialized       *bool
+}
+
+func (request productRequest) Validate(errs *utils.FieldErrors) {
+	if (request.LotTracked || request.Serialized) && request.Quantity != 0 {
+		errs.Add("Quantity", "tracked", "must be 0 for lot-tracked and serialized products, receive their stock with a purchase order")
+	}
+}
+
 func AddProduct(w http.ResponseWriter, r *http.Request) {
 	// w.Header().Set("Content-Type", "application/json")
 
-	var product models.Product
-
-	//decoding json into product structure
-	err := json.NewDecoder(r.Body).Decode(&product)
-	if err != nil {
-		utils.RespondWithError(w, http.StatusBadRequest, "Invalid Request")
+	var request productRequest
+	if !utils.DecodeJSON(w, r, &request) {
 		return
 	}
-	if product.Name == "" {
-		utils.RespondWithError(w, http.StatusBadRequest, "Product's name is required")
-		return
+	product := models.Product{
+		Name:             request.Name,
+		SKU:              request.SKU,
+		Description:      request.Description,
+		Price:            request.Price,
+		Quantity:         request.Quantity,
+		TaxClassID:       request.TaxClassID,
+		PriceIncludesTax: request.PriceIncludesTax,
+		LotTracked:       request.LotTracked,
+		Serialized:       request.Serialized,
+		Version:          1,
 	}
 
 	//checking if the product with the same name already lies in db
 	var existingProduct models.Product
-	duplicate_rec := database.DB.Where("name=?", product.Name).First(&existingProduct)
+	duplicate_rec := database.For(r).Where("name=?", product.Name).First(&existingProduct)
 	if duplicate_rec.Error == nil {
 		utils.RespondWithError(w, http.StatusConflict, "This product already exists")
 		return
 	}
 
-	result := database.DB.Create(&product)
+	if product.SKU != "" && skuTaken(database.For(r), product.SKU, 0) {
+		utils.RespondWithError(w, http.StatusConflict, "A product with this SKU already exists")
+		return
+	}
+
+	if product.TaxClassID != nil && database.For(r).First(&models.TaxClass{}, *product.TaxClassID).Error != nil {
+		utils.RespondWithError(w, http.StatusNotFound, "Tax class not found")
+		return
+	}
+
+	// opening stock is booked through the ledger like any other stock change
+	openingQuantity := product.Quantity
+	product.Quantity = 0
+
+	tx := database.For(r).Begin()
+	result := tx.Create(&product)
 	if result.Error != nil {
+		tx.Rollback()
 		// http.Error(w, "Failed to add product", http.StatusInternalServerError)
 		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to add product")
 		return
 	}
+	if openingQuantity != 0 && moveStock(tx, &product, openingQuantity, "opening_balance", "product", product.ID) != nil {
+		tx.Rollback()
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to add product")
+		return
+	}
+	tx.Commit()
 
+	utils.SetETag(w, product.Version)
 	json.NewEncoder(w).Encode(product)
 }
 func UpdateProduct(w http.ResponseWriter, r *http.Request) {
@@ -93,25 +197,26 @@ func UpdateProduct(w http.ResponseWriter, r *http.Request) {
 	id := params["id"]
 
 	var product models.Product
-	result := database.DB.First(&product, id)
+	result := database.For(r).First(&product, id)
 
 	if result.Error != nil {
 
 		utils.RespondWithError(w, http.StatusNotFound, "Product not found")
 		return
 	}
+	if !utils.IfMatch(w, r, product.Version) {
+		return
+	}
 
 	//if product exists
-	var updatedData models.Product
-	err := json.NewDecoder(r.Body).Decode(&updatedData)
-	if err != nil {
-		// http.Error(w, "Invalid JSON data", http.StatusBadRequest)
-		utils.RespondWithError(w, http.StatusBadRequest, "Invalid json data")
+	var updatedData productUpdateRequest
+	if !utils.DecodeJSON(w, r, &updatedData) {
 		return
 	}
 	var existingProduct models.Product
-	duplicate_rec := database.DB.Where("name=?", updatedData.Name).First(&existingProduct)
-	if duplicate_rec.Error == nil {
+	// keeping its own name is no duplicate
+	duplicate_rec := database.For(r).Where("name = ? AND id <> ?", updatedData.Name, product.ID).First(&existingProduct)
+	if updatedData.Name != "" && duplicate_rec.Error == nil {
 		utils.RespondWithError(w, http.StatusConflict, "This product already exists")
 		return
 	}
@@ -120,55 +225,343 @@ func UpdateProduct(w http.ResponseWriter, r *http.Request) {
 		product.Name = updatedData.Name
 	}
 
-	if updatedData.Price != 0 {
-		product.Price = updatedData.Price
+	if updatedData.Price != nil {
+		product.Price = *updatedData.Price
 	}
 
-	if updatedData.Quantity != 0 {
-		product.Quantity = updatedData.Quantity
+	// a manual stock count, booked as an adjustment when the product is saved
+	stockAdjustment := 0
+	if updatedData.Quantity != nil {
+		stockAdjustment = *updatedData.Quantity - product.Quantity
+	}
+	if stockAdjustment != 0 && tracksUnits(product) {
+		utils.RespondWithError(w, http.StatusConflict, "Stock of a lot-tracked or serialized product can only change through receipts and orders")
+		return
+	}
+	if stockAdjustment != 0 && *updatedData.Quantity < product.ReservedQuantity {
+		utils.RespondWithError(w, http.StatusConflict, "Quantity cannot go below the units reserved for sales orders")
+		return
 	}
 
 	if updatedData.Description != "" {
 		product.Description = updatedData.Description
 	}
 
-	res := database.DB.Save(&product)
+	if updatedData.SKU != "" {
+		if skuTaken(database.For(r), updatedData.SKU, product.ID) {
+			utils.RespondWithError(w, http.StatusConflict, "A product with this SKU already exists")
+			return
+		}
+		product.SKU = updatedData.SKU
+	}
+
+	if updatedData.TaxClassID.Null {
+		product.TaxClassID = nil
+	} else if updatedData.TaxClassID.Present {
+		if database.For(r).First(&models.TaxClass{}, updatedData.TaxClassID.Value).Error != nil {
+			utils.RespondWithError(w, http.StatusNotFound, "Tax class not found")
+			return
+		}
+		product.TaxClassID = &updatedData.TaxClassID.Value
+	}
+
+	if updatedData.PriceIncludesTax != nil {
+		product.PriceIncludesTax = *updatedData.PriceIncludesTax
+	}
+
+	// untracked stock has no lots, so tracking can only start or stop from empty
+	if updatedData.LotTracked != nil && *updatedData.LotTracked != product.LotTracked {
+		if product.Quantity != 0 {
+			utils.RespondWithError(w, http.StatusConflict, "Lot tracking can only change while the product has no stock")
+			return
+		}
+		product.LotTracked = *updatedData.LotTracked
+	}
+	if updatedData.Serialized != nil && *updatedData.Serialized != product.Serialized {
+		if product.Quantity != 0 {
+			utils.RespondWithError(w, http.StatusConflict, "Serial tracking can only change while the product has no stock")
+			return
+		}
+		product.Serialized = *updatedData.Serialized
+	}
+
+	saveProductUpdate(w, r, product, stockAdjustment)
+}
+
+// saveProductUpdate saves the changed product, booking a counted quantity as a stock adjustment,
+// and answers with it. Shared by PUT and PATCH.
+func saveProductUpdate(w http.ResponseWriter, r *http.Request, product models.Product, stockAdjustment int) {
+	tx := database.For(r).Begin()
+	if err := database.LockVersion(tx, &models.Product{}, product.ID, product.Version); err != nil {
+		tx.Rollback()
+		respondWithVersionError(w, err)
+		return
+	}
+	// the stock columns of the copy were read before the lock, they only change through the ledger
+	res := tx.Model(&product).Select("*").Omit("Quantity", "ReservedQuantity", "QuarantineQuantity").Updates(&product).Error
+	if res == nil && stockAdjustment != 0 {
+		res = moveStock(tx, &product, stockAdjustment, "adjustment", "product", product.ID)
+		if res == nil && stockAdjustment > 0 {
+			res = allocateBackorders(tx, &product, "product", product.ID)
+		}
+	}
 
-	if res.Error != nil {
+	if res != nil {
+		tx.Rollback()
 		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to update product")
 		return
 	}
+	tx.Commit()
+	utils.SetETag(w, product.Version)
 	utils.RespondWithJSON(w, http.StatusOK, product)
 }
+
+// productPatch is the body of PATCH /product/{id}, see PatchProduct
+type productPatch struct {
+	Name             utils.Optional[string]
+	SKU              utils.Optional[string]
+	Description      utils.Optional[string]
+	Price            utils.Optional[float64]
+	Quantity         utils.Optional[int]
+	TaxClassID       utils.Optional[uint]
+	PriceIncludesTax utils.Optional[bool]
+	LotTracked       utils.Optional[bool]
+	Serialized       utils.Optional[bool]
+}
+
+// PatchProduct changes only the fields sent, as a JSON Merge Patch: PATCH /product/{id} with
+// {"Price":0,"Description":null}. Zero values are set like any other, null clears SKU,
+// Description and TaxClassID. Quantity is a stock count, booked as an adjustment.
+func PatchProduct(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	var patch productPatch
+	errs, ok := utils.DecodeMergePatch(w, r, &patch)
+	if !ok {
+		return
+	}
+	var product models.Product
+	if database.For(r).First(&product, id).Error != nil {
+		utils.RespondWithError(w, http.StatusNotFound, "Product not found")
+		return
+	}
+	if !utils.IfMatch(w, r, product.Version) {
+		return
+	}
+
+	if patch.Name.Present {
+		name := strings.TrimSpace(patch.Name.Value)
+		var count int64
+		database.For(r).Model(&models.Product{}).Where("name = ? AND id <> ?", name, product.ID).Count(&count)
+		switch {
+		case name == "":
+			errs.Add("Name", "required", "is required")
+		case count > 0:
+			errs.Add("Name", "unique", "is already used by another product")
+		default:
+			product.Name = name
+		}
+	}
+	if patch.SKU.Present {
+		sku := strings.TrimSpace(patch.SKU.Value)
+		if sku != "" && skuTaken(database.For(r), sku, product.ID) {
+			errs.Add("SKU", "unique", "is already used by another product")
+		} else {
+			product.SKU = sku
+		}
+	}
+	if patch.Description.Present {
+		product.Description = patch.Description.Value
+	}
+	if patch.Price.Present {
+		if patch.Price.Null || patch.Price.Value < 0 {
+			errs.Add("Price", "min", "must be 0 or more")
+		} else {
+			product.Price = patch.Price.Value
+		}
+	}
+	if patch.TaxClassID.Present {
+		if patch.TaxClassID.Null {
+			product.TaxClassID = nil
+		} else if database.For(r).First(&models.TaxClass{}, patch.TaxClassID.Value).Error != nil {
+			errs.Add("TaxClassID", "not_found", "is not a tax class")
+		} else {
+			product.TaxClassID = &patch.TaxClassID.Value
+		}
+	}
+	if patch.PriceIncludesTax.Present {
+		if patch.PriceIncludesTax.Null {
+			errs.Add("PriceIncludesTax", "type", "must be true or false")
+		} else {
+			product.PriceIncludesTax = patch.PriceIncludesTax.Value
+		}
+	}
+	// tracking can only start or stop while there is no stock to sort into lots or serials
+	for _, tracking := range []struct {
+		name  string
+		patch utils.Optional[bool]
+		field *bool
+	}{
+		{"LotTracked", patch.LotTracked, &product.LotTracked},
+		{"Serialized", patch.Serialized, &product.Serialized},
+	} {
+		switch {
+		case !tracking.patch.Present:
+		case tracking.patch.Null:
+			errs.Add(tracking.name, "type", "must be true or false")
+		case tracking.patch.Value == *tracking.field:
+		case product.Quantity != 0:
+			errs.Add(tracking.name, "in_stock", "can only change while the product has no stock")
+		default:
+			*tracking.field = tracking.patch.Value
+		}
+	}
+
+	stockAdjustment := 0
+	if patch.Quantity.Present {
+		switch {
+		case patch.Quantity.Null || patch.Quantity.Value < 0:
+			errs.Add("Quantity", "min", "must be 0 or more")
+		case patch.Quantity.Value == product.Quantity:
+		case tracksUnits(product):
+			errs.Add("Quantity", "tracked", "of a lot-tracked or serialized product can only change through receipts and orders")
+		case patch.Quantity.Value < product.ReservedQuantity:
+			errs.Add("Quantity", "reserved", "cannot go below the units reserved for sales orders")
+		default:
+			stockAdjustment = patch.Quantity.Value - product.Quantity
+		}
+	}
+	if len(errs) > 0 {
+		utils.RespondWithFieldErrors(w, errs)
+		return
+	}
+	saveProductUpdate(w, r, product, stockAdjustment)
+}
 func DeleteProduct(w http.ResponseWriter, r *http.Request) {
 	var product models.Product
 	param := mux.Vars(r)
 	id := param["id"]
 	var count int64
 
-	data := database.DB.First(&product, id)
+	data := database.For(r).First(&product, id)
 	if data.Error != nil {
 		// w.WriteHeader(http.StatusNotFound)
 		// json.NewEncoder(w).Encode(map[string]string{"error": "product not found"})
 		utils.RespondWithError(w, http.StatusNotFound, "Product not found")
 		return
 	}
+	if !utils.IfMatch(w, r, product.Version) {
+		return
+	}
 
 	//if any sales order is associated with the product
-	database.DB.Model(&models.SalesOrder{}).Where("product_id = ?", product.ID).Count(&count)
+	database.For(r).Model(&models.SalesOrder{}).Where("product_id = ?", product.ID).Count(&count)
+	if count > 0 {
+		utils.RespondWithError(w, http.StatusConflict, "Cannot delete product because sales orders exist, archive it instead")
+		return
+	}
+	// kits would have nothing to be built from
+	database.For(r).Model(&models.BOMComponent{}).Where("component_id = ?", product.ID).Count(&count)
 	if count > 0 {
-		utils.RespondWithError(w, http.StatusConflict, "Cannot delete product because sales orders exist")
+		utils.RespondWithError(w, http.StatusConflict, "Cannot delete product because it is a component of a kit, remove it from the bill of materials first")
+		return
+	}
+	tx := database.For(r).Begin()
+	if err := database.LockVersion(tx, &models.Product{}, product.ID, product.Version); err != nil {
+		tx.Rollback()
+		respondWithVersionError(w, err)
 		return
 	}
-	deleteResult := database.DB.Delete(&product)
+	deleteResult := tx.Delete(&product)
 	if deleteResult.Error != nil {
+		tx.Rollback()
 		// w.WriteHeader(http.StatusInternalServerError)
 		// json.NewEncoder(w).Encode(map[string]string{"error": "Failed to delete product"})
 		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to delete product")
 		return
 	}
+	tx.Commit()
 
 	// json.NewEncoder(w).Encode(map[string]string{"message": "Product deleted successfully"})
 	utils.RespondWithJSON(w, http.StatusOK, map[string]string{"message": "Product deleted successfully"})
 
 }
+
+// RestoreProduct brings back a soft-deleted product, POST /product/{id}/restore
+func RestoreProduct(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	var product models.Product
+	if database.For(r).Unscoped().First(&product, id).Error != nil {
+		utils.RespondWithError(w, http.StatusNotFound, "Product not found")
+		return
+	}
+	if !product.DeletedAt.Valid {
+		utils.RespondWithError(w, http.StatusConflict, "Product is not deleted")
+		return
+	}
+	if !utils.IfMatch(w, r, product.Version) {
+		return
+	}
+
+	var existingProduct models.Product
+	if database.For(r).Where("name = ?", product.Name).First(&existingProduct).Error == nil {
+		utils.RespondWithError(w, http.StatusConflict, "Another product with this name exists")
+		return
+	}
+
+	tx := database.For(r).Begin()
+	if err := database.LockVersion(tx, &models.Product{}, product.ID, product.Version); err != nil {
+		tx.Rollback()
+		respondWithVersionError(w, err)
+		return
+	}
+	if tx.Unscoped().Model(&product).Update("deleted_at", nil).Error != nil {
+		tx.Rollback()
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to restore product")
+		return
+	}
+	tx.Commit()
+	product.DeletedAt = gorm.DeletedAt{}
+	utils.SetETag(w, product.Version)
+	utils.RespondWithJSON(w, http.StatusOK, product)
+}
+
+// ArchiveProduct stops a product from being sold or purchased, POST /product/{id}/archive.
+// Unlike deleting it, the product stays visible everywhere.
+func ArchiveProduct(w http.ResponseWriter, r *http.Request) {
+	setProductArchived(w, r, true)
+}
+
+// UnarchiveProduct makes an archived product sellable and purchasable again, POST /product/{id}/unarchive
+func UnarchiveProduct(w http.ResponseWriter, r *http.Request) {
+	setProductArchived(w, r, false)
+}
+
+func setProductArchived(w http.ResponseWriter, r *http.Request, archived bool) {
+	id := mux.Vars(r)["id"]
+
+	var product models.Product
+	if database.For(r).First(&product, id).Error != nil {
+		utils.RespondWithError(w, http.StatusNotFound, "Product not found")
+		return
+	}
+	if !utils.IfMatch(w, r, product.Version) {
+		return
+	}
+
+	tx := database.For(r).Begin()
+	if err := database.LockVersion(tx, &models.Product{}, product.ID, product.Version); err != nil {
+		tx.Rollback()
+		respondWithVersionError(w, err)
+		return
+	}
+	if tx.Model(&product).Update("archived", archived).Error != nil {
+		tx.Rollback()
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to update product")
+		return
+	}
+	tx.Commit()
+	utils.SetETag(w, product.Version)
+	utils.RespondWithJSON(w, http.StatusOK, product)
+}
@@ -0,0 +1,82 @@
+package controllers_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// Restoring and archiving change the product like an update does, they need the current ETag
+// and answer with the next one.
+func TestProductActionsNeedIfMatch(t *testing.T) {
+	router, acme := setUp(t)
+	product := create(t, router, acme, "POST", "/add-product", `{"Name":"desk","SKU":"DESK","Price":100}`)
+	create(t, router, acme, "DELETE", fmt.Sprintf("/delete-product/%d", product), "")
+
+	// the ETag the client holds, the delete didn't change the version
+	current := `"1"`
+	tests := []struct {
+		name     string
+		action   string
+		ifMatch  string
+		wantCode int
+	}{
+		{"restore without If-Match", "restore", "", http.StatusPreconditionRequired},
+		{"restore a stale copy", "restore", `"0"`, http.StatusPreconditionFailed},
+		{"restore", "restore", "current", http.StatusOK},
+		{"archive without If-Match", "archive", "", http.StatusPreconditionRequired},
+		{"archive", "archive", "current", http.StatusOK},
+		{"unarchive a stale copy", "unarchive", `"1"`, http.StatusPreconditionFailed},
+		{"unarchive", "unarchive", "current", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := acme.Request("POST", fmt.Sprintf("/product/%d/%s", product, tt.action), "")
+			r.Header.Del("If-Match")
+			if tt.ifMatch == "current" {
+				tt.ifMatch = current
+			}
+			if tt.ifMatch != "" {
+				r.Header.Set("If-Match", tt.ifMatch)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, r)
+			if w.Code != tt.wantCode {
+				t.Fatalf("answered %d, want %d: %s", w.Code, tt.wantCode, w.Body)
+			}
+			if tt.wantCode == http.StatusOK {
+				if etag := w.Header().Get("ETag"); etag == "" || etag == current {
+					t.Errorf("answered ETag %q after %s", etag, current)
+				} else {
+					current = etag
+				}
+			}
+		})
+	}
+}
+
+// A PUT may send the product's own name back, only another product's name is taken.
+func TestUpdateProductName(t *testing.T) {
+	router, acme := setUp(t)
+	desk := create(t, router, acme, "POST", "/add-product", `{"Name":"desk","SKU":"DESK","Price":100}`)
+	create(t, router, acme, "POST", "/add-product", `{"Name":"chair","SKU":"CHAIR","Price":50}`)
+
+	tests := []struct {
+		name     string
+		body     string
+		wantCode int
+	}{
+		{"own name", `{"Name":"desk","Price":120}`, http.StatusOK},
+		{"another product's name", `{"Name":"chair"}`, http.StatusConflict},
+		{"no name", `{"Price":110}`, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := acme.Do(router, "PUT", fmt.Sprintf("/update-product/%d", desk), tt.body)
+			if w.Code != tt.wantCode {
+				t.Errorf("answered %d, want %d: %s", w.Code, tt.wantCode, w.Body)
+			}
+		})
+	}
+}
@@ -0,0 +1,271 @@
+package controllers
+
+import (
+	"errors"
+	"inventory-control-hub/database"
+	"inventory-control-hub/models"
+	"inventory-control-hub/utils"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+)
+
+var errInvalidDiscount = errors.New("discount type must be percent or fixed with a non-negative value, percent at most 100")
+var errPromotionNotFound = errors.New("promotion code not found")
+var errPromotionNotActive = errors.New("promotion code is not valid at this time")
+var errPromotionUsedUp = errors.New("promotion code usage limit reached")
+var errPromotionMinimum = errors.New("order does not meet the promotion's minimum order value")
+
+// discountAmount works out a percent or fixed discount on base, never more than base itself
+func discountAmount(discountType string, value float64, base float64) (float64, error) {
+	var amount float64
+	switch discountType {
+	case "":
+		return 0, nil
+	case "percent":
+		if value < 0 || value > 100 {
+			return 0, errInvalidDiscount
+		}
+		amount = base * value / 100
+	case "fixed":
+		if value < 0 {
+			return 0, errInvalidDiscount
+		}
+		amount = value
+	default:
+		return 0, errInvalidDiscount
+	}
+	return utils.RoundMoney(math.Min(amount, base)), nil
+}
+
+// findPromotion looks up a code and checks its validity window and remaining uses.
+// The usage check here is only a fast fail, redeemPromotion is what enforces the limit.
+func findPromotion(db *gorm.DB, code string, at time.Time) (*models.Promotion, error) {
+	var promotion models.Promotion
+	if err := db.Where("code = ?", code).First(&promotion).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errPromotionNotFound
+		}
+		return nil, err
+	}
+	if (promotion.ValidFrom != nil && at.Before(*promotion.ValidFrom)) || (promotion.ValidTo != nil && at.After(*promotion.ValidTo)) {
+		return nil, errPromotionNotActive
+	}
+	if promotion.UsageLimit > 0 && promotion.TimesUsed >= promotion.UsageLimit {
+		return nil, errPromotionUsedUp
+	}
+	return &promotion, nil
+}
+
+// redeemPromotion counts one use of the promotion inside the order's transaction.
+// The limit is checked in the same UPDATE so two concurrent orders can't both take the last use.
+func redeemPromotion(tx *gorm.DB, promotionID uint) error {
+	res := tx.Model(&models.Promotion{}).
+		Where("id = ? AND (usage_limit = 0 OR times_used < usage_limit)", promotionID).
+		UpdateColumn("times_used", gorm.Expr("times_used + 1"))
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		// the promotion may be gone rather than used up
+		var count int64
+		if err := tx.Model(&models.Promotion{}).Where("id = ?", promotionID).Count(&count).Error; err != nil {
+			return err
+		}
+		if count == 0 {
+			return errPromotionNotFound
+		}
+		return errPromotionUsedUp
+	}
+	return nil
+}
+
+// releasePromotion gives a use back when an order that redeemed the promotion is deleted
+func releasePromotion(tx *gorm.DB, promotionID uint) error {
+	return tx.Model(&models.Promotion{}).
+		Where("id = ? AND times_used > 0", promotionID).
+		UpdateColumn("times_used", gorm.Expr("times_used - 1")).Error
+}
+
+func respondWithPromotionError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, errPromotionNotFound):
+		utils.RespondWithError(w, http.StatusNotFound, "Promotion code not found")
+	case errors.Is(err, errPromotionNotActive):
+		utils.RespondWithError(w, http.StatusBadRequest, "Promotion code is not valid at this time")
+	case errors.Is(err, errPromotionUsedUp):
+		utils.RespondWithError(w, http.StatusConflict, "Promotion code usage limit reached")
+	default:
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to apply promotion code")
+	}
+}
+
+// promotionRequest is the body of AddPromotion
+type promotionRequest struct {
+	Code          string `validate:"required,maxlen=50"`
+	Description   string
+	DiscountType  string  `validate:"required,enum=percent|fixed"`
+	DiscountValue float64 `validate:"min=0"`
+	ValidFrom     *time.Time
+	ValidTo       *time.Time
+	UsageLimit    int     `validate:"min=0"` // 0 is unlimited
+	MinOrderValue float64 `validate:"min=0"`
+}
+
+func (request promotionRequest) Validate(errs *utils.FieldErrors) {
+	validatePromotion(errs, request.DiscountType, request.DiscountValue, request.ValidFrom, request.ValidTo)
+}
+
+// promotionUpdateRequest is the body of UpdatePromotion. Fields left out keep their value, the
+// pointers tell a 0 that was sent from one that wasn't.
+type promotionUpdateRequest struct {
+	Description   string
+	DiscountType  string   `validate:"enum=percent|fixed"`
+	DiscountValue *float64 `validate:"min=0"`
+	ValidFrom     *time.Time
+	ValidTo       *time.Time
+	UsageLimit    *int     `validate:"min=0"`
+	MinOrderValue *float64 `validate:"min=0"`
+}
+
+// validatePromotion checks the rules across the fields of a promotion
+func validatePromotion(errs *utils.FieldErrors, discountType string, discountValue float64, validFrom *time.Time, validTo *time.Time) {
+	validateDiscount(errs, "Discount", discountType, discountValue)
+	validateWindow(errs, validFrom, validTo)
+}
+
+// validateWindow checks a ValidFrom and ValidTo pair, either can be left open
+func validateWindow(errs *utils.FieldErrors, validFrom *time.Time, validTo *time.Time) {
+	if validFrom != nil && validTo != nil && validTo.Before(*validFrom) {
+		errs.Add("ValidTo", "after", "must be after ValidFrom")
+	}
+}
+
+func GetPromotions(w http.ResponseWriter, r *http.Request) {
+	var promotions []models.Promotion
+	if database.For(r).Find(&promotions).Error != nil {
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to fetch promotions")
+		return
+	}
+	utils.RespondWithJSON(w, http.StatusOK, promotions)
+}
+
+func GetPromotionById(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	var promotion models.Promotion
+	if database.For(r).First(&promotion, id).Error != nil {
+		utils.RespondWithError(w, http.StatusNotFound, "Promotion not found")
+		return
+	}
+	utils.RespondWithJSON(w, http.StatusOK, promotion)
+}
+
+func AddPromotion(w http.ResponseWriter, r *http.Request) {
+	var request promotionRequest
+	if !utils.DecodeJSON(w, r, &request) {
+		return
+	}
+	promotion := models.Promotion{
+		Code:          request.Code,
+		Description:   request.Description,
+		DiscountType:  request.DiscountType,
+		DiscountValue: request.DiscountValue,
+		ValidFrom:     request.ValidFrom,
+		ValidTo:       request.ValidTo,
+		UsageLimit:    request.UsageLimit,
+		MinOrderValue: request.MinOrderValue,
+	}
+
+	var existing models.Promotion
+	if database.For(r).Where("code = ?", promotion.Code).First(&existing).Error == nil {
+		utils.RespondWithError(w, http.StatusConflict, "This promotion code already exists")
+		return
+	}
+
+	if database.For(r).Create(&promotion).Error != nil {
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to add promotion")
+		return
+	}
+	utils.RespondWithJSON(w, http.StatusOK, promotion)
+}
+
+// UpdatePromotion can change the discount, window and limits. The code and usage count stay as they are.
+func UpdatePromotion(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	var promotion models.Promotion
+	if database.For(r).First(&promotion, id).Error != nil {
+		utils.RespondWithError(w, http.StatusNotFound, "Promotion not found")
+		return
+	}
+
+	var updatedData promotionUpdateRequest
+	if !utils.DecodeJSON(w, r, &updatedData) {
+		return
+	}
+
+	if updatedData.Description != "" {
+		promotion.Description = updatedData.Description
+	}
+	if updatedData.DiscountType != "" {
+		promotion.DiscountType = updatedData.DiscountType
+	}
+	if updatedData.DiscountValue != nil {
+		promotion.DiscountValue = *updatedData.DiscountValue
+	}
+	if updatedData.ValidFrom != nil {
+		promotion.ValidFrom = updatedData.ValidFrom
+	}
+	if updatedData.ValidTo != nil {
+		promotion.ValidTo = updatedData.ValidTo
+	}
+	if updatedData.UsageLimit != nil {
+		promotion.UsageLimit = *updatedData.UsageLimit
+	}
+	if updatedData.MinOrderValue != nil {
+		promotion.MinOrderValue = *updatedData.MinOrderValue
+	}
+	// the rules across fields hold for the promotion as it will be
+	errs := utils.FieldErrors{}
+	validatePromotion(&errs, promotion.DiscountType, promotion.DiscountValue, promotion.ValidFrom, promotion.ValidTo)
+	if len(errs) > 0 {
+		utils.RespondWithFieldErrors(w, errs)
+		return
+	}
+
+	// TimesUsed is left out so a concurrent redemption isn't overwritten
+	if database.For(r).Model(&promotion).Omit("times_used", "code").Save(&promotion).Error != nil {
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to update promotion")
+		return
+	}
+	database.For(r).First(&promotion, promotion.ID)
+	utils.RespondWithJSON(w, http.StatusOK, promotion)
+}
+
+func DeletePromotion(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	var promotion models.Promotion
+	if database.For(r).First(&promotion, id).Error != nil {
+		utils.RespondWithError(w, http.StatusNotFound, "Promotion not found")
+		return
+	}
+
+	var count int64
+	// deleted orders count too, restoring one redeems the promotion again
+	database.For(r).Unscoped().Model(&models.SalesOrder{}).Where("promotion_id = ?", promotion.ID).Count(&count)
+	if count > 0 {
+		utils.RespondWithError(w, http.StatusConflict, "Cannot delete promotion because sales orders used it, end its validity instead")
+		return
+	}
+
+	if database.For(r).Delete(&promotion).Error != nil {
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to delete promotion")
+		return
+	}
+	utils.RespondWithJSON(w, http.StatusOK, map[string]string{"message": "Promotion deleted successfully"})
+}
@@ -0,0 +1,23 @@
+package controllers_test
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+// A deleted order keeps its promotion, restoring it redeems the promotion again.
+func TestDeletePromotionOfDeletedOrder(t *testing.T) {
+	router, acme := setUp(t)
+	product := create(t, router, acme, "POST", "/add-product", `{"Name":"desk","SKU":"DESK","Price":100,"Quantity":5}`)
+	promotion := create(t, router, acme, "POST", "/add-promotion", `{"Code":"TEN","DiscountType":"percent","DiscountValue":10}`)
+	salesOrder := create(t, router, acme, "POST", "/add-sales-order", fmt.Sprintf(`{"ProductID":%d,"Quantity":1,"PromotionCode":"TEN"}`, product))
+	create(t, router, acme, "DELETE", fmt.Sprintf("/delete-sales-order/%d", salesOrder), "")
+
+	if w := acme.Do(router, "DELETE", fmt.Sprintf("/delete-promotion/%d", promotion), ""); w.Code != http.StatusConflict {
+		t.Errorf("deleting the promotion answered %d, want %d: %s", w.Code, http.StatusConflict, w.Body)
+	}
+	if w := acme.Do(router, "POST", fmt.Sprintf("/sales-order/%d/restore", salesOrder), ""); w.Code != http.StatusOK {
+		t.Errorf("restoring the order answered %d, want %d: %s", w.Code, http.StatusOK, w.Body)
+	}
+}
@@ -1,7 +1,7 @@
 package controllers
 
 import (
-	"encoding/json"
+	"errors"
 	"inventory-control-hub/database"
 	"inventory-control-hub/models"
 	"inventory-control-hub/utils"
@@ -9,6 +9,8 @@ import (
 	"time"
 
 	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 func GetPurchaseOrder(w http.ResponseWriter, r *http.Request) {
@@ -16,7 +18,11 @@ func GetPurchaseOrder(w http.ResponseWriter, r *http.Request) {
 
 	var purchaseOrder []models.PurchaseOrder
 
-	if database.DB.Preload("Product").Find(&purchaseOrder).Error != nil {
+	query := database.For(r)
+	if includeDeleted(r) {
+		query = query.Unscoped()
+	}
+	if query.Preload("Product", withDeleted).Find(&purchaseOrder).Error != nil {
 		utils.RespondWithError(w, http.StatusNotFound, "Purchase order not found")
 		return
 	}
@@ -31,61 +37,97 @@ func GetPurchaseOrderById(w http.ResponseWriter, r *http.Request) {
 	id := params["id"]
 	var purchaseOrder models.PurchaseOrder
 
-	if database.DB.Preload("Product").First(&purchaseOrder, id).Error != nil {
+	query := database.For(r)
+	if includeDeleted(r) {
+		query = query.Unscoped()
+	}
+	if query.Preload("Product", withDeleted).Preload("Receipts.SerialNumbers").First(&purchaseOrder, id).Error != nil {
 		utils.RespondWithError(w, http.StatusNotFound, "Purchase order not found")
 		return
 	}
 
 	
 
+	utils.SetETag(w, purchaseOrder.Version)
 	utils.RespondWithJSON(w, http.StatusOK, purchaseOrder)
 
 }
 
-func CreatePurchaseOrder(w http.ResponseWriter, r *http.Request) {
-	var purchaseOrder models.PurchaseOrder
-
-	err := json.NewDecoder(r.Body).Decode(&purchaseOrder)
+// purchaseOrderRequest is the body of CreatePurchaseOrder
+type purchaseOrderRequest struct {
+	ProductID       *uint `validate:"required"`
+	Quantity        int   `validate:"min=1"`
+	Supplier        string
+	UnitCost        float64 `validate:"min=0"`
+	CostIncludesTax bool
+	DeferReceipt    bool
+	Jurisdiction    string `validate:"maxlen=50"`
+}
 
-	if err != nil {
-		utils.RespondWithError(w, http.StatusBadRequest, "Invalid input")
-		return
-	}
+// purchaseOrderUpdateRequest is the body of UpdatePurchaseOrder. Fields left out keep their
+// value, the pointers tell a false or 0 that was sent from one that wasn't.
+type purchaseOrderUpdateRequest struct {
+	ProductID       *uint
+	Quantity        *int `validate:"min=1"`
+	Supplier        string
+	UnitCost        *float64 `validate:"min=0"`
+	CostIncludesTax *bool
+	Jurisdiction    string `validate:"maxlen=50"`
+}
 
-	if purchaseOrder.ProductID==nil{
-		utils.RespondWithError(w, http.StatusBadRequest, "ProductID is required")
+func CreatePurchaseOrder(w http.ResponseWriter, r *http.Request) {
+	var request purchaseOrderRequest
+	if !utils.DecodeJSON(w, r, &request) {
 		return
 	}
-
-	// check the quantity of purchase order
-
-	if purchaseOrder.Quantity <= 0 {
-		utils.RespondWithError(w, http.StatusBadRequest, "Order quantity must be greater than 0")
-		return
+	purchaseOrder := models.PurchaseOrder{
+		ProductID:       request.ProductID,
+		Quantity:        request.Quantity,
+		Supplier:        request.Supplier,
+		UnitCost:        request.UnitCost,
+		CostIncludesTax: request.CostIncludesTax,
+		DeferReceipt:    request.DeferReceipt,
+		Jurisdiction:    request.Jurisdiction,
+		Version:         1,
 	}
 
 	//transaction begins
 
-	tx := database.DB.Begin()
+	tx := database.For(r).Begin()
 
 	//find the associated product
 
 	var product models.Product
-	if database.DB.First(&product, purchaseOrder.ProductID).Error != nil {
+	if database.For(r).First(&product, purchaseOrder.ProductID).Error != nil {
+		tx.Rollback()
 		utils.RespondWithError(w, http.StatusNotFound, "Associated product is not found")
 		return
 	}
+	if product.Archived {
+		tx.Rollback()
+		utils.RespondWithError(w, http.StatusConflict, "Product is archived and cannot be purchased")
+		return
+	}
+	if tracksUnits(product) && !purchaseOrder.DeferReceipt {
+		tx.Rollback()
+		utils.RespondWithError(w, http.StatusBadRequest, "Lot-tracked and serialized products need DeferReceipt, receive them with a LotNumber or SerialNumbers via /purchase-order/{id}/receive")
+		return
+	}
 
-	product.Quantity = product.Quantity + purchaseOrder.Quantity
-
-	if tx.Save(&product).Error != nil {
+	purchaseOrder.Jurisdiction = orderJurisdiction(purchaseOrder.Jurisdiction)
+	tax, err := calculateTax(tx, product.TaxClassID, purchaseOrder.Jurisdiction, float64(purchaseOrder.Quantity)*purchaseOrder.UnitCost, purchaseOrder.CostIncludesTax)
+	if err != nil {
 		tx.Rollback()
-		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to update product quantity")
+		respondWithTaxError(w, err)
 		return
 	}
+	applyPurchaseOrderTax(&purchaseOrder, tax)
 
 	//set price
+	purchaseOrder.ProductName = product.Name
+	purchaseOrder.ProductSKU = product.SKU
 	purchaseOrder.OrderDate = time.Now()
+	purchaseOrder.Status = "open"
 
 	//save purchaseOrder
 
@@ -95,14 +137,25 @@ func CreatePurchaseOrder(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// unless receipt is deferred the goods are treated as arrived with the order
+	if !purchaseOrder.DeferReceipt {
+		receipt := models.PurchaseReceipt{Quantity: purchaseOrder.Quantity, ReceivedDate: purchaseOrder.OrderDate, Note: "received on order"}
+		if receivePurchaseOrder(tx, &purchaseOrder, &product, receipt) != nil {
+			tx.Rollback()
+			utils.RespondWithError(w, http.StatusInternalServerError, "Failed to update product quantity")
+			return
+		}
+	}
+
 	tx.Commit()
 
 	var fullOrder models.PurchaseOrder
-	if err := database.DB.Preload("Product").First(&fullOrder, purchaseOrder.ID).Error; err != nil {
+	if err := database.For(r).Preload("Product", withDeleted).First(&fullOrder, purchaseOrder.ID).Error; err != nil {
 		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to fetch full purchase order")
 		return
 	}
 
+	utils.SetETag(w, fullOrder.Version)
 	utils.RespondWithJSON(w, http.StatusOK, fullOrder)
 
 }
@@ -112,62 +165,129 @@ func UpdatePurchaseOrder(w http.ResponseWriter, r *http.Request) {
 	id := params["id"]
 
 	var oldPurchaseOrder models.PurchaseOrder
-	if database.DB.First(&oldPurchaseOrder, id).Error != nil {
+	if database.For(r).First(&oldPurchaseOrder, id).Error != nil {
 		utils.RespondWithError(w, http.StatusNotFound, "The purchase order not found")
 		return
 	}
+	if !utils.IfMatch(w, r, oldPurchaseOrder.Version) {
+		return
+	}
 
-	var newPurchaseOrder models.PurchaseOrder
+	var newPurchaseOrder purchaseOrderUpdateRequest
 
 	//decode req body
-	err := json.NewDecoder(r.Body).Decode(&newPurchaseOrder)
-	if err != nil {
-		utils.RespondWithError(w, http.StatusBadRequest, "Invalid input")
+	if !utils.DecodeJSON(w, r, &newPurchaseOrder) {
 		return
 	}
 
-	//start transaction
-
-	tx := database.DB.Begin()
-
 	if newPurchaseOrder.ProductID != nil {
-		if oldPurchaseOrder.ProductID != newPurchaseOrder.ProductID {
+		if oldPurchaseOrder.ProductID == nil || *oldPurchaseOrder.ProductID != *newPurchaseOrder.ProductID {
 			utils.RespondWithError(w, http.StatusConflict, "Ordered product cannot be changed, please cancel the purchase order and place an order again")
 			return
 		}
 	}
 
+	//updating other fields
+
+	if newPurchaseOrder.Supplier != "" {
+		oldPurchaseOrder.Supplier = newPurchaseOrder.Supplier
+	}
+
+	if newPurchaseOrder.UnitCost != nil {
+		oldPurchaseOrder.UnitCost = *newPurchaseOrder.UnitCost
+	}
+
+	if newPurchaseOrder.CostIncludesTax != nil {
+		oldPurchaseOrder.CostIncludesTax = *newPurchaseOrder.CostIncludesTax
+	}
+
+	if newPurchaseOrder.Jurisdiction != "" {
+		oldPurchaseOrder.Jurisdiction = newPurchaseOrder.Jurisdiction
+	}
+
+	quantity := 0
+	if newPurchaseOrder.Quantity != nil {
+		quantity = *newPurchaseOrder.Quantity
+	}
+	savePurchaseOrderUpdate(w, r, oldPurchaseOrder, quantity)
+}
+
+// savePurchaseOrderUpdate saves the changed order with quantity, 0 keeps the quantity, and
+// answers with it. Orders received on placing correct the received stock with the quantity.
+// Shared by PUT and PATCH.
+func savePurchaseOrderUpdate(w http.ResponseWriter, r *http.Request, oldPurchaseOrder models.PurchaseOrder, quantity int) {
+	//start transaction
+
+	tx := database.For(r).Begin()
+	if err := database.LockVersion(tx, &models.PurchaseOrder{}, oldPurchaseOrder.ID, oldPurchaseOrder.Version); err != nil {
+		tx.Rollback()
+		respondWithVersionError(w, err)
+		return
+	}
+
 	var product models.Product
 
-	if tx.First(&product, oldPurchaseOrder.ProductID).Error != nil {
+	if tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&product, oldPurchaseOrder.ProductID).Error != nil {
 		tx.Rollback()
 		utils.RespondWithError(w, http.StatusNotFound, "Product not found")
 		return
 	}
 
-	product.Quantity -= oldPurchaseOrder.Quantity
-	product.Quantity += newPurchaseOrder.Quantity
-
-	//save updated product
-	if tx.Save(&product).Error != nil {
+	if quantity != 0 && quantity != oldPurchaseOrder.Quantity && oldPurchaseOrder.ReturnedQuantity > 0 {
 		tx.Rollback()
-		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to update product")
+		utils.RespondWithError(w, http.StatusConflict, "Goods on this purchase order were returned to the supplier, the quantity cannot be changed")
 		return
 	}
 
-	//updating other fields
+	if quantity != 0 {
+		if oldPurchaseOrder.DeferReceipt {
+			// stock only moves through receipts, the order can't drop below what already arrived
+			if quantity < oldPurchaseOrder.ReceivedQuantity {
+				tx.Rollback()
+				utils.RespondWithError(w, http.StatusConflict, "Quantity cannot be less than what has already been received")
+				return
+			}
+			oldPurchaseOrder.Quantity = quantity
+		} else {
+			// received on order: changing the quantity corrects what was received
+			delta := quantity - oldPurchaseOrder.ReceivedQuantity
+			oldPurchaseOrder.Quantity = quantity
+			if delta != 0 && tracksUnits(product) {
+				tx.Rollback()
+				utils.RespondWithError(w, http.StatusConflict, "Received quantity of a lot-tracked or serialized product cannot be corrected here")
+				return
+			}
+			// units already promised to sales orders can't be taken back from the supplier
+			if product.Quantity+delta < product.ReservedQuantity {
+				tx.Rollback()
+				utils.RespondWithError(w, http.StatusConflict, "Quantity cannot be lowered below what sales orders have reserved")
+				return
+			}
+			if delta != 0 {
+				receipt := models.PurchaseReceipt{Quantity: delta, ReceivedDate: time.Now(), Note: "quantity corrected"}
+				if receivePurchaseOrder(tx, &oldPurchaseOrder, &product, receipt) != nil {
+					tx.Rollback()
+					utils.RespondWithError(w, http.StatusInternalServerError, "Failed to update product")
+					return
+				}
+			}
+		}
+	}
+	oldPurchaseOrder.Status = purchaseOrderStatus(oldPurchaseOrder)
 
 	oldPurchaseOrder.OrderDate = time.Now()
 
-	if newPurchaseOrder.Supplier != "" {
-		oldPurchaseOrder.Supplier = newPurchaseOrder.Supplier
-	}
-
-	if newPurchaseOrder.Quantity != 0 {
-		oldPurchaseOrder.Quantity = newPurchaseOrder.Quantity
+	tax, err := calculateTax(tx, product.TaxClassID, orderJurisdiction(oldPurchaseOrder.Jurisdiction), float64(oldPurchaseOrder.Quantity)*oldPurchaseOrder.UnitCost, oldPurchaseOrder.CostIncludesTax)
+	if err != nil {
+		tx.Rollback()
+		respondWithTaxError(w, err)
+		return
 	}
+	oldPurchaseOrder.Jurisdiction = orderJurisdiction(oldPurchaseOrder.Jurisdiction)
+	applyPurchaseOrderTax(&oldPurchaseOrder, tax)
 
-	if tx.Save(&oldPurchaseOrder).Error != nil {
+	if tx.Omit("Receipts").Save(&oldPurchaseOrder).Error != nil {
+		tx.Rollback()
 		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to update purchase order")
 		return
 	}
@@ -176,42 +296,137 @@ func UpdatePurchaseOrder(w http.ResponseWriter, r *http.Request) {
 
 	var fullOrder models.PurchaseOrder
 
-	if err := database.DB.Preload("Product").First(&fullOrder, id).Error; err != nil {
+	if err := database.For(r).Preload("Product", withDeleted).First(&fullOrder, oldPurchaseOrder.ID).Error; err != nil {
 		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to fetch full purchase order")
 		return
 	}
 
 	// if database.DB.First()
 
+	utils.SetETag(w, fullOrder.Version)
 	utils.RespondWithJSON(w, http.StatusOK, fullOrder)
 
 }
 
+// purchaseOrderPatch is the body of PATCH /purchase-order/{id}, see PatchPurchaseOrder
+type purchaseOrderPatch struct {
+	Quantity        utils.Optional[int]
+	Supplier        utils.Optional[string]
+	UnitCost        utils.Optional[float64]
+	CostIncludesTax utils.Optional[bool]
+	Jurisdiction    utils.Optional[string]
+}
+
+// PatchPurchaseOrder changes only the fields sent, as a JSON Merge Patch: PATCH /purchase-order/{id}
+// with {"UnitCost":0,"Supplier":null}. Null clears Supplier and resets Jurisdiction to the default.
+// The product cannot be changed.
+func PatchPurchaseOrder(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	var patch purchaseOrderPatch
+	errs, ok := utils.DecodeMergePatch(w, r, &patch)
+	if !ok {
+		return
+	}
+	var purchaseOrder models.PurchaseOrder
+	if database.For(r).First(&purchaseOrder, id).Error != nil {
+		utils.RespondWithError(w, http.StatusNotFound, "Purchase order not found")
+		return
+	}
+	if !utils.IfMatch(w, r, purchaseOrder.Version) {
+		return
+	}
+
+	quantity := 0
+	if patch.Quantity.Present {
+		if patch.Quantity.Null || patch.Quantity.Value <= 0 {
+			errs.Add("Quantity", "min", "must be greater than 0")
+		} else {
+			quantity = patch.Quantity.Value
+		}
+	}
+	if patch.Supplier.Present {
+		purchaseOrder.Supplier = patch.Supplier.Value
+	}
+	if patch.UnitCost.Present {
+		if patch.UnitCost.Null || patch.UnitCost.Value < 0 {
+			errs.Add("UnitCost", "min", "must be 0 or more")
+		} else {
+			purchaseOrder.UnitCost = patch.UnitCost.Value
+		}
+	}
+	if patch.CostIncludesTax.Present {
+		if patch.CostIncludesTax.Null {
+			errs.Add("CostIncludesTax", "type", "must be true or false")
+		} else {
+			purchaseOrder.CostIncludesTax = patch.CostIncludesTax.Value
+		}
+	}
+	if patch.Jurisdiction.Present {
+		purchaseOrder.Jurisdiction = patch.Jurisdiction.Value
+	}
+	if len(errs) > 0 {
+		utils.RespondWithFieldErrors(w, errs)
+		return
+	}
+	savePurchaseOrderUpdate(w, r, purchaseOrder, quantity)
+}
+
 func DeletePurchaseOrder(w http.ResponseWriter, r *http.Request) {
 	params := mux.Vars(r)
 	id := params["id"]
 	var purchaseOrder models.PurchaseOrder
-	if database.DB.First(&purchaseOrder, id).Error != nil {
+	if database.For(r).First(&purchaseOrder, id).Error != nil {
 		utils.RespondWithError(w, http.StatusNotFound, "Purchase order not found")
 		return
 	}
+	if !utils.IfMatch(w, r, purchaseOrder.Version) {
+		return
+	}
 
-	// need to update the product quantity
+	var bills int64
+	database.For(r).Model(&models.SupplierBill{}).Where("purchase_order_id = ?", purchaseOrder.ID).Count(&bills)
+	if bills > 0 {
+		utils.RespondWithError(w, http.StatusConflict, "Cannot delete purchase order because supplier bills exist")
+		return
+	}
+	if hasSupplierReturns(database.For(r), purchaseOrder.ID) {
+		utils.RespondWithError(w, http.StatusConflict, "Cannot delete purchase order because goods were returned to the supplier")
+		return
+	}
+
+	// need to update the product quantity, only what was actually received is taken back out
 	var product models.Product
-	if database.DB.First(&product, purchaseOrder.ProductID).Error != nil {
+	if database.For(r).Unscoped().First(&product, purchaseOrder.ProductID).Error != nil {
 		utils.RespondWithError(w, http.StatusNotFound, "Product not found")
 		return
 	}
+	if product.Serialized && purchaseOrder.ReceivedQuantity > 0 {
+		utils.RespondWithError(w, http.StatusConflict, "Received serial numbers cannot be deleted, book a supplier return instead")
+		return
+	}
+	if purchaseOrder.ReceivedQuantity > availableQuantity(product) {
+		utils.RespondWithError(w, http.StatusConflict, "Cannot delete purchase order because its goods are reserved for sales orders")
+		return
+	}
 
-	product.Quantity = product.Quantity - purchaseOrder.Quantity
-
-	tx := database.DB.Begin()
+	tx := database.For(r).Begin()
+	if err := database.LockVersion(tx, &models.PurchaseOrder{}, purchaseOrder.ID, purchaseOrder.Version); err != nil {
+		tx.Rollback()
+		respondWithVersionError(w, err)
+		return
+	}
 
-	if tx.Save(&product).Error != nil {
+	if moveStock(tx, &product, -purchaseOrder.ReceivedQuantity, "purchase_cancelled", "purchase_order", purchaseOrder.ID) != nil {
 		tx.Rollback()
 		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to update product")
 		return
 	}
+	if moveReceiptLots(tx, purchaseOrder.ID, -1) != nil {
+		tx.Rollback()
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to update lots")
+		return
+	}
 
 	if tx.Delete(&purchaseOrder).Error != nil {
 		tx.Rollback()
@@ -222,3 +437,236 @@ func DeletePurchaseOrder(w http.ResponseWriter, r *http.Request) {
 	tx.Commit()
 	utils.RespondWithJSON(w, http.StatusOK, map[string]string{"message": "Purchase order deleted successfully"})
 }
+
+// RestorePurchaseOrder brings back a deleted purchase order, POST /purchase-order/{id}/restore.
+// Whatever had been received goes back into stock.
+func RestorePurchaseOrder(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	var purchaseOrder models.PurchaseOrder
+	if database.For(r).Unscoped().First(&purchaseOrder, id).Error != nil {
+		utils.RespondWithError(w, http.StatusNotFound, "Purchase order not found")
+		return
+	}
+	if !purchaseOrder.DeletedAt.Valid {
+		utils.RespondWithError(w, http.StatusConflict, "Purchase order is not deleted")
+		return
+	}
+	if !utils.IfMatch(w, r, purchaseOrder.Version) {
+		return
+	}
+
+	tx := database.For(r).Begin()
+	if err := database.LockVersion(tx, &models.PurchaseOrder{}, purchaseOrder.ID, purchaseOrder.Version); err != nil {
+		tx.Rollback()
+		respondWithVersionError(w, err)
+		return
+	}
+
+	var product models.Product
+	if tx.Unscoped().Clauses(clause.Locking{Strength: "UPDATE"}).First(&product, purchaseOrder.ProductID).Error != nil {
+		tx.Rollback()
+		utils.RespondWithError(w, http.StatusNotFound, "Product not found")
+		return
+	}
+
+	if purchaseOrder.ReceivedQuantity != 0 {
+		if moveStock(tx, &product, purchaseOrder.ReceivedQuantity, "purchase_restored", "purchase_order", purchaseOrder.ID) != nil {
+			tx.Rollback()
+			utils.RespondWithError(w, http.StatusInternalServerError, "Failed to update product")
+			return
+		}
+		if moveReceiptLots(tx, purchaseOrder.ID, 1) != nil {
+			tx.Rollback()
+			utils.RespondWithError(w, http.StatusInternalServerError, "Failed to update lots")
+			return
+		}
+		if allocateBackorders(tx, &product, "purchase_order", purchaseOrder.ID) != nil {
+			tx.Rollback()
+			utils.RespondWithError(w, http.StatusInternalServerError, "Failed to allocate backorders")
+			return
+		}
+	}
+
+	if tx.Unscoped().Model(&purchaseOrder).Update("deleted_at", nil).Error != nil {
+		tx.Rollback()
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to restore purchase order")
+		return
+	}
+
+	tx.Commit()
+
+	var fullOrder models.PurchaseOrder
+	if err := database.For(r).Preload("Product", withDeleted).Preload("Receipts.SerialNumbers").First(&fullOrder, purchaseOrder.ID).Error; err != nil {
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to fetch full purchase order")
+		return
+	}
+	utils.SetETag(w, fullOrder.Version)
+	utils.RespondWithJSON(w, http.StatusOK, fullOrder)
+}
+
+// applyPurchaseOrderTax copies the tax breakdown onto the purchase order
+func applyPurchaseOrderTax(purchaseOrder *models.PurchaseOrder, tax taxBreakdown) {
+	purchaseOrder.TaxRate = tax.Rate
+	purchaseOrder.NetAmount = tax.Net
+	purchaseOrder.TaxAmount = tax.Tax
+	purchaseOrder.GrossAmount = tax.Gross
+}
+
+func purchaseOrderStatus(purchaseOrder models.PurchaseOrder) string {
+	switch {
+	case purchaseOrder.ReceivedQuantity >= purchaseOrder.Quantity:
+		return "received"
+	case purchaseOrder.ReceivedQuantity > 0:
+		return "partially_received"
+	default:
+		return "open"
+	}
+}
+
+// receivePurchaseOrder books receipt.Quantity into stock against the order and records the receipt,
+// inside tx. Lot-tracked products also book the goods into the receipt's lot.
+func receivePurchaseOrder(tx *gorm.DB, purchaseOrder *models.PurchaseOrder, product *models.Product, receipt models.PurchaseReceipt) error {
+	receipt.ID = 0
+	receipt.PurchaseOrderID = &purchaseOrder.ID
+	serials := serialList(receipt.SerialNumbers)
+	receipt.SerialNumbers = nil
+	if product.LotTracked {
+		if err := receiveLot(tx, *product, &receipt); err != nil {
+			return err
+		}
+	} else {
+		receipt.LotID = nil
+		receipt.LotNumber = ""
+	}
+	receipt.SuggestedBinID = nil
+	if receipt.BinID == nil && receipt.Quantity > 0 {
+		bin, err := suggestBin(tx, product.ID)
+		if err != nil {
+			return err
+		}
+		if bin != nil {
+			receipt.SuggestedBinID = &bin.ID
+		}
+	}
+	if err := tx.Create(&receipt).Error; err != nil {
+		return err
+	}
+	if product.Serialized {
+		if err := receiveSerials(tx, *product, receipt, serials); err != nil {
+			return err
+		}
+	}
+
+	if err := moveStock(tx, product, receipt.Quantity, "purchase_receipt", "purchase_receipt", receipt.ID); err != nil {
+		return err
+	}
+	if receipt.BinID != nil {
+		if err := putAway(tx, *product, *receipt.BinID, receipt.Quantity, "purchase_receipt", receipt.ID); err != nil {
+			return err
+		}
+	}
+	// backordered sales orders get the goods first
+	if err := allocateBackorders(tx, product, "purchase_receipt", receipt.ID); err != nil {
+		return err
+	}
+
+	purchaseOrder.ReceivedQuantity += receipt.Quantity
+	purchaseOrder.Status = purchaseOrderStatus(*purchaseOrder)
+	return tx.Model(purchaseOrder).Updates(map[string]interface{}{
+		"received_quantity": purchaseOrder.ReceivedQuantity,
+		"status":            purchaseOrder.Status,
+	}).Error
+}
+
+// receiptRequest is the body of ReceivePurchaseOrder
+type receiptRequest struct {
+	Quantity     int `validate:"min=1"`
+	ReceivedDate time.Time
+	Note         string
+	// lot the goods go into, for lot-tracked products
+	LotNumber       string `validate:"maxlen=100"`
+	ManufactureDate *time.Time
+	ExpiryDate      *time.Time
+	// bin the goods are put away into, they stay unlocated without one
+	BinID *uint
+	// serials of the units received, for serialized products
+	SerialNumbers []struct {
+		Serial string `validate:"required,maxlen=100"`
+	}
+}
+
+// ReceivePurchaseOrder books delivered goods into stock, POST /purchase-order/{id}/receive
+// with {"Quantity": 5, "ReceivedDate": "...", "Note": "..."}
+func ReceivePurchaseOrder(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	var request receiptRequest
+	if !utils.DecodeJSON(w, r, &request) {
+		return
+	}
+	receipt := models.PurchaseReceipt{
+		Quantity:        request.Quantity,
+		ReceivedDate:    request.ReceivedDate,
+		Note:            request.Note,
+		LotNumber:       request.LotNumber,
+		ManufactureDate: request.ManufactureDate,
+		ExpiryDate:      request.ExpiryDate,
+		BinID:           request.BinID,
+	}
+	for _, serialNumber := range request.SerialNumbers {
+		receipt.SerialNumbers = append(receipt.SerialNumbers, models.SerialNumber{Serial: serialNumber.Serial})
+	}
+	if receipt.ReceivedDate.IsZero() {
+		receipt.ReceivedDate = time.Now()
+	}
+
+	tx := database.For(r).Begin()
+
+	var purchaseOrder models.PurchaseOrder
+	if tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&purchaseOrder, id).Error != nil {
+		tx.Rollback()
+		utils.RespondWithError(w, http.StatusNotFound, "Purchase order not found")
+		return
+	}
+	if receipt.Quantity > purchaseOrder.Quantity-purchaseOrder.ReceivedQuantity {
+		tx.Rollback()
+		utils.RespondWithError(w, http.StatusConflict, "Received quantity exceeds what is still outstanding on the purchase order")
+		return
+	}
+
+	var product models.Product
+	if tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&product, purchaseOrder.ProductID).Error != nil {
+		tx.Rollback()
+		utils.RespondWithError(w, http.StatusNotFound, "Product not found")
+		return
+	}
+
+	if err := receivePurchaseOrder(tx, &purchaseOrder, &product, receipt); err != nil {
+		tx.Rollback()
+		if errors.Is(err, errLotNumberRequired) {
+			respondWithLotError(w, err)
+			return
+		}
+		var serialErr *serialError
+		if errors.As(err, &serialErr) {
+			respondWithSerialError(w, err)
+			return
+		}
+		if errors.Is(err, errBinNotFound) || errors.Is(err, errUnlocatedTooSmall) {
+			respondWithBinError(w, err)
+			return
+		}
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to receive purchase order")
+		return
+	}
+
+	tx.Commit()
+
+	var fullOrder models.PurchaseOrder
+	if err := database.For(r).Preload("Product", withDeleted).Preload("Receipts.SerialNumbers").First(&fullOrder, purchaseOrder.ID).Error; err != nil {
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to fetch full purchase order")
+		return
+	}
+	utils.RespondWithJSON(w, http.StatusOK, fullOrder)
+}
@@ -0,0 +1,143 @@
+package controllers
+
+import (
+	"inventory-control-hub/database"
+	"inventory-control-hub/models"
+	"inventory-control-hub/utils"
+	"net/http"
+	"sort"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+var agingBuckets = []string{"0-30", "31-60", "61-90", "90+"}
+
+type agingRow struct {
+	CustomerID   *uint
+	CustomerName string
+	Buckets      map[string]float64
+	Total        float64
+}
+
+type agingReport struct {
+	AsOf      time.Time
+	Customers []agingRow
+	Totals    map[string]float64
+	Total     float64
+}
+
+// agingBucket puts an invoice in a bucket by days past its due date.
+// Invoices that are not due yet count as 0 days and land in 0-30.
+func agingBucket(dueDate time.Time, asOf time.Time) string {
+	days := int(asOf.Sub(dueDate).Hours() / 24)
+	switch {
+	case days <= 30:
+		return "0-30"
+	case days <= 60:
+		return "31-60"
+	case days <= 90:
+		return "61-90"
+	default:
+		return "90+"
+	}
+}
+
+func newBuckets() map[string]float64 {
+	buckets := make(map[string]float64, len(agingBuckets))
+	for _, bucket := range agingBuckets {
+		buckets[bucket] = 0
+	}
+	return buckets
+}
+
+// paidByDate is what was paid on each invoice by payments dated up to asOf, keyed by invoice id
+func paidByDate(db *gorm.DB, asOf time.Time) (map[uint]float64, error) {
+	var rows []struct {
+		InvoiceID uint
+		Amount    float64
+	}
+	err := db.Model(&models.PaymentAllocation{}).
+		Joins("JOIN payments ON payments.id = payment_allocations.payment_id").
+		Where("payments.payment_date <= ?", asOf).
+		Group("payment_allocations.invoice_id").
+		Select("payment_allocations.invoice_id, SUM(payment_allocations.amount) AS amount").Scan(&rows).Error
+	paid := make(map[uint]float64, len(rows))
+	for _, row := range rows {
+		paid[row.InvoiceID] = utils.RoundMoney(row.Amount)
+	}
+	return paid, err
+}
+
+// GetAgingReport buckets open receivables per customer, GET /reports/aging?as_of=2025-06-30
+func GetAgingReport(w http.ResponseWriter, r *http.Request) {
+	asOf := time.Now()
+	value := r.URL.Query().Get("as_of")
+	if value != "" {
+		parsed, err := time.ParseInLocation("2006-01-02", value, time.Local)
+		if err != nil {
+			utils.RespondWithError(w, http.StatusBadRequest, "as_of must be a date like 2025-06-30")
+			return
+		}
+		// end of that day, so invoices due on it are not yet a day late
+		asOf = parsed.AddDate(0, 0, 1).Add(-time.Second)
+	}
+
+	// a past date takes the payments received by then, invoices paid since were still open
+	query := database.For(r).Preload("Customer").Where("issue_date <= ?", asOf)
+	if value == "" {
+		query = query.Where("gross_amount > amount_paid")
+	}
+	var invoices []models.Invoice
+	if query.Find(&invoices).Error != nil {
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to fetch invoices")
+		return
+	}
+	if value != "" {
+		paid, err := paidByDate(database.For(r), asOf)
+		if err != nil {
+			utils.RespondWithError(w, http.StatusInternalServerError, "Failed to fetch payments")
+			return
+		}
+		for i := range invoices {
+			invoices[i].AmountPaid = paid[invoices[i].ID]
+		}
+	}
+
+	report := agingReport{AsOf: asOf, Customers: []agingRow{}, Totals: newBuckets()}
+	rows := map[uint]*agingRow{} // keyed by customer id, 0 for walk-in customers
+	for _, invoice := range invoices {
+		balance := utils.RoundMoney(invoice.GrossAmount - invoice.AmountPaid)
+		if balance <= 0 {
+			continue
+		}
+
+		var key uint
+		if invoice.CustomerID != nil {
+			key = *invoice.CustomerID
+		}
+		row, ok := rows[key]
+		if !ok {
+			row = &agingRow{CustomerID: invoice.CustomerID, CustomerName: "Walk-in customer", Buckets: newBuckets()}
+			if invoice.Customer != nil {
+				row.CustomerName = invoice.Customer.Name
+			}
+			rows[key] = row
+		}
+
+		bucket := agingBucket(invoice.DueDate, asOf)
+		row.Buckets[bucket] = utils.RoundMoney(row.Buckets[bucket] + balance)
+		row.Total = utils.RoundMoney(row.Total + balance)
+		report.Totals[bucket] = utils.RoundMoney(report.Totals[bucket] + balance)
+		report.Total = utils.RoundMoney(report.Total + balance)
+	}
+
+	for _, row := range rows {
+		report.Customers = append(report.Customers, *row)
+	}
+	sort.Slice(report.Customers, func(i, j int) bool {
+		return report.Customers[i].Total > report.Customers[j].Total
+	})
+
+	utils.RespondWithJSON(w, http.StatusOK, report)
+}
@@ -0,0 +1,52 @@
+package controllers_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// A past as_of counts only the payments dated up to it, an invoice paid since was still open then.
+func TestAgingReportAsOf(t *testing.T) {
+	router, acme := setUp(t)
+	product := create(t, router, acme, "POST", "/add-product", `{"Name":"desk","SKU":"DESK","Price":100,"Quantity":5}`)
+	customer := create(t, router, acme, "POST", "/add-customer", `{"Name":"buyer","Email":"buyer@acme.test"}`)
+	salesOrder := create(t, router, acme, "POST", "/add-sales-order", fmt.Sprintf(`{"ProductID":%d,"CustomerID":%d,"Quantity":1}`, product, customer))
+	invoice := create(t, router, acme, "POST", fmt.Sprintf("/sales-order/%d/invoice", salesOrder), `{}`)
+
+	today := time.Now()
+	later := today.AddDate(0, 0, 10)
+	for _, payment := range []struct {
+		amount float64
+		date   time.Time
+	}{{30, today}, {70, later}} {
+		create(t, router, acme, "POST", "/add-payment", fmt.Sprintf(`{"CustomerID":%d,"Amount":%v,"Method":"cash","PaymentDate":"%s","Allocations":[{"InvoiceID":%d,"Amount":%v}]}`,
+			customer, payment.amount, payment.date.Format(time.RFC3339), invoice, payment.amount))
+	}
+
+	tests := []struct {
+		name      string
+		query     string
+		wantTotal float64
+	}{
+		{"now", "", 0},
+		{"before the second payment", "?as_of=" + today.Format("2006-01-02"), 70},
+		{"after both payments", "?as_of=" + later.Format("2006-01-02"), 0},
+		{"before the invoice", "?as_of=" + today.AddDate(0, 0, -1).Format("2006-01-02"), 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := acme.Do(router, "GET", "/reports/aging"+tt.query, "")
+			if w.Code != http.StatusOK {
+				t.Fatalf("answered %d: %s", w.Code, w.Body)
+			}
+			var report struct{ Total float64 }
+			json.Unmarshal(w.Body.Bytes(), &report)
+			if report.Total != tt.wantTotal {
+				t.Errorf("total %v, want %v", report.Total, tt.wantTotal)
+			}
+		})
+	}
+}
@@ -0,0 +1,62 @@
+package controllers
+
+// Requests lists a value of every request body type the handlers decode, main hands them to
+// utils.CheckRules so a broken validate or pattern tag stops the server at startup. A new
+// request type goes in here too.
+var Requests = []interface{}{
+	// auth, users and tenants
+	loginRequest{},
+	refreshTokenRequest{},
+	userRequest{},
+	userUpdateRequest{},
+	apiKeyRequest{},
+	roleRequest{},
+	roleUpdateRequest{},
+	userRolesRequest{},
+	tenantRequest{},
+	tenantUpdateRequest{},
+
+	// catalogue and pricing
+	productRequest{},
+	productUpdateRequest{},
+	productPatch{},
+	bomRequest{},
+	assemblyRequest{},
+	priceListRequest{},
+	priceListItemRequest{},
+	promotionRequest{},
+	promotionUpdateRequest{},
+	taxClassRequest{},
+	taxRateRequest{},
+	taxRateUpdateRequest{},
+
+	// purchasing
+	purchaseOrderRequest{},
+	purchaseOrderUpdateRequest{},
+	purchaseOrderPatch{},
+	receiptRequest{},
+	supplierBillRequest{},
+	supplierBillApprovalRequest{},
+	supplierReturnRequest{},
+	supplierCreditRequest{},
+
+	// sales
+	customerRequest{},
+	customerUpdateRequest{},
+	salesOrderRequest{},
+	salesOrderUpdateRequest{},
+	salesOrderPatch{},
+	invoiceRequest{},
+	paymentRequest{},
+	allocationRequest{},
+	returnRequest{},
+	releaseQuarantineRequest{},
+
+	// warehouse
+	binRequest{},
+	binUpdateRequest{},
+	binMoveRequest{},
+	pickConfirmRequest{},
+	packRequest{},
+	shipRequest{},
+}
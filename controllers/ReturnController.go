@@ -0,0 +1,310 @@
+package controllers
+
+import (
+	"fmt"
+	"inventory-control-hub/database"
+	"inventory-control-hub/models"
+	"inventory-control-hub/utils"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// returnedQuantity is how many units of a sales order have already come back on earlier returns
+func returnedQuantity(db *gorm.DB, salesOrderID uint) (int, error) {
+	var returned int64
+	err := db.Model(&models.ReturnLine{}).
+		Joins("JOIN customer_returns ON customer_returns.id = return_lines.return_id").
+		Where("customer_returns.sales_order_id = ?", salesOrderID).
+		Select("COALESCE(SUM(return_lines.quantity), 0)").Scan(&returned).Error
+	return int(returned), err
+}
+
+// hasReturns reports whether any goods came back on the order, such orders can't be changed anymore
+func hasReturns(db *gorm.DB, salesOrderID uint) bool {
+	var count int64
+	db.Model(&models.Return{}).Where("sales_order_id = ?", salesOrderID).Count(&count)
+	return count > 0
+}
+
+func GetReturns(w http.ResponseWriter, r *http.Request) {
+	var returns []models.Return
+	query := database.For(r).Preload("Lines")
+	if salesOrder := r.URL.Query().Get("sales_order"); salesOrder != "" {
+		query = query.Where("sales_order_id = ?", salesOrder)
+	}
+	if query.Order("id").Find(&returns).Error != nil {
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to fetch returns")
+		return
+	}
+	utils.RespondWithJSON(w, http.StatusOK, returns)
+}
+
+func GetReturnById(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	var customerReturn models.Return
+	if database.For(r).Preload("Lines").Preload("SalesOrder.Product", withDeleted).First(&customerReturn, id).Error != nil {
+		utils.RespondWithError(w, http.StatusNotFound, "Return not found")
+		return
+	}
+	utils.RespondWithJSON(w, http.StatusOK, customerReturn)
+}
+
+// returnRequest is the body of CreateReturn
+type returnRequest struct {
+	Reason     string `validate:"required"`
+	RefundType string `validate:"enum=refund|credit_note"` // refund when left out
+	ReturnDate time.Time
+	Lines      []struct {
+		Quantity    int    `validate:"min=1"`
+		Disposition string `validate:"required,enum=restock|quarantine|scrap"`
+		Serials     []string
+	} `validate:"required"`
+}
+
+// CreateReturn books goods coming back on a sales order, POST /sales-order/{id}/return e.g.
+// {"Reason":"damaged","RefundType":"credit_note","Lines":[{"Quantity":2,"Disposition":"restock"},{"Quantity":1,"Disposition":"scrap"}]}
+// Each unit is refunded at the price it was sold for, including discounts and tax.
+func CreateReturn(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	var request returnRequest
+	if !utils.DecodeJSON(w, r, &request) {
+		return
+	}
+	customerReturn := models.Return{Reason: request.Reason, RefundType: request.RefundType, ReturnDate: request.ReturnDate}
+	if customerReturn.RefundType == "" {
+		customerReturn.RefundType = "refund"
+	}
+	quantity := 0
+	for _, line := range request.Lines {
+		if len(line.Serials) > 0 {
+			if err := checkSerialCount(line.Serials, line.Quantity); err != nil {
+				respondWithSerialError(w, err)
+				return
+			}
+		}
+		customerReturn.Lines = append(customerReturn.Lines, models.ReturnLine{Quantity: line.Quantity, Disposition: line.Disposition, Serials: line.Serials})
+		quantity += line.Quantity
+	}
+
+	tx := database.For(r).Begin()
+
+	// lock the order so two returns can't both pass the quantity check
+	var salesOrder models.SalesOrder
+	if tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&salesOrder, id).Error != nil {
+		tx.Rollback()
+		utils.RespondWithError(w, http.StatusNotFound, "Sales order not found")
+		return
+	}
+	returned, err := returnedQuantity(tx, salesOrder.ID)
+	if err != nil {
+		tx.Rollback()
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to check earlier returns")
+		return
+	}
+	if returned+quantity > salesOrder.ShippedQuantity {
+		tx.Rollback()
+		utils.RespondWithError(w, http.StatusBadRequest, fmt.Sprintf("Cannot return %d units, only %d of %d shipped units are left to return", quantity, salesOrder.ShippedQuantity-returned, salesOrder.ShippedQuantity))
+		return
+	}
+
+	var product models.Product
+	if tx.First(&product, salesOrder.ProductID).Error != nil {
+		tx.Rollback()
+		utils.RespondWithError(w, http.StatusNotFound, "The ordered product not found")
+		return
+	}
+
+	if product.Serialized {
+		for _, line := range customerReturn.Lines {
+			if len(line.Serials) == 0 {
+				tx.Rollback()
+				utils.RespondWithError(w, http.StatusBadRequest, "Serials are required on every line of a serialized product")
+				return
+			}
+		}
+	} else {
+		for i := range customerReturn.Lines {
+			customerReturn.Lines[i].Serials = nil
+		}
+	}
+
+	number, err := nextDocumentNumber(tx, "return")
+	if err != nil {
+		tx.Rollback()
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to allocate return number")
+		return
+	}
+
+	unitAmount := salesOrder.GrossAmount / float64(salesOrder.Quantity)
+	total := 0.0
+	for i := range customerReturn.Lines {
+		line := &customerReturn.Lines[i]
+		line.ProductID = salesOrder.ProductID
+		line.Amount = utils.RoundMoney(unitAmount * float64(line.Quantity))
+		total += line.Amount
+	}
+
+	customerReturn.Number = fmt.Sprintf("RMA-%06d", number)
+	customerReturn.SalesOrderID = &salesOrder.ID
+	customerReturn.CustomerID = salesOrder.CustomerID
+	if customerReturn.ReturnDate.IsZero() {
+		customerReturn.ReturnDate = time.Now()
+	}
+	if customerReturn.RefundType == "credit_note" {
+		customerReturn.CreditNoteAmount = utils.RoundMoney(total)
+	} else {
+		customerReturn.RefundAmount = utils.RoundMoney(total)
+	}
+
+	if tx.Omit("SalesOrder").Create(&customerReturn).Error != nil {
+		tx.Rollback()
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to create return")
+		return
+	}
+
+	// restocked units go back to sellable stock through the ledger, quarantined units are held apart
+	// and scrapped units pass through quarantine straight out again, they never re-enter stock
+	for _, line := range customerReturn.Lines {
+		if product.Serialized {
+			if err := returnSerials(tx, salesOrder, customerReturn.ID, line); err != nil {
+				tx.Rollback()
+				respondWithSerialError(w, err)
+				return
+			}
+		}
+		switch line.Disposition {
+		case "restock":
+			err = moveStock(tx, &product, line.Quantity, "customer_return", "return", customerReturn.ID)
+			if err == nil && product.LotTracked {
+				err = restockLots(tx, salesOrder.ID, line.Quantity)
+			}
+			if err == nil {
+				err = allocateBackorders(tx, &product, "return", customerReturn.ID)
+			}
+		case "quarantine":
+			err = moveStock(tx, &product, line.Quantity, "quarantined", "return", customerReturn.ID)
+		case "scrap":
+			err = moveStock(tx, &product, line.Quantity, "quarantined", "return", customerReturn.ID)
+			if err == nil {
+				err = moveStock(tx, &product, -line.Quantity, "scrapped", "return", customerReturn.ID)
+			}
+		}
+		if err != nil {
+			tx.Rollback()
+			utils.RespondWithError(w, http.StatusInternalServerError, "Failed to update product stock")
+			return
+		}
+	}
+
+	tx.Commit()
+
+	var fullReturn models.Return
+	if database.For(r).Preload("Lines").Preload("SalesOrder.Product", withDeleted).First(&fullReturn, customerReturn.ID).Error != nil {
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to fetch full return")
+		return
+	}
+	utils.RespondWithJSON(w, http.StatusOK, fullReturn)
+}
+
+// releaseQuarantineRequest is the body of ReleaseQuarantine
+type releaseQuarantineRequest struct {
+	Quantity    int    `validate:"min=1"`
+	Disposition string `validate:"required,enum=restock|scrap"`
+	LotID       *uint
+	Serials     []string
+}
+
+// ReleaseQuarantine settles quarantined units after inspection, POST /product/{id}/release-quarantine
+// with {"Quantity":2,"Disposition":"restock"} or "scrap". Restocking a lot-tracked product needs
+// the LotID the units go back into, serialized products name the units in Serials.
+func ReleaseQuarantine(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	var request releaseQuarantineRequest
+	if !utils.DecodeJSON(w, r, &request) {
+		return
+	}
+
+	tx := database.For(r).Begin()
+
+	var product models.Product
+	if tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&product, id).Error != nil {
+		tx.Rollback()
+		utils.RespondWithError(w, http.StatusNotFound, "Product not found")
+		return
+	}
+	if request.Quantity > product.QuarantineQuantity {
+		tx.Rollback()
+		utils.RespondWithError(w, http.StatusBadRequest, "Not enough units in quarantine")
+		return
+	}
+
+	restockLot := product.LotTracked && request.Disposition == "restock"
+	if restockLot {
+		if request.LotID == nil || tx.Where("product_id = ?", product.ID).First(&models.Lot{}, *request.LotID).Error != nil {
+			tx.Rollback()
+			utils.RespondWithError(w, http.StatusBadRequest, "A LotID of this product is required to restock a lot-tracked product")
+			return
+		}
+	}
+
+	// quarantined serials wait in status returned, inspection puts them back in stock or scraps them
+	if product.Serialized {
+		event, status := "restocked", "in_stock"
+		if request.Disposition == "scrap" {
+			event, status = "scrapped", "scrapped"
+		}
+		err := checkSerialCount(request.Serials, request.Quantity)
+		var serialNumbers []models.SerialNumber
+		if err == nil {
+			serialNumbers, err = lockSerials(tx, product.ID, request.Serials, "returned")
+		}
+		for i := 0; err == nil && i < len(serialNumbers); i++ {
+			if status == "in_stock" {
+				serialNumbers[i].SalesOrderID = nil
+			}
+			err = recordSerialEvent(tx, &serialNumbers[i], event, status, "product", product.ID)
+		}
+		if err != nil {
+			tx.Rollback()
+			respondWithSerialError(w, err)
+			return
+		}
+	}
+
+	var err error
+	if restockLot {
+		err = tx.Model(&models.Lot{}).Where("id = ?", *request.LotID).UpdateColumn("quantity", gorm.Expr("quantity + ?", request.Quantity)).Error
+	}
+	if err != nil {
+		tx.Rollback()
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to update lot")
+		return
+	}
+	if request.Disposition == "restock" {
+		// the units leave the quarantine stock and enter the sellable one
+		err = moveStock(tx, &product, -request.Quantity, "quarantine_restocked", "product", product.ID)
+		if err == nil {
+			err = moveStock(tx, &product, request.Quantity, "quarantine_released", "product", product.ID)
+		}
+		if err == nil {
+			err = allocateBackorders(tx, &product, "product", product.ID)
+		}
+	} else {
+		err = moveStock(tx, &product, -request.Quantity, "scrapped", "product", product.ID)
+	}
+	if err != nil {
+		tx.Rollback()
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to update product stock")
+		return
+	}
+
+	tx.Commit()
+	utils.RespondWithJSON(w, http.StatusOK, product)
+}
@@ -0,0 +1,50 @@
+package controllers_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+// Returned units are refunded at what they were sold for and go back to stock, into quarantine
+// or out of the warehouse by their disposition.
+func TestCreateReturn(t *testing.T) {
+	router, acme := setUp(t)
+	product := create(t, router, acme, "POST", "/add-product", `{"Name":"desk","SKU":"DESK","Price":100,"Quantity":10}`)
+	// 4 units at 100 less 10% are 90 each
+	salesOrder := create(t, router, acme, "POST", "/add-sales-order", fmt.Sprintf(`{"ProductID":%d,"Quantity":4,"LineDiscountType":"percent","LineDiscountValue":10}`, product))
+	ship(t, router, acme, salesOrder, 4)
+
+	tests := []struct {
+		name           string
+		body           string
+		wantCode       int
+		wantRefund     float64
+		wantCredit     float64
+		wantQuantity   int
+		wantQuarantine int
+	}{
+		{"restock", `{"Reason":"unwanted","Lines":[{"Quantity":1,"Disposition":"restock"}]}`, http.StatusOK, 90, 0, 7, 0},
+		{"quarantine as credit note", `{"Reason":"dented","RefundType":"credit_note","Lines":[{"Quantity":1,"Disposition":"quarantine"}]}`, http.StatusOK, 0, 90, 7, 1},
+		{"scrap", `{"Reason":"broken","Lines":[{"Quantity":1,"Disposition":"scrap"}]}`, http.StatusOK, 90, 0, 7, 1},
+		{"more than shipped", `{"Reason":"unwanted","Lines":[{"Quantity":2,"Disposition":"restock"}]}`, http.StatusBadRequest, 0, 0, 7, 1},
+		{"unknown disposition", `{"Reason":"unwanted","Lines":[{"Quantity":1,"Disposition":"resell"}]}`, http.StatusBadRequest, 0, 0, 7, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := acme.Do(router, "POST", fmt.Sprintf("/sales-order/%d/return", salesOrder), tt.body)
+			if w.Code != tt.wantCode {
+				t.Fatalf("answered %d, want %d: %s", w.Code, tt.wantCode, w.Body)
+			}
+			var customerReturn struct{ RefundAmount, CreditNoteAmount float64 }
+			json.Unmarshal(w.Body.Bytes(), &customerReturn)
+			if customerReturn.RefundAmount != tt.wantRefund || customerReturn.CreditNoteAmount != tt.wantCredit {
+				t.Errorf("refund %v credit note %v, want %v and %v", customerReturn.RefundAmount, customerReturn.CreditNoteAmount, tt.wantRefund, tt.wantCredit)
+			}
+			if got := stock(t, acme, product); got.Quantity != tt.wantQuantity || got.QuarantineQuantity != tt.wantQuarantine {
+				t.Errorf("stock %d quarantined %d, want %d and %d", got.Quantity, got.QuarantineQuantity, tt.wantQuantity, tt.wantQuarantine)
+			}
+		})
+	}
+}
@@ -0,0 +1,279 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"inventory-control-hub/audit"
+	"inventory-control-hub/auth"
+	"inventory-control-hub/database"
+	"inventory-control-hub/models"
+	"inventory-control-hub/utils"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+)
+
+// adminRole is built in, it always holds every permission so somebody can manage the rest
+const adminRole = "admin"
+
+// roleRequest is the body of AddRole
+type roleRequest struct {
+	Name        string `validate:"required"`
+	Description string
+	Permissions []string
+}
+
+// roleUpdateRequest is the body of UpdateRole, an empty Name keeps the role's name
+type roleUpdateRequest struct {
+	Name        string
+	Description *string
+	Permissions *[]string
+}
+
+// userRolesRequest is the body of SetUserRoles
+type userRolesRequest struct {
+	Roles []string
+}
+
+// rolePermissions validates permission names and turns them into rows of a role
+func rolePermissions(names []string) ([]models.RolePermission, error) {
+	seen := map[string]bool{}
+	var permissions []models.RolePermission
+	for _, name := range names {
+		if !auth.IsPermission(name) {
+			return nil, fmt.Errorf("Unknown permission %s", name)
+		}
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		permissions = append(permissions, models.RolePermission{Permission: name})
+	}
+	return permissions, nil
+}
+
+// GetPermissions lists every permission a role can be given, GET /permissions
+func GetPermissions(w http.ResponseWriter, r *http.Request) {
+	utils.RespondWithJSON(w, http.StatusOK, auth.Permissions)
+}
+
+func GetRoles(w http.ResponseWriter, r *http.Request) {
+	var roles []models.Role
+	if database.For(r).Preload("Permissions").Order("id").Find(&roles).Error != nil {
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to fetch roles")
+		return
+	}
+	utils.RespondWithJSON(w, http.StatusOK, roles)
+}
+
+// AddRole creates a role, POST /add-role with {"Name":"auditor","Description":"...","Permissions":["reports:read"]}
+func AddRole(w http.ResponseWriter, r *http.Request) {
+	var request roleRequest
+	if !utils.DecodeJSON(w, r, &request) {
+		return
+	}
+	name := strings.ToLower(strings.TrimSpace(request.Name))
+	permissions, err := rolePermissions(request.Permissions)
+	if err != nil {
+		utils.RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	var count int64
+	database.For(r).Model(&models.Role{}).Where("name = ?", name).Count(&count)
+	if count > 0 {
+		utils.RespondWithError(w, http.StatusConflict, "A role with this name already exists")
+		return
+	}
+
+	role := models.Role{Name: name, Description: request.Description, Permissions: permissions}
+	if database.For(r).Create(&role).Error != nil {
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to create role")
+		return
+	}
+	utils.RespondWithJSON(w, http.StatusOK, role)
+}
+
+// UpdateRole changes a role, PUT /update-role/{id} with {"Name":"...","Description":"...","Permissions":[...]}.
+// Permissions, when sent, replace the role's permissions. The admin role cannot be changed.
+func UpdateRole(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	var request roleUpdateRequest
+	if !utils.DecodeJSON(w, r, &request) {
+		return
+	}
+
+	var role models.Role
+	if database.For(r).Preload("Permissions").First(&role, id).Error != nil {
+		utils.RespondWithError(w, http.StatusNotFound, "Role not found")
+		return
+	}
+	if role.Name == adminRole {
+		utils.RespondWithError(w, http.StatusConflict, "The admin role cannot be changed")
+		return
+	}
+
+	if name := strings.ToLower(strings.TrimSpace(request.Name)); name != "" && name != role.Name {
+		var count int64
+		database.For(r).Model(&models.Role{}).Where("name = ?", name).Count(&count)
+		if count > 0 {
+			utils.RespondWithError(w, http.StatusConflict, "A role with this name already exists")
+			return
+		}
+		role.Name = name
+	}
+	if request.Description != nil {
+		role.Description = *request.Description
+	}
+
+	tx := database.For(r).Begin()
+	if tx.Model(&role).Updates(map[string]interface{}{"name": role.Name, "description": role.Description}).Error != nil {
+		tx.Rollback()
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to update role")
+		return
+	}
+	if request.Permissions != nil {
+		permissions, err := rolePermissions(*request.Permissions)
+		if err != nil {
+			tx.Rollback()
+			utils.RespondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if tx.Where("role_id = ?", role.ID).Delete(&models.RolePermission{}).Error != nil {
+			tx.Rollback()
+			utils.RespondWithError(w, http.StatusInternalServerError, "Failed to update role permissions")
+			return
+		}
+		for i := range permissions {
+			permissions[i].RoleID = &role.ID
+		}
+		if len(permissions) > 0 && tx.Create(&permissions).Error != nil {
+			tx.Rollback()
+			utils.RespondWithError(w, http.StatusInternalServerError, "Failed to update role permissions")
+			return
+		}
+		role.Permissions = permissions
+	}
+	tx.Commit()
+	utils.RespondWithJSON(w, http.StatusOK, role)
+}
+
+// DeleteRole deletes a role, its users lose its permissions, DELETE /delete-role/{id}
+func DeleteRole(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	var role models.Role
+	if database.For(r).First(&role, id).Error != nil {
+		utils.RespondWithError(w, http.StatusNotFound, "Role not found")
+		return
+	}
+	if role.Name == adminRole {
+		utils.RespondWithError(w, http.StatusConflict, "The admin role cannot be deleted")
+		return
+	}
+
+	tx := database.For(r).Begin()
+	// the users lose the role, through GORM and on the audit log of each user
+	var users []models.User
+	if tx.Preload("Roles").Joins("JOIN user_roles ON user_roles.user_id = users.id").
+		Where("user_roles.role_id = ?", role.ID).Find(&users).Error != nil {
+		tx.Rollback()
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to delete role")
+		return
+	}
+	for _, user := range users {
+		before := append([]models.Role(nil), user.Roles...)
+		remaining := make([]models.Role, 0, len(before))
+		for _, userRole := range before {
+			if userRole.ID != role.ID {
+				remaining = append(remaining, userRole)
+			}
+		}
+		if tx.Model(&user).Association("Roles").Delete(&role) != nil ||
+			recordUserRoles(tx, user, before, remaining) != nil {
+			tx.Rollback()
+			utils.RespondWithError(w, http.StatusInternalServerError, "Failed to delete role")
+			return
+		}
+	}
+	if tx.Where("role_id = ?", role.ID).Delete(&models.RolePermission{}).Error != nil ||
+		tx.Delete(&role).Error != nil {
+		tx.Rollback()
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to delete role")
+		return
+	}
+	tx.Commit()
+	utils.RespondWithJSON(w, http.StatusOK, "Role deleted successfully")
+}
+
+// findRoles looks up roles by name, every name has to exist
+func findRoles(db *gorm.DB, names []string) ([]models.Role, error) {
+	roles := []models.Role{}
+	for _, name := range names {
+		var role models.Role
+		err := db.Preload("Permissions").Where("name = ?", strings.ToLower(strings.TrimSpace(name))).First(&role).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("Unknown role %s", name)
+		}
+		if err != nil {
+			return nil, err
+		}
+		roles = append(roles, role)
+	}
+	return roles, nil
+}
+
+// SetUserRoles replaces the roles of a user, PUT /user/{id}/roles with {"Roles":["sales","warehouse"]}.
+// Users cannot take away their own right to manage users.
+func SetUserRoles(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	var request userRolesRequest
+	if !utils.DecodeJSON(w, r, &request) {
+		return
+	}
+
+	var user models.User
+	if database.For(r).Preload("Roles").First(&user, id).Error != nil {
+		utils.RespondWithError(w, http.StatusNotFound, "User not found")
+		return
+	}
+	previous := append([]models.Role(nil), user.Roles...)
+	roles, err := findRoles(database.For(r), request.Roles)
+	if err != nil {
+		utils.RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if user.ID == auth.CurrentUser(r).ID && !auth.HasPermission(&models.User{Roles: roles}, auth.UsersManage) {
+		utils.RespondWithError(w, http.StatusConflict, "You cannot take away your own permission to manage users")
+		return
+	}
+
+	tx := database.For(r).Begin()
+	if tx.Model(&user).Association("Roles").Replace(roles) != nil ||
+		recordUserRoles(tx, user, previous, roles) != nil {
+		tx.Rollback()
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to update user roles")
+		return
+	}
+	tx.Commit()
+	user.Roles = roles
+	utils.RespondWithJSON(w, http.StatusOK, user)
+}
+
+// recordUserRoles puts a change of a user's roles on the audit log, the join table rows
+// themselves are not audited
+func recordUserRoles(tx *gorm.DB, user models.User, before []models.Role, after []models.Role) error {
+	names := func(roles []models.Role) []string {
+		list := make([]string, len(roles))
+		for i, role := range roles {
+			list[i] = role.Name
+		}
+		return list
+	}
+	return audit.Record(tx, "users", user.ID, "update", map[string]map[string]interface{}{
+		"Roles": {"Old": names(before), "New": names(after)},
+	})
+}
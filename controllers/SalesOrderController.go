@@ -2,6 +2,7 @@ package controllers
 
 import (
 	"encoding/json"
+	"errors"
 	"inventory-control-hub/database"
 	"inventory-control-hub/models"
 	"inventory-control-hub/utils"
@@ -9,12 +10,18 @@ import (
 	"time"
 
 	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 func GetSalesOrder(w http.ResponseWriter, r *http.Request) {
 	var salesOrder []models.SalesOrder
 
-	result := database.DB.Preload("Product").Find(&salesOrder)
+	query := database.For(r)
+	if includeDeleted(r) {
+		query = query.Unscoped()
+	}
+	result := query.Preload("Product", withDeleted).Preload("Customer").Find(&salesOrder)
 
 	if result.Error != nil {
 		utils.RespondWithError(w, http.StatusNotFound, "Sales order not found")
@@ -25,22 +32,98 @@ func GetSalesOrder(w http.ResponseWriter, r *http.Request) {
 
 }
 
-func CreateSalesOrder(w http.ResponseWriter, r *http.Request) {
-	var salesOrder models.SalesOrder
-	err := json.NewDecoder(r.Body).Decode(&salesOrder)
+func GetSalesOrderById(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
 
-	if err != nil {
-		utils.RespondWithError(w, http.StatusBadRequest, "Invalid Input")
+	query := database.For(r)
+	if includeDeleted(r) {
+		query = query.Unscoped()
+	}
+	var salesOrder models.SalesOrder
+	if query.Preload("Product", withDeleted).Preload("Customer").Preload("Lots").Preload("SerialNumbers").First(&salesOrder, id).Error != nil {
+		utils.RespondWithError(w, http.StatusNotFound, "Sales order not found")
 		return
 	}
-	if salesOrder.ProductID==nil{
-		utils.RespondWithError(w, http.StatusBadRequest, "ProductID is required")
+	utils.SetETag(w, salesOrder.Version)
+	utils.RespondWithJSON(w, http.StatusOK, salesOrder)
+}
+
+// salesOrderRequest is the body of CreateSalesOrder, the rest of the order is worked out
+type salesOrderRequest struct {
+	ProductID         *uint `validate:"required"`
+	CustomerID        *uint
+	Quantity          int `validate:"min=1"`
+	AllowBackorder    bool
+	Priority          int
+	PromotionCode     string  `validate:"maxlen=50"`
+	Jurisdiction      string  `validate:"maxlen=50"`
+	LineDiscountType  string  `validate:"enum=percent|fixed"`
+	LineDiscountValue float64 `validate:"min=0"`
+	// a discount on the whole order, after the line discount
+	OrderDiscountType  string  `validate:"enum=percent|fixed"`
+	OrderDiscountValue float64 `validate:"min=0"`
+	// lots to take the units from, for lot-tracked products (first-expired-first-out when left out)
+	Lots []struct {
+		LotID    *uint `validate:"required"`
+		Quantity int   `validate:"min=1"`
+	}
+	// serials of the units sold, for serialized products
+	SerialNumbers []struct {
+		Serial string `validate:"required"`
+	}
+}
+
+func (request salesOrderRequest) Validate(errs *utils.FieldErrors) {
+	validateDiscount(errs, "LineDiscount", request.LineDiscountType, request.LineDiscountValue)
+	validateDiscount(errs, "OrderDiscount", request.OrderDiscountType, request.OrderDiscountValue)
+}
+
+// validateDiscount checks the <prefix>Type and <prefix>Value pair of a discount, the rules
+// discountAmount applies
+func validateDiscount(errs *utils.FieldErrors, prefix string, discountType string, value float64) {
+	if discountType == "percent" && value > 100 {
+		errs.Add(prefix+"Value", "max", "must be at most 100 for a percent discount")
+	}
+	if discountType == "" && value != 0 {
+		errs.Add(prefix+"Type", "required", "is required with a "+prefix+"Value")
+	}
+}
+
+// salesOrder is the order the request asks for
+func (request salesOrderRequest) salesOrder() models.SalesOrder {
+	salesOrder := models.SalesOrder{
+		ProductID:          request.ProductID,
+		CustomerID:         request.CustomerID,
+		Quantity:           request.Quantity,
+		AllowBackorder:     request.AllowBackorder,
+		Priority:           request.Priority,
+		PromotionCode:      request.PromotionCode,
+		Jurisdiction:       request.Jurisdiction,
+		LineDiscountType:   request.LineDiscountType,
+		LineDiscountValue:  request.LineDiscountValue,
+		OrderDiscountType:  request.OrderDiscountType,
+		OrderDiscountValue: request.OrderDiscountValue,
+		Version:            1,
+	}
+	for _, lot := range request.Lots {
+		salesOrder.Lots = append(salesOrder.Lots, models.LotAllocation{LotID: lot.LotID, Quantity: lot.Quantity})
+	}
+	for _, serialNumber := range request.SerialNumbers {
+		salesOrder.SerialNumbers = append(salesOrder.SerialNumbers, models.SerialNumber{Serial: serialNumber.Serial})
+	}
+	return salesOrder
+}
+
+func CreateSalesOrder(w http.ResponseWriter, r *http.Request) {
+	var request salesOrderRequest
+	if !utils.DecodeJSON(w, r, &request) {
 		return
 	}
+	salesOrder := request.salesOrder()
 
 	var product models.Product
 
-	result := database.DB.First(&product, salesOrder.ProductID)
+	result := database.For(r).First(&product, salesOrder.ProductID)
 
 	//checking if the product whose order has come exists or not
 	if result.Error != nil {
@@ -48,22 +131,85 @@ func CreateSalesOrder(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if salesOrder.Quantity <= 0 {
-		utils.RespondWithError(w, http.StatusBadRequest, "Quantity must be greater than 0")
+	if product.Archived {
+		utils.RespondWithError(w, http.StatusConflict, "Product is archived and cannot be sold")
 		return
 	}
 
-	// if product is available-check the stock of the product
-	if product.Quantity < salesOrder.Quantity {
-		utils.RespondWithError(w, http.StatusBadRequest, "Product out of stock")
+	if salesOrder.AllowBackorder && tracksUnits(product) {
+		utils.RespondWithError(w, http.StatusBadRequest, "Lot-tracked and serialized products cannot be backordered")
 		return
 	}
 
-	// price and order-date
-	salesOrder.TotalPrice = float64(salesOrder.Quantity) * product.Price
+	// serialized products are sold unit by unit, the request names the serials
+	requestedSerials := serialList(salesOrder.SerialNumbers)
+	salesOrder.SerialNumbers = nil
+	if product.Serialized {
+		if err := checkSerialCount(requestedSerials, salesOrder.Quantity); err != nil {
+			respondWithSerialError(w, err)
+			return
+		}
+	}
+
+	// promotion code is checked up front, the use itself is counted inside the transaction
+	var promotion *models.Promotion
+	if salesOrder.PromotionCode != "" {
+		var err error
+		promotion, err = findPromotion(database.For(r), salesOrder.PromotionCode, time.Now())
+		if err != nil {
+			respondWithPromotionError(w, err)
+			return
+		}
+	}
+
+	// price (customer's price list or product price), discounts, tax and order-date
+	salesOrder.Customer = nil
+	salesOrder.ProductName = product.Name
+	salesOrder.ProductSKU = product.SKU
+	salesOrder.Jurisdiction = orderJurisdiction(salesOrder.Jurisdiction)
+	if err := priceSalesOrder(database.For(r), &salesOrder, product, promotion, time.Now()); err != nil {
+		respondWithPricingError(w, err)
+		return
+	}
 	salesOrder.OrderDate = time.Now()
+	salesOrder.PickedQuantity, salesOrder.ShortQuantity, salesOrder.PackedQuantity, salesOrder.ShippedQuantity = 0, 0, 0, 0
+
+	tx := database.For(r).Begin()
+
+	// if product is available-check the stock of the product, units reserved for other orders don't count.
+	// With AllowBackorder the missing units wait for stock instead. The product stays locked until
+	// the units are reserved, so concurrent orders can't promise the same stock
+	if tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&product, product.ID).Error != nil {
+		tx.Rollback()
+		utils.RespondWithError(w, http.StatusNotFound, "Product not found")
+		return
+	}
+	var err error
+	salesOrder.BackorderedQuantity, err = shortOfStock(tx, product, salesOrder.Quantity)
+	if err != nil {
+		tx.Rollback()
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to check stock")
+		return
+	}
+	if salesOrder.BackorderedQuantity > 0 && !salesOrder.AllowBackorder {
+		tx.Rollback()
+		utils.RespondWithError(w, http.StatusBadRequest, "Product out of stock")
+		return
+	}
+	salesOrder.Status = salesOrderStatus(salesOrder, false)
+
+	if promotion != nil {
+		if err := redeemPromotion(tx, promotion.ID); err != nil {
+			tx.Rollback()
+			respondWithPromotionError(w, err)
+			return
+		}
+	}
+
+	// lots are allocated after the order exists, Lots in the request only picks them
+	requestedLots := salesOrder.Lots
+	salesOrder.Lots = nil
 
-	tx := database.DB.Begin()
 	res := tx.Create(&salesOrder)
 
 	if res.Error != nil {
@@ -72,132 +218,610 @@ func CreateSalesOrder(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// update product quantity
-	product.Quantity = product.Quantity - salesOrder.Quantity
+	if product.LotTracked {
+		if err := allocateLots(tx, salesOrder, requestedLots, time.Now()); err != nil {
+			tx.Rollback()
+			respondWithLotError(w, err)
+			return
+		}
+	}
+	if product.Serialized {
+		if err := sellSerials(tx, salesOrder, requestedSerials); err != nil {
+			tx.Rollback()
+			respondWithSerialError(w, err)
+			return
+		}
+	}
 
-	if tx.Save(product).Error != nil {
+	// reserve the units, they leave stock when the order ships
+	if err := reserveStock(tx, &product, salesOrder.Quantity-salesOrder.BackorderedQuantity, salesOrder.ID); err != nil {
 		tx.Rollback()
-		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to update product quantity")
+		respondWithStockError(w, err)
 		return
 
 	}
 
 	tx.Commit()
-	if err := database.DB.Preload("Product").First(&salesOrder, salesOrder.ID).Error; err != nil {
+	if err := database.For(r).Preload("Product", withDeleted).Preload("Customer").Preload("Lots").Preload("SerialNumbers").First(&salesOrder, salesOrder.ID).Error; err != nil {
 		utils.RespondWithError(w, http.StatusNotFound, "Sales order not found")
 		return
 	}
 
+	utils.SetETag(w, salesOrder.Version)
 	utils.RespondWithJSON(w, http.StatusOK, salesOrder)
 
 }
 
+// salesOrderUpdateRequest is the body of UpdateSalesOrder. The product and promotion cannot
+// change, a discount is only replaced when its type is sent.
+type salesOrderUpdateRequest struct {
+	ProductID          *uint
+	Quantity           int `validate:"min=1"`
+	AllowBackorder     bool
+	Priority           int
+	PromotionCode      string
+	Jurisdiction       string  `validate:"maxlen=50"`
+	LineDiscountType   string  `validate:"enum=percent|fixed"`
+	LineDiscountValue  float64 `validate:"min=0"`
+	OrderDiscountType  string  `validate:"enum=percent|fixed"`
+	OrderDiscountValue float64 `validate:"min=0"`
+}
+
+func (request salesOrderUpdateRequest) Validate(errs *utils.FieldErrors) {
+	validateDiscount(errs, "LineDiscount", request.LineDiscountType, request.LineDiscountValue)
+	validateDiscount(errs, "OrderDiscount", request.OrderDiscountType, request.OrderDiscountValue)
+}
+
 func UpdateSalesOrder(w http.ResponseWriter, r *http.Request) {
 	// fetch id from path
 	params := mux.Vars(r)
 
 	id := params["id"]
-	var salesOrder models.SalesOrder
+	var salesOrder salesOrderUpdateRequest
 
-	err := json.NewDecoder(r.Body).Decode(&salesOrder)
-	if err != nil {
-		utils.RespondWithError(w, http.StatusBadRequest, "Invalid input")
+	if !utils.DecodeJSON(w, r, &salesOrder) {
 		return
 	}
 
 	// existing record
 
-	var existingOrder models.SalesOrder
+	existingOrder, product, ok := editableSalesOrder(w, r, id)
+	if !ok {
+		return
+	}
 
-	if database.DB.First(&existingOrder, id).Error != nil {
-		utils.RespondWithError(w, http.StatusNotFound, "Sales order not found")
+	if salesOrder.ProductID != nil {
+		if existingOrder.ProductID == nil || *salesOrder.ProductID != *existingOrder.ProductID {
+			utils.RespondWithError(w, http.StatusConflict, "The sales order cannot be updated for new product, please cancel the order and place another order again ")
+		 	return
+		}
+	}
+
+	// if product id is updated
+
+	if salesOrder.AllowBackorder {
+		if tracksUnits(product) {
+			utils.RespondWithError(w, http.StatusBadRequest, "Lot-tracked and serialized products cannot be backordered")
+			return
+		}
+		existingOrder.AllowBackorder = true
+	}
+	if salesOrder.Priority != 0 {
+		existingOrder.Priority = salesOrder.Priority
+	}
+
+	//update order
+	if salesOrder.Jurisdiction != "" {
+		existingOrder.Jurisdiction = salesOrder.Jurisdiction
+	}
+	if salesOrder.LineDiscountType != "" {
+		existingOrder.LineDiscountType = salesOrder.LineDiscountType
+		existingOrder.LineDiscountValue = salesOrder.LineDiscountValue
+	}
+	if salesOrder.OrderDiscountType != "" {
+		existingOrder.OrderDiscountType = salesOrder.OrderDiscountType
+		existingOrder.OrderDiscountValue = salesOrder.OrderDiscountValue
+	}
+	if salesOrder.PromotionCode != "" && salesOrder.PromotionCode != existingOrder.PromotionCode {
+		utils.RespondWithError(w, http.StatusConflict, "Promotion code cannot be changed, please cancel the order and place another order again")
 		return
 	}
 
+	saveSalesOrderUpdate(w, r, existingOrder, product, salesOrder.Quantity)
+}
+
+// editableSalesOrder loads the sales order with id and its product for a change, answering
+// when it doesn't exist, the client's copy is stale or the order is past changing
+func editableSalesOrder(w http.ResponseWriter, r *http.Request, id string) (models.SalesOrder, models.Product, bool) {
+	var existingOrder models.SalesOrder
 	var product models.Product
-	if database.DB.First(&product, existingOrder.ProductID).Error != nil {
+
+	if database.For(r).First(&existingOrder, id).Error != nil {
+		utils.RespondWithError(w, http.StatusNotFound, "Sales order not found")
+		return existingOrder, product, false
+	}
+	if !utils.IfMatch(w, r, existingOrder.Version) {
+		return existingOrder, product, false
+	}
+
+	if isInvoiced(database.For(r), existingOrder.ID) {
+		utils.RespondWithError(w, http.StatusConflict, "Sales order has been invoiced and cannot be changed")
+		return existingOrder, product, false
+	}
+	if hasReturns(database.For(r), existingOrder.ID) {
+		utils.RespondWithError(w, http.StatusConflict, "Goods were returned on this sales order, it cannot be changed")
+		return existingOrder, product, false
+	}
+	if hasPickTasks(database.For(r), existingOrder.ID) {
+		utils.RespondWithError(w, http.StatusConflict, "Picking has started on this sales order, it cannot be changed")
+		return existingOrder, product, false
+	}
+
+	if database.For(r).First(&product, existingOrder.ProductID).Error != nil {
 		utils.RespondWithError(w, http.StatusNotFound, "The ordered product not found")
+		return existingOrder, product, false
+	}
+	return existingOrder, product, true
+}
+
+// saveSalesOrderUpdate moves the changed order to quantity, reserving or releasing stock,
+// re-prices it and answers with it. Shared by PUT and PATCH.
+func saveSalesOrderUpdate(w http.ResponseWriter, r *http.Request, existingOrder models.SalesOrder, product models.Product, quantity int) {
+	if quantity != existingOrder.Quantity && product.Serialized {
+		utils.RespondWithError(w, http.StatusConflict, "Quantity of a serialized order cannot be changed, please cancel the order and place another order again")
 		return
 	}
 
-	if salesOrder.ProductID != nil {
-		if salesOrder.ProductID != existingOrder.ProductID {
-			utils.RespondWithError(w, http.StatusConflict, "The sales order cannot be updated for new product, please cancel the order and place another order again ")
-		 	return
+	// the promotion was already redeemed when the order was placed, so only its discount is re-applied
+	var promotion *models.Promotion
+	if existingOrder.PromotionID != nil {
+		promotion = &models.Promotion{}
+		if database.For(r).First(promotion, existingOrder.PromotionID).Error != nil {
+			utils.RespondWithError(w, http.StatusNotFound, "Applied promotion not found")
+			return
 		}
 	}
 
-	// if product id is updated
+	// re-price, a new quantity can cross a quantity break or the promotion's minimum
+	reserved := existingOrder.Quantity - existingOrder.BackorderedQuantity
+	existingOrder.Quantity = quantity
+	existingOrder.Jurisdiction = orderJurisdiction(existingOrder.Jurisdiction)
+	if err := priceSalesOrder(database.For(r), &existingOrder, product, promotion, time.Now()); err != nil {
+		respondWithPricingError(w, err)
+		return
+	}
 
-	product.Quantity = product.Quantity + existingOrder.Quantity
+	tx := database.For(r).Begin()
+	if err := database.LockVersion(tx, &models.SalesOrder{}, existingOrder.ID, existingOrder.Version); err != nil {
+		tx.Rollback()
+		respondWithVersionError(w, err)
+		return
+	}
 
-	//insufficient
-	if salesOrder.Quantity > product.Quantity {
+	//insufficient, the units the order already reserved count as available. The product stays
+	//locked until the change is booked, so concurrent orders can't promise the same stock
+	if tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&product, product.ID).Error != nil {
+		tx.Rollback()
+		utils.RespondWithError(w, http.StatusNotFound, "The ordered product not found")
+		return
+	}
+	product.ReservedQuantity -= reserved
+	backordered, err := shortOfStock(tx, product, quantity)
+	product.ReservedQuantity += reserved
+	if err != nil {
+		tx.Rollback()
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to check stock")
+		return
+	}
+	if backordered > 0 && !existingOrder.AllowBackorder {
+		tx.Rollback()
 		utils.RespondWithError(w, http.StatusBadRequest, "Insufficient stock for the updated quantity")
 		return
 	}
+	stockChange := reserved - (quantity - backordered)
+	existingOrder.BackorderedQuantity = backordered
+	existingOrder.Status = salesOrderStatus(existingOrder, false)
 
-	product.Quantity = product.Quantity - salesOrder.Quantity
-
-	//update order
-	existingOrder.Quantity = salesOrder.Quantity
-	existingOrder.TotalPrice = float64(salesOrder.Quantity) * product.Price
-	existingOrder.OrderDate = time.Now()
-
-	tx := database.DB.Begin()
 	if err := tx.Save(&existingOrder).Error; err != nil {
 		tx.Rollback()
 		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to update order")
 		return
 	}
 
-	if err := tx.Save(&product).Error; err != nil {
-		tx.Rollback()
-		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to update product stock")
-		return
+	if stockChange != 0 {
+		var err error
+		if stockChange > 0 {
+			err = releaseStock(tx, &product, stockChange, existingOrder.ID)
+		} else {
+			err = reserveStock(tx, &product, -stockChange, existingOrder.ID)
+		}
+		if err != nil {
+			tx.Rollback()
+			respondWithStockError(w, err)
+			return
+		}
+		// the new quantity is allocated from scratch, first-expired-first-out
+		if product.LotTracked {
+			if err := releaseLots(tx, existingOrder.ID); err != nil {
+				tx.Rollback()
+				respondWithLotError(w, err)
+				return
+			}
+			if err := allocateLots(tx, existingOrder, nil, time.Now()); err != nil {
+				tx.Rollback()
+				respondWithLotError(w, err)
+				return
+			}
+		}
+		// units the order gave up can go to orders waiting for them
+		if stockChange > 0 && allocateBackorders(tx, &product, "sales_order", existingOrder.ID) != nil {
+			tx.Rollback()
+			utils.RespondWithError(w, http.StatusInternalServerError, "Failed to allocate backorders")
+			return
+		}
 	}
 	tx.Commit()
 	var updatedOrder models.SalesOrder
-	if database.DB.Preload("Product").First(&updatedOrder, id).Error != nil {
+	if database.For(r).Preload("Product", withDeleted).Preload("Customer").Preload("Lots").Preload("SerialNumbers").First(&updatedOrder, existingOrder.ID).Error != nil {
 		utils.RespondWithError(w, http.StatusNotFound, "Updated sales order not found")
 		return
 	}
+	utils.SetETag(w, updatedOrder.Version)
 	utils.RespondWithJSON(w, http.StatusOK, updatedOrder)
 
 }
 
+// salesOrderPatch is the body of PATCH /sales-order/{id}, see PatchSalesOrder
+type salesOrderPatch struct {
+	Quantity           utils.Optional[int]
+	AllowBackorder     utils.Optional[bool]
+	Priority           utils.Optional[int]
+	Jurisdiction       utils.Optional[string]
+	LineDiscountType   utils.Optional[string]
+	LineDiscountValue  utils.Optional[float64]
+	OrderDiscountType  utils.Optional[string]
+	OrderDiscountValue utils.Optional[float64]
+}
+
+// PatchSalesOrder changes only the fields sent, as a JSON Merge Patch: PATCH /sales-order/{id}
+// with {"Priority":0,"LineDiscountType":null}. Null resets Priority to 0, Jurisdiction to the
+// default and removes the line or order discount. The product and promotion cannot be changed.
+func PatchSalesOrder(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	var patch salesOrderPatch
+	errs, ok := utils.DecodeMergePatch(w, r, &patch)
+	if !ok {
+		return
+	}
+	existingOrder, product, ok := editableSalesOrder(w, r, id)
+	if !ok {
+		return
+	}
+
+	quantity := existingOrder.Quantity
+	if patch.Quantity.Present {
+		if patch.Quantity.Null || patch.Quantity.Value <= 0 {
+			errs.Add("Quantity", "min", "must be greater than 0")
+		} else {
+			quantity = patch.Quantity.Value
+		}
+	}
+	if patch.AllowBackorder.Present {
+		switch {
+		case patch.AllowBackorder.Null:
+			errs.Add("AllowBackorder", "type", "must be true or false")
+		case patch.AllowBackorder.Value && tracksUnits(product):
+			errs.Add("AllowBackorder", "tracked", "is not possible for lot-tracked and serialized products")
+		default:
+			existingOrder.AllowBackorder = patch.AllowBackorder.Value
+		}
+	}
+	if patch.Priority.Present {
+		existingOrder.Priority = patch.Priority.Value
+	}
+	if patch.Jurisdiction.Present {
+		existingOrder.Jurisdiction = patch.Jurisdiction.Value
+	}
+	for _, discount := range []struct {
+		name         string
+		typePatch    utils.Optional[string]
+		valuePatch   utils.Optional[float64]
+		discountType *string
+		value        *float64
+	}{
+		{"LineDiscount", patch.LineDiscountType, patch.LineDiscountValue, &existingOrder.LineDiscountType, &existingOrder.LineDiscountValue},
+		{"OrderDiscount", patch.OrderDiscountType, patch.OrderDiscountValue, &existingOrder.OrderDiscountType, &existingOrder.OrderDiscountValue},
+	} {
+		if discount.typePatch.Present {
+			*discount.discountType = discount.typePatch.Value
+			if *discount.discountType == "" {
+				*discount.value = 0
+			}
+		}
+		if discount.valuePatch.Present {
+			*discount.value = discount.valuePatch.Value
+		}
+		switch *discount.discountType {
+		case "", "percent", "fixed":
+			if _, err := discountAmount(*discount.discountType, *discount.value, 0); err != nil {
+				errs.Add(discount.name+"Value", "range", "must be 0 or more, a percent at most 100")
+			}
+		default:
+			errs.Add(discount.name+"Type", "enum", "must be percent or fixed")
+		}
+	}
+	if len(errs) > 0 {
+		utils.RespondWithFieldErrors(w, errs)
+		return
+	}
+	saveSalesOrderUpdate(w, r, existingOrder, product, quantity)
+}
+
 func DeleteSalesOrder(w http.ResponseWriter, r *http.Request) {
 	params := mux.Vars(r)
 	id := params["id"]
 
 	var salesOrder models.SalesOrder
 
-	if database.DB.First(&salesOrder, id).Error != nil {
+	if database.For(r).First(&salesOrder, id).Error != nil {
 		utils.RespondWithError(w, http.StatusNotFound, "Sales order not found")
 		return
 	}
+	if !utils.IfMatch(w, r, salesOrder.Version) {
+		return
+	}
+
+	if isInvoiced(database.For(r), salesOrder.ID) {
+		utils.RespondWithError(w, http.StatusConflict, "Sales order has been invoiced and cannot be deleted")
+		return
+	}
+	if hasReturns(database.For(r), salesOrder.ID) {
+		utils.RespondWithError(w, http.StatusConflict, "Goods were returned on this sales order, book further returns instead of deleting it")
+		return
+	}
+	if hasPickTasks(database.For(r), salesOrder.ID) {
+		utils.RespondWithError(w, http.StatusConflict, "Picking has started on this sales order, it cannot be deleted")
+		return
+	}
 	// finding the associates product
 	var product models.Product
-	if database.DB.First(&product, salesOrder.ProductID).Error != nil {
+	if database.For(r).First(&product, salesOrder.ProductID).Error != nil {
 		utils.RespondWithError(w, http.StatusNotFound, "Product not found")
+		return
 	}
 
-	product.Quantity = product.Quantity + salesOrder.Quantity
-
-	tx := database.DB.Begin()
-	if database.DB.Save(&product).Error != nil {
+	tx := database.For(r).Begin()
+	if err := database.LockVersion(tx, &models.SalesOrder{}, salesOrder.ID, salesOrder.Version); err != nil {
+		tx.Rollback()
+		respondWithVersionError(w, err)
+		return
+	}
+	if releaseStock(tx, &product, salesOrder.Quantity-salesOrder.BackorderedQuantity, salesOrder.ID) != nil {
 		tx.Rollback()
 		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to update product stock")
 		return
 	}
+	if releaseLots(tx, salesOrder.ID) != nil {
+		tx.Rollback()
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to release lots")
+		return
+	}
+	if unsellSerials(tx, salesOrder.ID) != nil {
+		tx.Rollback()
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to release serial numbers")
+		return
+	}
+
+	// the promotion use goes back to the pool
+	if salesOrder.PromotionID != nil {
+		if releasePromotion(tx, *salesOrder.PromotionID) != nil {
+			tx.Rollback()
+			utils.RespondWithError(w, http.StatusInternalServerError, "Failed to release promotion code")
+			return
+		}
+	}
 
 	if tx.Delete(&salesOrder).Error != nil {
 		tx.Rollback()
 		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to delete sales order")
 		return
 	}
+	// the released units can go to orders waiting for them
+	if allocateBackorders(tx, &product, "sales_order", salesOrder.ID) != nil {
+		tx.Rollback()
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to allocate backorders")
+		return
+	}
 
 	tx.Commit()
 	utils.RespondWithJSON(w, http.StatusOK, "Sales order deleted successfully")
 
 }
+
+// RestoreSalesOrder brings back a deleted sales order, POST /sales-order/{id}/restore.
+// The goods are reserved again and the promotion use is counted again.
+func RestoreSalesOrder(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	var salesOrder models.SalesOrder
+	if database.For(r).Unscoped().First(&salesOrder, id).Error != nil {
+		utils.RespondWithError(w, http.StatusNotFound, "Sales order not found")
+		return
+	}
+	if !salesOrder.DeletedAt.Valid {
+		utils.RespondWithError(w, http.StatusConflict, "Sales order is not deleted")
+		return
+	}
+	if !utils.IfMatch(w, r, salesOrder.Version) {
+		return
+	}
+
+	tx := database.For(r).Begin()
+	if err := database.LockVersion(tx, &models.SalesOrder{}, salesOrder.ID, salesOrder.Version); err != nil {
+		tx.Rollback()
+		respondWithVersionError(w, err)
+		return
+	}
+
+	var product models.Product
+	if tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&product, salesOrder.ProductID).Error != nil {
+		tx.Rollback()
+		utils.RespondWithError(w, http.StatusNotFound, "The ordered product not found")
+		return
+	}
+	if product.Archived {
+		tx.Rollback()
+		utils.RespondWithError(w, http.StatusConflict, "Product is archived and cannot be sold")
+		return
+	}
+	backordered, err := shortOfStock(tx, product, salesOrder.Quantity)
+	if err != nil {
+		tx.Rollback()
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to check stock")
+		return
+	}
+	if backordered > 0 && !salesOrder.AllowBackorder {
+		tx.Rollback()
+		utils.RespondWithError(w, http.StatusBadRequest, "Product out of stock")
+		return
+	}
+
+	if salesOrder.PromotionID != nil {
+		if err := redeemPromotion(tx, *salesOrder.PromotionID); err != nil {
+			tx.Rollback()
+			if errors.Is(err, errPromotionNotFound) {
+				utils.RespondWithError(w, http.StatusNotFound, "Applied promotion not found")
+				return
+			}
+			respondWithPromotionError(w, err)
+			return
+		}
+	}
+
+	if err := reserveStock(tx, &product, salesOrder.Quantity-backordered, salesOrder.ID); err != nil {
+		tx.Rollback()
+		respondWithStockError(w, err)
+		return
+	}
+	if product.LotTracked {
+		if err := allocateLots(tx, salesOrder, nil, time.Now()); err != nil {
+			tx.Rollback()
+			respondWithLotError(w, err)
+			return
+		}
+	}
+	// the same units are sold again, they must not have gone to another customer meanwhile
+	if product.Serialized {
+		serials, err := cancelledSerials(tx, salesOrder.ID)
+		if err == nil {
+			err = sellSerials(tx, salesOrder, serials)
+		}
+		if err != nil {
+			tx.Rollback()
+			respondWithSerialError(w, err)
+			return
+		}
+	}
+
+	// the backorder queue goes by order date, so the restored order gets its old place back,
+	// ahead of orders placed after it
+	salesOrder.BackorderedQuantity = backordered
+	salesOrder.Status = salesOrderStatus(salesOrder, false)
+	if tx.Unscoped().Model(&salesOrder).Updates(map[string]interface{}{
+		"deleted_at":           nil,
+		"backordered_quantity": salesOrder.BackorderedQuantity,
+		"status":               salesOrder.Status,
+	}).Error != nil {
+		tx.Rollback()
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to restore sales order")
+		return
+	}
+
+	tx.Commit()
+
+	var restoredOrder models.SalesOrder
+	if database.For(r).Preload("Product", withDeleted).Preload("Customer").Preload("Lots").Preload("SerialNumbers").First(&restoredOrder, salesOrder.ID).Error != nil {
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to fetch restored sales order")
+		return
+	}
+	utils.SetETag(w, restoredOrder.Version)
+	utils.RespondWithJSON(w, http.StatusOK, restoredOrder)
+}
+
+// isInvoiced reports whether an invoice was generated for the order, invoiced orders are frozen
+func isInvoiced(db *gorm.DB, salesOrderID uint) bool {
+	var count int64
+	db.Model(&models.Invoice{}).Where("sales_order_id = ?", salesOrderID).Count(&count)
+	return count > 0
+}
+
+// priceSalesOrder fills in the order's unit price, discounts and tax breakdown.
+// Order of operations: quoted line amount, minus line discount, minus promotion discount, then tax.
+func priceSalesOrder(db *gorm.DB, salesOrder *models.SalesOrder, product models.Product, promotion *models.Promotion, at time.Time) error {
+	quote, err := quotePrice(db, product, salesOrder.CustomerID, salesOrder.Quantity, at)
+	if err != nil {
+		return err
+	}
+	salesOrder.UnitPrice = quote.UnitPrice
+	salesOrder.PriceListID = quote.PriceListID
+
+	lineDiscount, err := discountAmount(salesOrder.LineDiscountType, salesOrder.LineDiscountValue, quote.LineAmount)
+	if err != nil {
+		return err
+	}
+	amount := quote.LineAmount - lineDiscount
+
+	// the order discount comes off what is left after the line discount, the promotion off
+	// what is left after both
+	orderDiscount, err := discountAmount(salesOrder.OrderDiscountType, salesOrder.OrderDiscountValue, amount)
+	if err != nil {
+		return err
+	}
+	amount -= orderDiscount
+
+	promotionDiscount := 0.0
+	salesOrder.PromotionID = nil
+	salesOrder.PromotionCode = ""
+	if promotion != nil {
+		if amount < promotion.MinOrderValue {
+			return errPromotionMinimum
+		}
+		promotionDiscount, err = discountAmount(promotion.DiscountType, promotion.DiscountValue, amount)
+		if err != nil {
+			return err
+		}
+		salesOrder.PromotionID = &promotion.ID
+		salesOrder.PromotionCode = promotion.Code
+	}
+	amount -= promotionDiscount
+	orderDiscount = utils.RoundMoney(orderDiscount + promotionDiscount)
+
+	salesOrder.LineDiscountAmount = lineDiscount
+	salesOrder.OrderDiscountAmount = orderDiscount
+	salesOrder.DiscountAmount = utils.RoundMoney(lineDiscount + orderDiscount)
+
+	tax, err := calculateTax(db, product.TaxClassID, salesOrder.Jurisdiction, amount, product.PriceIncludesTax)
+	if err != nil {
+		return err
+	}
+	salesOrder.TaxRate = tax.Rate
+	salesOrder.NetAmount = tax.Net
+	salesOrder.TaxAmount = tax.Tax
+	salesOrder.GrossAmount = tax.Gross
+	salesOrder.TotalPrice = tax.Gross
+	return nil
+}
+
+func respondWithPricingError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, errCustomerNotFound):
+		utils.RespondWithError(w, http.StatusNotFound, "Customer not found")
+	case errors.Is(err, errInvalidDiscount):
+		utils.RespondWithError(w, http.StatusBadRequest, "Discount type must be percent or fixed with a non-negative value, percent at most 100")
+	case errors.Is(err, errPromotionMinimum):
+		utils.RespondWithError(w, http.StatusBadRequest, "Order does not meet the promotion's minimum order value")
+	default:
+		respondWithTaxError(w, err)
+	}
+}
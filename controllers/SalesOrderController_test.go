@@ -0,0 +1,76 @@
+package controllers_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+// Deleting a sales order gives its units back, restoring it reserves them again if they are
+// still there.
+func TestDeleteAndRestoreSalesOrder(t *testing.T) {
+	router, acme := setUp(t)
+	product := create(t, router, acme, "POST", "/add-product", `{"Name":"desk","SKU":"DESK","Price":100,"Quantity":5}`)
+	first := create(t, router, acme, "POST", "/add-sales-order", fmt.Sprintf(`{"ProductID":%d,"Quantity":3}`, product))
+	var second uint
+
+	tests := []struct {
+		name         string
+		do           func() int
+		wantCode     int
+		wantReserved int
+	}{
+		{"delete", func() int {
+			return acme.Do(router, "DELETE", fmt.Sprintf("/delete-sales-order/%d", first), "").Code
+		}, http.StatusOK, 0},
+		{"another order takes the units", func() int {
+			second = create(t, router, acme, "POST", "/add-sales-order", fmt.Sprintf(`{"ProductID":%d,"Quantity":4}`, product))
+			return http.StatusOK
+		}, http.StatusOK, 4},
+		{"restore without stock", func() int {
+			return acme.Do(router, "POST", fmt.Sprintf("/sales-order/%d/restore", first), "").Code
+		}, http.StatusBadRequest, 4},
+		{"the other order goes", func() int {
+			return acme.Do(router, "DELETE", fmt.Sprintf("/delete-sales-order/%d", second), "").Code
+		}, http.StatusOK, 0},
+		{"restore", func() int {
+			return acme.Do(router, "POST", fmt.Sprintf("/sales-order/%d/restore", first), "").Code
+		}, http.StatusOK, 3},
+		{"restore again", func() int {
+			return acme.Do(router, "POST", fmt.Sprintf("/sales-order/%d/restore", first), "").Code
+		}, http.StatusConflict, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if code := tt.do(); code != tt.wantCode {
+				t.Errorf("answered %d, want %d", code, tt.wantCode)
+			}
+			if got := stock(t, acme, product); got.ReservedQuantity != tt.wantReserved || got.Quantity != 5 {
+				t.Errorf("stock %d reserved %d, want 5 and %d", got.Quantity, got.ReservedQuantity, tt.wantReserved)
+			}
+		})
+	}
+}
+
+// An order keeps the product's name and SKU as they were when it was placed.
+func TestSalesOrderSnapshotsProduct(t *testing.T) {
+	router, acme := setUp(t)
+	product := create(t, router, acme, "POST", "/add-product", `{"Name":"desk","SKU":"DESK","Price":100,"Quantity":5}`)
+	salesOrder := create(t, router, acme, "POST", "/add-sales-order", fmt.Sprintf(`{"ProductID":%d,"Quantity":1}`, product))
+	create(t, router, acme, "PUT", fmt.Sprintf("/update-product/%d", product), `{"Name":"standing desk","SKU":"DESK-2","Price":150}`)
+
+	w := acme.Do(router, "GET", fmt.Sprintf("/sales-order/%d", salesOrder), "")
+	var order struct {
+		ProductName, ProductSKU string
+		UnitPrice               float64
+		Product                 struct{ Name string }
+	}
+	json.Unmarshal(w.Body.Bytes(), &order)
+	if order.ProductName != "desk" || order.ProductSKU != "DESK" || order.UnitPrice != 100 {
+		t.Errorf("order has %q %q at %v, want desk DESK at 100", order.ProductName, order.ProductSKU, order.UnitPrice)
+	}
+	if order.Product.Name != "standing desk" {
+		t.Errorf("live product is %q, want standing desk", order.Product.Name)
+	}
+}
@@ -0,0 +1,241 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"inventory-control-hub/database"
+	"inventory-control-hub/models"
+	"inventory-control-hub/utils"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// serialError is a problem with the serial numbers given in a request, the message names the serial
+type serialError struct {
+	code    int
+	message string
+}
+
+func (e *serialError) Error() string { return e.message }
+
+func respondWithSerialError(w http.ResponseWriter, err error) {
+	var serialErr *serialError
+	if errors.As(err, &serialErr) {
+		utils.RespondWithError(w, serialErr.code, serialErr.message)
+		return
+	}
+	utils.RespondWithError(w, http.StatusInternalServerError, "Failed to update serial numbers")
+}
+
+// checkSerialCount makes sure exactly one serial is given per unit, without duplicates
+func checkSerialCount(serials []string, quantity int) error {
+	if len(serials) != quantity {
+		return &serialError{http.StatusBadRequest, fmt.Sprintf("Serialized product needs exactly %d serial numbers, got %d", quantity, len(serials))}
+	}
+	seen := map[string]bool{}
+	for _, serial := range serials {
+		if serial == "" {
+			return &serialError{http.StatusBadRequest, "Serial numbers cannot be empty"}
+		}
+		if seen[serial] {
+			return &serialError{http.StatusBadRequest, fmt.Sprintf("Serial %s is listed twice", serial)}
+		}
+		seen[serial] = true
+	}
+	return nil
+}
+
+// serialList pulls the serial strings out of a request's SerialNumbers
+func serialList(serialNumbers []models.SerialNumber) []string {
+	serials := make([]string, len(serialNumbers))
+	for i, serialNumber := range serialNumbers {
+		serials[i] = serialNumber.Serial
+	}
+	return serials
+}
+
+// recordSerialEvent moves a serial to a new status and adds the step to its history
+func recordSerialEvent(tx *gorm.DB, serialNumber *models.SerialNumber, event string, status string, referenceType string, referenceID uint) error {
+	serialNumber.Status = status
+	if err := tx.Model(serialNumber).Updates(map[string]interface{}{"status": status, "sales_order_id": serialNumber.SalesOrderID}).Error; err != nil {
+		return err
+	}
+	return tx.Create(&models.SerialEvent{
+		SerialNumberID: &serialNumber.ID,
+		Event:          event,
+		Status:         status,
+		ReferenceType:  referenceType,
+		ReferenceID:    referenceID,
+	}).Error
+}
+
+// lockSerials loads the given serials of a product, each one has to exist and be in one of the allowed statuses
+func lockSerials(tx *gorm.DB, productID uint, serials []string, allowed ...string) ([]models.SerialNumber, error) {
+	serialNumbers := make([]models.SerialNumber, 0, len(serials))
+	for _, serial := range serials {
+		var serialNumber models.SerialNumber
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("serial = ? AND product_id = ?", serial, productID).First(&serialNumber).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, &serialError{http.StatusNotFound, fmt.Sprintf("Serial %s not found for this product", serial)}
+		}
+		if err != nil {
+			return nil, err
+		}
+		ok := false
+		for _, status := range allowed {
+			ok = ok || serialNumber.Status == status
+		}
+		if !ok {
+			return nil, &serialError{http.StatusConflict, fmt.Sprintf("Serial %s is %s", serial, serialNumber.Status)}
+		}
+		serialNumbers = append(serialNumbers, serialNumber)
+	}
+	return serialNumbers, nil
+}
+
+// receiveSerials creates the serial numbers that arrived on a receipt
+func receiveSerials(tx *gorm.DB, product models.Product, receipt models.PurchaseReceipt, serials []string) error {
+	if err := checkSerialCount(serials, receipt.Quantity); err != nil {
+		return err
+	}
+	for _, serial := range serials {
+		var count int64
+		if err := tx.Model(&models.SerialNumber{}).Where("serial = ?", serial).Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			return &serialError{http.StatusConflict, fmt.Sprintf("Serial %s has already been received", serial)}
+		}
+		serialNumber := models.SerialNumber{Serial: serial, ProductID: &product.ID, PurchaseReceiptID: &receipt.ID}
+		if err := tx.Omit("Product", "Events").Create(&serialNumber).Error; err != nil {
+			return err
+		}
+		if err := recordSerialEvent(tx, &serialNumber, "received", "in_stock", "purchase_receipt", receipt.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sellSerials marks the chosen in-stock serials as sold on the order
+func sellSerials(tx *gorm.DB, salesOrder models.SalesOrder, serials []string) error {
+	if err := checkSerialCount(serials, salesOrder.Quantity); err != nil {
+		return err
+	}
+	serialNumbers, err := lockSerials(tx, *salesOrder.ProductID, serials, "in_stock")
+	if err != nil {
+		return err
+	}
+	for i := range serialNumbers {
+		serialNumbers[i].SalesOrderID = &salesOrder.ID
+		if err := recordSerialEvent(tx, &serialNumbers[i], "sold", "sold", "sales_order", salesOrder.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// unsellSerials puts the serials of a cancelled order back in stock
+func unsellSerials(tx *gorm.DB, salesOrderID uint) error {
+	var serialNumbers []models.SerialNumber
+	if err := tx.Where("sales_order_id = ? AND status = ?", salesOrderID, "sold").Find(&serialNumbers).Error; err != nil {
+		return err
+	}
+	for i := range serialNumbers {
+		serialNumbers[i].SalesOrderID = nil
+		if err := recordSerialEvent(tx, &serialNumbers[i], "sale_cancelled", "in_stock", "sales_order", salesOrderID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// cancelledSerials are the serials that went back to stock when the order was deleted, a restore sells them again
+func cancelledSerials(db *gorm.DB, salesOrderID uint) ([]string, error) {
+	var serials []string
+	err := db.Model(&models.SerialNumber{}).
+		Joins("JOIN serial_events ON serial_events.serial_number_id = serial_numbers.id").
+		Where("serial_events.event = ? AND serial_events.reference_type = ? AND serial_events.reference_id = ?", "sale_cancelled", "sales_order", salesOrderID).
+		Distinct().Pluck("serial_numbers.serial", &serials).Error
+	return serials, err
+}
+
+// returnSerials books serials coming back from the customer on a return line.
+// Restocked units are sellable again, quarantined units wait as returned, scrapped units are gone.
+func returnSerials(tx *gorm.DB, salesOrder models.SalesOrder, customerReturnID uint, line models.ReturnLine) error {
+	if err := checkSerialCount(line.Serials, line.Quantity); err != nil {
+		return err
+	}
+	serialNumbers, err := lockSerials(tx, *salesOrder.ProductID, line.Serials, "sold")
+	if err != nil {
+		return err
+	}
+	for i := range serialNumbers {
+		if serialNumbers[i].SalesOrderID == nil || *serialNumbers[i].SalesOrderID != salesOrder.ID {
+			return &serialError{http.StatusConflict, fmt.Sprintf("Serial %s was not sold on this sales order", serialNumbers[i].Serial)}
+		}
+		status := map[string]string{"restock": "in_stock", "quarantine": "returned", "scrap": "scrapped"}[line.Disposition]
+		if status == "in_stock" {
+			serialNumbers[i].SalesOrderID = nil
+		}
+		if err := recordSerialEvent(tx, &serialNumbers[i], "returned", status, "return", customerReturnID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// returnSerialsToSupplier sends in-stock serials back, each one must have arrived on the purchase order
+func returnSerialsToSupplier(tx *gorm.DB, purchaseOrder models.PurchaseOrder, supplierReturn models.SupplierReturn) error {
+	serialNumbers, err := lockSerials(tx, *purchaseOrder.ProductID, supplierReturn.Serials, "in_stock")
+	if err != nil {
+		return err
+	}
+	for i := range serialNumbers {
+		var count int64
+		query := tx.Model(&models.PurchaseReceipt{}).Where("purchase_order_id = ?", purchaseOrder.ID)
+		if serialNumbers[i].PurchaseReceiptID == nil || query.Where("id = ?", *serialNumbers[i].PurchaseReceiptID).Count(&count).Error != nil || count == 0 {
+			return &serialError{http.StatusConflict, fmt.Sprintf("Serial %s was not received on this purchase order", serialNumbers[i].Serial)}
+		}
+		if supplierReturn.PurchaseReceiptID != nil && *serialNumbers[i].PurchaseReceiptID != *supplierReturn.PurchaseReceiptID {
+			return &serialError{http.StatusConflict, fmt.Sprintf("Serial %s was not received on that purchase receipt", serialNumbers[i].Serial)}
+		}
+		if err := recordSerialEvent(tx, &serialNumbers[i], "returned_to_supplier", "returned_to_supplier", "supplier_return", supplierReturn.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func GetSerialNumbers(w http.ResponseWriter, r *http.Request) {
+	var serialNumbers []models.SerialNumber
+	query := database.For(r).Order("id")
+	if product := r.URL.Query().Get("product"); product != "" {
+		query = query.Where("product_id = ?", product)
+	}
+	if status := r.URL.Query().Get("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+	if query.Find(&serialNumbers).Error != nil {
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to fetch serial numbers")
+		return
+	}
+	utils.RespondWithJSON(w, http.StatusOK, serialNumbers)
+}
+
+// GetSerialNumber shows a unit and its full history, GET /serial/{sn}
+func GetSerialNumber(w http.ResponseWriter, r *http.Request) {
+	serial := mux.Vars(r)["sn"]
+
+	var serialNumber models.SerialNumber
+	if database.For(r).Preload("Product", withDeleted).Preload("Events", func(db *gorm.DB) *gorm.DB {
+		return db.Order("id")
+	}).Where("serial = ?", serial).First(&serialNumber).Error != nil {
+		utils.RespondWithError(w, http.StatusNotFound, "Serial number not found")
+		return
+	}
+	utils.RespondWithJSON(w, http.StatusOK, serialNumber)
+}
@@ -0,0 +1,44 @@
+package controllers_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+// A serialized product is sold by naming the exact units, each of them can only go once.
+func TestSellSerials(t *testing.T) {
+	router, acme := setUp(t)
+	product := create(t, router, acme, "POST", "/add-product", `{"Name":"laptop","SKU":"LAP","Price":900,"Serialized":true}`)
+	purchaseOrder := create(t, router, acme, "POST", "/add-purchase-order", fmt.Sprintf(`{"ProductID":%d,"Quantity":2,"Supplier":"chip co","UnitCost":500,"DeferReceipt":true}`, product))
+	create(t, router, acme, "POST", fmt.Sprintf("/purchase-order/%d/receive", purchaseOrder), `{"Quantity":2,"SerialNumbers":[{"Serial":"SN-1"},{"Serial":"SN-2"}]}`)
+
+	tests := []struct {
+		name       string
+		body       string
+		wantCode   int
+		wantStatus string
+	}{
+		{"sell one", `{"ProductID":%d,"Quantity":1,"SerialNumbers":[{"Serial":"SN-1"}]}`, http.StatusOK, "sold"},
+		{"sell it again", `{"ProductID":%d,"Quantity":1,"SerialNumbers":[{"Serial":"SN-1"}]}`, http.StatusConflict, "sold"},
+		{"unknown serial", `{"ProductID":%d,"Quantity":1,"SerialNumbers":[{"Serial":"SN-9"}]}`, http.StatusNotFound, "sold"},
+		{"too few serials", `{"ProductID":%d,"Quantity":2,"SerialNumbers":[{"Serial":"SN-2"}]}`, http.StatusBadRequest, "sold"},
+		{"same serial twice", `{"ProductID":%d,"Quantity":2,"SerialNumbers":[{"Serial":"SN-2"},{"Serial":"SN-2"}]}`, http.StatusBadRequest, "sold"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if w := acme.Do(router, "POST", "/add-sales-order", fmt.Sprintf(tt.body, product)); w.Code != tt.wantCode {
+				t.Errorf("answered %d, want %d: %s", w.Code, tt.wantCode, w.Body)
+			}
+			var serialNumber struct{ Status string }
+			json.Unmarshal(acme.Do(router, "GET", "/serial/SN-1", "").Body.Bytes(), &serialNumber)
+			if serialNumber.Status != tt.wantStatus {
+				t.Errorf("SN-1 is %q, want %q", serialNumber.Status, tt.wantStatus)
+			}
+			if got := stock(t, acme, product); got.Quantity != 2 || got.ReservedQuantity != 1 {
+				t.Errorf("stock %d reserved %d, want 2 and 1", got.Quantity, got.ReservedQuantity)
+			}
+		})
+	}
+}
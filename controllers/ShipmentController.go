@@ -0,0 +1,404 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"inventory-control-hub/carriers"
+	"inventory-control-hub/database"
+	"inventory-control-hub/models"
+	"inventory-control-hub/utils"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm/clause"
+)
+
+func GetShipments(w http.ResponseWriter, r *http.Request) {
+	var shipments []models.Shipment
+	query := database.For(r).Preload("Parcels").Order("id")
+	if salesOrder := r.URL.Query().Get("sales_order"); salesOrder != "" {
+		query = query.Where("sales_order_id = ?", salesOrder)
+	}
+	if query.Find(&shipments).Error != nil {
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to fetch shipments")
+		return
+	}
+	utils.RespondWithJSON(w, http.StatusOK, shipments)
+}
+
+func GetShipmentById(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	var shipment models.Shipment
+	if database.For(r).Preload("Parcels").First(&shipment, id).Error != nil {
+		utils.RespondWithError(w, http.StatusNotFound, "Shipment not found")
+		return
+	}
+	utils.RespondWithJSON(w, http.StatusOK, shipment)
+}
+
+// packRequest is the body of PackSalesOrder
+type packRequest struct {
+	Note    string
+	Parcels []struct {
+		Quantity int     `validate:"min=1"`
+		Weight   float64 `validate:"min=0"`
+		Length   float64 `validate:"min=0"`
+		Width    float64 `validate:"min=0"`
+		Height   float64 `validate:"min=0"`
+	} `validate:"required"`
+}
+
+// PackSalesOrder packs picked units into parcels, POST /sales-order/{id}/pack with
+// {"Parcels":[{"Quantity":2,"Weight":1.2,"Length":30,"Width":20,"Height":15}]}.
+// Only units that were picked and not packed yet can go in.
+func PackSalesOrder(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	var request packRequest
+	if !utils.DecodeJSON(w, r, &request) {
+		return
+	}
+	shipment := models.Shipment{Note: request.Note}
+	for _, parcel := range request.Parcels {
+		shipment.Parcels = append(shipment.Parcels, models.Parcel{
+			Quantity: parcel.Quantity,
+			Weight:   parcel.Weight,
+			Length:   parcel.Length,
+			Width:    parcel.Width,
+			Height:   parcel.Height,
+		})
+		shipment.Quantity += parcel.Quantity
+		shipment.TotalWeight += parcel.Weight
+	}
+
+	tx := database.For(r).Begin()
+
+	var salesOrder models.SalesOrder
+	if tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&salesOrder, id).Error != nil {
+		tx.Rollback()
+		utils.RespondWithError(w, http.StatusNotFound, "Sales order not found")
+		return
+	}
+	if shipment.Quantity > salesOrder.PickedQuantity-salesOrder.PackedQuantity {
+		tx.Rollback()
+		utils.RespondWithError(w, http.StatusConflict, fmt.Sprintf("Cannot pack %d units, only %d picked units are waiting to be packed", shipment.Quantity, salesOrder.PickedQuantity-salesOrder.PackedQuantity))
+		return
+	}
+
+	number, err := nextDocumentNumber(tx, "shipment")
+	if err != nil {
+		tx.Rollback()
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to allocate shipment number")
+		return
+	}
+
+	shipment.Number = fmt.Sprintf("SHP-%06d", number)
+	shipment.SalesOrderID = &salesOrder.ID
+	shipment.Status = "packed"
+	shipment.PackedAt = time.Now()
+	if tx.Create(&shipment).Error != nil {
+		tx.Rollback()
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to create shipment")
+		return
+	}
+
+	var open int64
+	tx.Model(&models.PickTask{}).Where("sales_order_id = ? AND status = ?", salesOrder.ID, "open").Count(&open)
+	salesOrder.PackedQuantity += shipment.Quantity
+	salesOrder.Status = salesOrderStatus(salesOrder, open > 0)
+	if tx.Model(&salesOrder).Updates(map[string]interface{}{"packed_quantity": salesOrder.PackedQuantity, "status": salesOrder.Status}).Error != nil {
+		tx.Rollback()
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to update sales order")
+		return
+	}
+
+	tx.Commit()
+	utils.RespondWithJSON(w, http.StatusOK, shipment)
+}
+
+// carrierRequest describes a shipment's parcels to a carrier integration
+func carrierRequest(shipment models.Shipment) carriers.Request {
+	request := carriers.Request{ShipmentNumber: shipment.Number}
+	for _, parcel := range shipment.Parcels {
+		request.Parcels = append(request.Parcels, carriers.Parcel{Weight: parcel.Weight, Length: parcel.Length, Width: parcel.Width, Height: parcel.Height})
+	}
+	return request
+}
+
+// GetCarriers lists the carrier integrations rates and labels can be asked from, GET /carriers
+func GetCarriers(w http.ResponseWriter, r *http.Request) {
+	utils.RespondWithJSON(w, http.StatusOK, carriers.Names())
+}
+
+// GetShipmentRates asks the carriers what shipping the parcels costs, GET /shipment/{id}/rates?carrier=local.
+// Without a carrier every registered carrier is asked.
+func GetShipmentRates(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	var shipment models.Shipment
+	if database.For(r).Preload("Parcels").First(&shipment, id).Error != nil {
+		utils.RespondWithError(w, http.StatusNotFound, "Shipment not found")
+		return
+	}
+
+	names := carriers.Names()
+	if name := r.URL.Query().Get("carrier"); name != "" {
+		if _, ok := carriers.Get(name); !ok {
+			utils.RespondWithError(w, http.StatusNotFound, "Carrier not found")
+			return
+		}
+		names = []string{name}
+	}
+
+	rates := []carriers.Rate{}
+	for _, name := range names {
+		carrier, _ := carriers.Get(name)
+		carrierRates, err := carrier.Rates(carrierRequest(shipment))
+		if err != nil {
+			utils.RespondWithError(w, http.StatusBadGateway, fmt.Sprintf("Carrier %s could not quote the shipment", name))
+			return
+		}
+		rates = append(rates, carrierRates...)
+	}
+	utils.RespondWithJSON(w, http.StatusOK, rates)
+}
+
+// shipRequest is the body of ShipShipment
+type shipRequest struct {
+	Carrier        string  `validate:"required,maxlen=50"`
+	ServiceLevel   string  `validate:"maxlen=50"`
+	TrackingNumber string  `validate:"maxlen=100"`
+	ShippingCost   float64 `validate:"min=0"`
+	ShippedAt      *time.Time
+}
+
+// ShipShipment hands a packed shipment to the carrier, POST /shipment/{id}/ship with
+// {"Carrier":"local","ServiceLevel":"express"}. For a registered carrier without a TrackingNumber
+// a label is bought, otherwise the TrackingNumber given is recorded. The units leave stock here.
+func ShipShipment(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	var request shipRequest
+	if !utils.DecodeJSON(w, r, &request) {
+		return
+	}
+	carrier, registered := carriers.Get(request.Carrier)
+	if !registered && request.TrackingNumber == "" {
+		utils.RespondWithError(w, http.StatusBadRequest, "TrackingNumber is required for carriers without an integration")
+		return
+	}
+
+	var shipment models.Shipment
+	if database.For(r).Preload("Parcels").First(&shipment, id).Error != nil {
+		utils.RespondWithError(w, http.StatusNotFound, "Shipment not found")
+		return
+	}
+	if shipment.Status != "packed" {
+		utils.RespondWithError(w, http.StatusConflict, "Shipment has already shipped")
+		return
+	}
+
+	// the label is bought before any row is locked, the carrier can take its time answering
+	var label *models.ShippingLabel
+	var bought carriers.Label
+	if registered && request.TrackingNumber == "" {
+		var err error
+		bought, err = carrier.CreateLabel(carrierRequest(shipment), request.ServiceLevel)
+		if err != nil {
+			if errors.Is(err, carriers.ErrUnknownServiceLevel) {
+				utils.RespondWithError(w, http.StatusBadRequest, "Unknown service level for this carrier")
+				return
+			}
+			utils.RespondWithError(w, http.StatusBadGateway, "Carrier could not create a label")
+			return
+		}
+	}
+
+	tx := database.For(r).Begin()
+
+	// shipped by someone else while the carrier was asked
+	if tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&shipment, id).Error != nil {
+		tx.Rollback()
+		utils.RespondWithError(w, http.StatusNotFound, "Shipment not found")
+		return
+	}
+	if shipment.Status != "packed" {
+		tx.Rollback()
+		utils.RespondWithError(w, http.StatusConflict, "Shipment has already shipped")
+		return
+	}
+
+	shipment.Carrier = request.Carrier
+	shipment.ServiceLevel = request.ServiceLevel
+	shipment.TrackingNumber = request.TrackingNumber
+	shipment.ShippingCost = utils.RoundMoney(request.ShippingCost)
+	if bought.TrackingNumber != "" {
+		shipment.TrackingNumber = bought.TrackingNumber
+		shipment.ShippingCost = bought.Cost
+		label = &models.ShippingLabel{ShipmentID: &shipment.ID, Data: bought.Data}
+	}
+	shippedAt := time.Now()
+	if request.ShippedAt != nil {
+		shippedAt = *request.ShippedAt
+	}
+	shipment.ShippedAt = &shippedAt
+	shipment.Status = "shipped"
+
+	if tx.Omit("Parcels").Save(&shipment).Error != nil {
+		tx.Rollback()
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to update shipment")
+		return
+	}
+	if label != nil && tx.Create(label).Error != nil {
+		tx.Rollback()
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to store shipping label")
+		return
+	}
+
+	var salesOrder models.SalesOrder
+	if tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&salesOrder, shipment.SalesOrderID).Error != nil {
+		tx.Rollback()
+		utils.RespondWithError(w, http.StatusNotFound, "Sales order not found")
+		return
+	}
+	var product models.Product
+	if tx.Clauses(clause.Locking{Strength: "UPDATE"}).Unscoped().First(&product, salesOrder.ProductID).Error != nil {
+		tx.Rollback()
+		utils.RespondWithError(w, http.StatusNotFound, "Product not found")
+		return
+	}
+
+	// the reservation turns into goods leaving the warehouse
+	if addToStock(tx, &product, "reserved_quantity", -shipment.Quantity) != nil ||
+		moveStock(tx, &product, -shipment.Quantity, "shipment", "shipment", shipment.ID) != nil {
+		tx.Rollback()
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to update product stock")
+		return
+	}
+
+	var open int64
+	tx.Model(&models.PickTask{}).Where("sales_order_id = ? AND status = ?", salesOrder.ID, "open").Count(&open)
+	salesOrder.ShippedQuantity += shipment.Quantity
+	salesOrder.Status = salesOrderStatus(salesOrder, open > 0)
+	if tx.Model(&salesOrder).Updates(map[string]interface{}{"shipped_quantity": salesOrder.ShippedQuantity, "status": salesOrder.Status}).Error != nil {
+		tx.Rollback()
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to update sales order")
+		return
+	}
+
+	tx.Commit()
+	utils.RespondWithJSON(w, http.StatusOK, shipment)
+}
+
+// GetShippingLabel reprints the label bought from the carrier, GET /shipment/{id}/label
+func GetShippingLabel(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	var shipment models.Shipment
+	if database.For(r).First(&shipment, id).Error != nil {
+		utils.RespondWithError(w, http.StatusNotFound, "Shipment not found")
+		return
+	}
+	var label models.ShippingLabel
+	if database.For(r).Where("shipment_id = ?", shipment.ID).First(&label).Error != nil {
+		utils.RespondWithError(w, http.StatusNotFound, "No label was bought for this shipment")
+		return
+	}
+	utils.RespondWithPDF(w, shipment.Number+"-label.pdf", label.Data)
+}
+
+// GetPackingSlip prints the packing slip that goes in the box, GET /shipment/{id}/packing-slip
+func GetPackingSlip(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	var shipment models.Shipment
+	if database.For(r).Preload("Parcels").First(&shipment, id).Error != nil {
+		utils.RespondWithError(w, http.StatusNotFound, "Shipment not found")
+		return
+	}
+	var salesOrder models.SalesOrder
+	if database.For(r).Unscoped().Preload("Customer").First(&salesOrder, shipment.SalesOrderID).Error != nil {
+		utils.RespondWithError(w, http.StatusNotFound, "Sales order not found")
+		return
+	}
+	utils.RespondWithPDF(w, shipment.Number+".pdf", renderPackingSlipPDF(companyName(r), shipment, salesOrder))
+}
+
+func renderPackingSlipPDF(company string, shipment models.Shipment, salesOrder models.SalesOrder) []byte {
+	doc := utils.NewPDFDocument()
+	left, right := 50.0, utils.PDFPageWidth-50
+	y := utils.PDFPageHeight - 60
+
+	doc.Text(left, y, 18, true, company)
+	doc.Text(right-150, y, 18, true, "PACKING SLIP")
+	y -= 30
+	doc.Text(left, y, 10, false, "Shipment: "+shipment.Number)
+	y -= 14
+	doc.Text(left, y, 10, false, fmt.Sprintf("Sales order: %d", salesOrder.ID))
+	y -= 14
+	doc.Text(left, y, 10, false, "Packed: "+shipment.PackedAt.Format("02 Jan 2006"))
+	if shipment.TrackingNumber != "" {
+		y -= 14
+		doc.Text(left, y, 10, false, fmt.Sprintf("Carrier: %s %s, tracking %s", shipment.Carrier, shipment.ServiceLevel, shipment.TrackingNumber))
+	}
+
+	y -= 30
+	doc.Text(left, y, 11, true, "Ship to")
+	y -= 14
+	if salesOrder.Customer != nil {
+		doc.Text(left, y, 10, false, salesOrder.Customer.Name)
+		for _, detail := range []string{salesOrder.Customer.Email, salesOrder.Customer.Phone} {
+			if detail != "" {
+				y -= 14
+				doc.Text(left, y, 10, false, detail)
+			}
+		}
+	} else {
+		doc.Text(left, y, 10, false, "Walk-in customer")
+	}
+
+	y -= 36
+	doc.Text(left, y, 10, true, "Item")
+	doc.Text(320, y, 10, true, "SKU")
+	doc.Text(450, y, 10, true, "Qty")
+	y -= 6
+	doc.Line(left, y, right, y)
+	y -= 16
+	doc.Text(left, y, 10, false, salesOrder.ProductName)
+	doc.Text(320, y, 10, false, salesOrder.ProductSKU)
+	doc.Text(450, y, 10, false, strconv.Itoa(shipment.Quantity))
+	y -= 8
+	doc.Line(left, y, right, y)
+
+	y -= 30
+	columns := []float64{left, 150, 250, 450}
+	for i, heading := range []string{"Parcel", "Qty", "Dimensions (cm)", "Weight (kg)"} {
+		doc.Text(columns[i], y, 10, true, heading)
+	}
+	y -= 6
+	doc.Line(left, y, right, y)
+	for i, parcel := range shipment.Parcels {
+		y -= 16
+		if y < 80 {
+			doc.AddPage()
+			y = utils.PDFPageHeight - 60
+		}
+		doc.Text(columns[0], y, 10, false, fmt.Sprintf("%d of %d", i+1, len(shipment.Parcels)))
+		doc.Text(columns[1], y, 10, false, strconv.Itoa(parcel.Quantity))
+		doc.Text(columns[2], y, 10, false, fmt.Sprintf("%g x %g x %g", parcel.Length, parcel.Width, parcel.Height))
+		doc.Text(columns[3], y, 10, false, fmt.Sprintf("%g", parcel.Weight))
+	}
+	y -= 16
+	doc.Text(columns[2], y, 10, true, "Total weight")
+	doc.Text(columns[3], y, 10, true, fmt.Sprintf("%g", shipment.TotalWeight))
+
+	if salesOrder.ShortQuantity > 0 {
+		y -= 30
+		doc.Text(left, y, 10, false, fmt.Sprintf("%d of %d ordered units could not be supplied.", salesOrder.ShortQuantity, salesOrder.Quantity))
+	}
+
+	return doc.Bytes()
+}
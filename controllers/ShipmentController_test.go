@@ -0,0 +1,52 @@
+package controllers_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+// Units stay reserved until the shipment carrying them leaves, an order can go out in several
+// shipments and nothing can be ordered past what isn't reserved yet.
+func TestShipInParts(t *testing.T) {
+	router, acme := setUp(t)
+	product := create(t, router, acme, "POST", "/add-product", `{"Name":"desk","SKU":"DESK","Price":100,"Quantity":6}`)
+	salesOrder := create(t, router, acme, "POST", "/add-sales-order", fmt.Sprintf(`{"ProductID":%d,"Quantity":4}`, product))
+	pickTask := create(t, router, acme, "POST", fmt.Sprintf("/sales-order/%d/pick-list", salesOrder), `{}`)
+	create(t, router, acme, "POST", fmt.Sprintf("/pick-task/%d/confirm", pickTask), `{}`)
+	first := create(t, router, acme, "POST", fmt.Sprintf("/sales-order/%d/pack", salesOrder), `{"Parcels":[{"Quantity":3,"Weight":1}]}`)
+	second := create(t, router, acme, "POST", fmt.Sprintf("/sales-order/%d/pack", salesOrder), `{"Parcels":[{"Quantity":1,"Weight":1}]}`)
+
+	tests := []struct {
+		name         string
+		method, path string
+		body         string
+		wantCode     int
+		wantStatus   string
+		wantQuantity int
+		wantReserved int
+	}{
+		{"order more than is free", "POST", "/add-sales-order", fmt.Sprintf(`{"ProductID":%d,"Quantity":3}`, product), http.StatusBadRequest, "packed", 6, 4},
+		{"ship the first parcel", "POST", fmt.Sprintf("/shipment/%d/ship", first), `{"Carrier":"own van","TrackingNumber":"VAN-1"}`, http.StatusOK, "partially_shipped", 3, 1},
+		{"ship it again", "POST", fmt.Sprintf("/shipment/%d/ship", first), `{"Carrier":"own van","TrackingNumber":"VAN-1"}`, http.StatusConflict, "partially_shipped", 3, 1},
+		{"no tracking number", "POST", fmt.Sprintf("/shipment/%d/ship", second), `{"Carrier":"own van"}`, http.StatusBadRequest, "partially_shipped", 3, 1},
+		{"ship the rest", "POST", fmt.Sprintf("/shipment/%d/ship", second), `{"Carrier":"own van","TrackingNumber":"VAN-2"}`, http.StatusOK, "shipped", 2, 0},
+		{"order what is left", "POST", "/add-sales-order", fmt.Sprintf(`{"ProductID":%d,"Quantity":2}`, product), http.StatusOK, "shipped", 2, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if w := acme.Do(router, tt.method, tt.path, tt.body); w.Code != tt.wantCode {
+				t.Errorf("answered %d, want %d: %s", w.Code, tt.wantCode, w.Body)
+			}
+			var order struct{ Status string }
+			json.Unmarshal(acme.Do(router, "GET", fmt.Sprintf("/sales-order/%d", salesOrder), "").Body.Bytes(), &order)
+			if order.Status != tt.wantStatus {
+				t.Errorf("order is %q, want %q", order.Status, tt.wantStatus)
+			}
+			if got := stock(t, acme, product); got.Quantity != tt.wantQuantity || got.ReservedQuantity != tt.wantReserved {
+				t.Errorf("stock %d reserved %d, want %d and %d", got.Quantity, got.ReservedQuantity, tt.wantQuantity, tt.wantReserved)
+			}
+		})
+	}
+}
@@ -0,0 +1,159 @@
+package controllers
+
+import (
+	"errors"
+	"inventory-control-hub/database"
+	"inventory-control-hub/models"
+	"inventory-control-hub/utils"
+	"net/http"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// errStockShort is returned when a reservation would promise more units than are on hand
+var errStockShort = errors.New("not enough stock")
+
+// respondWithStockError answers a failed reservation, stock another order took meanwhile is a conflict
+func respondWithStockError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, errStockShort), errors.Is(err, errComponentsShort):
+		utils.RespondWithError(w, http.StatusConflict, "Product out of stock")
+	default:
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to update product stock")
+	}
+}
+
+// quarantineReasons are stock movements of units held in quarantine, they book on
+// Product.QuarantineQuantity and never touch the bins
+var quarantineReasons = map[string]bool{"quarantined": true, "quarantine_restocked": true, "scrapped": true}
+
+// moveStock changes a product's quantity and writes the matching stock ledger entry, inside tx.
+// All stock changes go through here so the ledger and Product.Quantity can't drift apart.
+// Units leaving stock are taken out of the bins, units coming in are unlocated until put away.
+// Quarantine reasons change the quarantined quantity instead, on their own stock in the ledger.
+func moveStock(tx *gorm.DB, product *models.Product, change int, reason string, referenceType string, referenceID uint) error {
+	stock, column := "on_hand", "quantity"
+	if quarantineReasons[reason] {
+		stock, column = "quarantine", "quarantine_quantity"
+	}
+	if err := addToStock(tx, product, column, change); err != nil {
+		return err
+	}
+
+	quantityAfter := product.Quantity
+	if stock == "quarantine" {
+		quantityAfter = product.QuarantineQuantity
+	}
+	movement := models.StockMovement{
+		ProductID:     &product.ID,
+		Stock:         stock,
+		Change:        change,
+		QuantityAfter: quantityAfter,
+		Reason:        reason,
+		ReferenceType: referenceType,
+		ReferenceID:   referenceID,
+	}
+	if err := tx.Create(&movement).Error; err != nil {
+		return err
+	}
+
+	switch {
+	case stock == "quarantine":
+		return nil
+	case change < 0 && reservedReasons[reason]:
+		return nil
+	case change < 0:
+		return takeFromBins(tx, product.ID, -change, referenceType, referenceID)
+	case reversingReasons[reason]:
+		return returnToBins(tx, product.ID, change, referenceType, referenceID)
+	}
+	return nil
+}
+
+// addToStock adds change to one of the stock columns of a product in the database rather than
+// saving the caller's copy, which a nested helper may have left behind, then reloads the copy
+// under lock so the caller goes on with what was booked. Unscoped, stock of a deleted product
+// still has to be corrected when its orders are.
+func addToStock(tx *gorm.DB, product *models.Product, column string, change int) error {
+	// nothing changes, so the version and the ETag clients hold stay valid
+	if change == 0 {
+		return nil
+	}
+	if err := tx.Unscoped().Model(product).UpdateColumn(column, gorm.Expr(column+" + ?", change)).Error; err != nil {
+		return err
+	}
+	return tx.Unscoped().Clauses(clause.Locking{Strength: "UPDATE"}).First(product, product.ID).Error
+}
+
+// availableQuantity is what can still be promised to new sales orders
+func availableQuantity(product models.Product) int {
+	return product.Quantity - product.ReservedQuantity
+}
+
+// reserveStock promises units to a sales order. They stay on hand until shipped but are taken
+// out of their bins for the order's pick list. The booked stock is checked again, callers lock
+// the product before counting what's available but a stale count must not oversell.
+func reserveStock(tx *gorm.DB, product *models.Product, quantity int, salesOrderID uint) error {
+	// a virtual kit builds the kits it is short of from its components
+	if short := quantity - max(availableQuantity(*product), 0); short > 0 {
+		bom, err := virtualBOM(tx, product.ID)
+		if err != nil {
+			return err
+		}
+		if bom != nil {
+			if _, err := assembleKits(tx, product, *bom, short, &salesOrderID, ""); err != nil {
+				return err
+			}
+		}
+	}
+	if err := addToStock(tx, product, "reserved_quantity", quantity); err != nil {
+		return err
+	}
+	if quantity > 0 && availableQuantity(*product) < 0 {
+		return errStockShort
+	}
+	return takeFromBins(tx, product.ID, quantity, "sales_order", salesOrderID)
+}
+
+// releaseStock gives up units a sales order reserved, they go back into the bins they came from
+func releaseStock(tx *gorm.DB, product *models.Product, quantity int, salesOrderID uint) error {
+	if err := addToStock(tx, product, "reserved_quantity", -quantity); err != nil {
+		return err
+	}
+	if err := returnToBins(tx, product.ID, quantity, "sales_order", salesOrderID); err != nil {
+		return err
+	}
+	// a virtual kit isn't kept in stock, the kits go back to their components
+	bom, err := virtualBOM(tx, product.ID)
+	if err != nil || bom == nil {
+		return err
+	}
+	if kits := min(quantity, availableQuantity(*product)); kits > 0 {
+		_, err = disassembleKits(tx, product, *bom, kits, &salesOrderID, "")
+	}
+	return err
+}
+
+// GetStockMovements lists the stock ledger, GET /stock-movements?product=&stock=&reference_type=&reference_id=
+func GetStockMovements(w http.ResponseWriter, r *http.Request) {
+	var movements []models.StockMovement
+	query := database.For(r).Order("id")
+	if product := r.URL.Query().Get("product"); product != "" {
+		query = query.Where("product_id = ?", product)
+	}
+	if stock := r.URL.Query().Get("stock"); stock != "" {
+		query = query.Where("stock = ?", stock)
+	}
+	if referenceType := r.URL.Query().Get("reference_type"); referenceType != "" {
+		query = query.Where("reference_type = ?", referenceType)
+	}
+	if referenceID := r.URL.Query().Get("reference_id"); referenceID != "" {
+		query = query.Where("reference_id = ?", referenceID)
+	}
+	if query.Find(&movements).Error != nil {
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to fetch stock movements")
+		return
+	}
+	utils.RespondWithJSON(w, http.StatusOK, movements)
+}
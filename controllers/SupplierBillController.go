@@ -0,0 +1,253 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"inventory-control-hub/database"
+	"inventory-control-hub/models"
+	"inventory-control-hub/utils"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+)
+
+// billTolerances are the matching tolerances from .env:
+// BILL_QUANTITY_TOLERANCE in units and BILL_PRICE_TOLERANCE_PERCENT for unit cost and totals
+func billTolerances() (quantity int, pricePercent float64) {
+	quantity, _ = strconv.Atoi(os.Getenv("BILL_QUANTITY_TOLERANCE"))
+	pricePercent, _ = strconv.ParseFloat(os.Getenv("BILL_PRICE_TOLERANCE_PERCENT"), 64)
+	return max(quantity, 0), math.Max(pricePercent, 0)
+}
+
+// matchSupplierBill runs the three-way match of a bill against its purchase order (ordered
+// quantity and cost) and the receipts (received quantity less returns), setting MatchStatus and MatchNotes.
+// Approved bills stay approved.
+func matchSupplierBill(db *gorm.DB, bill *models.SupplierBill, purchaseOrder models.PurchaseOrder) error {
+	quantityTolerance, priceTolerance := billTolerances()
+	var notes []string
+
+	// other bills for the same order count too, a supplier can't bill the same goods twice
+	var otherBilled int64
+	query := db.Model(&models.SupplierBill{}).Where("purchase_order_id = ?", purchaseOrder.ID)
+	if bill.ID != 0 {
+		query = query.Where("id <> ?", bill.ID)
+	}
+	if err := query.Select("COALESCE(SUM(quantity), 0)").Scan(&otherBilled).Error; err != nil {
+		return err
+	}
+	billed := int(otherBilled) + bill.Quantity
+
+	if billed > purchaseOrder.Quantity+quantityTolerance {
+		notes = append(notes, fmt.Sprintf("Billed quantity %d exceeds ordered quantity %d", billed, purchaseOrder.Quantity))
+	}
+	// goods sent back to the supplier are credited, not billed
+	kept := purchaseOrder.ReceivedQuantity - purchaseOrder.ReturnedQuantity
+	if billed > kept+quantityTolerance {
+		if purchaseOrder.ReturnedQuantity > 0 {
+			notes = append(notes, fmt.Sprintf("Billed quantity %d exceeds received quantity %d less %d returned", billed, purchaseOrder.ReceivedQuantity, purchaseOrder.ReturnedQuantity))
+		} else {
+			notes = append(notes, fmt.Sprintf("Billed quantity %d exceeds received quantity %d", billed, purchaseOrder.ReceivedQuantity))
+		}
+	}
+
+	if purchaseOrder.UnitCost == 0 {
+		if bill.UnitCost > 0 {
+			notes = append(notes, "Purchase order has no unit cost to match against")
+		}
+	} else {
+		difference := math.Abs(bill.UnitCost-purchaseOrder.UnitCost) / purchaseOrder.UnitCost * 100
+		if difference > priceTolerance {
+			notes = append(notes, fmt.Sprintf("Unit cost %s differs from purchase order cost %s by %.2f%%", formatMoney(bill.UnitCost), formatMoney(purchaseOrder.UnitCost), difference))
+		}
+	}
+
+	expectedTotal := float64(bill.Quantity) * bill.UnitCost
+	if !purchaseOrder.CostIncludesTax {
+		expectedTotal *= 1 + purchaseOrder.TaxRate/100
+	}
+	expectedTotal = utils.RoundMoney(expectedTotal)
+	if bill.TotalAmount == 0 {
+		bill.TotalAmount = expectedTotal
+	} else if math.Abs(bill.TotalAmount-expectedTotal) > expectedTotal*priceTolerance/100+0.01 {
+		notes = append(notes, fmt.Sprintf("Bill total %s does not match quantity x unit cost incl. tax (%s)", formatMoney(bill.TotalAmount), formatMoney(expectedTotal)))
+	}
+
+	if bill.Supplier != "" && purchaseOrder.Supplier != "" && !strings.EqualFold(bill.Supplier, purchaseOrder.Supplier) {
+		notes = append(notes, fmt.Sprintf("Supplier %q does not match purchase order supplier %q", bill.Supplier, purchaseOrder.Supplier))
+	}
+
+	bill.MatchNotes = strings.Join(notes, "; ")
+	if bill.MatchStatus == "approved" {
+		return nil
+	}
+	if len(notes) == 0 {
+		bill.MatchStatus = "matched"
+	} else {
+		bill.MatchStatus = "exception"
+	}
+	return nil
+}
+
+func GetSupplierBills(w http.ResponseWriter, r *http.Request) {
+	var bills []models.SupplierBill
+	query := database.For(r).Preload("PurchaseOrder")
+	if status := r.URL.Query().Get("status"); status != "" {
+		query = query.Where("match_status = ?", status)
+	}
+	if query.Order("bill_date").Find(&bills).Error != nil {
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to fetch supplier bills")
+		return
+	}
+	utils.RespondWithJSON(w, http.StatusOK, bills)
+}
+
+// GetSupplierBillExceptions is the mismatch queue, bills waiting for someone to sort them out
+func GetSupplierBillExceptions(w http.ResponseWriter, r *http.Request) {
+	var bills []models.SupplierBill
+	if database.For(r).Preload("PurchaseOrder").Where("match_status = ?", "exception").Order("bill_date").Find(&bills).Error != nil {
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to fetch supplier bill exceptions")
+		return
+	}
+	utils.RespondWithJSON(w, http.StatusOK, bills)
+}
+
+func GetSupplierBillById(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	var bill models.SupplierBill
+	if database.For(r).Preload("PurchaseOrder.Receipts").First(&bill, id).Error != nil {
+		utils.RespondWithError(w, http.StatusNotFound, "Supplier bill not found")
+		return
+	}
+	utils.RespondWithJSON(w, http.StatusOK, bill)
+}
+
+// supplierBillRequest is the body of AddSupplierBill
+type supplierBillRequest struct {
+	PurchaseOrderID *uint  `validate:"required"`
+	Supplier        string // the purchase order's supplier when left out
+	BillNumber      string `validate:"required,maxlen=100"`
+	BillDate        time.Time
+	Quantity        int     `validate:"min=1"`
+	UnitCost        float64 `validate:"min=0"`
+	TotalAmount     float64 `validate:"min=0"`
+}
+
+func AddSupplierBill(w http.ResponseWriter, r *http.Request) {
+	var request supplierBillRequest
+	if !utils.DecodeJSON(w, r, &request) {
+		return
+	}
+	bill := models.SupplierBill{
+		PurchaseOrderID: request.PurchaseOrderID,
+		Supplier:        request.Supplier,
+		BillNumber:      request.BillNumber,
+		BillDate:        request.BillDate,
+		Quantity:        request.Quantity,
+		UnitCost:        request.UnitCost,
+		TotalAmount:     request.TotalAmount,
+	}
+
+	var purchaseOrder models.PurchaseOrder
+	if database.For(r).First(&purchaseOrder, bill.PurchaseOrderID).Error != nil {
+		utils.RespondWithError(w, http.StatusNotFound, "Purchase order not found")
+		return
+	}
+	if bill.Supplier == "" {
+		bill.Supplier = purchaseOrder.Supplier
+	}
+
+	var existing models.SupplierBill
+	if database.For(r).Where("supplier = ? AND bill_number = ?", bill.Supplier, bill.BillNumber).First(&existing).Error == nil {
+		utils.RespondWithError(w, http.StatusConflict, "This supplier bill has already been entered")
+		return
+	}
+
+	if bill.BillDate.IsZero() {
+		bill.BillDate = time.Now()
+	}
+	if matchSupplierBill(database.For(r), &bill, purchaseOrder) != nil {
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to match supplier bill")
+		return
+	}
+	// the unique index catches a bill entered twice at the same time, past the check above
+	if err := database.For(r).Omit("PurchaseOrder").Create(&bill).Error; err != nil {
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			utils.RespondWithError(w, http.StatusConflict, "This supplier bill has already been entered")
+			return
+		}
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to add supplier bill")
+		return
+	}
+
+	database.For(r).Preload("PurchaseOrder").First(&bill, bill.ID)
+	utils.RespondWithJSON(w, http.StatusOK, bill)
+}
+
+// RematchSupplierBill runs the match again, e.g. after the rest of the goods were received
+func RematchSupplierBill(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	var bill models.SupplierBill
+	if database.For(r).First(&bill, id).Error != nil {
+		utils.RespondWithError(w, http.StatusNotFound, "Supplier bill not found")
+		return
+	}
+	var purchaseOrder models.PurchaseOrder
+	if database.For(r).First(&purchaseOrder, bill.PurchaseOrderID).Error != nil {
+		utils.RespondWithError(w, http.StatusNotFound, "Purchase order not found")
+		return
+	}
+
+	if matchSupplierBill(database.For(r), &bill, purchaseOrder) != nil {
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to match supplier bill")
+		return
+	}
+	if database.For(r).Model(&bill).Updates(map[string]interface{}{"match_status": bill.MatchStatus, "match_notes": bill.MatchNotes}).Error != nil {
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to update supplier bill")
+		return
+	}
+
+	database.For(r).Preload("PurchaseOrder").First(&bill, bill.ID)
+	utils.RespondWithJSON(w, http.StatusOK, bill)
+}
+
+// supplierBillApprovalRequest is the body of ApproveSupplierBill
+type supplierBillApprovalRequest struct {
+	Note string `validate:"required"`
+}
+
+// ApproveSupplierBill releases a bill from the exception queue, a note explaining why is required
+func ApproveSupplierBill(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	var request supplierBillApprovalRequest
+	if !utils.DecodeJSON(w, r, &request) {
+		return
+	}
+
+	var bill models.SupplierBill
+	if database.For(r).First(&bill, id).Error != nil {
+		utils.RespondWithError(w, http.StatusNotFound, "Supplier bill not found")
+		return
+	}
+	if bill.MatchStatus != "exception" {
+		utils.RespondWithError(w, http.StatusConflict, "Only bills in the exception queue need approval")
+		return
+	}
+
+	now := time.Now()
+	if database.For(r).Model(&bill).Updates(map[string]interface{}{"match_status": "approved", "approved_at": &now, "approval_note": request.Note}).Error != nil {
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to approve supplier bill")
+		return
+	}
+
+	database.For(r).Preload("PurchaseOrder").First(&bill, bill.ID)
+	utils.RespondWithJSON(w, http.StatusOK, bill)
+}
@@ -0,0 +1,54 @@
+package controllers_test
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"inventory-control-hub/models"
+	"net/http"
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+// Bills are matched against what was received less what went back to the supplier, and a
+// supplier's bill number is only taken once.
+func TestSupplierBillMatch(t *testing.T) {
+	router, acme := setUp(t)
+	product := create(t, router, acme, "POST", "/add-product", `{"Name":"desk","SKU":"DESK","Price":100}`)
+	purchaseOrder := create(t, router, acme, "POST", "/add-purchase-order", fmt.Sprintf(`{"ProductID":%d,"Quantity":10,"Supplier":"wood co","UnitCost":10,"DeferReceipt":true}`, product))
+	create(t, router, acme, "POST", fmt.Sprintf("/purchase-order/%d/receive", purchaseOrder), `{"Quantity":10}`)
+	create(t, router, acme, "POST", fmt.Sprintf("/purchase-order/%d/return", purchaseOrder), `{"Quantity":2,"Reason":"scratched"}`)
+
+	tests := []struct {
+		name       string
+		billNumber string
+		quantity   int
+		wantCode   int
+		wantStatus string
+	}{
+		{"the kept goods", "WC-1", 8, http.StatusOK, "matched"},
+		{"same number again", "WC-1", 1, http.StatusConflict, ""},
+		{"the returned goods", "WC-2", 2, http.StatusOK, "exception"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := acme.Do(router, "POST", "/add-supplier-bill", fmt.Sprintf(`{"PurchaseOrderID":%d,"BillNumber":%q,"Quantity":%d,"UnitCost":10}`, purchaseOrder, tt.billNumber, tt.quantity))
+			if w.Code != tt.wantCode {
+				t.Fatalf("answered %d, want %d: %s", w.Code, tt.wantCode, w.Body)
+			}
+			var bill models.SupplierBill
+			json.Unmarshal(w.Body.Bytes(), &bill)
+			if bill.MatchStatus != tt.wantStatus {
+				t.Errorf("match status %q, want %q: %s", bill.MatchStatus, tt.wantStatus, bill.MatchNotes)
+			}
+		})
+	}
+
+	// two requests entering the same bill at once both pass the check, the index stops the second
+	orderID := purchaseOrder
+	err := acme.DB().Create(&models.SupplierBill{PurchaseOrderID: &orderID, Supplier: "wood co", BillNumber: "WC-1"}).Error
+	if !errors.Is(err, gorm.ErrDuplicatedKey) {
+		t.Errorf("second WC-1 saved with %v, want a duplicate key", err)
+	}
+}
@@ -0,0 +1,319 @@
+package controllers
+
+import (
+	"fmt"
+	"inventory-control-hub/database"
+	"inventory-control-hub/models"
+	"inventory-control-hub/utils"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// supplierCreditStatus works out how far the supplier has settled a debit note
+func supplierCreditStatus(supplierReturn models.SupplierReturn) string {
+	switch {
+	case utils.RoundMoney(supplierReturn.DebitNoteAmount-supplierReturn.CreditReceived) <= 0:
+		return "credited"
+	case supplierReturn.CreditReceived > 0:
+		return "partially_credited"
+	default:
+		return "open"
+	}
+}
+
+// hasSupplierReturns reports whether goods on the order were sent back, the order is frozen then
+func hasSupplierReturns(db *gorm.DB, purchaseOrderID uint) bool {
+	var count int64
+	db.Model(&models.SupplierReturn{}).Where("purchase_order_id = ?", purchaseOrderID).Count(&count)
+	return count > 0
+}
+
+func GetSupplierReturns(w http.ResponseWriter, r *http.Request) {
+	var supplierReturns []models.SupplierReturn
+	query := database.For(r).Order("id")
+	if supplier := r.URL.Query().Get("supplier"); supplier != "" {
+		query = query.Where("supplier = ?", supplier)
+	}
+	if status := r.URL.Query().Get("status"); status != "" {
+		query = query.Where("credit_status = ?", status)
+	}
+	if query.Find(&supplierReturns).Error != nil {
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to fetch supplier returns")
+		return
+	}
+	utils.RespondWithJSON(w, http.StatusOK, supplierReturns)
+}
+
+func GetSupplierReturnById(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	var supplierReturn models.SupplierReturn
+	if database.For(r).Preload("PurchaseOrder.Product", withDeleted).First(&supplierReturn, id).Error != nil {
+		utils.RespondWithError(w, http.StatusNotFound, "Supplier return not found")
+		return
+	}
+	utils.RespondWithJSON(w, http.StatusOK, supplierReturn)
+}
+
+// debitNoteTax is the share of the purchase order's net and tax for quantity returned units, at
+// the rate stored on the order, so the debit mirrors what was billed even after the tax rate or
+// the product's tax class changed
+func debitNoteTax(purchaseOrder models.PurchaseOrder, quantity int) taxBreakdown {
+	if purchaseOrder.Quantity <= 0 {
+		return taxBreakdown{Rate: purchaseOrder.TaxRate}
+	}
+	share := float64(quantity) / float64(purchaseOrder.Quantity)
+	net := utils.RoundMoney(purchaseOrder.NetAmount * share)
+	tax := utils.RoundMoney(purchaseOrder.TaxAmount * share)
+	return taxBreakdown{Rate: purchaseOrder.TaxRate, Net: net, Tax: tax, Gross: utils.RoundMoney(net + tax)}
+}
+
+// supplierReturnRequest is the body of CreateSupplierReturn
+type supplierReturnRequest struct {
+	Quantity          int    `validate:"min=1"`
+	Reason            string `validate:"required"`
+	PurchaseReceiptID *uint
+	ReturnDate        time.Time
+	Serials           []string
+}
+
+// CreateSupplierReturn sends goods back against a purchase order, POST /purchase-order/{id}/return
+// with {"Quantity":3,"Reason":"faulty batch","PurchaseReceiptID":2}. Only received and not yet
+// returned units can go back, and they leave stock through the ledger. Serialized products list
+// the units in Serials.
+func CreateSupplierReturn(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	var request supplierReturnRequest
+	if !utils.DecodeJSON(w, r, &request) {
+		return
+	}
+	supplierReturn := models.SupplierReturn{
+		PurchaseReceiptID: request.PurchaseReceiptID,
+		Reason:            request.Reason,
+		ReturnDate:        request.ReturnDate,
+		Quantity:          request.Quantity,
+		Serials:           request.Serials,
+	}
+
+	tx := database.For(r).Begin()
+
+	var purchaseOrder models.PurchaseOrder
+	if tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&purchaseOrder, id).Error != nil {
+		tx.Rollback()
+		utils.RespondWithError(w, http.StatusNotFound, "Purchase order not found")
+		return
+	}
+	if supplierReturn.Quantity > purchaseOrder.ReceivedQuantity-purchaseOrder.ReturnedQuantity {
+		tx.Rollback()
+		utils.RespondWithError(w, http.StatusConflict, fmt.Sprintf("Cannot return %d units, only %d received units are left to return", supplierReturn.Quantity, purchaseOrder.ReceivedQuantity-purchaseOrder.ReturnedQuantity))
+		return
+	}
+
+	var product models.Product
+	if tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&product, purchaseOrder.ProductID).Error != nil {
+		tx.Rollback()
+		utils.RespondWithError(w, http.StatusNotFound, "Product not found")
+		return
+	}
+	if supplierReturn.Quantity > availableQuantity(product) {
+		tx.Rollback()
+		utils.RespondWithError(w, http.StatusConflict, "Not enough unreserved stock on hand to send back")
+		return
+	}
+	if product.LotTracked && supplierReturn.PurchaseReceiptID == nil {
+		tx.Rollback()
+		utils.RespondWithError(w, http.StatusBadRequest, "PurchaseReceiptID is required for lot-tracked products, it tells which lot goes back")
+		return
+	}
+
+	if product.Serialized {
+		if err := checkSerialCount(supplierReturn.Serials, supplierReturn.Quantity); err != nil {
+			tx.Rollback()
+			respondWithSerialError(w, err)
+			return
+		}
+	} else {
+		supplierReturn.Serials = nil
+	}
+
+	var receipt models.PurchaseReceipt
+	if supplierReturn.PurchaseReceiptID != nil {
+		if tx.Where("purchase_order_id = ?", purchaseOrder.ID).First(&receipt, *supplierReturn.PurchaseReceiptID).Error != nil {
+			tx.Rollback()
+			utils.RespondWithError(w, http.StatusNotFound, "Purchase receipt not found on this purchase order")
+			return
+		}
+		var returnedFromReceipt int64
+		tx.Model(&models.SupplierReturn{}).Where("purchase_receipt_id = ?", receipt.ID).Select("COALESCE(SUM(quantity), 0)").Scan(&returnedFromReceipt)
+		if supplierReturn.Quantity > receipt.Quantity-int(returnedFromReceipt) {
+			tx.Rollback()
+			utils.RespondWithError(w, http.StatusConflict, "Returned quantity exceeds what is left of that receipt")
+			return
+		}
+	}
+
+	// the goods leave the lot they arrived in
+	if receipt.LotID != nil {
+		res := tx.Model(&models.Lot{}).Where("id = ? AND quantity >= ?", *receipt.LotID, supplierReturn.Quantity).
+			UpdateColumn("quantity", gorm.Expr("quantity - ?", supplierReturn.Quantity))
+		if res.Error != nil {
+			tx.Rollback()
+			utils.RespondWithError(w, http.StatusInternalServerError, "Failed to update lot")
+			return
+		}
+		if res.RowsAffected == 0 {
+			tx.Rollback()
+			utils.RespondWithError(w, http.StatusConflict, "Not enough stock left in the receipt's lot")
+			return
+		}
+	}
+
+	// the debit note is priced like the order was, at its cost and tax
+	tax := debitNoteTax(purchaseOrder, supplierReturn.Quantity)
+
+	number, err := nextDocumentNumber(tx, "debit_note")
+	if err != nil {
+		tx.Rollback()
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to allocate debit note number")
+		return
+	}
+
+	supplierReturn.Number = fmt.Sprintf("DN-%06d", number)
+	supplierReturn.PurchaseOrderID = &purchaseOrder.ID
+	supplierReturn.Supplier = purchaseOrder.Supplier
+	if supplierReturn.ReturnDate.IsZero() {
+		supplierReturn.ReturnDate = time.Now()
+	}
+	supplierReturn.UnitCost = purchaseOrder.UnitCost
+	supplierReturn.NetAmount = tax.Net
+	supplierReturn.TaxAmount = tax.Tax
+	supplierReturn.DebitNoteAmount = tax.Gross
+	supplierReturn.CreditStatus = supplierCreditStatus(supplierReturn)
+
+	if tx.Omit("PurchaseOrder").Create(&supplierReturn).Error != nil {
+		tx.Rollback()
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to create supplier return")
+		return
+	}
+
+	if product.Serialized {
+		if err := returnSerialsToSupplier(tx, purchaseOrder, supplierReturn); err != nil {
+			tx.Rollback()
+			respondWithSerialError(w, err)
+			return
+		}
+	}
+
+	if moveStock(tx, &product, -supplierReturn.Quantity, "supplier_return", "supplier_return", supplierReturn.ID) != nil {
+		tx.Rollback()
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to update product stock")
+		return
+	}
+
+	purchaseOrder.ReturnedQuantity += supplierReturn.Quantity
+	if tx.Model(&purchaseOrder).Update("returned_quantity", purchaseOrder.ReturnedQuantity).Error != nil {
+		tx.Rollback()
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to update purchase order")
+		return
+	}
+
+	tx.Commit()
+
+	var fullReturn models.SupplierReturn
+	if database.For(r).Preload("PurchaseOrder.Product", withDeleted).First(&fullReturn, supplierReturn.ID).Error != nil {
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to fetch full supplier return")
+		return
+	}
+	utils.RespondWithJSON(w, http.StatusOK, fullReturn)
+}
+
+// supplierCreditRequest is the body of RecordSupplierCredit
+type supplierCreditRequest struct {
+	Amount float64
+}
+
+func (request supplierCreditRequest) Validate(errs *utils.FieldErrors) {
+	if request.Amount <= 0 {
+		errs.Add("Amount", "min", "must be greater than 0")
+	}
+}
+
+// RecordSupplierCredit books a credit note or refund from the supplier against a debit note,
+// POST /supplier-return/{id}/credit with {"Amount": 150}
+func RecordSupplierCredit(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	var request supplierCreditRequest
+	if !utils.DecodeJSON(w, r, &request) {
+		return
+	}
+
+	tx := database.For(r).Begin()
+
+	var supplierReturn models.SupplierReturn
+	if tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&supplierReturn, id).Error != nil {
+		tx.Rollback()
+		utils.RespondWithError(w, http.StatusNotFound, "Supplier return not found")
+		return
+	}
+	outstanding := utils.RoundMoney(supplierReturn.DebitNoteAmount - supplierReturn.CreditReceived)
+	if utils.RoundMoney(request.Amount) > outstanding {
+		tx.Rollback()
+		utils.RespondWithError(w, http.StatusConflict, fmt.Sprintf("Credit exceeds the outstanding debit note amount (%s)", formatMoney(outstanding)))
+		return
+	}
+
+	supplierReturn.CreditReceived = utils.RoundMoney(supplierReturn.CreditReceived + request.Amount)
+	supplierReturn.CreditStatus = supplierCreditStatus(supplierReturn)
+	if tx.Model(&supplierReturn).Updates(map[string]interface{}{"credit_received": supplierReturn.CreditReceived, "credit_status": supplierReturn.CreditStatus}).Error != nil {
+		tx.Rollback()
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to record supplier credit")
+		return
+	}
+
+	tx.Commit()
+	utils.RespondWithJSON(w, http.StatusOK, supplierReturn)
+}
+
+type supplierCredit struct {
+	Supplier        string
+	DebitNoteAmount float64
+	CreditReceived  float64
+	Outstanding     float64
+}
+
+// GetSupplierCredits sums up per supplier what they still owe us on debit notes, GET /supplier-credits
+func GetSupplierCredits(w http.ResponseWriter, r *http.Request) {
+	var supplierReturns []models.SupplierReturn
+	if database.For(r).Find(&supplierReturns).Error != nil {
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to fetch supplier returns")
+		return
+	}
+
+	credits := map[string]*supplierCredit{}
+	for _, supplierReturn := range supplierReturns {
+		credit, ok := credits[supplierReturn.Supplier]
+		if !ok {
+			credit = &supplierCredit{Supplier: supplierReturn.Supplier}
+			credits[supplierReturn.Supplier] = credit
+		}
+		credit.DebitNoteAmount = utils.RoundMoney(credit.DebitNoteAmount + supplierReturn.DebitNoteAmount)
+		credit.CreditReceived = utils.RoundMoney(credit.CreditReceived + supplierReturn.CreditReceived)
+		credit.Outstanding = utils.RoundMoney(credit.DebitNoteAmount - credit.CreditReceived)
+	}
+
+	result := []supplierCredit{}
+	for _, credit := range credits {
+		result = append(result, *credit)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Outstanding > result[j].Outstanding
+	})
+	utils.RespondWithJSON(w, http.StatusOK, result)
+}
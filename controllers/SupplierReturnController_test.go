@@ -0,0 +1,47 @@
+package controllers_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+// Only received units that aren't promised to a sales order can go back, the debit note is
+// priced at the order's cost.
+func TestCreateSupplierReturn(t *testing.T) {
+	router, acme := setUp(t)
+	product := create(t, router, acme, "POST", "/add-product", `{"Name":"desk","SKU":"DESK","Price":100}`)
+	purchaseOrder := create(t, router, acme, "POST", "/add-purchase-order", fmt.Sprintf(`{"ProductID":%d,"Quantity":10,"Supplier":"wood co","UnitCost":10,"DeferReceipt":true}`, product))
+	create(t, router, acme, "POST", fmt.Sprintf("/purchase-order/%d/receive", purchaseOrder), `{"Quantity":6}`)
+	create(t, router, acme, "POST", "/add-sales-order", fmt.Sprintf(`{"ProductID":%d,"Quantity":1}`, product))
+
+	tests := []struct {
+		name         string
+		quantity     int
+		wantCode     int
+		wantDebit    float64
+		wantQuantity int
+	}{
+		{"received units", 4, http.StatusOK, 40, 2},
+		{"more than is left of the receipt", 3, http.StatusConflict, 0, 2},
+		{"reserved units", 2, http.StatusConflict, 0, 2},
+		{"the rest", 1, http.StatusOK, 10, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := acme.Do(router, "POST", fmt.Sprintf("/purchase-order/%d/return", purchaseOrder), fmt.Sprintf(`{"Quantity":%d,"Reason":"scratched"}`, tt.quantity))
+			if w.Code != tt.wantCode {
+				t.Fatalf("answered %d, want %d: %s", w.Code, tt.wantCode, w.Body)
+			}
+			var supplierReturn struct{ DebitNoteAmount float64 }
+			json.Unmarshal(w.Body.Bytes(), &supplierReturn)
+			if supplierReturn.DebitNoteAmount != tt.wantDebit {
+				t.Errorf("debit note %v, want %v", supplierReturn.DebitNoteAmount, tt.wantDebit)
+			}
+			if got := stock(t, acme, product).Quantity; got != tt.wantQuantity {
+				t.Errorf("stock %d, want %d", got, tt.wantQuantity)
+			}
+		})
+	}
+}
@@ -0,0 +1,236 @@
+package controllers
+
+import (
+	"errors"
+	"inventory-control-hub/database"
+	"inventory-control-hub/models"
+	"inventory-control-hub/utils"
+	"net/http"
+	"os"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+)
+
+var errNoJurisdiction = errors.New("jurisdiction is required for taxable products")
+var errNoTaxRate = errors.New("no tax rate configured for this product's tax class in the given jurisdiction")
+
+// taxBreakdown is the result of taxing one order line
+type taxBreakdown struct {
+	Rate  float64
+	Net   float64
+	Tax   float64
+	Gross float64
+}
+
+// calculateTax splits a line amount into net/tax/gross.
+// When inclusive is true the amount already contains tax and the net part is backed out of it,
+// otherwise tax is added on top. Products without a tax class are not taxed.
+func calculateTax(db *gorm.DB, taxClassID *uint, jurisdiction string, amount float64, inclusive bool) (taxBreakdown, error) {
+	if taxClassID == nil {
+		amount = utils.RoundMoney(amount)
+		return taxBreakdown{Net: amount, Gross: amount}, nil
+	}
+	if jurisdiction == "" {
+		return taxBreakdown{}, errNoJurisdiction
+	}
+
+	var taxRate models.TaxRate
+	if err := db.Where("tax_class_id = ? AND jurisdiction = ?", *taxClassID, jurisdiction).First(&taxRate).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return taxBreakdown{}, errNoTaxRate
+		}
+		return taxBreakdown{}, err
+	}
+
+	result := taxBreakdown{Rate: taxRate.Rate}
+	if inclusive {
+		result.Gross = utils.RoundMoney(amount)
+		result.Net = utils.RoundMoney(amount / (1 + taxRate.Rate/100))
+		result.Tax = utils.RoundMoney(result.Gross - result.Net)
+	} else {
+		result.Net = utils.RoundMoney(amount)
+		result.Tax = utils.RoundMoney(result.Net * taxRate.Rate / 100)
+		result.Gross = utils.RoundMoney(result.Net + result.Tax)
+	}
+	return result, nil
+}
+
+// orderJurisdiction falls back to DEFAULT_TAX_JURISDICTION from .env when the order doesn't name one
+func orderJurisdiction(jurisdiction string) string {
+	if jurisdiction != "" {
+		return jurisdiction
+	}
+	return os.Getenv("DEFAULT_TAX_JURISDICTION")
+}
+
+// respondWithTaxError maps calculateTax errors to a response
+func respondWithTaxError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, errNoJurisdiction):
+		utils.RespondWithError(w, http.StatusBadRequest, "Jurisdiction is required for taxable products")
+	case errors.Is(err, errNoTaxRate):
+		utils.RespondWithError(w, http.StatusBadRequest, "No tax rate configured for this product in the given jurisdiction")
+	default:
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to calculate tax")
+	}
+}
+
+func GetTaxClasses(w http.ResponseWriter, r *http.Request) {
+	var taxClasses []models.TaxClass
+	if database.For(r).Find(&taxClasses).Error != nil {
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to fetch tax classes")
+		return
+	}
+	utils.RespondWithJSON(w, http.StatusOK, taxClasses)
+}
+
+// taxClassRequest is the body of AddTaxClass
+type taxClassRequest struct {
+	Name        string `validate:"required,maxlen=100"`
+	Description string
+}
+
+func AddTaxClass(w http.ResponseWriter, r *http.Request) {
+	var request taxClassRequest
+	if !utils.DecodeJSON(w, r, &request) {
+		return
+	}
+	taxClass := models.TaxClass{Name: request.Name, Description: request.Description}
+
+	var existing models.TaxClass
+	if database.For(r).Where("name = ?", taxClass.Name).First(&existing).Error == nil {
+		utils.RespondWithError(w, http.StatusConflict, "This tax class already exists")
+		return
+	}
+
+	if database.For(r).Create(&taxClass).Error != nil {
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to add tax class")
+		return
+	}
+	utils.RespondWithJSON(w, http.StatusOK, taxClass)
+}
+
+func DeleteTaxClass(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	var taxClass models.TaxClass
+	if database.For(r).First(&taxClass, id).Error != nil {
+		utils.RespondWithError(w, http.StatusNotFound, "Tax class not found")
+		return
+	}
+
+	var count int64
+	// deleted products count too, they'd come back without their tax class when restored
+	database.For(r).Unscoped().Model(&models.Product{}).Where("tax_class_id = ?", taxClass.ID).Count(&count)
+	if count > 0 {
+		utils.RespondWithError(w, http.StatusConflict, "Cannot delete tax class because products use it")
+		return
+	}
+
+	tx := database.For(r).Begin()
+	if tx.Where("tax_class_id = ?", taxClass.ID).Delete(&models.TaxRate{}).Error != nil {
+		tx.Rollback()
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to delete tax rates")
+		return
+	}
+	if tx.Delete(&taxClass).Error != nil {
+		tx.Rollback()
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to delete tax class")
+		return
+	}
+	tx.Commit()
+	utils.RespondWithJSON(w, http.StatusOK, map[string]string{"message": "Tax class deleted successfully"})
+}
+
+func GetTaxRates(w http.ResponseWriter, r *http.Request) {
+	var taxRates []models.TaxRate
+	query := database.For(r).Preload("TaxClass")
+	if jurisdiction := r.URL.Query().Get("jurisdiction"); jurisdiction != "" {
+		query = query.Where("jurisdiction = ?", jurisdiction)
+	}
+	if query.Find(&taxRates).Error != nil {
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to fetch tax rates")
+		return
+	}
+	utils.RespondWithJSON(w, http.StatusOK, taxRates)
+}
+
+// taxRateRequest is the body of AddTaxRate
+type taxRateRequest struct {
+	TaxClassID   *uint   `validate:"required"`
+	Jurisdiction string  `validate:"required,maxlen=50"`
+	Rate         float64 `validate:"min=0"`
+}
+
+func AddTaxRate(w http.ResponseWriter, r *http.Request) {
+	var request taxRateRequest
+	if !utils.DecodeJSON(w, r, &request) {
+		return
+	}
+	taxRate := models.TaxRate{TaxClassID: request.TaxClassID, Jurisdiction: request.Jurisdiction, Rate: request.Rate}
+
+	var taxClass models.TaxClass
+	if database.For(r).First(&taxClass, taxRate.TaxClassID).Error != nil {
+		utils.RespondWithError(w, http.StatusNotFound, "Tax class not found")
+		return
+	}
+
+	var existing models.TaxRate
+	if database.For(r).Where("tax_class_id = ? AND jurisdiction = ?", taxRate.TaxClassID, taxRate.Jurisdiction).First(&existing).Error == nil {
+		utils.RespondWithError(w, http.StatusConflict, "A rate for this tax class and jurisdiction already exists")
+		return
+	}
+
+	if database.For(r).Create(&taxRate).Error != nil {
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to add tax rate")
+		return
+	}
+	database.For(r).Preload("TaxClass").First(&taxRate, taxRate.ID)
+	utils.RespondWithJSON(w, http.StatusOK, taxRate)
+}
+
+// taxRateUpdateRequest is the body of UpdateTaxRate
+type taxRateUpdateRequest struct {
+	Rate *float64 `validate:"required,min=0"`
+}
+
+// UpdateTaxRate only changes the percentage; class and jurisdiction identify the rate.
+// Existing orders keep the rate they were created with.
+func UpdateTaxRate(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	var taxRate models.TaxRate
+	if database.For(r).First(&taxRate, id).Error != nil {
+		utils.RespondWithError(w, http.StatusNotFound, "Tax rate not found")
+		return
+	}
+
+	var updatedData taxRateUpdateRequest
+	if !utils.DecodeJSON(w, r, &updatedData) {
+		return
+	}
+
+	taxRate.Rate = *updatedData.Rate
+	if database.For(r).Save(&taxRate).Error != nil {
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to update tax rate")
+		return
+	}
+	database.For(r).Preload("TaxClass").First(&taxRate, taxRate.ID)
+	utils.RespondWithJSON(w, http.StatusOK, taxRate)
+}
+
+func DeleteTaxRate(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	var taxRate models.TaxRate
+	if database.For(r).First(&taxRate, id).Error != nil {
+		utils.RespondWithError(w, http.StatusNotFound, "Tax rate not found")
+		return
+	}
+	if database.For(r).Delete(&taxRate).Error != nil {
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to delete tax rate")
+		return
+	}
+	utils.RespondWithJSON(w, http.StatusOK, map[string]string{"message": "Tax rate deleted successfully"})
+}
@@ -0,0 +1,19 @@
+package controllers_test
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+// A tax class can't be deleted while a product uses it, deleted products included.
+func TestDeleteTaxClassInUse(t *testing.T) {
+	router, acme := setUp(t)
+	taxClass := create(t, router, acme, "POST", "/add-tax-class", `{"Name":"standard"}`)
+	product := create(t, router, acme, "POST", "/add-product", fmt.Sprintf(`{"Name":"desk","SKU":"DESK","Price":100,"TaxClassID":%d}`, taxClass))
+	create(t, router, acme, "DELETE", fmt.Sprintf("/delete-product/%d", product), "")
+
+	if w := acme.Do(router, "DELETE", fmt.Sprintf("/delete-tax-class/%d", taxClass), ""); w.Code != http.StatusConflict {
+		t.Errorf("answered %d, want %d: %s", w.Code, http.StatusConflict, w.Body)
+	}
+}
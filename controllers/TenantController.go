@@ -0,0 +1,123 @@
+package controllers
+
+import (
+	"inventory-control-hub/auth"
+	"inventory-control-hub/database"
+	"inventory-control-hub/models"
+	"inventory-control-hub/utils"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+var tenantSlugPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,49}$`)
+
+// requireOperator only lets the default tenant, the company running the deployment, manage tenants
+func requireOperator(w http.ResponseWriter, r *http.Request) bool {
+	tenantID, _ := database.TenantID(r.Context())
+	var tenant models.Tenant
+	if database.For(r).First(&tenant, tenantID).Error != nil || tenant.Slug != database.DefaultTenantSlug {
+		utils.RespondWithError(w, http.StatusForbidden, "Tenants can only be managed from the default tenant")
+		return false
+	}
+	return true
+}
+
+func GetTenants(w http.ResponseWriter, r *http.Request) {
+	if !requireOperator(w, r) {
+		return
+	}
+	var tenants []models.Tenant
+	if database.For(r).Order("id").Find(&tenants).Error != nil {
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to fetch tenants")
+		return
+	}
+	utils.RespondWithJSON(w, http.StatusOK, tenants)
+}
+
+// tenantRequest is the body of AddTenant
+type tenantRequest struct {
+	Name          string `validate:"required"`
+	Slug          string `validate:"required"`
+	AdminEmail    string `validate:"required,maxlen=255" pattern:"^[^@\\s]+@[^@\\s]+$"`
+	AdminPassword string `validate:"required"`
+}
+
+func (request tenantRequest) Validate(errs *utils.FieldErrors) {
+	if slug := strings.ToLower(strings.TrimSpace(request.Slug)); slug != "" && !tenantSlugPattern.MatchString(slug) {
+		errs.Add("Slug", "pattern", "must be lowercase letters, digits and dashes")
+	}
+	validatePassword(errs, "AdminPassword", request.AdminPassword)
+}
+
+// tenantUpdateRequest is the body of UpdateTenant
+type tenantUpdateRequest struct {
+	Name string `validate:"required"`
+}
+
+// AddTenant creates a tenant with the default roles and its first admin, POST /add-tenant with
+// {"Name":"Acme Ltd","Slug":"acme","AdminEmail":"...","AdminPassword":"..."}
+func AddTenant(w http.ResponseWriter, r *http.Request) {
+	if !requireOperator(w, r) {
+		return
+	}
+	var request tenantRequest
+	if !utils.DecodeJSON(w, r, &request) {
+		return
+	}
+	request.Slug = strings.ToLower(strings.TrimSpace(request.Slug))
+	var count int64
+	database.For(r).Model(&models.Tenant{}).Where("slug = ?", request.Slug).Count(&count)
+	if count > 0 {
+		utils.RespondWithError(w, http.StatusConflict, "A tenant with this slug already exists")
+		return
+	}
+
+	tx := database.For(r).Begin()
+	tenant := models.Tenant{Name: request.Name, Slug: request.Slug}
+	if tx.Create(&tenant).Error != nil {
+		tx.Rollback()
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to create tenant")
+		return
+	}
+	// the roles and admin belong to the new tenant, the audit log keeps who created them
+	tenantTx := tx.WithContext(database.WithTenant(r.Context(), tenant.ID))
+	if auth.CreateDefaultRoles(tenantTx) != nil {
+		tx.Rollback()
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to create the tenant's roles")
+		return
+	}
+	if _, err := auth.CreateAdmin(tenantTx, request.AdminEmail, request.AdminPassword); err != nil {
+		tx.Rollback()
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to create the tenant's admin")
+		return
+	}
+	tx.Commit()
+	utils.RespondWithJSON(w, http.StatusOK, tenant)
+}
+
+// UpdateTenant renames a tenant, PUT /update-tenant/{id} with {"Name":"..."}
+func UpdateTenant(w http.ResponseWriter, r *http.Request) {
+	if !requireOperator(w, r) {
+		return
+	}
+	id := mux.Vars(r)["id"]
+
+	var request tenantUpdateRequest
+	if !utils.DecodeJSON(w, r, &request) {
+		return
+	}
+
+	var tenant models.Tenant
+	if database.For(r).First(&tenant, id).Error != nil {
+		utils.RespondWithError(w, http.StatusNotFound, "Tenant not found")
+		return
+	}
+	if database.For(r).Model(&tenant).Update("name", request.Name).Error != nil {
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to update tenant")
+		return
+	}
+	utils.RespondWithJSON(w, http.StatusOK, tenant)
+}
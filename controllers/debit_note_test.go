@@ -0,0 +1,28 @@
+package controllers
+
+import (
+	"inventory-control-hub/models"
+	"testing"
+)
+
+func TestDebitNoteTax(t *testing.T) {
+	purchaseOrder := models.PurchaseOrder{Quantity: 3, TaxRate: 20, NetAmount: 100, TaxAmount: 20, GrossAmount: 120}
+
+	tests := []struct {
+		name          string
+		purchaseOrder models.PurchaseOrder
+		quantity      int
+		want          taxBreakdown
+	}{
+		{"everything", purchaseOrder, 3, taxBreakdown{Rate: 20, Net: 100, Tax: 20, Gross: 120}},
+		{"a share, rounded to cents", purchaseOrder, 1, taxBreakdown{Rate: 20, Net: 33.33, Tax: 6.67, Gross: 40}},
+		{"order without quantity", models.PurchaseOrder{TaxRate: 20}, 1, taxBreakdown{Rate: 20}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := debitNoteTax(tt.purchaseOrder, tt.quantity); got != tt.want {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,89 @@
+package controllers
+
+import (
+	"errors"
+	"inventory-control-hub/apitest"
+	"inventory-control-hub/models"
+	"testing"
+	"time"
+)
+
+func TestDiscountAmount(t *testing.T) {
+	tests := []struct {
+		name         string
+		discountType string
+		value        float64
+		base         float64
+		want         float64
+		wantErr      error
+	}{
+		{"none", "", 10, 200, 0, nil},
+		{"percent", "percent", 12.5, 99.99, 12.5, nil},
+		{"all of it", "percent", 100, 80, 80, nil},
+		{"fixed", "fixed", 30, 200, 30, nil},
+		{"fixed above the base", "fixed", 300, 200, 200, nil},
+		{"percent above 100", "percent", 101, 200, 0, errInvalidDiscount},
+		{"negative", "fixed", -5, 200, 0, errInvalidDiscount},
+		{"unknown type", "bogof", 1, 200, 0, errInvalidDiscount},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := discountAmount(tt.discountType, tt.value, tt.base)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("error %v, want %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// The line discount comes off first, then the order discount and the promotion, each from what's left.
+func TestPriceSalesOrderDiscounts(t *testing.T) {
+	apitest.Open(t)
+	db := apitest.AddTenant(t, "acme").DB()
+	product := models.Product{Name: "desk", Price: 100}
+	if err := db.Create(&product).Error; err != nil {
+		t.Fatal(err)
+	}
+	promotion := &models.Promotion{ID: 7, Code: "TEN", DiscountType: "percent", DiscountValue: 10, MinOrderValue: 100}
+
+	tests := []struct {
+		name              string
+		order             models.SalesOrder
+		promotion         *models.Promotion
+		wantLineDiscount  float64
+		wantOrderDiscount float64
+		wantGross         float64
+		wantErr           error
+	}{
+		{"no discounts", models.SalesOrder{Quantity: 2}, nil, 0, 0, 200, nil},
+		{"line discount", models.SalesOrder{Quantity: 2, LineDiscountType: "percent", LineDiscountValue: 10}, nil, 20, 0, 180, nil},
+		{"all stacked", models.SalesOrder{Quantity: 2, LineDiscountType: "percent", LineDiscountValue: 10, OrderDiscountType: "fixed", OrderDiscountValue: 30}, promotion, 20, 45, 135, nil},
+		{"below the promotion's minimum", models.SalesOrder{Quantity: 1, OrderDiscountType: "fixed", OrderDiscountValue: 10}, promotion, 0, 0, 0, errPromotionMinimum},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			order := tt.order
+			order.ProductID = &product.ID
+			err := priceSalesOrder(db, &order, product, tt.promotion, time.Now())
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("error %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if order.LineDiscountAmount != tt.wantLineDiscount || order.OrderDiscountAmount != tt.wantOrderDiscount || order.GrossAmount != tt.wantGross {
+				t.Errorf("line discount %v order discount %v gross %v, want %v, %v and %v",
+					order.LineDiscountAmount, order.OrderDiscountAmount, order.GrossAmount, tt.wantLineDiscount, tt.wantOrderDiscount, tt.wantGross)
+			}
+			if order.DiscountAmount != tt.wantLineDiscount+tt.wantOrderDiscount || order.TotalPrice != order.GrossAmount {
+				t.Errorf("discount %v total %v, want %v and %v", order.DiscountAmount, order.TotalPrice, tt.wantLineDiscount+tt.wantOrderDiscount, order.GrossAmount)
+			}
+			if (order.PromotionID != nil) != (tt.promotion != nil) {
+				t.Errorf("promotion %v applied, want %v", order.PromotionID, tt.promotion)
+			}
+		})
+	}
+}
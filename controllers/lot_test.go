@@ -0,0 +1,87 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"inventory-control-hub/apitest"
+	"inventory-control-hub/models"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestAllocateLots(t *testing.T) {
+	apitest.Open(t)
+	db := apitest.AddTenant(t, "acme").DB()
+	now := time.Now()
+	in := func(days int) *time.Time {
+		date := now.AddDate(0, 0, days)
+		return &date
+	}
+
+	tests := []struct {
+		name      string
+		quantity  int
+		requested map[string]int // lot number to quantity, nil for first-expired-first-out
+		want      map[string]int
+		wantErr   error
+	}{
+		{"soonest expiry first", 4, nil, map[string]int{"IN-5": 3, "IN-10": 1}, nil},
+		{"lots without expiry last", 6, nil, map[string]int{"IN-5": 3, "IN-10": 2, "NONE": 1}, nil},
+		{"more than the unexpired lots hold", 16, nil, nil, errLotInsufficient},
+		{"requested lot", 2, map[string]int{"IN-10": 2}, map[string]int{"IN-10": 2}, nil},
+		{"requested expired lot", 2, map[string]int{"EXPIRED": 2}, nil, errLotExpired},
+		{"requested more than the lot holds", 3, map[string]int{"IN-10": 3}, nil, errLotInsufficient},
+		{"requested less than ordered", 3, map[string]int{"IN-10": 2}, nil, errLotInsufficient},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			product := models.Product{Name: fmt.Sprintf("glue %d", i), LotTracked: true}
+			if err := db.Create(&product).Error; err != nil {
+				t.Fatal(err)
+			}
+			lots := map[string]*models.Lot{}
+			for _, lot := range []models.Lot{
+				{LotNumber: "EXPIRED", ExpiryDate: in(-1), Quantity: 5},
+				{LotNumber: "NONE", Quantity: 10},
+				{LotNumber: "IN-10", ExpiryDate: in(10), Quantity: 2},
+				{LotNumber: "IN-5", ExpiryDate: in(5), Quantity: 3},
+			} {
+				lot.ProductID = &product.ID
+				if err := db.Create(&lot).Error; err != nil {
+					t.Fatal(err)
+				}
+				lots[lot.LotNumber] = &lot
+			}
+			salesOrder := models.SalesOrder{ProductID: &product.ID, Quantity: tt.quantity}
+			if err := db.Create(&salesOrder).Error; err != nil {
+				t.Fatal(err)
+			}
+			var requested []models.LotAllocation
+			for number, quantity := range tt.requested {
+				requested = append(requested, models.LotAllocation{LotID: &lots[number].ID, Quantity: quantity})
+			}
+
+			tx := db.Begin()
+			defer tx.Rollback()
+			if err := allocateLots(tx, salesOrder, requested, now); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("error %v, want %v", err, tt.wantErr)
+			}
+			var allocations []models.LotAllocation
+			tx.Preload("Lot").Where("sales_order_id = ?", salesOrder.ID).Find(&allocations)
+			got := map[string]int{}
+			for _, allocation := range allocations {
+				got[allocation.Lot.LotNumber] = allocation.Quantity
+				if left := lots[allocation.Lot.LotNumber].Quantity - allocation.Quantity; allocation.Lot.Quantity != left {
+					t.Errorf("lot %s holds %d, want %d", allocation.Lot.LotNumber, allocation.Lot.Quantity, left)
+				}
+			}
+			if tt.want == nil {
+				tt.want = map[string]int{}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("allocated %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
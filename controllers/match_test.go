@@ -0,0 +1,74 @@
+package controllers
+
+import (
+	"inventory-control-hub/apitest"
+	"inventory-control-hub/models"
+	"testing"
+)
+
+func TestMatchSupplierBill(t *testing.T) {
+	apitest.Open(t)
+	db := apitest.AddTenant(t, "acme").DB()
+	t.Setenv("BILL_QUANTITY_TOLERANCE", "1")
+	t.Setenv("BILL_PRICE_TOLERANCE_PERCENT", "2")
+
+	purchaseOrder := models.PurchaseOrder{Supplier: "Wood Co", Quantity: 10, ReceivedQuantity: 10, UnitCost: 10, TaxRate: 10}
+	if err := db.Create(&purchaseOrder).Error; err != nil {
+		t.Fatal(err)
+	}
+	received := func(received int, returned int) models.PurchaseOrder {
+		changed := purchaseOrder
+		changed.ReceivedQuantity, changed.ReturnedQuantity = received, returned
+		return changed
+	}
+	inclusive := purchaseOrder
+	inclusive.CostIncludesTax = true
+
+	tests := []struct {
+		name          string
+		bill          models.SupplierBill
+		purchaseOrder models.PurchaseOrder
+		wantStatus    string
+		wantTotal     float64
+	}{
+		{"exact", models.SupplierBill{Quantity: 10, UnitCost: 10}, purchaseOrder, "matched", 110},
+		{"supplier in other case", models.SupplierBill{Supplier: "WOOD CO", Quantity: 10, UnitCost: 10}, purchaseOrder, "matched", 110},
+		{"within the quantity tolerance", models.SupplierBill{Quantity: 11, UnitCost: 10}, purchaseOrder, "matched", 121},
+		{"more than ordered", models.SupplierBill{Quantity: 12, UnitCost: 10}, received(12, 0), "exception", 132},
+		{"more than received", models.SupplierBill{Quantity: 8, UnitCost: 10}, received(6, 0), "exception", 88},
+		{"returned goods billed", models.SupplierBill{Quantity: 10, UnitCost: 10}, received(10, 3), "exception", 110},
+		{"within the price tolerance", models.SupplierBill{Quantity: 10, UnitCost: 10.15}, purchaseOrder, "matched", 111.65},
+		{"price above the tolerance", models.SupplierBill{Quantity: 10, UnitCost: 10.5}, purchaseOrder, "exception", 115.5},
+		{"total off", models.SupplierBill{Quantity: 10, UnitCost: 10, TotalAmount: 120}, purchaseOrder, "exception", 120},
+		{"cost includes tax", models.SupplierBill{Quantity: 10, UnitCost: 10, TotalAmount: 100}, inclusive, "matched", 100},
+		{"other supplier", models.SupplierBill{Supplier: "Metal Co", Quantity: 10, UnitCost: 10}, purchaseOrder, "exception", 110},
+		{"approved stays approved", models.SupplierBill{Quantity: 12, UnitCost: 10, MatchStatus: "approved"}, purchaseOrder, "approved", 132},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bill := tt.bill
+			if err := matchSupplierBill(db, &bill, tt.purchaseOrder); err != nil {
+				t.Fatal(err)
+			}
+			if bill.MatchStatus != tt.wantStatus {
+				t.Errorf("status %q, want %q: %s", bill.MatchStatus, tt.wantStatus, bill.MatchNotes)
+			}
+			if bill.TotalAmount != tt.wantTotal {
+				t.Errorf("total %v, want %v", bill.TotalAmount, tt.wantTotal)
+			}
+		})
+	}
+
+	// bills already entered for the order count towards what is billed
+	first := models.SupplierBill{PurchaseOrderID: &purchaseOrder.ID, Supplier: "Wood Co", BillNumber: "WC-1", Quantity: 6, UnitCost: 10}
+	if err := db.Create(&first).Error; err != nil {
+		t.Fatal(err)
+	}
+	second := models.SupplierBill{Quantity: 6, UnitCost: 10}
+	if err := matchSupplierBill(db, &second, purchaseOrder); err != nil {
+		t.Fatal(err)
+	}
+	if second.MatchStatus != "exception" {
+		t.Errorf("second bill for 6 of 10 is %q, want exception", second.MatchStatus)
+	}
+}
@@ -0,0 +1,76 @@
+package controllers
+
+import (
+	"errors"
+	"inventory-control-hub/apitest"
+	"inventory-control-hub/models"
+	"testing"
+	"time"
+)
+
+func TestQuotePrice(t *testing.T) {
+	apitest.Open(t)
+	db := apitest.AddTenant(t, "acme").DB()
+	now := time.Now()
+	yesterday, tomorrow := now.AddDate(0, 0, -1), now.AddDate(0, 0, 1)
+
+	product := models.Product{Name: "desk", Price: 100}
+	other := models.Product{Name: "chair", Price: 50}
+	priceList := models.PriceList{Name: "trade"}
+	for _, row := range []interface{}{&product, &other, &priceList} {
+		if err := db.Create(row).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	items := []models.PriceListItem{
+		{PriceListID: &priceList.ID, ProductID: &product.ID, MinQuantity: 0, Price: 90},
+		{PriceListID: &priceList.ID, ProductID: &product.ID, MinQuantity: 10, Price: 80},
+		{PriceListID: &priceList.ID, ProductID: &product.ID, MinQuantity: 50, Price: 85}, // a higher break that costs more doesn't win
+		{PriceListID: &priceList.ID, ProductID: &product.ID, MinQuantity: 0, Price: 70, ValidFrom: &tomorrow},
+		{PriceListID: &priceList.ID, ProductID: &product.ID, MinQuantity: 0, Price: 60, ValidTo: &yesterday},
+	}
+	if err := db.Create(&items).Error; err != nil {
+		t.Fatal(err)
+	}
+	trade := models.Customer{Name: "trade buyer", PriceListID: &priceList.ID}
+	retail := models.Customer{Name: "retail buyer"}
+	for _, customer := range []*models.Customer{&trade, &retail} {
+		if err := db.Create(customer).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	missing := uint(999)
+
+	tests := []struct {
+		name           string
+		product        models.Product
+		customerID     *uint
+		quantity       int
+		wantUnitPrice  float64
+		wantLineAmount float64
+		wantItem       *uint
+		wantErr        error
+	}{
+		{"walk-in customer", product, nil, 2, 100, 200, nil, nil},
+		{"customer without a price list", product, &retail.ID, 2, 100, 200, nil, nil},
+		{"list price", product, &trade.ID, 2, 90, 180, &items[0].ID, nil},
+		{"quantity break", product, &trade.ID, 10, 80, 800, &items[1].ID, nil},
+		{"lowest qualifying price", product, &trade.ID, 50, 80, 4000, &items[1].ID, nil},
+		{"product not on the list", other, &trade.ID, 2, 50, 100, nil, nil},
+		{"unknown customer", product, &missing, 2, 100, 0, nil, errCustomerNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			quote, err := quotePrice(db, tt.product, tt.customerID, tt.quantity, now)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("error %v, want %v", err, tt.wantErr)
+			}
+			if quote.UnitPrice != tt.wantUnitPrice || quote.LineAmount != tt.wantLineAmount {
+				t.Errorf("unit price %v line amount %v, want %v and %v", quote.UnitPrice, quote.LineAmount, tt.wantUnitPrice, tt.wantLineAmount)
+			}
+			if (quote.PriceListItemID == nil) != (tt.wantItem == nil) || (tt.wantItem != nil && *quote.PriceListItemID != *tt.wantItem) {
+				t.Errorf("price list item %v, want %v", quote.PriceListItemID, tt.wantItem)
+			}
+		})
+	}
+}
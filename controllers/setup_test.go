@@ -0,0 +1,49 @@
+package controllers_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"inventory-control-hub/apitest"
+	"inventory-control-hub/models"
+	"inventory-control-hub/routes"
+	"net/http"
+	"testing"
+)
+
+// setUp runs the API on a throwaway database with one tenant the requests are made as
+func setUp(t *testing.T) (http.Handler, apitest.Tenant) {
+	t.Helper()
+	apitest.Open(t)
+	return routes.SetupRouter(), apitest.AddTenant(t, "acme")
+}
+
+// create sends a request that has to succeed and returns the ID of what it answered
+func create(t *testing.T, router http.Handler, tenant apitest.Tenant, method string, path string, body string) uint {
+	t.Helper()
+	w := tenant.Do(router, method, path, body)
+	if w.Code != http.StatusOK && w.Code != http.StatusCreated {
+		t.Fatalf("%s %s answered %d: %s", method, path, w.Code, w.Body)
+	}
+	var answer struct{ ID uint }
+	json.Unmarshal(w.Body.Bytes(), &answer)
+	return answer.ID
+}
+
+// ship picks, packs and ships quantity units of a sales order in one parcel
+func ship(t *testing.T, router http.Handler, tenant apitest.Tenant, salesOrder uint, quantity int) {
+	t.Helper()
+	pickTask := create(t, router, tenant, "POST", fmt.Sprintf("/sales-order/%d/pick-list", salesOrder), `{}`)
+	create(t, router, tenant, "POST", fmt.Sprintf("/pick-task/%d/confirm", pickTask), `{}`)
+	shipment := create(t, router, tenant, "POST", fmt.Sprintf("/sales-order/%d/pack", salesOrder), fmt.Sprintf(`{"Parcels":[{"Quantity":%d,"Weight":1}]}`, quantity))
+	create(t, router, tenant, "POST", fmt.Sprintf("/shipment/%d/ship", shipment), `{"Carrier":"own van","TrackingNumber":"VAN-1"}`)
+}
+
+// stock is the stock columns of a product as they are in the database
+func stock(t *testing.T, tenant apitest.Tenant, product uint) models.Product {
+	t.Helper()
+	var row models.Product
+	if err := tenant.DB().Unscoped().First(&row, product).Error; err != nil {
+		t.Fatal(err)
+	}
+	return row
+}
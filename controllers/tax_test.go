@@ -0,0 +1,48 @@
+package controllers
+
+import (
+	"errors"
+	"inventory-control-hub/apitest"
+	"inventory-control-hub/models"
+	"testing"
+)
+
+func TestCalculateTax(t *testing.T) {
+	apitest.Open(t)
+	db := apitest.AddTenant(t, "acme").DB()
+	taxClass := models.TaxClass{Name: "standard"}
+	if err := db.Create(&taxClass).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Create(&models.TaxRate{TaxClassID: &taxClass.ID, Jurisdiction: "CA", Rate: 20}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name         string
+		taxClassID   *uint
+		jurisdiction string
+		amount       float64
+		inclusive    bool
+		want         taxBreakdown
+		wantErr      error
+	}{
+		{"untaxed product", nil, "", 99.999, false, taxBreakdown{Net: 100, Gross: 100}, nil},
+		{"tax on top", &taxClass.ID, "CA", 100, false, taxBreakdown{Rate: 20, Net: 100, Tax: 20, Gross: 120}, nil},
+		{"tax included", &taxClass.ID, "CA", 120, true, taxBreakdown{Rate: 20, Net: 100, Tax: 20, Gross: 120}, nil},
+		{"rounded to cents", &taxClass.ID, "CA", 10, true, taxBreakdown{Rate: 20, Net: 8.33, Tax: 1.67, Gross: 10}, nil},
+		{"no jurisdiction", &taxClass.ID, "", 100, false, taxBreakdown{}, errNoJurisdiction},
+		{"no rate in the jurisdiction", &taxClass.ID, "NY", 100, false, taxBreakdown{}, errNoTaxRate},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := calculateTax(db, tt.taxClassID, tt.jurisdiction, tt.amount, tt.inclusive)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("error %v, want %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,86 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sync/atomic"
+
+	"gorm.io/gorm"
+)
+
+// Commits tells whether statements run with a context saved anything: a transaction was
+// committed or a statement ran outside of one
+type Commits struct {
+	committed atomic.Bool
+}
+
+// Committed is true once something run with the context may have been saved
+func (c *Commits) Committed() bool {
+	return c.committed.Load()
+}
+
+// WithCommits returns a context whose commits are recorded on the returned Commits, on a DB
+// set up with TrackCommits
+func WithCommits(ctx context.Context) (context.Context, *Commits) {
+	commits := &Commits{}
+	return context.WithValue(ctx, commitsKey, commits), commits
+}
+
+func markCommitted(ctx context.Context) {
+	if commits, ok := ctx.Value(commitsKey).(*Commits); ok {
+		commits.committed.Store(true)
+	}
+}
+
+// TrackCommits wraps the connection pool of db so WithCommits sees commits. GORM has no
+// callback for Commit and a rolled back statement runs the same callbacks as a saved one.
+func TrackCommits(db *gorm.DB) error {
+	sqlDB, ok := db.ConnPool.(*sql.DB)
+	if !ok {
+		return errors.New("database: the connection pool is not a *sql.DB")
+	}
+	pool := &trackingPool{DB: sqlDB}
+	db.ConnPool = pool
+	db.Statement.ConnPool = pool
+	return nil
+}
+
+// trackingPool hands out transactions that record their commit, statements run on the pool
+// itself commit on their own
+type trackingPool struct {
+	*sql.DB
+}
+
+func (p *trackingPool) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	result, err := p.DB.ExecContext(ctx, query, args...)
+	if err == nil {
+		markCommitted(ctx)
+	}
+	return result, err
+}
+
+func (p *trackingPool) BeginTx(ctx context.Context, opts *sql.TxOptions) (gorm.ConnPool, error) {
+	tx, err := p.DB.BeginTx(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &trackingTx{Tx: tx, ctx: ctx}, nil
+}
+
+func (p *trackingPool) GetDBConn() (*sql.DB, error) {
+	return p.DB, nil
+}
+
+type trackingTx struct {
+	*sql.Tx
+	ctx context.Context
+}
+
+func (tx *trackingTx) Commit() error {
+	err := tx.Tx.Commit()
+	if err == nil {
+		markCommitted(tx.ctx)
+	}
+	return err
+}
@@ -2,8 +2,171 @@
 
 package database
 
-import "inventory-control-hub/models"
+import (
+	"inventory-control-hub/models"
+	"os"
+
+	"gorm.io/gorm"
+)
 
 func Migrate() {
-	DB.AutoMigrate(&models.Product{}, &models.PurchaseOrder{}, &models.SalesOrder{})
+	db := AllTenants()
+
+	// stock used to leave the warehouse when a sales order was placed, see the backfill below
+	stockLeftOnSale := db.Migrator().HasTable(&models.SalesOrder{}) && !db.Migrator().HasColumn(&models.SalesOrder{}, "ShippedQuantity")
+
+	// a single-company install moves into the default tenant, see the backfill below
+	tenantsAdded := !db.Migrator().HasTable(&models.Tenant{})
+	// document sequences were keyed by name alone, the table is rebuilt keyed by tenant and name
+	var sequences []models.DocumentSequence
+	if db.Migrator().HasTable(&models.DocumentSequence{}) && !db.Migrator().HasColumn(&models.DocumentSequence{}, "TenantID") {
+		db.Find(&sequences)
+		db.Migrator().DropTable(&models.DocumentSequence{})
+	}
+	// names and numbers used to be unique across the install, now they are unique per tenant
+	for _, index := range []struct {
+		model interface{}
+		name  string
+	}{
+		{&models.AssemblyOrder{}, "idx_assembly_orders_number"},
+		{&models.Bin{}, "idx_bins_code"},
+		{&models.Invoice{}, "idx_invoices_number"},
+		{&models.PickTask{}, "idx_pick_tasks_number"},
+		{&models.PriceList{}, "idx_price_lists_name"},
+		{&models.Promotion{}, "idx_promotions_code"},
+		{&models.Return{}, "idx_customer_returns_number"},
+		{&models.Role{}, "idx_roles_name"},
+		{&models.SerialNumber{}, "idx_serial_numbers_serial"},
+		{&models.Shipment{}, "idx_shipments_number"},
+		{&models.SupplierReturn{}, "idx_supplier_returns_number"},
+		{&models.TaxClass{}, "idx_tax_classes_name"},
+		{&models.User{}, "idx_users_email"},
+	} {
+		if db.Migrator().HasIndex(index.model, index.name) {
+			db.Migrator().DropIndex(index.model, index.name)
+		}
+	}
+
+	tables := []interface{}{
+		&models.Tenant{},
+		&models.Role{},
+		&models.RolePermission{},
+		&models.User{},
+		&models.AuditLog{},
+		&models.RefreshToken{},
+		&models.APIKey{},
+		&models.IdempotencyKey{},
+		&models.TaxClass{},
+		&models.TaxRate{},
+		&models.Product{},
+		&models.BillOfMaterials{},
+		&models.BOMComponent{},
+		&models.PriceList{},
+		&models.PriceListItem{},
+		&models.Customer{},
+		&models.Promotion{},
+		&models.PurchaseOrder{},
+		&models.PurchaseReceipt{},
+		&models.Lot{},
+		&models.SupplierBill{},
+		&models.SupplierReturn{},
+		&models.SalesOrder{},
+		&models.LotAllocation{},
+		&models.BackorderAllocation{},
+		&models.Notification{},
+		&models.SerialNumber{},
+		&models.SerialEvent{},
+		&models.DocumentSequence{},
+		&models.Invoice{},
+		&models.InvoiceLine{},
+		&models.Payment{},
+		&models.PaymentAllocation{},
+		&models.StockMovement{},
+		&models.AssemblyOrder{},
+		&models.AssemblyLine{},
+		&models.Bin{},
+		&models.BinStock{},
+		&models.BinMovement{},
+		&models.PickTask{},
+		&models.PickTaskLine{},
+		&models.Shipment{},
+		&models.Parcel{},
+		&models.ShippingLabel{},
+		&models.Return{},
+		&models.ReturnLine{},
+	}
+	db.AutoMigrate(tables...)
+
+	var count int64
+	db.Model(&models.Tenant{}).Count(&count)
+	if count == 0 {
+		name := os.Getenv("COMPANY_NAME")
+		if name == "" {
+			name = "Inventory Control Hub"
+		}
+		db.Create(&models.Tenant{Name: name, Slug: DefaultTenantSlug})
+	}
+	if tenantsAdded {
+		var tenant models.Tenant
+		db.Where("slug = ?", DefaultTenantSlug).First(&tenant)
+		// plain SQL, moving rows into the tenant is not a change worth an audit log entry
+		for _, table := range tables {
+			if db.Migrator().HasColumn(table, "TenantID") {
+				statement := &gorm.Statement{DB: db}
+				statement.Parse(table)
+				db.Exec("UPDATE "+statement.Table+" SET tenant_id = ? WHERE tenant_id = 0 OR tenant_id IS NULL", tenant.ID)
+			}
+		}
+		for i := range sequences {
+			sequences[i].TenantID = tenant.ID
+		}
+		if len(sequences) > 0 {
+			db.Create(&sequences)
+		}
+	}
+
+	// purchase orders placed before receipts existed were received in full when they were created
+	db.Model(&models.PurchaseOrder{}).
+		Where("defer_receipt = ? AND (status IS NULL OR status = '')", false).
+		Updates(map[string]interface{}{"received_quantity": gorm.Expr("quantity"), "status": "received"})
+
+	// sales orders placed before picking existed are treated as confirmed, not yet picked
+	db.Unscoped().Model(&models.SalesOrder{}).
+		Where("status IS NULL OR status = ''").
+		Update("status", "confirmed")
+
+	// orders placed before reservations existed already took their units out of stock,
+	// they count as shipped so shipping them again can't deduct the stock twice
+	if stockLeftOnSale {
+		db.Unscoped().Model(&models.SalesOrder{}).
+			Where("deleted_at IS NULL").
+			Updates(map[string]interface{}{"shipped_quantity": gorm.Expr("quantity"), "status": "shipped"})
+		db.Model(&models.Shipment{}).
+			Where("status = ?", "packed").
+			Update("status", "shipped")
+	}
+
+	// orders placed before taxes existed only have their total, it was charged without tax
+	db.Unscoped().Model(&models.SalesOrder{}).
+		Where("gross_amount = 0 AND total_price > 0").
+		Updates(map[string]interface{}{"net_amount": gorm.Expr("total_price"), "gross_amount": gorm.Expr("total_price")})
+
+	// orders placed before price lists existed were sold at one price for every unit
+	db.Unscoped().Model(&models.SalesOrder{}).
+		Where("unit_price = 0 AND total_price > 0 AND quantity > 0").
+		Update("unit_price", gorm.Expr("total_price / quantity"))
+
+	// orders placed before product details were snapshotted take the product's current details
+	db.Unscoped().Model(&models.SalesOrder{}).
+		Where("product_name IS NULL OR product_name = ''").
+		Updates(map[string]interface{}{
+			"product_name": gorm.Expr("(SELECT name FROM products WHERE products.id = sales_orders.product_id)"),
+			"product_sku":  gorm.Expr("(SELECT sku FROM products WHERE products.id = sales_orders.product_id)"),
+		})
+	db.Unscoped().Model(&models.PurchaseOrder{}).
+		Where("product_name IS NULL OR product_name = ''").
+		Updates(map[string]interface{}{
+			"product_name": gorm.Expr("(SELECT name FROM products WHERE products.id = purchase_orders.product_id)"),
+			"product_sku":  gorm.Expr("(SELECT sku FROM products WHERE products.id = purchase_orders.product_id)"),
+		})
 }
@@ -0,0 +1,104 @@
+package database_test
+
+import (
+	"inventory-control-hub/apitest"
+	"inventory-control-hub/database"
+	"inventory-control-hub/models"
+	"reflect"
+	"testing"
+)
+
+// The backfill moving a single-company install into the default tenant is plain SQL without
+// tenant scoping, it may only take rows without a tenant.
+func TestMigrateBackfillKeepsTenantsRows(t *testing.T) {
+	apitest.Open(t)
+	acme := apitest.AddTenant(t, "acme")
+	product := models.Product{Name: "acme desk"}
+	if err := acme.DB().Create(&product).Error; err != nil {
+		t.Fatal(err)
+	}
+	db := database.AllTenants()
+	noTenant := uint(0)
+	legacy := models.Product{Name: "legacy desk", TenantID: &noTenant}
+	if err := db.Create(&legacy).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	counts := func() map[string]int64 {
+		counts := map[string]int64{}
+		for _, table := range []string{"products", "users", "roles"} {
+			var count int64
+			db.Table(table).Where("tenant_id = ?", acme.ID).Count(&count)
+			counts[table] = count
+		}
+		return counts
+	}
+	before := counts()
+
+	// the install as it was before tenants existed
+	if err := db.Migrator().DropTable(&models.Tenant{}); err != nil {
+		t.Fatal(err)
+	}
+	database.Migrate()
+
+	var tenant models.Tenant
+	if err := db.Where("slug = ?", database.DefaultTenantSlug).First(&tenant).Error; err != nil {
+		t.Fatal(err)
+	}
+	if tenant.ID == acme.ID {
+		t.Fatal("the default tenant got acme's ID, the test can't tell the tenants apart")
+	}
+	var products []models.Product
+	db.Order("id").Find(&products)
+	if len(products) != 2 {
+		t.Fatalf("%d products, want 2", len(products))
+	}
+	if *products[0].TenantID != acme.ID || *products[1].TenantID != tenant.ID {
+		t.Errorf("products belong to tenants %v and %v, want acme %d and the default tenant %d",
+			*products[0].TenantID, *products[1].TenantID, acme.ID, tenant.ID)
+	}
+	if after := counts(); !reflect.DeepEqual(after, before) {
+		t.Errorf("acme's rows went from %v to %v", before, after)
+	}
+}
+
+// Orders placed before taxes and price lists existed only have TotalPrice, the backfill makes it
+// their net and gross amount and works out the unit price, orders that went through pricing are
+// left alone.
+func TestMigrateBackfillsLegacyOrderAmounts(t *testing.T) {
+	apitest.Open(t)
+	acme := apitest.AddTenant(t, "acme")
+
+	tests := []struct {
+		name      string
+		order     models.SalesOrder
+		wantNet   float64
+		wantGross float64
+		wantUnit  float64
+	}{
+		{"legacy", models.SalesOrder{Quantity: 3, TotalPrice: 30}, 30, 30, 10},
+		{"taxed", models.SalesOrder{Quantity: 3, UnitPrice: 12, TotalPrice: 36, NetAmount: 30, TaxAmount: 6, GrossAmount: 36}, 30, 36, 12},
+		{"free", models.SalesOrder{Quantity: 3}, 0, 0, 0},
+	}
+	for i := range tests {
+		if err := acme.DB().Create(&tests[i].order).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	database.Migrate()
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var order models.SalesOrder
+			if err := acme.DB().First(&order, tt.order.ID).Error; err != nil {
+				t.Fatal(err)
+			}
+			if order.NetAmount != tt.wantNet || order.GrossAmount != tt.wantGross {
+				t.Errorf("net %v gross %v, want %v and %v", order.NetAmount, order.GrossAmount, tt.wantNet, tt.wantGross)
+			}
+			if order.UnitPrice != tt.wantUnit {
+				t.Errorf("unit price %v, want %v", order.UnitPrice, tt.wantUnit)
+			}
+		})
+	}
+}
@@ -29,7 +29,8 @@ func Connect(){
 	dsn := fmt.Sprintf("%s:%s@tcp(%s:%s)/%s?charset=utf8mb4&parseTime=True&loc=Local", user, password, host,port, dbname)
 
 	// connection to database 
-	db, err := gorm.Open(mysql.Open(dsn), &gorm.Config{})
+	// translated errors tell a duplicate key apart, see idempotency.Middleware
+	db, err := gorm.Open(mysql.Open(dsn), &gorm.Config{TranslateError: true})
 	if err!=nil{
 		log.Fatal("Failed to connect with database")
 	}
@@ -0,0 +1,13 @@
+package database
+
+import (
+	"net/http"
+
+	"gorm.io/gorm"
+)
+
+// For is DB bound to the context of a request, so the audit log knows who made the changes.
+// Handlers use it instead of DB.
+func For(r *http.Request) *gorm.DB {
+	return DB.WithContext(r.Context())
+}
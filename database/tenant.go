@@ -0,0 +1,111 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"reflect"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// DefaultTenantSlug is the tenant requests without X-Tenant belong to. Migrate creates it and
+// moves the data of a single-company install into it.
+const DefaultTenantSlug = "default"
+
+// ErrNoTenant is returned by statements on tenant data made without a tenant on their context
+var ErrNoTenant = errors.New("no tenant on the context")
+
+type contextKey int
+
+const (
+	tenantKey contextKey = iota
+	allTenantsKey
+	commitsKey
+)
+
+// WithTenant scopes every statement run with ctx to one tenant
+func WithTenant(ctx context.Context, tenantID uint) context.Context {
+	return context.WithValue(ctx, tenantKey, tenantID)
+}
+
+// TenantID is the tenant ctx is scoped to
+func TenantID(ctx context.Context) (uint, bool) {
+	tenantID, ok := ctx.Value(tenantKey).(uint)
+	return tenantID, ok
+}
+
+// AllTenants is DB without tenant scoping, for start-up, migrations and finding out which
+// tenant a credential belongs to. Rows created through it need their TenantID set.
+func AllTenants() *gorm.DB {
+	return DB.WithContext(context.WithValue(context.Background(), allTenantsKey, true))
+}
+
+// ScopeTenants adds callbacks to db that limit every query, update and delete on a model with
+// a TenantID to the tenant of the statement's context and set TenantID on created rows.
+// Statements without a tenant fail with ErrNoTenant unless made through AllTenants,
+// so a handler forgetting the request context can't see or change other tenants' data.
+func ScopeTenants(db *gorm.DB) error {
+	callbacks := db.Callback()
+	if err := callbacks.Create().Before("gorm:create").Register("tenant:create", setTenant); err != nil {
+		return err
+	}
+	if err := callbacks.Query().Before("gorm:query").Register("tenant:query", scopeTenant); err != nil {
+		return err
+	}
+	if err := callbacks.Row().Before("gorm:row").Register("tenant:row", scopeTenant); err != nil {
+		return err
+	}
+	if err := callbacks.Update().Before("gorm:update").Register("tenant:update", scopeTenant); err != nil {
+		return err
+	}
+	return callbacks.Delete().Before("gorm:delete").Register("tenant:delete", scopeTenant)
+}
+
+// statementTenant is the tenant a statement on tenant data runs for, ok is false when it
+// needs no scoping
+func statementTenant(db *gorm.DB) (tenantID uint, ok bool) {
+	if db.Error != nil || db.Statement.Schema == nil || db.Statement.Schema.LookUpField("TenantID") == nil {
+		return 0, false
+	}
+	if allTenants, _ := db.Statement.Context.Value(allTenantsKey).(bool); allTenants {
+		return 0, false
+	}
+	tenantID, ok = TenantID(db.Statement.Context)
+	if !ok {
+		db.AddError(ErrNoTenant)
+	}
+	return tenantID, ok
+}
+
+func scopeTenant(db *gorm.DB) {
+	tenantID, ok := statementTenant(db)
+	if !ok {
+		return
+	}
+	// a statement built once and run twice, e.g. Count then Find, is only scoped once
+	if _, scoped := db.InstanceGet("tenant:scoped"); scoped {
+		return
+	}
+	db.InstanceSet("tenant:scoped", true)
+	field := db.Statement.Schema.LookUpField("TenantID")
+	db.Statement.AddClause(clause.Where{Exprs: []clause.Expression{
+		clause.Eq{Column: clause.Column{Table: db.Statement.Table, Name: field.DBName}, Value: tenantID},
+	}})
+}
+
+func setTenant(db *gorm.DB) {
+	tenantID, ok := statementTenant(db)
+	if !ok {
+		return
+	}
+	field := db.Statement.Schema.LookUpField("TenantID")
+	switch value := db.Statement.ReflectValue; value.Kind() {
+	case reflect.Struct:
+		db.AddError(field.Set(db.Statement.Context, value, &tenantID))
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < value.Len(); i++ {
+			db.AddError(field.Set(db.Statement.Context, reflect.Indirect(value.Index(i)), &tenantID))
+		}
+	}
+}
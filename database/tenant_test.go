@@ -0,0 +1,65 @@
+package database_test
+
+import (
+	"context"
+	"errors"
+	"inventory-control-hub/apitest"
+	"inventory-control-hub/database"
+	"inventory-control-hub/models"
+	"net/http/httptest"
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+func TestStatementsWithoutTenantFail(t *testing.T) {
+	apitest.Open(t)
+	acme := apitest.AddTenant(t, "acme")
+	product := models.Product{Name: "acme desk", Quantity: 5}
+	if err := acme.DB().Create(&product).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	// a handler that forgot the request context, or a request auth.Middleware didn't see
+	withoutTenant := []struct {
+		name string
+		db   func() *gorm.DB
+	}{
+		{"background context", func() *gorm.DB { return database.DB.WithContext(context.Background()) }},
+		{"request without a tenant", func() *gorm.DB { return database.For(httptest.NewRequest("GET", "/products", nil)) }},
+	}
+	statements := []struct {
+		name string
+		run  func(db *gorm.DB) error
+	}{
+		{"find", func(db *gorm.DB) error { return db.Find(&[]models.Product{}).Error }},
+		{"first", func(db *gorm.DB) error { return db.First(&models.Product{}, product.ID).Error }},
+		{"count", func(db *gorm.DB) error { var count int64; return db.Model(&models.Product{}).Count(&count).Error }},
+		{"rows", func(db *gorm.DB) error {
+			rows, err := db.Model(&models.Product{}).Select("name").Rows()
+			if err == nil {
+				rows.Close()
+			}
+			return err
+		}},
+		{"create", func(db *gorm.DB) error { return db.Create(&models.Product{Name: "stray desk"}).Error }},
+		{"update", func(db *gorm.DB) error {
+			return db.Model(&models.Product{}).Where("id = ?", product.ID).Update("quantity", 0).Error
+		}},
+		{"delete", func(db *gorm.DB) error { return db.Delete(&models.Product{}, product.ID).Error }},
+	}
+	for _, context := range withoutTenant {
+		for _, statement := range statements {
+			t.Run(context.name+" "+statement.name, func(t *testing.T) {
+				if err := statement.run(context.db()); !errors.Is(err, database.ErrNoTenant) {
+					t.Errorf("error = %v, want ErrNoTenant", err)
+				}
+				var products []models.Product
+				database.AllTenants().Unscoped().Find(&products)
+				if len(products) != 1 || products[0].Quantity != 5 || products[0].DeletedAt.Valid {
+					t.Errorf("products = %+v, want acme's desk unchanged", products)
+				}
+			})
+		}
+	}
+}
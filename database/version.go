@@ -0,0 +1,67 @@
+package database
+
+import (
+	"errors"
+	"reflect"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ErrVersionChanged is returned by LockVersion when the row is no longer at the version the
+// client edited
+var ErrVersionChanged = errors.New("version changed")
+
+// VersionRows adds a callback to db that bumps the Version column of every row an update
+// changes, on models that have one. Clients send the version back in If-Match to prove they
+// edited the latest copy. Models tag Version with <-:create so saving a stale copy of a row
+// can't write an old version back. Register it before the audit callbacks, so the audit log
+// sees the new version.
+func VersionRows(db *gorm.DB) error {
+	return db.Callback().Update().After("gorm:update").Before("gorm:commit_or_rollback_transaction").
+		Register("version:bump", bumpVersion)
+}
+
+func bumpVersion(db *gorm.DB) {
+	stmt := db.Statement
+	if db.Error != nil || stmt.Schema == nil || stmt.RowsAffected == 0 {
+		return
+	}
+	field := stmt.Schema.LookUpField("Version")
+	if field == nil {
+		return
+	}
+	where, ok := stmt.Clauses["WHERE"].Expression.(clause.Where)
+	if !ok || len(where.Exprs) == 0 {
+		return
+	}
+	// the same rows the update changed, by the conditions it already carries: primary key,
+	// tenant and soft delete. Without a model the statement has no schema, so this doesn't
+	// come back through here.
+	err := db.Session(&gorm.Session{NewDB: true}).Table(stmt.Table).Clauses(where).
+		UpdateColumn(field.DBName, gorm.Expr(field.DBName+" + 1")).Error
+	if err != nil {
+		db.AddError(err)
+		return
+	}
+	// keep the caller's copy in step, so it can answer with the new ETag
+	if value := reflect.Indirect(stmt.ReflectValue); value.Kind() == reflect.Struct {
+		if version, isZero := field.ValueOf(stmt.Context, value); !isZero {
+			db.AddError(field.Set(stmt.Context, value, version.(uint)+1))
+		}
+	}
+}
+
+// LockVersion locks the row of model with id for the rest of tx and checks it is still at
+// version, so two requests holding the same ETag can't both change it
+func LockVersion(tx *gorm.DB, model interface{}, id uint, version uint) error {
+	var row struct{ Version uint }
+	if err := tx.Model(model).Unscoped().Clauses(clause.Locking{Strength: "UPDATE"}).
+		Select("version").Where("id = ?", id).Take(&row).Error; err != nil {
+		return err
+	}
+	if row.Version != version {
+		return ErrVersionChanged
+	}
+	return nil
+}
@@ -0,0 +1,187 @@
+package idempotency
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"inventory-control-hub/auth"
+	"inventory-control-hub/database"
+	"inventory-control-hub/models"
+	"inventory-control-hub/utils"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Header is the request header naming the key, any unique string the client picks per operation
+const Header = "Idempotency-Key"
+
+const maxKeyLength = 255
+
+// skippedPaths answer with credentials that are only stored hashed, keeping a copy of the
+// response would store them in plain text
+var skippedPaths = map[string]bool{
+	"/api-keys": true,
+}
+
+// TTL is how long a key is remembered, IDEMPOTENCY_KEY_HOURS in .env (default 24).
+// Afterwards the same key runs the request again.
+func TTL() time.Duration {
+	hours, err := strconv.Atoi(os.Getenv("IDEMPOTENCY_KEY_HOURS"))
+	if err != nil || hours <= 0 {
+		hours = 24
+	}
+	return time.Duration(hours) * time.Hour
+}
+
+// Middleware makes POST requests sent with an Idempotency-Key safe to retry. The first response
+// is stored with a fingerprint of the request and replayed for every retry with the same key.
+// Reusing a key for a different request gets 422, a retry while the first request is still
+// running gets 409. The key is only freed for another run when the request provably saved
+// nothing, a server error or panic before any commit. Once something was committed the response
+// is kept even when it is a server error, running the request again could apply it twice.
+// Keys belong to the signed-in user, it runs after auth.Middleware.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(Header)
+		user := auth.CurrentUser(r)
+		if r.Method != http.MethodPost || key == "" || user == nil || skippedPaths[r.URL.Path] {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if len(key) > maxKeyLength {
+			utils.RespondWithError(w, http.StatusBadRequest, "Idempotency-Key must be at most 255 characters")
+			return
+		}
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			utils.RespondWithError(w, http.StatusBadRequest, "Invalid input")
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		fingerprint := requestFingerprint(r, body)
+
+		// the response is stored even when the client hung up, that is when it retries
+		db := database.DB.WithContext(context.WithoutCancel(r.Context()))
+		now := time.Now()
+		db.Where("expires_at <= ?", now).Delete(&models.IdempotencyKey{})
+
+		var stored models.IdempotencyKey
+		err = db.Where(&models.IdempotencyKey{UserID: &user.ID, Key: key}).First(&stored).Error
+		if err == nil {
+			switch {
+			case stored.Fingerprint != fingerprint:
+				utils.RespondWithError(w, http.StatusUnprocessableEntity, "Idempotency-Key was already used for a different request")
+			case stored.StatusCode == 0:
+				utils.RespondWithError(w, http.StatusConflict, "A request with this Idempotency-Key is still being processed")
+			default:
+				replay(w, stored)
+			}
+			return
+		}
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			utils.RespondWithError(w, http.StatusInternalServerError, "Failed to look up Idempotency-Key")
+			return
+		}
+
+		// claiming the key first, a retry racing this request fails on the unique index
+		claim := models.IdempotencyKey{
+			UserID:      &user.ID,
+			Key:         key,
+			Method:      r.Method,
+			Path:        r.URL.Path,
+			Fingerprint: fingerprint,
+			ExpiresAt:   now.Add(TTL()),
+		}
+		if err := db.Create(&claim).Error; err != nil {
+			if errors.Is(err, gorm.ErrDuplicatedKey) {
+				utils.RespondWithError(w, http.StatusConflict, "A request with this Idempotency-Key is still being processed")
+			} else {
+				utils.RespondWithError(w, http.StatusInternalServerError, "Failed to claim Idempotency-Key")
+			}
+			return
+		}
+
+		ctx, commits := database.WithCommits(r.Context())
+		recorder := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+		returned := false
+		defer func() {
+			if returned {
+				return
+			}
+			// the handler panicked, a retry may only run it again when nothing was saved
+			if commits.Committed() {
+				store(db, claim, http.StatusInternalServerError, "application/json", `{"error":"The request failed after saving its changes"}`+"\n")
+			} else {
+				db.Delete(&claim)
+			}
+		}()
+		next.ServeHTTP(recorder, r.WithContext(ctx))
+		returned = true
+
+		if recorder.status >= http.StatusInternalServerError && !commits.Committed() {
+			db.Delete(&claim)
+			return
+		}
+		// when the response can't be stored after a commit the claim stays, retries get 409
+		// until it expires rather than running the request twice
+		if store(db, claim, recorder.status, recorder.Header().Get("Content-Type"), recorder.body.String()) != nil && !commits.Committed() {
+			db.Delete(&claim)
+		}
+	})
+}
+
+// store keeps the response to the claimed key for replaying
+func store(db *gorm.DB, claim models.IdempotencyKey, status int, contentType string, body string) error {
+	return db.Model(&claim).Updates(map[string]interface{}{
+		"status_code":  status,
+		"content_type": contentType,
+		"body":         body,
+	}).Error
+}
+
+// requestFingerprint tells requests apart, retries have to match the first request exactly
+func requestFingerprint(r *http.Request, body []byte) string {
+	hash := sha256.New()
+	hash.Write([]byte(r.Method + " " + r.URL.RequestURI() + "\n"))
+	hash.Write(body)
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// replay writes the stored response, Idempotent-Replayed tells the client it is a copy
+func replay(w http.ResponseWriter, stored models.IdempotencyKey) {
+	if stored.ContentType != "" {
+		w.Header().Set("Content-Type", stored.ContentType)
+	}
+	w.Header().Set("Idempotent-Replayed", "true")
+	w.WriteHeader(stored.StatusCode)
+	io.WriteString(w, stored.Body)
+}
+
+// responseRecorder passes the response through to the client and keeps a copy of it
+type responseRecorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+	body        bytes.Buffer
+}
+
+func (r *responseRecorder) WriteHeader(status int) {
+	if !r.wroteHeader {
+		r.status = status
+		r.wroteHeader = true
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	r.wroteHeader = true
+	r.body.Write(b)
+	return r.ResponseWriter.Write(b)
+}
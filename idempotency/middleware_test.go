@@ -0,0 +1,247 @@
+package idempotency_test
+
+import (
+	"encoding/json"
+	"inventory-control-hub/apitest"
+	"inventory-control-hub/auth"
+	"inventory-control-hub/database"
+	"inventory-control-hub/idempotency"
+	"inventory-control-hub/models"
+	"inventory-control-hub/utils"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// addProduct is a handler creating the product named in the body, runs counts its calls
+type addProduct struct {
+	runs    atomic.Int32
+	started chan struct{} // closed when the first call is running, when set
+	release chan struct{} // the first call waits for it, when set
+	fail    func(w http.ResponseWriter, r *http.Request) bool
+}
+
+func (h *addProduct) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if h.runs.Add(1) == 1 && h.started != nil {
+		close(h.started)
+		<-h.release
+	}
+	if h.fail != nil && h.fail(w, r) {
+		return
+	}
+	var product models.Product
+	json.NewDecoder(r.Body).Decode(&product)
+	if database.For(r).Create(&product).Error != nil {
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to add product")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	utils.RespondWithJSON(w, http.StatusCreated, product)
+}
+
+func setUp(t *testing.T, handler http.Handler) (apitest.Tenant, http.Handler) {
+	apitest.Open(t)
+	return apitest.AddTenant(t, "acme"), auth.Middleware(idempotency.Middleware(handler))
+}
+
+func post(tenant apitest.Tenant, handler http.Handler, key string, body string) *httptest.ResponseRecorder {
+	r := tenant.Request("POST", "/add-product", body)
+	r.Header.Set(idempotency.Header, key)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	return w
+}
+
+func products(t *testing.T, tenant apitest.Tenant) int64 {
+	var count int64
+	if err := tenant.DB().Model(&models.Product{}).Count(&count).Error; err != nil {
+		t.Fatal(err)
+	}
+	return count
+}
+
+func TestReplay(t *testing.T) {
+	handler := &addProduct{}
+	tenant, server := setUp(t, handler)
+
+	first := post(tenant, server, "order-1", `{"Name":"Desk"}`)
+	if first.Code != http.StatusCreated {
+		t.Fatalf("first request answered %d: %s", first.Code, first.Body)
+	}
+	retry := post(tenant, server, "order-1", `{"Name":"Desk"}`)
+	if retry.Code != http.StatusCreated || retry.Body.String() != first.Body.String() {
+		t.Errorf("retry answered %d %s, want the first response %s", retry.Code, retry.Body, first.Body)
+	}
+	if retry.Header().Get("Idempotent-Replayed") != "true" || retry.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("retry headers = %v", retry.Header())
+	}
+	if first.Header().Get("Idempotent-Replayed") != "" {
+		t.Error("the first response is marked as replayed")
+	}
+
+	other := post(tenant, server, "order-2", `{"Name":"Desk"}`)
+	if other.Code != http.StatusCreated || other.Header().Get("Idempotent-Replayed") != "" {
+		t.Errorf("another key answered %d, replayed %q", other.Code, other.Header().Get("Idempotent-Replayed"))
+	}
+	if runs, count := handler.runs.Load(), products(t, tenant); runs != 2 || count != 2 {
+		t.Errorf("handler ran %d times creating %d products, want 2 and 2", runs, count)
+	}
+}
+
+func TestKeyReusedForAnotherRequest(t *testing.T) {
+	handler := &addProduct{}
+	tenant, server := setUp(t, handler)
+
+	post(tenant, server, "order-1", `{"Name":"Desk"}`)
+	w := post(tenant, server, "order-1", `{"Name":"Chair"}`)
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("changed body answered %d, want 422", w.Code)
+	}
+	if runs := handler.runs.Load(); runs != 1 {
+		t.Errorf("handler ran %d times, want 1", runs)
+	}
+
+	// keys belong to a user, another tenant's admin can use the same one
+	other := apitest.AddTenant(t, "globex")
+	if w := post(other, server, "order-1", `{"Name":"Chair"}`); w.Code != http.StatusCreated || w.Header().Get("Idempotent-Replayed") != "" {
+		t.Errorf("another user's request answered %d, replayed %q", w.Code, w.Header().Get("Idempotent-Replayed"))
+	}
+}
+
+func TestRetryWhileRunning(t *testing.T) {
+	handler := &addProduct{started: make(chan struct{}), release: make(chan struct{})}
+	tenant, server := setUp(t, handler)
+
+	done := make(chan *httptest.ResponseRecorder)
+	go func() { done <- post(tenant, server, "order-1", `{"Name":"Desk"}`) }()
+	<-handler.started
+
+	if w := post(tenant, server, "order-1", `{"Name":"Desk"}`); w.Code != http.StatusConflict {
+		t.Errorf("retry while running answered %d, want 409", w.Code)
+	}
+	close(handler.release)
+	if w := <-done; w.Code != http.StatusCreated {
+		t.Errorf("first request answered %d", w.Code)
+	}
+	if w := post(tenant, server, "order-1", `{"Name":"Desk"}`); w.Code != http.StatusCreated || w.Header().Get("Idempotent-Replayed") != "true" {
+		t.Errorf("retry after it finished answered %d, replayed %q", w.Code, w.Header().Get("Idempotent-Replayed"))
+	}
+}
+
+func TestConcurrentDuplicates(t *testing.T) {
+	handler := &addProduct{}
+	tenant, server := setUp(t, handler)
+
+	const requests = 8
+	var wg sync.WaitGroup
+	codes := make(chan int, requests)
+	for i := 0; i < requests; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w := post(tenant, server, "order-1", `{"Name":"Desk"}`)
+			if w.Header().Get("Idempotent-Replayed") == "true" {
+				codes <- 0
+				return
+			}
+			codes <- w.Code
+		}()
+	}
+	wg.Wait()
+	close(codes)
+
+	created := 0
+	for code := range codes {
+		switch code {
+		case http.StatusCreated:
+			created++
+		case 0, http.StatusConflict:
+		default:
+			t.Errorf("a duplicate answered %d, want 409 or the replayed response", code)
+		}
+	}
+	if created != 1 {
+		t.Errorf("%d requests ran, want 1", created)
+	}
+	if runs, count := handler.runs.Load(), products(t, tenant); runs != 1 || count != 1 {
+		t.Errorf("handler ran %d times creating %d products, want 1 and 1", runs, count)
+	}
+}
+
+func TestFailedRequests(t *testing.T) {
+	tests := []struct {
+		name string
+		fail func(w http.ResponseWriter, r *http.Request) bool
+		// whether the retry runs the handler again instead of replaying the first answer
+		rerun bool
+	}{
+		{"server error before saving", func(w http.ResponseWriter, r *http.Request) bool {
+			utils.RespondWithError(w, http.StatusInternalServerError, "Failed to check stock")
+			return true
+		}, true},
+		{"rolled back", func(w http.ResponseWriter, r *http.Request) bool {
+			tx := database.For(r).Begin()
+			tx.Create(&models.Product{Name: "Rolled back"})
+			tx.Rollback()
+			utils.RespondWithError(w, http.StatusInternalServerError, "Failed to update stock")
+			return true
+		}, true},
+		{"panic before saving", func(w http.ResponseWriter, r *http.Request) bool {
+			panic("broken handler")
+		}, true},
+		{"server error after commit", func(w http.ResponseWriter, r *http.Request) bool {
+			tx := database.For(r).Begin()
+			tx.Create(&models.Product{Name: "Saved"})
+			tx.Commit()
+			utils.RespondWithError(w, http.StatusInternalServerError, "Failed to fetch the product")
+			return true
+		}, false},
+		{"server error after a statement outside a transaction", func(w http.ResponseWriter, r *http.Request) bool {
+			database.For(r).Create(&models.Product{Name: "Saved"})
+			utils.RespondWithError(w, http.StatusInternalServerError, "Failed to fetch the product")
+			return true
+		}, false},
+		{"panic after commit", func(w http.ResponseWriter, r *http.Request) bool {
+			database.For(r).Create(&models.Product{Name: "Saved"})
+			panic("broken handler")
+		}, false},
+		{"client error", func(w http.ResponseWriter, r *http.Request) bool {
+			utils.RespondWithError(w, http.StatusBadRequest, "Invalid input")
+			return true
+		}, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			handler := &addProduct{fail: test.fail}
+			tenant, server := setUp(t, handler)
+
+			first := send(tenant, server)
+			handler.fail = nil
+			retry := send(tenant, server)
+
+			runs := handler.runs.Load()
+			if test.rerun && (runs != 2 || retry.Code != http.StatusCreated) {
+				t.Errorf("retry answered %d after %d runs, want the request run again", retry.Code, runs)
+			}
+			if !test.rerun {
+				if runs != 1 || retry.Code != first.Code || retry.Header().Get("Idempotent-Replayed") != "true" {
+					t.Errorf("retry answered %d after %d runs, want the first answer %d replayed", retry.Code, runs, first.Code)
+				}
+			}
+		})
+	}
+}
+
+// send posts a product with the key order-1, a panic in the handler answers 500 like net/http
+// does by closing the connection
+func send(tenant apitest.Tenant, server http.Handler) (w *httptest.ResponseRecorder) {
+	defer func() {
+		if recover() != nil {
+			w = httptest.NewRecorder()
+			w.Code = http.StatusInternalServerError
+		}
+	}()
+	return post(tenant, server, "order-1", `{"Name":"Desk"}`)
+}
@@ -1,8 +1,13 @@
 package main
 
 import (
+	"inventory-control-hub/audit"
+	"inventory-control-hub/auth"
+	"inventory-control-hub/carriers"
+	"inventory-control-hub/controllers"
 	"inventory-control-hub/database"
 	"inventory-control-hub/routes"
+	"inventory-control-hub/utils"
 
 	"log"
 	"net/http"
@@ -22,8 +27,34 @@ func main() {
 	if port == "" {
 		port = "8080" // default port
 	}
+	// the local carrier prices and labels shipments without an external account
+	if os.Getenv("LOCAL_CARRIER") == "true" {
+		carriers.Register(carriers.Local{})
+	}
+
+	if err := auth.CheckSecret(); err != nil {
+		log.Fatal(err.Error() + ", e.g. the output of openssl rand -hex 32")
+	}
+	if err := utils.CheckRules(controllers.Requests...); err != nil {
+		log.Fatal("Broken request rules: " + err.Error())
+	}
+
 	database.Connect()
+	if err := database.TrackCommits(database.DB); err != nil {
+		log.Fatal("Failed to track commits: " + err.Error())
+	}
+	if err := database.ScopeTenants(database.DB); err != nil {
+		log.Fatal("Failed to register tenant scoping: " + err.Error())
+	}
+	if err := database.VersionRows(database.DB); err != nil {
+		log.Fatal("Failed to register row versioning: " + err.Error())
+	}
+	if err := audit.Register(database.DB); err != nil {
+		log.Fatal("Failed to register audit callbacks: " + err.Error())
+	}
 	database.Migrate()
+	auth.SeedRoles()
+	auth.SeedAdmin()
 	log.Println("Server running at http://localhost:" + port)
 	
 
@@ -0,0 +1,22 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// AuditLog records one row created, updated or deleted, by whom and from which request.
+// Changes maps each changed field to its Old and New value, e.g. {"Price":{"Old":5,"New":6}}.
+type AuditLog struct {
+	ID        uint            `gorm:"primaryKey"`
+	TenantID  *uint           `gorm:"not null;index" json:"-"`
+	UserID    *uint           `gorm:"index"` // nil for changes made by the system, e.g. on start
+	Actor     string          `gorm:"size:255"`
+	Entity    string          `gorm:"not null;size:100;index:idx_audit_entity"` // table name, e.g. products
+	EntityID  string          `gorm:"not null;size:100;index:idx_audit_entity"`
+	Action    string          `gorm:"not null;size:10"` // create, update or delete
+	Changes   json.RawMessage `gorm:"type:text"`
+	RequestID string          `gorm:"size:64;index"`
+	ClientIP  string          `gorm:"size:64"`
+	CreatedAt time.Time       `gorm:"index"`
+}
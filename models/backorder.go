@@ -0,0 +1,30 @@
+package models
+
+import "time"
+
+// BackorderAllocation records stock that arrived being reserved for a backordered sales order.
+// The reference is what brought the stock in, e.g. a purchase receipt or a customer return.
+type BackorderAllocation struct {
+	ID            uint        `gorm:"primaryKey"`
+	TenantID      *uint       `gorm:"not null;index" json:"-"`
+	SalesOrderID  *uint       `gorm:"not null;index"`
+	SalesOrder    *SalesOrder `gorm:"foreignKey:SalesOrderID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
+	ProductID     *uint       `gorm:"not null;index"`
+	Quantity      int
+	Remaining     int    // still backordered on the order after this allocation
+	ReferenceType string `gorm:"size:50;index:idx_backorder_allocation_reference"`
+	ReferenceID   uint   `gorm:"index:idx_backorder_allocation_reference"`
+	CreatedAt     time.Time
+}
+
+// Notification is a message for the people following an order, e.g. stock was allocated to a backorder
+type Notification struct {
+	ID           uint   `gorm:"primaryKey"`
+	TenantID     *uint  `gorm:"not null;index" json:"-"`
+	CustomerID   *uint  `gorm:"index"`
+	SalesOrderID *uint  `gorm:"index"`
+	Event        string `gorm:"size:50;index"` // e.g. backorder_allocated
+	Message      string `gorm:"size:500"`
+	CreatedAt    time.Time
+	ReadAt       *time.Time
+}
@@ -0,0 +1,47 @@
+package models
+
+import "time"
+
+// BillOfMaterials lists the components a kit product is built from. A virtual kit isn't built ahead,
+// selling it assembles the missing kits from component stock on the spot.
+type BillOfMaterials struct {
+	ID         uint           `gorm:"primaryKey"`
+	TenantID   *uint          `gorm:"not null;index" json:"-"`
+	ProductID  *uint          `gorm:"not null;uniqueIndex"`
+	Product    *Product       `gorm:"foreignKey:ProductID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
+	Virtual    bool           `gorm:"column:is_virtual"` // VIRTUAL is reserved in MySQL
+	Components []BOMComponent `gorm:"foreignKey:BillOfMaterialsID;constraint:OnDelete:CASCADE;"`
+}
+
+// BOMComponent is one component of a kit and how many go into a single kit
+type BOMComponent struct {
+	ID                uint     `gorm:"primaryKey"`
+	TenantID          *uint    `gorm:"not null;index" json:"-"`
+	BillOfMaterialsID *uint    `gorm:"not null;index"`
+	ComponentID       *uint    `gorm:"not null;index"`
+	Component         *Product `gorm:"foreignKey:ComponentID;constraint:OnUpdate:CASCADE,OnDelete:RESTRICT;"`
+	Quantity          int
+}
+
+// AssemblyOrder converts components into kits (Type assembly) or kits back into their
+// components (Type disassembly). Lines are the component units consumed or recovered.
+type AssemblyOrder struct {
+	ID           uint   `gorm:"primaryKey"`
+	TenantID     *uint  `gorm:"not null;uniqueIndex:idx_assembly_order_tenant_number" json:"-"`
+	Number       string `gorm:"not null;uniqueIndex:idx_assembly_order_tenant_number;size:30"`
+	Type         string `gorm:"not null;size:20;index"`
+	ProductID    *uint  `gorm:"not null;index"`
+	Quantity     int
+	SalesOrderID *uint `gorm:"index"` // set when a virtual kit sale built the kits or gave them up
+	Note         string
+	CreatedAt    time.Time
+	Lines        []AssemblyLine `gorm:"foreignKey:AssemblyOrderID;constraint:OnDelete:CASCADE;"`
+}
+
+type AssemblyLine struct {
+	ID              uint  `gorm:"primaryKey"`
+	TenantID        *uint `gorm:"not null;index" json:"-"`
+	AssemblyOrderID *uint `gorm:"not null;index"`
+	ComponentID     *uint `gorm:"not null;index"`
+	Quantity        int
+}
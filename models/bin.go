@@ -0,0 +1,43 @@
+package models
+
+import "time"
+
+// Bin is a shelf location in the warehouse. Pickers walk the bins in PickSequence order,
+// then by zone, aisle, rack and shelf.
+type Bin struct {
+	ID           uint   `gorm:"primaryKey"`
+	TenantID     *uint  `gorm:"not null;uniqueIndex:idx_bin_tenant_code" json:"-"`
+	Code         string `gorm:"not null;uniqueIndex:idx_bin_tenant_code;size:64"` // e.g. A-03-02-1, built from the parts when left empty
+	Zone         string `gorm:"not null;size:16"`
+	Aisle        string `gorm:"size:16"`
+	Rack         string `gorm:"size:16"`
+	Shelf        string `gorm:"size:16"`
+	PickSequence int    // position on the picking path, bins with the same value are walked by code parts
+	Stock        []BinStock
+}
+
+// BinStock is how much of a product sits in a bin. Together the bins hold at most
+// Product.Quantity, the rest is unlocated (received but not put away yet).
+type BinStock struct {
+	ID        uint     `gorm:"primaryKey"`
+	TenantID  *uint    `gorm:"not null;index" json:"-"`
+	BinID     *uint    `gorm:"not null;uniqueIndex:idx_bin_stock_bin_product"`
+	Bin       *Bin     `gorm:"foreignKey:BinID;constraint:OnUpdate:CASCADE,OnDelete:RESTRICT;"`
+	ProductID *uint    `gorm:"not null;uniqueIndex:idx_bin_stock_bin_product;index"`
+	Product   *Product `gorm:"foreignKey:ProductID;constraint:OnUpdate:CASCADE,OnDelete:RESTRICT;"`
+	Quantity  int
+}
+
+// BinMovement records stock moving into, out of or between bins. An empty FromBinID means the
+// units came from unlocated stock, an empty ToBinID that they left the bins (sold, returned, ...).
+type BinMovement struct {
+	ID            uint  `gorm:"primaryKey"`
+	TenantID      *uint `gorm:"not null;index" json:"-"`
+	ProductID     *uint `gorm:"not null;index"`
+	FromBinID     *uint `gorm:"index"`
+	ToBinID       *uint `gorm:"index"`
+	Quantity      int
+	ReferenceType string `gorm:"size:50;index:idx_bin_movement_reference"`
+	ReferenceID   uint   `gorm:"index:idx_bin_movement_reference"`
+	CreatedAt     time.Time
+}
@@ -0,0 +1,13 @@
+package models
+
+// Customer is who a sales order is sold to. Customers assigned to a price list
+// get that list's prices instead of Product.Price.
+type Customer struct {
+	ID          uint   `gorm:"primaryKey"`
+	TenantID    *uint  `gorm:"not null;index" json:"-"`
+	Name        string `gorm:"not null"`
+	Email       string
+	Phone       string
+	PriceListID *uint
+	PriceList   *PriceList `gorm:"foreignKey:PriceListID;constraint:OnUpdate:CASCADE,OnDelete:SET NULL;"`
+}
@@ -0,0 +1,9 @@
+package models
+
+// DocumentSequence hands out the next number for a document type (e.g. "invoice").
+// The row is locked while a document is created so numbers are never skipped or reused.
+type DocumentSequence struct {
+	TenantID   uint   `gorm:"primaryKey;autoIncrement:false" json:"-"`
+	Name       string `gorm:"primaryKey;size:50"`
+	NextNumber uint
+}
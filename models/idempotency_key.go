@@ -0,0 +1,21 @@
+package models
+
+import "time"
+
+// IdempotencyKey remembers the response to a POST sent with an Idempotency-Key header, so a
+// client retrying the request gets the same response instead of creating the order twice.
+// Fingerprint is the SHA-256 of the method, path and body of the first request.
+type IdempotencyKey struct {
+	ID          uint      `gorm:"primaryKey"`
+	TenantID    *uint     `gorm:"not null;uniqueIndex:idx_idempotency_key" json:"-"`
+	UserID      *uint     `gorm:"not null;uniqueIndex:idx_idempotency_key"`
+	Key         string    `gorm:"not null;size:255;uniqueIndex:idx_idempotency_key"`
+	Method      string    `gorm:"size:10"`
+	Path        string    `gorm:"size:255"`
+	Fingerprint string    `gorm:"not null;size:64"`
+	StatusCode  int       // 0 while the first request is still running
+	ContentType string    `gorm:"size:100"`
+	Body        string    `gorm:"type:mediumtext"`
+	ExpiresAt   time.Time `gorm:"index"`
+	CreatedAt   time.Time
+}
@@ -0,0 +1,38 @@
+package models
+
+import "time"
+
+// Invoice is generated once from a sales order. Number comes from the "invoice"
+// document sequence so numbers are sequential without gaps.
+type Invoice struct {
+	ID             uint   `gorm:"primaryKey"`
+	TenantID       *uint  `gorm:"not null;uniqueIndex:idx_invoice_tenant_number" json:"-"`
+	Number         string `gorm:"not null;uniqueIndex:idx_invoice_tenant_number;size:30"`
+	SalesOrderID   *uint  `gorm:"not null;uniqueIndex"`
+	CustomerID     *uint
+	Customer       *Customer `gorm:"foreignKey:CustomerID;constraint:OnUpdate:CASCADE,OnDelete:RESTRICT;"`
+	IssueDate      time.Time
+	DueDate        time.Time
+	NetAmount      float64
+	DiscountAmount float64
+	TaxAmount      float64
+	GrossAmount    float64
+	AmountPaid     float64
+	Status         string        `gorm:"size:20;default:'open'"` // open, partially_paid, paid or overdue
+	Lines          []InvoiceLine `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
+}
+
+type InvoiceLine struct {
+	ID             uint  `gorm:"primaryKey"`
+	TenantID       *uint `gorm:"not null;index" json:"-"`
+	InvoiceID      *uint `gorm:"not null;index"`
+	ProductID      *uint
+	Description    string
+	Quantity       int
+	UnitPrice      float64
+	DiscountAmount float64
+	NetAmount      float64
+	TaxRate        float64
+	TaxAmount      float64
+	GrossAmount    float64
+}
@@ -0,0 +1,29 @@
+package models
+
+import "time"
+
+// Lot is a batch of a lot-tracked product, created when the goods are received.
+// Quantity is what is still on hand from the lot.
+type Lot struct {
+	ID               uint    `gorm:"primaryKey"`
+	TenantID         *uint   `gorm:"not null;index" json:"-"`
+	ProductID        *uint   `gorm:"not null;uniqueIndex:idx_lot_product_number"`
+	Product          Product `gorm:"foreignKey:ProductID;constraint:OnUpdate:CASCADE,OnDelete:RESTRICT;"`
+	LotNumber        string  `gorm:"not null;size:100;uniqueIndex:idx_lot_product_number"`
+	ManufactureDate  *time.Time
+	ExpiryDate       *time.Time `gorm:"index"`
+	ReceivedQuantity int
+	Quantity         int
+	CreatedAt        time.Time
+}
+
+// LotAllocation records which lot the units of a sales order were taken from
+type LotAllocation struct {
+	ID               uint  `gorm:"primaryKey"`
+	TenantID         *uint `gorm:"not null;index" json:"-"`
+	SalesOrderID     *uint `gorm:"not null;index"`
+	LotID            *uint `gorm:"not null;index"`
+	Lot              *Lot  `gorm:"foreignKey:LotID;constraint:OnUpdate:CASCADE,OnDelete:RESTRICT;"`
+	Quantity         int
+	ReturnedQuantity int // restocked back into the lot by customer returns
+}
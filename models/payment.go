@@ -0,0 +1,28 @@
+package models
+
+import "time"
+
+// Payment is money received from a customer. It can be split across several invoices
+// through Allocations, whatever isn't allocated yet stays in UnallocatedAmount.
+type Payment struct {
+	ID                uint  `gorm:"primaryKey"`
+	TenantID          *uint `gorm:"not null;index" json:"-"`
+	CustomerID        *uint
+	Customer          *Customer `gorm:"foreignKey:CustomerID;constraint:OnUpdate:CASCADE,OnDelete:RESTRICT;"`
+	Amount            float64
+	UnallocatedAmount float64
+	Method            string // cash, card, bank_transfer, cheque, other
+	Reference         string
+	PaymentDate       time.Time
+	Allocations       []PaymentAllocation `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
+}
+
+// PaymentAllocation is the part of a payment applied to one invoice
+type PaymentAllocation struct {
+	ID        uint     `gorm:"primaryKey"`
+	TenantID  *uint    `gorm:"not null;index" json:"-"`
+	PaymentID *uint    `gorm:"not null;index"`
+	InvoiceID *uint    `gorm:"not null;index"`
+	Invoice   *Invoice `gorm:"foreignKey:InvoiceID;constraint:OnUpdate:CASCADE,OnDelete:RESTRICT;"`
+	Amount    float64
+}
@@ -0,0 +1,31 @@
+package models
+
+import "time"
+
+// PickTask sends a picker round the bins for a sales order. Confirming it books what was
+// actually found, units missing from a bin are recorded as short.
+type PickTask struct {
+	ID             uint   `gorm:"primaryKey"`
+	TenantID       *uint  `gorm:"not null;uniqueIndex:idx_pick_task_tenant_number" json:"-"`
+	Number         string `gorm:"not null;uniqueIndex:idx_pick_task_tenant_number;size:30"`
+	SalesOrderID   *uint  `gorm:"not null;index"`
+	Status         string `gorm:"size:20"` // open, picked or short
+	Quantity       int
+	PickedQuantity int
+	ShortQuantity  int
+	CreatedAt      time.Time
+	ConfirmedAt    *time.Time
+	Lines          []PickTaskLine `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
+}
+
+// PickTaskLine is one stop on the picking path, an empty BinID means the receiving area
+type PickTaskLine struct {
+	ID             uint  `gorm:"primaryKey"`
+	TenantID       *uint `gorm:"not null;index" json:"-"`
+	PickTaskID     *uint `gorm:"not null;index"`
+	BinID          *uint
+	BinCode        string
+	Quantity       int
+	PickedQuantity int
+	ShortQuantity  int
+}
@@ -0,0 +1,26 @@
+package models
+
+import "time"
+
+// PriceList is a named set of prices (e.g. retail, wholesale, distributor)
+type PriceList struct {
+	ID          uint   `gorm:"primaryKey"`
+	TenantID    *uint  `gorm:"not null;uniqueIndex:idx_price_list_tenant_name" json:"-"`
+	Name        string `gorm:"not null;uniqueIndex:idx_price_list_tenant_name;size:100"`
+	Description string
+	Items       []PriceListItem `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
+}
+
+// PriceListItem is the price of a product on a list from MinQuantity units upwards.
+// Several items for the same product give quantity breaks, ValidFrom/ValidTo bound promotional prices.
+type PriceListItem struct {
+	ID          uint    `gorm:"primaryKey"`
+	TenantID    *uint   `gorm:"not null;index" json:"-"`
+	PriceListID *uint   `gorm:"not null;index"`
+	ProductID   *uint   `gorm:"not null;index"`
+	Product     Product `gorm:"foreignKey:ProductID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
+	MinQuantity int
+	Price       float64
+	ValidFrom   *time.Time
+	ValidTo     *time.Time
+}
@@ -1,11 +1,25 @@
 package models
 
+import "gorm.io/gorm"
+
 type Product struct {
-	ID            uint   `gorm:"primaryKey"`
-	Name          string `gorm:"not null"`
-	Description   string
-	Price         float64
-	Quantity      int
+	ID                 uint   `gorm:"primaryKey"`
+	TenantID           *uint  `gorm:"not null;index" json:"-"`
+	Name               string `gorm:"not null"`
+	SKU                string `gorm:"size:64;index"` // optional, unique when set
+	Description        string
+	Price              float64
+	Quantity           int // on hand, including units reserved for sales orders that haven't shipped
+	TaxClassID         *uint
+	TaxClass           *TaxClass      `gorm:"foreignKey:TaxClassID;constraint:OnUpdate:CASCADE,OnDelete:SET NULL;"`
+	PriceIncludesTax   bool           // true when Price already contains tax (retail style pricing)
+	QuarantineQuantity int            // returned units held back for inspection, not sellable
+	ReservedQuantity   int            // promised to sales orders, leaves stock when shipped
+	Archived           bool           // archived products can't be sold or purchased but stay on old orders
+	LotTracked         bool           // stock is kept in lots with expiry dates, see Lot
+	Serialized         bool           // every unit has a serial number, see SerialNumber
+	Version            uint           `gorm:"not null;default:1;<-:create"` // bumped on every change, the ETag of the product
+	DeletedAt          gorm.DeletedAt `gorm:"index"`
 }
 
 // In Go, if a field starts with a lowercase letter (like id, name, etc.), it's unexported and invisible to GORM, JSON, or any other reflection-based tools.
@@ -0,0 +1,20 @@
+package models
+
+import "time"
+
+// Promotion is a code customers can apply to a sales order for an order-level discount.
+// UsageLimit 0 means unlimited, TimesUsed is only ever changed with a single UPDATE so
+// concurrent orders can't redeem a code past its limit.
+type Promotion struct {
+	ID            uint   `gorm:"primaryKey"`
+	TenantID      *uint  `gorm:"not null;uniqueIndex:idx_promotion_tenant_code" json:"-"`
+	Code          string `gorm:"not null;uniqueIndex:idx_promotion_tenant_code;size:50"`
+	Description   string
+	DiscountType  string // "percent" or "fixed"
+	DiscountValue float64
+	ValidFrom     *time.Time
+	ValidTo       *time.Time
+	UsageLimit    int
+	TimesUsed     int
+	MinOrderValue float64
+}
@@ -1,12 +1,44 @@
 package models
 
-import "time"
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
 
 type PurchaseOrder struct {
-	ID        uint    `gorm:"primaryKey"`
-	ProductID *uint    
+	ID        uint  `gorm:"primaryKey"`
+	TenantID  *uint `gorm:"not null;index" json:"-"`
+	ProductID *uint
 	Product   Product `gorm:"foreignKey:ProductID;constraint:OnUpdate:CASCADE,OnDelete:SET NULL;"`
 	Quantity  int
 	Supplier  string
 	OrderDate time.Time
+
+	// product details as they were when the order was placed, Product is the live record
+	ProductName string
+	ProductSKU  string
+
+	UnitCost        float64
+	CostIncludesTax bool // true when the supplier quoted UnitCost including tax
+
+	// DeferReceipt false (the default) receives the full quantity when the order is placed,
+	// true leaves stock untouched until goods are booked in with /purchase-order/{id}/receive
+	DeferReceipt     bool
+	ReceivedQuantity int
+	Status           string            `gorm:"size:20"` // open, partially_received or received
+	ReturnedQuantity int               // sent back to the supplier with supplier returns
+	Receipts         []PurchaseReceipt `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
+
+	// tax breakdown, computed on create/update
+	Jurisdiction string
+	TaxRate      float64
+	NetAmount    float64
+	TaxAmount    float64
+	GrossAmount  float64
+
+	// bumped on every change, the ETag of the order
+	Version uint `gorm:"not null;default:1;<-:create"`
+
+	DeletedAt gorm.DeletedAt `gorm:"index"`
 }
@@ -0,0 +1,27 @@
+package models
+
+import "time"
+
+// PurchaseReceipt records goods arriving against a purchase order, it is the proof
+// of delivery supplier bills are matched against
+type PurchaseReceipt struct {
+	ID              uint  `gorm:"primaryKey"`
+	TenantID        *uint `gorm:"not null;index" json:"-"`
+	PurchaseOrderID *uint `gorm:"not null;index"`
+	Quantity        int   // negative for corrections made through UpdatePurchaseOrder
+	ReceivedDate    time.Time
+	Note            string
+
+	// lot the goods were booked into, for lot-tracked products
+	LotID           *uint
+	LotNumber       string
+	ManufactureDate *time.Time
+	ExpiryDate      *time.Time
+
+	// bin the goods were put away into, or where putaway should go when they were left unlocated
+	BinID          *uint
+	SuggestedBinID *uint
+
+	// units received, for serialized products
+	SerialNumbers []SerialNumber `gorm:"foreignKey:PurchaseReceiptID;constraint:OnDelete:SET NULL;"`
+}
@@ -0,0 +1,36 @@
+package models
+
+import "time"
+
+// Return is a customer return (RMA) against a sales order. Each line says how many units came
+// back and what happens to them: restock (back to sellable stock), quarantine or scrap.
+type Return struct {
+	ID               uint       `gorm:"primaryKey"`
+	TenantID         *uint      `gorm:"not null;uniqueIndex:idx_return_tenant_number" json:"-"`
+	Number           string     `gorm:"size:20;uniqueIndex:idx_return_tenant_number"` // RMA-000001, gap-free
+	SalesOrderID     *uint      `gorm:"not null;index"`
+	SalesOrder       SalesOrder `gorm:"foreignKey:SalesOrderID;constraint:OnUpdate:CASCADE,OnDelete:RESTRICT;"`
+	CustomerID       *uint
+	Reason           string
+	ReturnDate       time.Time
+	RefundType       string // "refund" (money back) or "credit_note"
+	RefundAmount     float64
+	CreditNoteAmount float64
+	Lines            []ReturnLine `gorm:"foreignKey:ReturnID;constraint:OnDelete:CASCADE;"`
+}
+
+type ReturnLine struct {
+	ID          uint  `gorm:"primaryKey"`
+	TenantID    *uint `gorm:"not null;index" json:"-"`
+	ReturnID    *uint `gorm:"not null;index"`
+	ProductID   *uint
+	Quantity    int
+	Disposition string   // "restock", "quarantine" or "scrap"
+	Amount      float64  // gross amount refunded or credited for this line
+	Serials     []string `gorm:"serializer:json"` // units coming back, for serialized products
+}
+
+// RETURN is a reserved word in SQL, keep the table name clear of it
+func (Return) TableName() string {
+	return "customer_returns"
+}
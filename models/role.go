@@ -0,0 +1,18 @@
+package models
+
+// Role bundles permissions, e.g. products:write. Users get the permissions of all their roles,
+// the * permission grants everything.
+type Role struct {
+	ID          uint   `gorm:"primaryKey"`
+	TenantID    *uint  `gorm:"not null;uniqueIndex:idx_role_tenant_name" json:"-"`
+	Name        string `gorm:"not null;uniqueIndex:idx_role_tenant_name;size:50"`
+	Description string
+	Permissions []RolePermission `gorm:"foreignKey:RoleID;constraint:OnDelete:CASCADE;"`
+}
+
+type RolePermission struct {
+	ID         uint   `gorm:"primaryKey"`
+	TenantID   *uint  `gorm:"not null;index" json:"-"`
+	RoleID     *uint  `gorm:"not null;uniqueIndex:idx_role_permission"`
+	Permission string `gorm:"not null;size:50;uniqueIndex:idx_role_permission"`
+}
@@ -1,12 +1,68 @@
 package models
 
-import "time"
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
 
 type SalesOrder struct {
-	ID         uint `gorm:"primaryKey"`
+	ID         uint  `gorm:"primaryKey"`
+	TenantID   *uint `gorm:"not null;index" json:"-"`
 	ProductID  *uint
 	Product    Product `gorm:"foreignKey:ProductID;constraint:OnUpdate:CASCADE,OnDelete:SET NULL;"`
+	CustomerID *uint
+	Customer   *Customer `gorm:"foreignKey:CustomerID;constraint:OnUpdate:CASCADE,OnDelete:RESTRICT;"`
 	Quantity   int
-	TotalPrice float64
+	TotalPrice float64 // amount the customer pays, kept equal to GrossAmount, orders from before taxes had only this
 	OrderDate  time.Time
+
+	// product details as they were when the order was placed, Product is the live record
+	ProductName string
+	ProductSKU  string
+
+	// price the line was sold at and the price list it came from (nil means Product.Price)
+	UnitPrice   float64
+	PriceListID *uint
+
+	// discounts, type/value are taken from the request and the amounts are computed
+	LineDiscountType    string // "percent", "fixed" or empty for none
+	LineDiscountValue   float64
+	LineDiscountAmount  float64
+	OrderDiscountType   string // "percent", "fixed" or empty for none, applied after the line discount
+	OrderDiscountValue  float64
+	PromotionID         *uint
+	PromotionCode       string
+	OrderDiscountAmount float64 // order discount + promotion
+	DiscountAmount      float64 // line + order discount
+
+	// tax breakdown, computed on create/update
+	Jurisdiction string
+	TaxRate      float64
+	NetAmount    float64
+	TaxAmount    float64
+	GrossAmount  float64
+
+	// lots the units were taken from, for lot-tracked products
+	Lots []LotAllocation `gorm:"foreignKey:SalesOrderID;constraint:OnDelete:CASCADE;"`
+	// units sold, for serialized products
+	SerialNumbers []SerialNumber `gorm:"foreignKey:SalesOrderID;constraint:OnDelete:SET NULL;"`
+
+	// fulfilment progress, see salesOrderStatus for the statuses
+	Status          string `gorm:"size:20"`
+	PickedQuantity  int
+	ShortQuantity   int // missing at picking with no stock left to replace them, they won't ship
+	PackedQuantity  int
+	ShippedQuantity int
+
+	// backorders: with AllowBackorder the units stock can't cover wait in BackorderedQuantity and
+	// are reserved as stock arrives, orders with a higher Priority first, then the oldest
+	AllowBackorder      bool
+	BackorderedQuantity int
+	Priority            int
+
+	// bumped on every change, the ETag of the order
+	Version uint `gorm:"not null;default:1;<-:create"`
+
+	DeletedAt gorm.DeletedAt `gorm:"index"`
 }
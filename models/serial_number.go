@@ -0,0 +1,29 @@
+package models
+
+import "time"
+
+// SerialNumber is one unit of a serialized product. Status is where the unit is now,
+// the SerialEvents are how it got there.
+type SerialNumber struct {
+	ID                uint          `gorm:"primaryKey"`
+	TenantID          *uint         `gorm:"not null;uniqueIndex:idx_serial_number_tenant_serial" json:"-"`
+	Serial            string        `gorm:"not null;size:100;uniqueIndex:idx_serial_number_tenant_serial"`
+	ProductID         *uint         `gorm:"not null;index"`
+	Product           Product       `gorm:"foreignKey:ProductID;constraint:OnUpdate:CASCADE,OnDelete:RESTRICT;"`
+	Status            string        `gorm:"size:30;index"` // in_stock, sold, returned, scrapped or returned_to_supplier
+	PurchaseReceiptID *uint         `gorm:"index"`         // receipt the unit arrived on
+	SalesOrderID      *uint         `gorm:"index"`         // order the unit is sold on, nil while in stock
+	Events            []SerialEvent `gorm:"foreignKey:SerialNumberID;constraint:OnDelete:CASCADE;"`
+}
+
+// SerialEvent is one step in a serial number's history
+type SerialEvent struct {
+	ID             uint   `gorm:"primaryKey"`
+	TenantID       *uint  `gorm:"not null;index" json:"-"`
+	SerialNumberID *uint  `gorm:"not null;index"`
+	Event          string `gorm:"size:30"` // received, sold, sale_cancelled, returned, restocked, scrapped, returned_to_supplier
+	Status         string `gorm:"size:30"` // status after the event
+	ReferenceType  string `gorm:"size:50"`
+	ReferenceID    uint
+	CreatedAt      time.Time
+}
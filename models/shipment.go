@@ -0,0 +1,45 @@
+package models
+
+import "time"
+
+// Shipment is a set of parcels packed for a sales order from picked units. An order can ship in
+// several shipments on different days, stock leaves when a shipment ships.
+type Shipment struct {
+	ID           uint   `gorm:"primaryKey"`
+	TenantID     *uint  `gorm:"not null;uniqueIndex:idx_shipment_tenant_number" json:"-"`
+	Number       string `gorm:"not null;uniqueIndex:idx_shipment_tenant_number;size:30"`
+	SalesOrderID *uint  `gorm:"not null;index"`
+	Quantity     int
+	TotalWeight  float64
+	Status       string `gorm:"size:20"` // packed or shipped
+	Note         string
+	PackedAt     time.Time
+	Parcels      []Parcel `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
+
+	// filled in when the shipment leaves
+	Carrier        string `gorm:"size:50"`
+	ServiceLevel   string `gorm:"size:50"`
+	TrackingNumber string `gorm:"size:100;index"`
+	ShippingCost   float64
+	ShippedAt      *time.Time
+}
+
+// Parcel is one box of a shipment, weight in kg and dimensions in cm
+type Parcel struct {
+	ID         uint  `gorm:"primaryKey"`
+	TenantID   *uint `gorm:"not null;index" json:"-"`
+	ShipmentID *uint `gorm:"not null;index"`
+	Quantity   int
+	Weight     float64
+	Length     float64
+	Width      float64
+	Height     float64
+}
+
+// ShippingLabel is the label a carrier integration returned for a shipment, kept for reprinting
+type ShippingLabel struct {
+	ID         uint  `gorm:"primaryKey"`
+	TenantID   *uint `gorm:"not null;index" json:"-"`
+	ShipmentID *uint `gorm:"not null;uniqueIndex"`
+	Data       []byte
+}
@@ -0,0 +1,19 @@
+package models
+
+import "time"
+
+// StockMovement is one line of the stock ledger. Every change to Product.Quantity writes one on
+// the on_hand stock and every change to Product.QuarantineQuantity one on the quarantine stock,
+// so both can always be explained by summing Change for the product and stock.
+type StockMovement struct {
+	ID            uint   `gorm:"primaryKey"`
+	TenantID      *uint  `gorm:"not null;index" json:"-"`
+	ProductID     *uint  `gorm:"not null;index"`
+	Stock         string `gorm:"size:20;not null;default:on_hand"` // on_hand or quarantine
+	Change        int    // positive into stock, negative out of stock
+	QuantityAfter int
+	Reason        string `gorm:"size:50"` // e.g. sale, sale_cancelled, purchase_receipt, customer_return
+	ReferenceType string `gorm:"size:50;index:idx_stock_movement_reference"`
+	ReferenceID   uint   `gorm:"index:idx_stock_movement_reference"`
+	CreatedAt     time.Time
+}
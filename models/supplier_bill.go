@@ -0,0 +1,23 @@
+package models
+
+import "time"
+
+// SupplierBill is the invoice a supplier sent for a purchase order. On entry it is matched
+// against the ordered quantity/cost and the received quantity (three-way match),
+// mismatches outside the tolerances go to the exception queue until approved.
+type SupplierBill struct {
+	ID              uint          `gorm:"primaryKey"`
+	TenantID        *uint         `gorm:"not null;uniqueIndex:idx_supplier_bill_tenant_number" json:"-"`
+	PurchaseOrderID *uint         `gorm:"not null;index"`
+	PurchaseOrder   PurchaseOrder `gorm:"foreignKey:PurchaseOrderID;constraint:OnUpdate:CASCADE,OnDelete:RESTRICT;"`
+	Supplier        string        `gorm:"size:255;uniqueIndex:idx_supplier_bill_tenant_number"`
+	BillNumber      string        `gorm:"not null;size:100;uniqueIndex:idx_supplier_bill_tenant_number"`
+	BillDate        time.Time
+	Quantity        int
+	UnitCost        float64
+	TotalAmount     float64
+	MatchStatus     string `gorm:"size:20;index"` // matched, exception or approved
+	MatchNotes      string
+	ApprovedAt      *time.Time
+	ApprovalNote    string
+}
@@ -0,0 +1,27 @@
+package models
+
+import "time"
+
+// SupplierReturn sends received goods back to the supplier. It takes the units out of stock and
+// raises a debit note, the amount the supplier owes us until they credit or refund it.
+type SupplierReturn struct {
+	ID                uint          `gorm:"primaryKey"`
+	TenantID          *uint         `gorm:"not null;uniqueIndex:idx_supplier_return_tenant_number" json:"-"`
+	Number            string        `gorm:"size:20;uniqueIndex:idx_supplier_return_tenant_number"` // DN-000001, gap-free
+	PurchaseOrderID   *uint         `gorm:"not null;index"`
+	PurchaseOrder     PurchaseOrder `gorm:"foreignKey:PurchaseOrderID;constraint:OnUpdate:CASCADE,OnDelete:RESTRICT;"`
+	PurchaseReceiptID *uint         // optional, the delivery the goods came in on
+	Supplier          string        `gorm:"index"`
+	Reason            string
+	ReturnDate        time.Time
+	Quantity          int
+	UnitCost          float64
+	Serials           []string `gorm:"serializer:json"` // units sent back, for serialized products
+
+	// debit note, priced like the purchase order line including tax
+	NetAmount       float64
+	TaxAmount       float64
+	DebitNoteAmount float64
+	CreditReceived  float64 // credited or refunded by the supplier so far
+	CreditStatus    string  `gorm:"size:20;index"` // open, partially_credited or credited
+}
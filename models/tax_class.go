@@ -0,0 +1,10 @@
+package models
+
+// TaxClass groups products that are taxed the same way (e.g. "standard", "reduced", "exempt").
+// The actual percentage depends on the jurisdiction and lives in TaxRate.
+type TaxClass struct {
+	ID          uint   `gorm:"primaryKey"`
+	TenantID    *uint  `gorm:"not null;uniqueIndex:idx_tax_class_tenant_name" json:"-"`
+	Name        string `gorm:"not null;uniqueIndex:idx_tax_class_tenant_name;size:100"`
+	Description string
+}
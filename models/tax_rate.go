@@ -0,0 +1,12 @@
+package models
+
+// TaxRate is the percentage charged for a tax class in one jurisdiction (country/state code),
+// e.g. class "standard" in "IN-MH" at 18.
+type TaxRate struct {
+	ID           uint     `gorm:"primaryKey"`
+	TenantID     *uint    `gorm:"not null;index" json:"-"`
+	TaxClassID   *uint    `gorm:"not null;uniqueIndex:idx_tax_rate_class_jurisdiction"`
+	TaxClass     TaxClass `gorm:"foreignKey:TaxClassID;constraint:OnUpdate:CASCADE,OnDelete:RESTRICT;"`
+	Jurisdiction string   `gorm:"not null;size:50;uniqueIndex:idx_tax_rate_class_jurisdiction"`
+	Rate         float64  // percentage, 18 means 18%
+}
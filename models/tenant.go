@@ -0,0 +1,12 @@
+package models
+
+import "time"
+
+// Tenant is one company using the deployment. Every other model carries a TenantID and the
+// database package scopes all queries to the tenant of the request.
+type Tenant struct {
+	ID        uint   `gorm:"primaryKey"`
+	Name      string `gorm:"not null"`                     // printed on invoices, packing slips and credit notes
+	Slug      string `gorm:"not null;uniqueIndex;size:50"` // sent as X-Tenant when signing in
+	CreatedAt time.Time
+}
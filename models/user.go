@@ -0,0 +1,43 @@
+package models
+
+import "time"
+
+// User is someone who can sign in. The password is only kept as a bcrypt hash.
+type User struct {
+	ID           uint   `gorm:"primaryKey"`
+	TenantID     *uint  `gorm:"not null;uniqueIndex:idx_user_tenant_email" json:"-"`
+	Email        string `gorm:"not null;uniqueIndex:idx_user_tenant_email;size:255"`
+	Name         string
+	PasswordHash string `gorm:"not null" json:"-"`
+	Active       bool   `gorm:"not null;default:true"`
+	Roles        []Role `gorm:"many2many:user_roles;constraint:OnDelete:CASCADE;"`
+	CreatedAt    time.Time
+}
+
+// RefreshToken lets a signed-in user get new access tokens. Only the SHA-256 of the token is
+// stored, and every refresh replaces the token with a new one.
+type RefreshToken struct {
+	ID        uint   `gorm:"primaryKey"`
+	TenantID  *uint  `gorm:"not null;index" json:"-"`
+	UserID    *uint  `gorm:"not null;index"`
+	User      *User  `gorm:"foreignKey:UserID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;" json:"-"`
+	TokenHash string `gorm:"not null;uniqueIndex;size:64" json:"-"`
+	ExpiresAt time.Time
+	RevokedAt *time.Time
+	CreatedAt time.Time
+}
+
+// APIKey is a long-lived credential for machine clients, acting as its user. The key is shown once
+// when created, afterwards only its Prefix and the SHA-256 hash are known.
+type APIKey struct {
+	ID         uint   `gorm:"primaryKey"`
+	TenantID   *uint  `gorm:"not null;index" json:"-"`
+	UserID     *uint  `gorm:"not null;index"`
+	User       *User  `gorm:"foreignKey:UserID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;" json:"-"`
+	Name       string `gorm:"not null"`
+	Prefix     string `gorm:"size:16"`
+	KeyHash    string `gorm:"not null;uniqueIndex;size:64" json:"-"`
+	LastUsedAt *time.Time
+	RevokedAt  *time.Time
+	CreatedAt  time.Time
+}
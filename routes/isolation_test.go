@@ -0,0 +1,453 @@
+package routes_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"inventory-control-hub/apitest"
+	"inventory-control-hub/database"
+	"inventory-control-hub/models"
+	"inventory-control-hub/routes"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+// seeded are the IDs of a tenant's records made through the API, by name
+type seeded map[string]uint
+
+// fill replaces {prefix+name} in s with the ID seeded as name
+func (ids seeded) fill(s string, prefix string) string {
+	for name, id := range ids {
+		s = strings.ReplaceAll(s, "{"+prefix+name+"}", fmt.Sprint(id))
+	}
+	return s
+}
+
+// create sends a request as tenant that has to succeed and returns the ID of what it answered
+func create(t *testing.T, router http.Handler, tenant apitest.Tenant, method string, path string, body string) uint {
+	t.Helper()
+	w := tenant.Do(router, method, path, body)
+	if w.Code != http.StatusOK && w.Code != http.StatusCreated {
+		t.Fatalf("%s %s answered %d: %s", method, path, w.Code, w.Body)
+	}
+	var answer struct{ ID uint }
+	json.Unmarshal(w.Body.Bytes(), &answer)
+	return answer.ID
+}
+
+// seed gives tenant one of everything the routes with an ID work on
+func seed(t *testing.T, router http.Handler, tenant apitest.Tenant) seeded {
+	t.Helper()
+	ids := seeded{}
+	do := func(name string, method string, path string, body string) {
+		t.Helper()
+		id := create(t, router, tenant, method, ids.fill(path, ""), ids.fill(body, ""))
+		if name != "" {
+			ids[name] = id
+		}
+	}
+
+	do("taxClass", "POST", "/add-tax-class", `{"Name":"acme standard"}`)
+	do("taxRate", "POST", "/add-tax-rate", `{"TaxClassID":{taxClass},"Jurisdiction":"CA","Rate":0.1}`)
+	do("product", "POST", "/add-product", `{"Name":"acme desk","SKU":"ACME-DESK","Price":100,"Quantity":20,"TaxClassID":{taxClass}}`)
+	do("component", "POST", "/add-product", `{"Name":"acme leg","SKU":"ACME-LEG","Price":5,"Quantity":20}`)
+	do("kit", "POST", "/add-product", `{"Name":"acme table","SKU":"ACME-TABLE","Price":150}`)
+	do("", "PUT", "/product/{kit}/bom", `{"Components":[{"ComponentID":{component},"Quantity":4}]}`)
+	do("assemblyOrder", "POST", "/assembly-order", `{"ProductID":{kit},"Quantity":1}`)
+	do("bin", "POST", "/add-bin", `{"Zone":"A","Aisle":"1"}`)
+	do("", "POST", "/bin-move", `{"ProductID":{product},"ToBinID":{bin},"Quantity":10}`)
+
+	do("lotted", "POST", "/add-product", `{"Name":"acme glue","SKU":"ACME-GLUE","Price":3,"LotTracked":true}`)
+	do("lottedOrder", "POST", "/add-purchase-order", `{"ProductID":{lotted},"Quantity":10,"Supplier":"acme supplies","UnitCost":1,"DeferReceipt":true}`)
+	do("", "POST", "/purchase-order/{lottedOrder}/receive", `{"Quantity":10,"LotNumber":"ACME-LOT-1"}`)
+	do("serialized", "POST", "/add-product", `{"Name":"acme lamp","SKU":"ACME-LAMP","Price":30,"Serialized":true}`)
+	do("purchaseOrder", "POST", "/add-purchase-order", `{"ProductID":{serialized},"Quantity":2,"Supplier":"acme supplies","UnitCost":10,"DeferReceipt":true}`)
+	do("", "POST", "/purchase-order/{purchaseOrder}/receive", `{"Quantity":2,"SerialNumbers":[{"Serial":"ACME-SN-1"},{"Serial":"ACME-SN-2"}]}`)
+	do("supplierReturn", "POST", "/purchase-order/{purchaseOrder}/return", `{"Quantity":1,"Reason":"acme broken","Serials":["ACME-SN-2"]}`)
+	do("", "POST", "/supplier-return/{supplierReturn}/credit", `{"Amount":5}`)
+	do("supplierBill", "POST", "/add-supplier-bill", `{"PurchaseOrderID":{purchaseOrder},"BillNumber":"ACME-BILL-1","Quantity":2,"UnitCost":12,"TotalAmount":24}`)
+
+	do("priceList", "POST", "/add-price-list", `{"Name":"acme trade"}`)
+	do("priceListItem", "POST", "/price-list/{priceList}/add-item", `{"ProductID":{product},"Price":90}`)
+	do("customer", "POST", "/add-customer", `{"Name":"acme customer","Email":"buyer@acme.test","PriceListID":{priceList}}`)
+	do("promotion", "POST", "/add-promotion", `{"Code":"ACME10","DiscountType":"percent","DiscountValue":10}`)
+
+	do("salesOrder", "POST", "/add-sales-order", `{"ProductID":{product},"CustomerID":{customer},"Quantity":2,"Jurisdiction":"CA"}`)
+	do("pickTask", "POST", "/sales-order/{salesOrder}/pick-list", `{}`)
+	do("", "POST", "/pick-task/{pickTask}/confirm", `{}`)
+	do("shipment", "POST", "/sales-order/{salesOrder}/pack", `{"Parcels":[{"Quantity":2,"Weight":10}]}`)
+	do("", "POST", "/shipment/{shipment}/ship", `{"Carrier":"acme freight","TrackingNumber":"ACME-TRACK-1"}`)
+	do("invoice", "POST", "/sales-order/{salesOrder}/invoice", `{}`)
+	do("return", "POST", "/sales-order/{salesOrder}/return", `{"Reason":"acme damaged","Lines":[{"Quantity":1,"Disposition":"quarantine"}]}`)
+	do("payment", "POST", "/add-payment", `{"CustomerID":{customer},"Amount":10,"Method":"cash"}`)
+	do("openOrder", "POST", "/add-sales-order", `{"ProductID":{product},"CustomerID":{customer},"Quantity":1,"Jurisdiction":"CA"}`)
+	do("deletedOrder", "POST", "/add-sales-order", `{"ProductID":{product},"Quantity":1,"Jurisdiction":"CA"}`)
+	do("", "DELETE", "/delete-sales-order/{deletedOrder}", ``)
+	do("deletedProduct", "POST", "/add-product", `{"Name":"acme stool","SKU":"ACME-STOOL"}`)
+	do("", "DELETE", "/delete-product/{deletedProduct}", ``)
+	do("deletedPurchaseOrder", "POST", "/add-purchase-order", `{"ProductID":{product},"Quantity":1,"DeferReceipt":true,"Jurisdiction":"CA"}`)
+	do("", "DELETE", "/delete-purchase-order/{deletedPurchaseOrder}", ``)
+
+	do("role", "POST", "/add-role", `{"Name":"warehouse clerk","Permissions":["products:read"]}`)
+	do("user", "POST", "/add-user", `{"Email":"clerk@acme.test","Name":"acme clerk","Password":"acme-password","Roles":["warehouse clerk"]}`)
+	do("apiKey", "POST", "/api-keys", `{"Name":"acme shop"}`)
+
+	notification := models.Notification{CustomerID: uintPtr(ids["customer"]), SalesOrderID: uintPtr(ids["salesOrder"]), Event: "backorder_allocated", Message: "acme order allocated"}
+	if err := tenant.DB().Create(&notification).Error; err != nil {
+		t.Fatal(err)
+	}
+	ids["notification"] = notification.ID
+	var line models.PickTaskLine
+	if err := tenant.DB().Where("pick_task_id = ?", ids["pickTask"]).First(&line).Error; err != nil {
+		t.Fatal(err)
+	}
+	ids["pickTaskLine"] = line.ID
+	var lot models.Lot
+	if err := tenant.DB().Where("product_id = ?", ids["lotted"]).First(&lot).Error; err != nil {
+		t.Fatal(err)
+	}
+	ids["lot"] = lot.ID
+	ids["tenant"] = tenant.ID
+	return ids
+}
+
+func uintPtr(value uint) *uint {
+	return &value
+}
+
+// seedOwn gives tenant globex what its requests referring to acme's IDs need of its own
+func seedOwn(t *testing.T, router http.Handler, tenant apitest.Tenant) seeded {
+	t.Helper()
+	ids := seeded{"admin": tenant.Admin.ID}
+	do := func(name string, method string, path string, body string) {
+		t.Helper()
+		id := create(t, router, tenant, method, ids.fill(path, ""), ids.fill(body, ""))
+		if name != "" {
+			ids[name] = id
+		}
+	}
+	do("product", "POST", "/add-product", `{"Name":"globex desk","SKU":"GLOBEX-DESK","Price":100,"Quantity":20}`)
+	do("bin", "POST", "/add-bin", `{"Zone":"G"}`)
+	do("priceList", "POST", "/add-price-list", `{"Name":"globex trade"}`)
+	do("customer", "POST", "/add-customer", `{"Name":"globex customer"}`)
+	do("purchaseOrder", "POST", "/add-purchase-order", `{"ProductID":{product},"Quantity":5,"DeferReceipt":true}`)
+	do("payment", "POST", "/add-payment", `{"Amount":10,"Method":"cash"}`)
+	do("salesOrder", "POST", "/add-sales-order", `{"ProductID":{product},"Quantity":1}`)
+	do("pickTask", "POST", "/sales-order/{salesOrder}/pick-list", `{}`)
+	return ids
+}
+
+// snapshot is every row of every table, or only of one tenant's rows when tenantID isn't 0.
+// A tenant's rows are those with its tenant_id and the user_roles of its users.
+func snapshot(t *testing.T, tenantID uint) map[string][]map[string]interface{} {
+	t.Helper()
+	db := database.AllTenants()
+	var tables []string
+	if err := db.Raw("SELECT name FROM sqlite_master WHERE type = 'table' AND name NOT LIKE 'sqlite_%'").Scan(&tables).Error; err != nil {
+		t.Fatal(err)
+	}
+	rows := map[string][]map[string]interface{}{}
+	for _, table := range tables {
+		query := db.Table(table).Order("rowid")
+		switch {
+		case tenantID == 0:
+		case db.Migrator().HasColumn(table, "tenant_id"):
+			query = query.Where("tenant_id = ?", tenantID)
+		case table == "user_roles":
+			query = query.Where("user_id IN (SELECT id FROM users WHERE tenant_id = ?)", tenantID)
+		default:
+			continue
+		}
+		var tableRows []map[string]interface{}
+		if err := query.Find(&tableRows).Error; err != nil {
+			t.Fatal(err)
+		}
+		rows[table] = tableRows
+	}
+	return rows
+}
+
+// unchanged fails the test when a table differs between the snapshots
+func unchanged(t *testing.T, before map[string][]map[string]interface{}, after map[string][]map[string]interface{}) {
+	t.Helper()
+	for table, rows := range before {
+		if !reflect.DeepEqual(rows, after[table]) {
+			t.Errorf("%s changed:\nbefore %v\nafter  %v", table, rows, after[table])
+		}
+	}
+}
+
+// isolationCase is a request of tenant globex on an ID of tenant acme. {name} in the path and
+// body is acme's ID seeded as name, {globex.name} one of globex's own.
+type isolationCase struct {
+	method string
+	route  string // the route's path template in SetupRouter
+	path   string
+	body   string
+	status int
+}
+
+// isolationCases covers every route taking an ID in its path, and the routes taking IDs in
+// their body, TestIsolationCoversEveryRoute checks none is left out
+var isolationCases = []isolationCase{
+	// users, roles and tenants
+	{"PUT", "/update-user/{id}", "/update-user/{user}", `{"Name":"taken over"}`, http.StatusNotFound},
+	{"PUT", "/user/{id}/roles", "/user/{user}/roles", `{"Roles":[]}`, http.StatusNotFound},
+	{"PUT", "/update-role/{id}", "/update-role/{role}", `{"Name":"taken over"}`, http.StatusNotFound},
+	{"DELETE", "/delete-role/{id}", "/delete-role/{role}", ``, http.StatusNotFound},
+	// only the default tenant manages tenants
+	{"PUT", "/update-tenant/{id}", "/update-tenant/{tenant}", `{"Name":"taken over"}`, http.StatusForbidden},
+	{"DELETE", "/api-key/{id}", "/api-key/{apiKey}", ``, http.StatusNotFound},
+	{"POST", "/add-user", "/add-user", `{"Email":"spy@globex.test","Password":"globex-password","Roles":["warehouse clerk"]}`, http.StatusBadRequest},
+	{"PUT", "/user/{id}/roles", "/user/{globex.admin}/roles", `{"Roles":["warehouse clerk"]}`, http.StatusBadRequest},
+
+	// products, stock and the warehouse
+	{"GET", "/product/{id}", "/product/{product}", ``, http.StatusNotFound},
+	{"GET", "/product/{id}/price", "/product/{product}/price", ``, http.StatusNotFound},
+	{"PUT", "/update-product/{id}", "/update-product/{product}", `{"Name":"taken over"}`, http.StatusNotFound},
+	{"PUT", "/update-product/{id}", "/update-product/{globex.product}", `{"TaxClassID":{taxClass}}`, http.StatusNotFound},
+	{"PATCH", "/product/{id}", "/product/{product}", `{"Name":"taken over"}`, http.StatusNotFound},
+	{"DELETE", "/delete-product/{id}", "/delete-product/{product}", ``, http.StatusNotFound},
+	{"POST", "/product/{id}/restore", "/product/{deletedProduct}/restore", ``, http.StatusNotFound},
+	{"POST", "/product/{id}/archive", "/product/{product}/archive", ``, http.StatusNotFound},
+	{"POST", "/product/{id}/unarchive", "/product/{product}/unarchive", ``, http.StatusNotFound},
+	{"POST", "/product/{id}/release-quarantine", "/product/{product}/release-quarantine", `{"Quantity":1,"Disposition":"restock"}`, http.StatusNotFound},
+	{"POST", "/add-product", "/add-product", `{"Name":"globex chair","TaxClassID":{taxClass}}`, http.StatusNotFound},
+	{"GET", "/lot/{id}", "/lot/{lot}", ``, http.StatusNotFound},
+	{"GET", "/lot/{id}/trace", "/lot/{lot}/trace", ``, http.StatusNotFound},
+	{"GET", "/bin/{id}", "/bin/{bin}", ``, http.StatusNotFound},
+	{"PUT", "/update-bin/{id}", "/update-bin/{bin}", `{"Zone":"Z"}`, http.StatusNotFound},
+	{"DELETE", "/delete-bin/{id}", "/delete-bin/{bin}", ``, http.StatusNotFound},
+	{"POST", "/bin-move", "/bin-move", `{"ProductID":{product},"ToBinID":{globex.bin},"Quantity":1}`, http.StatusNotFound},
+	{"POST", "/bin-move", "/bin-move", `{"ProductID":{globex.product},"ToBinID":{bin},"Quantity":1}`, http.StatusNotFound},
+	{"POST", "/bin-move", "/bin-move", `{"ProductID":{globex.product},"FromBinID":{bin},"ToBinID":{globex.bin},"Quantity":1}`, http.StatusNotFound},
+	{"GET", "/product/{id}/bins", "/product/{product}/bins", ``, http.StatusNotFound},
+	{"GET", "/product/{id}/backorders", "/product/{product}/backorders", ``, http.StatusNotFound},
+	{"GET", "/product/{id}/bom", "/product/{kit}/bom", ``, http.StatusNotFound},
+	{"PUT", "/product/{id}/bom", "/product/{kit}/bom", `{"Components":[{"ComponentID":{globex.product},"Quantity":1}]}`, http.StatusNotFound},
+	{"PUT", "/product/{id}/bom", "/product/{globex.product}/bom", `{"Components":[{"ComponentID":{component},"Quantity":1}]}`, http.StatusNotFound},
+	{"DELETE", "/product/{id}/bom", "/product/{kit}/bom", ``, http.StatusNotFound},
+	{"GET", "/assembly-order/{id}", "/assembly-order/{assemblyOrder}", ``, http.StatusNotFound},
+	{"POST", "/assembly-order", "/assembly-order", `{"ProductID":{kit},"Quantity":1}`, http.StatusNotFound},
+	{"POST", "/disassembly-order", "/disassembly-order", `{"ProductID":{kit},"Quantity":1}`, http.StatusNotFound},
+	{"GET", "/serial/{sn}", "/serial/ACME-SN-1", ``, http.StatusNotFound},
+
+	// sales
+	{"GET", "/sales-order/{id}", "/sales-order/{salesOrder}", ``, http.StatusNotFound},
+	{"POST", "/add-sales-order", "/add-sales-order", `{"ProductID":{product},"Quantity":1}`, http.StatusNotFound},
+	{"POST", "/add-sales-order", "/add-sales-order", `{"ProductID":{globex.product},"CustomerID":{customer},"Quantity":1}`, http.StatusNotFound},
+	{"PUT", "/update-sales-order/{id}", "/update-sales-order/{openOrder}", `{"Quantity":1}`, http.StatusNotFound},
+	{"PATCH", "/sales-order/{id}", "/sales-order/{openOrder}", `{"Quantity":1}`, http.StatusNotFound},
+	{"DELETE", "/delete-sales-order/{id}", "/delete-sales-order/{openOrder}", ``, http.StatusNotFound},
+	{"POST", "/sales-order/{id}/restore", "/sales-order/{deletedOrder}/restore", ``, http.StatusNotFound},
+	{"POST", "/sales-order/{id}/invoice", "/sales-order/{openOrder}/invoice", ``, http.StatusNotFound},
+	{"GET", "/invoice/{id}", "/invoice/{invoice}", ``, http.StatusNotFound},
+	{"POST", "/sales-order/{id}/return", "/sales-order/{salesOrder}/return", `{"Reason":"taken over","Lines":[{"Quantity":1,"Disposition":"restock"}]}`, http.StatusNotFound},
+	{"GET", "/sales-order/{id}/pick-list", "/sales-order/{openOrder}/pick-list", ``, http.StatusNotFound},
+	{"POST", "/sales-order/{id}/pick-list", "/sales-order/{openOrder}/pick-list", ``, http.StatusNotFound},
+	{"POST", "/sales-order/{id}/pack", "/sales-order/{openOrder}/pack", `{"Parcels":[{"Quantity":1}]}`, http.StatusNotFound},
+	{"POST", "/notification/{id}/read", "/notification/{notification}/read", ``, http.StatusNotFound},
+	{"GET", "/pick-task/{id}", "/pick-task/{pickTask}", ``, http.StatusNotFound},
+	{"POST", "/pick-task/{id}/confirm", "/pick-task/{pickTask}/confirm", ``, http.StatusNotFound},
+	{"POST", "/pick-task/{id}/confirm", "/pick-task/{globex.pickTask}/confirm", `{"Lines":[{"ID":{pickTaskLine},"PickedQuantity":1}]}`, http.StatusBadRequest},
+	{"GET", "/shipment/{id}", "/shipment/{shipment}", ``, http.StatusNotFound},
+	{"GET", "/shipment/{id}/packing-slip", "/shipment/{shipment}/packing-slip", ``, http.StatusNotFound},
+	{"GET", "/shipment/{id}/rates", "/shipment/{shipment}/rates", ``, http.StatusNotFound},
+	{"POST", "/shipment/{id}/ship", "/shipment/{shipment}/ship", `{"Carrier":"globex freight","TrackingNumber":"GLOBEX-1"}`, http.StatusNotFound},
+	{"GET", "/shipment/{id}/label", "/shipment/{shipment}/label", ``, http.StatusNotFound},
+	{"GET", "/return/{id}", "/return/{return}", ``, http.StatusNotFound},
+	{"GET", "/payment/{id}", "/payment/{payment}", ``, http.StatusNotFound},
+	{"POST", "/add-payment", "/add-payment", `{"CustomerID":{customer},"Amount":10,"Method":"cash"}`, http.StatusNotFound},
+	{"POST", "/add-payment", "/add-payment", `{"Amount":10,"Method":"cash","Allocations":[{"InvoiceID":{invoice},"Amount":10}]}`, http.StatusNotFound},
+	{"POST", "/payment/{id}/allocate", "/payment/{payment}/allocate", `[{"InvoiceID":{invoice},"Amount":1}]`, http.StatusNotFound},
+	{"POST", "/payment/{id}/allocate", "/payment/{globex.payment}/allocate", `[{"InvoiceID":{invoice},"Amount":1}]`, http.StatusNotFound},
+
+	// purchasing
+	{"GET", "/purchase-order/{id}", "/purchase-order/{purchaseOrder}", ``, http.StatusNotFound},
+	{"POST", "/add-purchase-order", "/add-purchase-order", `{"ProductID":{product},"Quantity":1}`, http.StatusNotFound},
+	{"PUT", "/update-purchase-order/{id}", "/update-purchase-order/{purchaseOrder}", `{"Supplier":"taken over"}`, http.StatusNotFound},
+	{"PATCH", "/purchase-order/{id}", "/purchase-order/{purchaseOrder}", `{"Supplier":"taken over"}`, http.StatusNotFound},
+	{"DELETE", "/delete-purchase-order/{id}", "/delete-purchase-order/{purchaseOrder}", ``, http.StatusNotFound},
+	{"POST", "/purchase-order/{id}/restore", "/purchase-order/{deletedPurchaseOrder}/restore", ``, http.StatusNotFound},
+	{"POST", "/purchase-order/{id}/receive", "/purchase-order/{lottedOrder}/receive", `{"Quantity":1}`, http.StatusNotFound},
+	{"POST", "/purchase-order/{id}/receive", "/purchase-order/{globex.purchaseOrder}/receive", `{"Quantity":1,"BinID":{bin}}`, http.StatusNotFound},
+	{"POST", "/purchase-order/{id}/return", "/purchase-order/{purchaseOrder}/return", `{"Quantity":1,"Reason":"taken over"}`, http.StatusNotFound},
+	{"GET", "/supplier-return/{id}", "/supplier-return/{supplierReturn}", ``, http.StatusNotFound},
+	{"POST", "/supplier-return/{id}/credit", "/supplier-return/{supplierReturn}/credit", `{"Amount":1}`, http.StatusNotFound},
+	{"GET", "/supplier-bill/{id}", "/supplier-bill/{supplierBill}", ``, http.StatusNotFound},
+	{"POST", "/add-supplier-bill", "/add-supplier-bill", `{"PurchaseOrderID":{purchaseOrder},"BillNumber":"GLOBEX-1","Quantity":1}`, http.StatusNotFound},
+	{"POST", "/supplier-bill/{id}/rematch", "/supplier-bill/{supplierBill}/rematch", ``, http.StatusNotFound},
+	{"POST", "/supplier-bill/{id}/approve", "/supplier-bill/{supplierBill}/approve", `{"Note":"taken over"}`, http.StatusNotFound},
+
+	// customers and pricing
+	{"GET", "/customer/{id}", "/customer/{customer}", ``, http.StatusNotFound},
+	{"POST", "/add-customer", "/add-customer", `{"Name":"globex buyer","PriceListID":{priceList}}`, http.StatusNotFound},
+	{"PUT", "/update-customer/{id}", "/update-customer/{customer}", `{"Name":"taken over"}`, http.StatusNotFound},
+	{"PUT", "/update-customer/{id}", "/update-customer/{globex.customer}", `{"PriceListID":{priceList}}`, http.StatusNotFound},
+	{"DELETE", "/delete-customer/{id}", "/delete-customer/{customer}", ``, http.StatusNotFound},
+	{"GET", "/price-list/{id}", "/price-list/{priceList}", ``, http.StatusNotFound},
+	{"DELETE", "/delete-price-list/{id}", "/delete-price-list/{priceList}", ``, http.StatusNotFound},
+	{"POST", "/price-list/{id}/add-item", "/price-list/{priceList}/add-item", `{"ProductID":{globex.product},"Price":1}`, http.StatusNotFound},
+	{"POST", "/price-list/{id}/add-item", "/price-list/{globex.priceList}/add-item", `{"ProductID":{product},"Price":1}`, http.StatusNotFound},
+	{"DELETE", "/delete-price-list-item/{id}", "/delete-price-list-item/{priceListItem}", ``, http.StatusNotFound},
+	{"GET", "/promotion/{id}", "/promotion/{promotion}", ``, http.StatusNotFound},
+	{"PUT", "/update-promotion/{id}", "/update-promotion/{promotion}", `{"Description":"taken over"}`, http.StatusNotFound},
+	{"DELETE", "/delete-promotion/{id}", "/delete-promotion/{promotion}", ``, http.StatusNotFound},
+	{"DELETE", "/delete-tax-class/{id}", "/delete-tax-class/{taxClass}", ``, http.StatusNotFound},
+	{"POST", "/add-tax-rate", "/add-tax-rate", `{"TaxClassID":{taxClass},"Jurisdiction":"NY","Rate":0.2}`, http.StatusNotFound},
+	{"PUT", "/update-tax-rate/{id}", "/update-tax-rate/{taxRate}", `{"Rate":0.2}`, http.StatusNotFound},
+	{"DELETE", "/delete-tax-rate/{id}", "/delete-tax-rate/{taxRate}", ``, http.StatusNotFound},
+}
+
+// routesWithoutIDs take no ID of another record, the list routes aside
+var routesWithoutIDs = []string{
+	"POST /auth/login",
+	"POST /auth/refresh",
+	"POST /auth/logout",
+	"POST /add-role",
+	"POST /add-tenant",
+	"POST /api-keys",
+	"POST /add-bin",
+	"POST /add-price-list",
+	"POST /add-promotion",
+	"POST /add-tax-class",
+}
+
+// setUpTenants seeds tenant acme and tenant globex, whose requests the tests make
+func setUpTenants(t *testing.T) (router http.Handler, acme seeded, globex apitest.Tenant, own seeded) {
+	apitest.Open(t)
+	router = routes.SetupRouter()
+	acme = seed(t, router, apitest.AddTenant(t, "acme"))
+	globex = apitest.AddTenant(t, "globex")
+	return router, acme, globex, seedOwn(t, router, globex)
+}
+
+func TestIsolation(t *testing.T) {
+	router, acme, globex, own := setUpTenants(t)
+
+	for _, test := range isolationCases {
+		path := acme.fill(own.fill(test.path, "globex."), "")
+		body := acme.fill(own.fill(test.body, "globex."), "")
+		t.Run(test.method+" "+path+" "+body, func(t *testing.T) {
+			before := snapshot(t, 0)
+			w := globex.Do(router, test.method, path, body)
+			if w.Code != test.status {
+				t.Errorf("answered %d, want %d: %s", w.Code, test.status, w.Body)
+			}
+			if strings.Contains(strings.ToLower(w.Body.String()), "acme") {
+				t.Errorf("answer shows acme's data: %s", w.Body)
+			}
+			unchanged(t, before, snapshot(t, 0))
+		})
+	}
+}
+
+// TestIsolationOfLists reads every list as globex, filtered by acme's IDs too, none may show
+// anything of acme's
+func TestIsolationOfLists(t *testing.T) {
+	router, acme, globex, _ := setUpTenants(t)
+	filter := acme.fill("?product={product}&customer={customer}&sales_order={salesOrder}&include_deleted=true", "")
+
+	for _, path := range listRoutes(t, router.(*mux.Router)) {
+		for _, path := range []string{path, path + filter} {
+			t.Run(path, func(t *testing.T) {
+				w := globex.Do(router, "GET", path, ``)
+				if path == "/tenants" || strings.HasPrefix(path, "/tenants?") {
+					// only the default tenant manages tenants
+					if w.Code != http.StatusForbidden {
+						t.Errorf("answered %d, want 403", w.Code)
+					}
+					return
+				}
+				if w.Code != http.StatusOK {
+					t.Errorf("answered %d: %s", w.Code, w.Body)
+				}
+				if strings.Contains(strings.ToLower(w.Body.String()), "acme") {
+					t.Errorf("answer shows acme's data: %s", w.Body)
+				}
+			})
+		}
+	}
+}
+
+// listRoutes are the GET routes without an ID in their path
+func listRoutes(t *testing.T, router *mux.Router) []string {
+	var paths []string
+	for _, route := range routeKeys(t, router) {
+		if path := strings.TrimPrefix(route, "GET "); path != route && !strings.Contains(path, "{") {
+			paths = append(paths, path)
+		}
+	}
+	return paths
+}
+
+// routeKeys are "METHOD template" of every route of router
+func routeKeys(t *testing.T, router *mux.Router) []string {
+	var keys []string
+	err := router.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		template, err := route.GetPathTemplate()
+		if err != nil {
+			return err
+		}
+		methods, err := route.GetMethods()
+		if err != nil {
+			return err
+		}
+		for _, method := range methods {
+			keys = append(keys, method+" "+template)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func TestIsolationCoversEveryRoute(t *testing.T) {
+	covered := map[string]bool{}
+	for _, test := range isolationCases {
+		covered[test.method+" "+test.route] = true
+	}
+	for _, key := range routesWithoutIDs {
+		covered[key] = true
+	}
+	router := routes.SetupRouter()
+	for _, path := range listRoutes(t, router) {
+		covered["GET "+path] = true
+	}
+	for _, key := range routeKeys(t, router) {
+		if !covered[key] {
+			t.Errorf("%s has no isolation case", key)
+		}
+	}
+}
+
+// DeleteRole finds the role's users by joining user_roles, which has no tenant_id. Deleting
+// globex's role of the same name leaves acme's role and its users' roles alone.
+func TestDeleteRoleKeepsOtherTenantsRoles(t *testing.T) {
+	router, acme, globex, _ := setUpTenants(t)
+	role := create(t, router, globex, "POST", "/add-role", `{"Name":"warehouse clerk","Permissions":["products:read"]}`)
+	create(t, router, globex, "POST", "/add-user", `{"Email":"clerk@globex.test","Password":"globex-password","Roles":["warehouse clerk"]}`)
+
+	before := snapshot(t, acme["tenant"])
+	if w := globex.Do(router, "DELETE", fmt.Sprintf("/delete-role/%d", role), ``); w.Code != http.StatusOK {
+		t.Fatalf("deleting globex's role answered %d: %s", w.Code, w.Body)
+	}
+	unchanged(t, before, snapshot(t, acme["tenant"]))
+
+	var userRoles int64
+	database.AllTenants().Table("user_roles").Where("role_id = ?", role).Count(&userRoles)
+	if userRoles != 0 {
+		t.Errorf("globex's role is still held by %d users", userRoles)
+	}
+	database.AllTenants().Table("user_roles").Where("user_id = ? AND role_id = ?", acme["user"], acme["role"]).Count(&userRoles)
+	if userRoles != 1 {
+		t.Error("acme's user lost its role")
+	}
+}
@@ -1,31 +1,170 @@
 package routes
 
 import (
+	"inventory-control-hub/audit"
+	"inventory-control-hub/auth"
 	"inventory-control-hub/controllers"
+	"inventory-control-hub/idempotency"
 
 	"github.com/gorilla/mux"
 )
 
 func SetupRouter() *mux.Router {
 	r := mux.NewRouter()
+	// every route needs a signed-in user or API key, except the home route and signing in,
+	// auth.Require additionally checks the user's roles grant the route's permission.
+	// POSTs sent with an Idempotency-Key can be retried safely, see idempotency.Middleware
+	r.Use(audit.Middleware, auth.Middleware, idempotency.Middleware)
 	r.HandleFunc("/", controllers.HomeRoute).Methods("GET")
 
-	r.HandleFunc("/products", controllers.GetProduct).Methods("GET")
-	r.HandleFunc("/product/{id}", controllers.GetProductById).Methods("GET")
-	r.HandleFunc("/add-product", controllers.AddProduct).Methods("POST")
-	r.HandleFunc("/update-product/{id}", controllers.UpdateProduct).Methods("PUT")
-	r.HandleFunc("/delete-product/{id}", controllers.DeleteProduct).Methods("DELETE")
-
-	r.HandleFunc("/sales-order", controllers.GetSalesOrder).Methods("GET")
-	r.HandleFunc("/add-sales-order", controllers.CreateSalesOrder).Methods("POST")
-	r.HandleFunc("/update-sales-order/{id}", controllers.UpdateSalesOrder).Methods("PUT")
-	r.HandleFunc("/delete-sales-order/{id}", controllers.DeleteSalesOrder).Methods("DELETE")
-
-	r.HandleFunc("/purchase-orders", controllers.GetPurchaseOrder).Methods("GET")
-	r.HandleFunc("/purchase-order/{id}", controllers.GetPurchaseOrderById).Methods("GET")
-	r.HandleFunc("/add-purchase-order", controllers.CreatePurchaseOrder).Methods("POST")
-	r.HandleFunc("/update-purchase-order/{id}", controllers.UpdatePurchaseOrder).Methods("PUT")
-	r.HandleFunc("/delete-purchase-order/{id}", controllers.DeletePurchaseOrder).Methods("DELETE")
+	r.HandleFunc("/auth/login", controllers.Login).Methods("POST")
+	r.HandleFunc("/auth/refresh", controllers.RefreshTokens).Methods("POST")
+	r.HandleFunc("/auth/logout", controllers.Logout).Methods("POST")
+	r.HandleFunc("/auth/me", controllers.GetCurrentUser).Methods("GET")
+	r.HandleFunc("/users", auth.Require(auth.UsersManage, controllers.GetUsers)).Methods("GET")
+	r.HandleFunc("/add-user", auth.Require(auth.UsersManage, controllers.AddUser)).Methods("POST")
+	r.HandleFunc("/update-user/{id}", auth.Require(auth.UsersManage, controllers.UpdateUser)).Methods("PUT")
+	r.HandleFunc("/user/{id}/roles", auth.Require(auth.UsersManage, controllers.SetUserRoles)).Methods("PUT")
+	r.HandleFunc("/roles", auth.Require(auth.UsersManage, controllers.GetRoles)).Methods("GET")
+	r.HandleFunc("/add-role", auth.Require(auth.UsersManage, controllers.AddRole)).Methods("POST")
+	r.HandleFunc("/update-role/{id}", auth.Require(auth.UsersManage, controllers.UpdateRole)).Methods("PUT")
+	r.HandleFunc("/delete-role/{id}", auth.Require(auth.UsersManage, controllers.DeleteRole)).Methods("DELETE")
+	r.HandleFunc("/permissions", auth.Require(auth.UsersManage, controllers.GetPermissions)).Methods("GET")
+	r.HandleFunc("/tenants", auth.Require(auth.TenantsManage, controllers.GetTenants)).Methods("GET")
+	r.HandleFunc("/add-tenant", auth.Require(auth.TenantsManage, controllers.AddTenant)).Methods("POST")
+	r.HandleFunc("/update-tenant/{id}", auth.Require(auth.TenantsManage, controllers.UpdateTenant)).Methods("PUT")
+	r.HandleFunc("/api-keys", controllers.GetAPIKeys).Methods("GET")
+	r.HandleFunc("/api-keys", controllers.CreateAPIKey).Methods("POST")
+	r.HandleFunc("/api-key/{id}", controllers.RevokeAPIKey).Methods("DELETE")
+
+	r.HandleFunc("/products", auth.Require(auth.ProductsRead, controllers.GetProduct)).Methods("GET")
+	r.HandleFunc("/product/{id}", auth.Require(auth.ProductsRead, controllers.GetProductById)).Methods("GET")
+	r.HandleFunc("/product/{id}/price", auth.Require(auth.ProductsRead, controllers.GetProductPrice)).Methods("GET")
+	r.HandleFunc("/add-product", auth.Require(auth.ProductsWrite, controllers.AddProduct)).Methods("POST")
+	r.HandleFunc("/update-product/{id}", auth.Require(auth.ProductsWrite, controllers.UpdateProduct)).Methods("PUT")
+	r.HandleFunc("/product/{id}", auth.Require(auth.ProductsWrite, controllers.PatchProduct)).Methods("PATCH")
+	r.HandleFunc("/delete-product/{id}", auth.Require(auth.ProductsDelete, controllers.DeleteProduct)).Methods("DELETE")
+	r.HandleFunc("/product/{id}/restore", auth.Require(auth.ProductsDelete, controllers.RestoreProduct)).Methods("POST")
+	r.HandleFunc("/product/{id}/archive", auth.Require(auth.ProductsWrite, controllers.ArchiveProduct)).Methods("POST")
+	r.HandleFunc("/product/{id}/unarchive", auth.Require(auth.ProductsWrite, controllers.UnarchiveProduct)).Methods("POST")
+	r.HandleFunc("/product/{id}/release-quarantine", auth.Require(auth.WarehouseWrite, controllers.ReleaseQuarantine)).Methods("POST")
+	r.HandleFunc("/stock-movements", auth.Require(auth.ProductsRead, controllers.GetStockMovements)).Methods("GET")
+
+	r.HandleFunc("/lots", auth.Require(auth.ProductsRead, controllers.GetLots)).Methods("GET")
+	r.HandleFunc("/lots/expiring", auth.Require(auth.ProductsRead, controllers.GetExpiringLots)).Methods("GET")
+	r.HandleFunc("/lot/{id}", auth.Require(auth.ProductsRead, controllers.GetLotById)).Methods("GET")
+	r.HandleFunc("/lot/{id}/trace", auth.Require(auth.ProductsRead, controllers.GetLotTrace)).Methods("GET")
+
+	r.HandleFunc("/bins", auth.Require(auth.WarehouseRead, controllers.GetBins)).Methods("GET")
+	r.HandleFunc("/bin/{id}", auth.Require(auth.WarehouseRead, controllers.GetBinById)).Methods("GET")
+	r.HandleFunc("/add-bin", auth.Require(auth.WarehouseWrite, controllers.AddBin)).Methods("POST")
+	r.HandleFunc("/update-bin/{id}", auth.Require(auth.WarehouseWrite, controllers.UpdateBin)).Methods("PUT")
+	r.HandleFunc("/delete-bin/{id}", auth.Require(auth.WarehouseWrite, controllers.DeleteBin)).Methods("DELETE")
+	r.HandleFunc("/bin-move", auth.Require(auth.WarehouseWrite, controllers.MoveBinStock)).Methods("POST")
+	r.HandleFunc("/product/{id}/bins", auth.Require(auth.ProductsRead, controllers.GetProductBins)).Methods("GET")
+	r.HandleFunc("/product/{id}/backorders", auth.Require(auth.ProductsRead, controllers.GetProductBackorders)).Methods("GET")
+
+	r.HandleFunc("/boms", auth.Require(auth.ProductsRead, controllers.GetBOMs)).Methods("GET")
+	r.HandleFunc("/product/{id}/bom", auth.Require(auth.ProductsRead, controllers.GetProductBOM)).Methods("GET")
+	r.HandleFunc("/product/{id}/bom", auth.Require(auth.ProductsWrite, controllers.SetProductBOM)).Methods("PUT")
+	r.HandleFunc("/product/{id}/bom", auth.Require(auth.ProductsWrite, controllers.DeleteProductBOM)).Methods("DELETE")
+	r.HandleFunc("/assembly-orders", auth.Require(auth.WarehouseRead, controllers.GetAssemblyOrders)).Methods("GET")
+	r.HandleFunc("/assembly-order/{id}", auth.Require(auth.WarehouseRead, controllers.GetAssemblyOrderById)).Methods("GET")
+	r.HandleFunc("/assembly-order", auth.Require(auth.WarehouseWrite, controllers.CreateAssemblyOrder)).Methods("POST")
+	r.HandleFunc("/disassembly-order", auth.Require(auth.WarehouseWrite, controllers.CreateDisassemblyOrder)).Methods("POST")
+
+	r.HandleFunc("/serials", auth.Require(auth.ProductsRead, controllers.GetSerialNumbers)).Methods("GET")
+	r.HandleFunc("/serial/{sn}", auth.Require(auth.ProductsRead, controllers.GetSerialNumber)).Methods("GET")
+
+	r.HandleFunc("/sales-order", auth.Require(auth.SalesRead, controllers.GetSalesOrder)).Methods("GET")
+	r.HandleFunc("/sales-order/{id}", auth.Require(auth.SalesRead, controllers.GetSalesOrderById)).Methods("GET")
+	r.HandleFunc("/add-sales-order", auth.Require(auth.SalesWrite, controllers.CreateSalesOrder)).Methods("POST")
+	r.HandleFunc("/update-sales-order/{id}", auth.Require(auth.SalesWrite, controllers.UpdateSalesOrder)).Methods("PUT")
+	r.HandleFunc("/sales-order/{id}", auth.Require(auth.SalesWrite, controllers.PatchSalesOrder)).Methods("PATCH")
+	r.HandleFunc("/delete-sales-order/{id}", auth.Require(auth.SalesWrite, controllers.DeleteSalesOrder)).Methods("DELETE")
+	r.HandleFunc("/sales-order/{id}/restore", auth.Require(auth.SalesWrite, controllers.RestoreSalesOrder)).Methods("POST")
+
+	r.HandleFunc("/sales-order/{id}/invoice", auth.Require(auth.SalesWrite, controllers.CreateInvoice)).Methods("POST")
+	r.HandleFunc("/invoices", auth.Require(auth.SalesRead, controllers.GetInvoices)).Methods("GET")
+	r.HandleFunc("/invoice/{id}", auth.Require(auth.SalesRead, controllers.GetInvoiceById)).Methods("GET")
+
+	r.HandleFunc("/sales-order/{id}/return", auth.Require(auth.SalesWrite, controllers.CreateReturn)).Methods("POST")
+	r.HandleFunc("/sales-order/{id}/pick-list", auth.Require(auth.WarehouseRead, controllers.GetPickList)).Methods("GET")
+	r.HandleFunc("/sales-order/{id}/pick-list", auth.Require(auth.WarehouseWrite, controllers.CreatePickTask)).Methods("POST")
+	r.HandleFunc("/sales-order/{id}/pack", auth.Require(auth.WarehouseWrite, controllers.PackSalesOrder)).Methods("POST")
+	r.HandleFunc("/backorder-allocations", auth.Require(auth.SalesRead, controllers.GetBackorderAllocations)).Methods("GET")
+	r.HandleFunc("/notifications", auth.Require(auth.SalesRead, controllers.GetNotifications)).Methods("GET")
+	r.HandleFunc("/notification/{id}/read", auth.Require(auth.SalesWrite, controllers.MarkNotificationRead)).Methods("POST")
+	r.HandleFunc("/pick-tasks", auth.Require(auth.WarehouseRead, controllers.GetPickTasks)).Methods("GET")
+	r.HandleFunc("/pick-task/{id}", auth.Require(auth.WarehouseRead, controllers.GetPickTaskById)).Methods("GET")
+	r.HandleFunc("/pick-task/{id}/confirm", auth.Require(auth.WarehouseWrite, controllers.ConfirmPickTask)).Methods("POST")
+	r.HandleFunc("/shipments", auth.Require(auth.WarehouseRead, controllers.GetShipments)).Methods("GET")
+	r.HandleFunc("/shipment/{id}", auth.Require(auth.WarehouseRead, controllers.GetShipmentById)).Methods("GET")
+	r.HandleFunc("/shipment/{id}/packing-slip", auth.Require(auth.WarehouseRead, controllers.GetPackingSlip)).Methods("GET")
+	r.HandleFunc("/shipment/{id}/rates", auth.Require(auth.WarehouseRead, controllers.GetShipmentRates)).Methods("GET")
+	r.HandleFunc("/shipment/{id}/ship", auth.Require(auth.WarehouseWrite, controllers.ShipShipment)).Methods("POST")
+	r.HandleFunc("/shipment/{id}/label", auth.Require(auth.WarehouseRead, controllers.GetShippingLabel)).Methods("GET")
+	r.HandleFunc("/carriers", auth.Require(auth.WarehouseRead, controllers.GetCarriers)).Methods("GET")
+	r.HandleFunc("/returns", auth.Require(auth.SalesRead, controllers.GetReturns)).Methods("GET")
+	r.HandleFunc("/return/{id}", auth.Require(auth.SalesRead, controllers.GetReturnById)).Methods("GET")
+
+	r.HandleFunc("/payments", auth.Require(auth.SalesRead, controllers.GetPayments)).Methods("GET")
+	r.HandleFunc("/payment/{id}", auth.Require(auth.SalesRead, controllers.GetPaymentById)).Methods("GET")
+	r.HandleFunc("/add-payment", auth.Require(auth.SalesWrite, controllers.AddPayment)).Methods("POST")
+	r.HandleFunc("/payment/{id}/allocate", auth.Require(auth.SalesWrite, controllers.AllocatePayment)).Methods("POST")
+
+	r.HandleFunc("/reports/aging", auth.Require(auth.ReportsRead, controllers.GetAgingReport)).Methods("GET")
+	r.HandleFunc("/audit", auth.Require(auth.AuditRead, controllers.GetAuditLogs)).Methods("GET")
+
+	r.HandleFunc("/purchase-orders", auth.Require(auth.PurchasingRead, controllers.GetPurchaseOrder)).Methods("GET")
+	r.HandleFunc("/purchase-order/{id}", auth.Require(auth.PurchasingRead, controllers.GetPurchaseOrderById)).Methods("GET")
+	r.HandleFunc("/add-purchase-order", auth.Require(auth.PurchasingWrite, controllers.CreatePurchaseOrder)).Methods("POST")
+	r.HandleFunc("/update-purchase-order/{id}", auth.Require(auth.PurchasingWrite, controllers.UpdatePurchaseOrder)).Methods("PUT")
+	r.HandleFunc("/purchase-order/{id}", auth.Require(auth.PurchasingWrite, controllers.PatchPurchaseOrder)).Methods("PATCH")
+	r.HandleFunc("/delete-purchase-order/{id}", auth.Require(auth.PurchasingWrite, controllers.DeletePurchaseOrder)).Methods("DELETE")
+	r.HandleFunc("/purchase-order/{id}/restore", auth.Require(auth.PurchasingWrite, controllers.RestorePurchaseOrder)).Methods("POST")
+	r.HandleFunc("/purchase-order/{id}/receive", auth.Require(auth.ReceivingWrite, controllers.ReceivePurchaseOrder)).Methods("POST")
+	r.HandleFunc("/purchase-order/{id}/return", auth.Require(auth.PurchasingWrite, controllers.CreateSupplierReturn)).Methods("POST")
+
+	r.HandleFunc("/supplier-returns", auth.Require(auth.PurchasingRead, controllers.GetSupplierReturns)).Methods("GET")
+	r.HandleFunc("/supplier-return/{id}", auth.Require(auth.PurchasingRead, controllers.GetSupplierReturnById)).Methods("GET")
+	r.HandleFunc("/supplier-return/{id}/credit", auth.Require(auth.PurchasingWrite, controllers.RecordSupplierCredit)).Methods("POST")
+	r.HandleFunc("/supplier-credits", auth.Require(auth.PurchasingRead, controllers.GetSupplierCredits)).Methods("GET")
+
+	r.HandleFunc("/supplier-bills", auth.Require(auth.PurchasingRead, controllers.GetSupplierBills)).Methods("GET")
+	r.HandleFunc("/supplier-bills/exceptions", auth.Require(auth.PurchasingRead, controllers.GetSupplierBillExceptions)).Methods("GET")
+	r.HandleFunc("/supplier-bill/{id}", auth.Require(auth.PurchasingRead, controllers.GetSupplierBillById)).Methods("GET")
+	r.HandleFunc("/add-supplier-bill", auth.Require(auth.PurchasingWrite, controllers.AddSupplierBill)).Methods("POST")
+	r.HandleFunc("/supplier-bill/{id}/rematch", auth.Require(auth.PurchasingWrite, controllers.RematchSupplierBill)).Methods("POST")
+	r.HandleFunc("/supplier-bill/{id}/approve", auth.Require(auth.PurchasingWrite, controllers.ApproveSupplierBill)).Methods("POST")
+
+	r.HandleFunc("/customers", auth.Require(auth.CustomersRead, controllers.GetCustomers)).Methods("GET")
+	r.HandleFunc("/customer/{id}", auth.Require(auth.CustomersRead, controllers.GetCustomerById)).Methods("GET")
+	r.HandleFunc("/add-customer", auth.Require(auth.CustomersWrite, controllers.AddCustomer)).Methods("POST")
+	r.HandleFunc("/update-customer/{id}", auth.Require(auth.CustomersWrite, controllers.UpdateCustomer)).Methods("PUT")
+	r.HandleFunc("/delete-customer/{id}", auth.Require(auth.CustomersWrite, controllers.DeleteCustomer)).Methods("DELETE")
+
+	r.HandleFunc("/price-lists", auth.Require(auth.PricingRead, controllers.GetPriceLists)).Methods("GET")
+	r.HandleFunc("/price-list/{id}", auth.Require(auth.PricingRead, controllers.GetPriceListById)).Methods("GET")
+	r.HandleFunc("/add-price-list", auth.Require(auth.PricingWrite, controllers.AddPriceList)).Methods("POST")
+	r.HandleFunc("/delete-price-list/{id}", auth.Require(auth.PricingWrite, controllers.DeletePriceList)).Methods("DELETE")
+	r.HandleFunc("/price-list/{id}/add-item", auth.Require(auth.PricingWrite, controllers.AddPriceListItem)).Methods("POST")
+	r.HandleFunc("/delete-price-list-item/{id}", auth.Require(auth.PricingWrite, controllers.DeletePriceListItem)).Methods("DELETE")
+
+	r.HandleFunc("/promotions", auth.Require(auth.PricingRead, controllers.GetPromotions)).Methods("GET")
+	r.HandleFunc("/promotion/{id}", auth.Require(auth.PricingRead, controllers.GetPromotionById)).Methods("GET")
+	r.HandleFunc("/add-promotion", auth.Require(auth.PricingWrite, controllers.AddPromotion)).Methods("POST")
+	r.HandleFunc("/update-promotion/{id}", auth.Require(auth.PricingWrite, controllers.UpdatePromotion)).Methods("PUT")
+	r.HandleFunc("/delete-promotion/{id}", auth.Require(auth.PricingWrite, controllers.DeletePromotion)).Methods("DELETE")
+
+	r.HandleFunc("/tax-classes", auth.Require(auth.PricingRead, controllers.GetTaxClasses)).Methods("GET")
+	r.HandleFunc("/add-tax-class", auth.Require(auth.PricingWrite, controllers.AddTaxClass)).Methods("POST")
+	r.HandleFunc("/delete-tax-class/{id}", auth.Require(auth.PricingWrite, controllers.DeleteTaxClass)).Methods("DELETE")
+
+	r.HandleFunc("/tax-rates", auth.Require(auth.PricingRead, controllers.GetTaxRates)).Methods("GET")
+	r.HandleFunc("/add-tax-rate", auth.Require(auth.PricingWrite, controllers.AddTaxRate)).Methods("POST")
+	r.HandleFunc("/update-tax-rate/{id}", auth.Require(auth.PricingWrite, controllers.UpdateTaxRate)).Methods("PUT")
+	r.HandleFunc("/delete-tax-rate/{id}", auth.Require(auth.PricingWrite, controllers.DeleteTaxRate)).Methods("DELETE")
 
 	return r
 }
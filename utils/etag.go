@@ -0,0 +1,43 @@
+package utils
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// ETag is the entity tag of a row at version
+func ETag(version uint) string {
+	return `"` + strconv.FormatUint(uint64(version), 10) + `"`
+}
+
+func SetETag(w http.ResponseWriter, version uint) {
+	w.Header().Set("ETag", ETag(version))
+}
+
+// IfMatch checks the request's If-Match header against the row's current version. It answers
+// 428 when the header is missing and 412 with the current ETag when the client edited an older
+// copy, and reports whether the handler can go on.
+func IfMatch(w http.ResponseWriter, r *http.Request, version uint) bool {
+	header := r.Header.Get("If-Match")
+	if header == "" {
+		RespondWithError(w, http.StatusPreconditionRequired, "If-Match header is required, send the ETag of the copy you edited")
+		return false
+	}
+	// If-Match compares strongly, a weak tag never matches
+	current := ETag(version)
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || tag == current {
+			return true
+		}
+	}
+	SetETag(w, version)
+	PreconditionFailed(w)
+	return false
+}
+
+// PreconditionFailed answers 412 for a row changed by somebody else since the client read it
+func PreconditionFailed(w http.ResponseWriter) {
+	RespondWithError(w, http.StatusPreconditionFailed, "The record was changed by somebody else, reload it and try again")
+}
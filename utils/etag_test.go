@@ -0,0 +1,42 @@
+package utils
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestIfMatch(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		ok     bool
+		status int
+	}{
+		{"missing", "", false, http.StatusPreconditionRequired},
+		{"current", `"3"`, true, http.StatusOK},
+		{"any", "*", true, http.StatusOK},
+		{"one of several", `"2", "3"`, true, http.StatusOK},
+		{"older copy", `"2"`, false, http.StatusPreconditionFailed},
+		{"weak tag", `W/"3"`, false, http.StatusPreconditionFailed},
+		{"unquoted", "3", false, http.StatusPreconditionFailed},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest("PUT", "/", nil)
+			if test.header != "" {
+				r.Header.Set("If-Match", test.header)
+			}
+			w := httptest.NewRecorder()
+			if ok := IfMatch(w, r, 3); ok != test.ok {
+				t.Fatalf("IfMatch() = %v, want %v", ok, test.ok)
+			}
+			if w.Code != test.status {
+				t.Errorf("status = %d, want %d", w.Code, test.status)
+			}
+			if test.status == http.StatusPreconditionFailed && w.Header().Get("ETag") != `"3"` {
+				t.Errorf("ETag = %q, want the current one", w.Header().Get("ETag"))
+			}
+		})
+	}
+}
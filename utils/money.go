@@ -0,0 +1,8 @@
+package utils
+
+import "math"
+
+// RoundMoney rounds an amount to 2 decimal places so stored totals don't carry float noise.
+func RoundMoney(amount float64) float64 {
+	return math.Round(amount*100) / 100
+}
@@ -0,0 +1,84 @@
+package utils
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+)
+
+// MergePatchContentType is the media type of JSON Merge Patch (RFC 7396) documents
+const MergePatchContentType = "application/merge-patch+json"
+
+// Optional is one member of a JSON Merge Patch document. Present is false when the member was
+// left out and the field stays as it is, Null is true when it was sent as null to clear the field.
+type Optional[T any] struct {
+	Present bool
+	Null    bool
+	Value   T
+}
+
+func (o *Optional[T]) UnmarshalJSON(data []byte) error {
+	o.Present = true
+	if string(data) == "null" {
+		o.Null = true
+		return nil
+	}
+	return json.Unmarshal(data, &o.Value)
+}
+
+// DecodeMergePatch reads a JSON Merge Patch body into patch, a pointer to a struct of Optional
+// fields named like the fields of the resource. Members patch has no field for or whose value
+// has the wrong type come back as field errors, for the handler to answer with its own.
+// It answers 415 for other media types and 400 for a body that isn't a JSON object, ok is
+// false then.
+func DecodeMergePatch(w http.ResponseWriter, r *http.Request, patch interface{}) (errs FieldErrors, ok bool) {
+	if contentType, _, _ := strings.Cut(r.Header.Get("Content-Type"), ";"); contentType != "" &&
+		contentType != MergePatchContentType && contentType != "application/json" {
+		RespondWithError(w, http.StatusUnsupportedMediaType, "PATCH expects a JSON Merge Patch document ("+MergePatchContentType+")")
+		return nil, false
+	}
+	var members map[string]json.RawMessage
+	if err := json.NewDecoder(r.Body).Decode(&members); err != nil || members == nil {
+		RespondWithError(w, http.StatusBadRequest, "Invalid input, expected a JSON object")
+		return nil, false
+	}
+
+	fields := reflect.ValueOf(patch).Elem()
+	errs = FieldErrors{}
+	for name, value := range members {
+		field, ok := fields.Type().FieldByNameFunc(func(fieldName string) bool { return strings.EqualFold(fieldName, name) })
+		if !ok {
+			errs.Add(name, "unknown", "cannot be changed")
+			continue
+		}
+		target := fields.FieldByIndex(field.Index)
+		if err := json.Unmarshal(value, target.Addr().Interface()); err != nil {
+			errs.Add(field.Name, "type", "must be a "+typeName(target.FieldByName("Value").Type()))
+			// left out of the patch, so the handler doesn't validate the value again
+			target.Set(reflect.Zero(target.Type()))
+		}
+	}
+	return errs, true
+}
+
+// typeName describes a Go type the way the JSON it decodes from looks
+func typeName(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.Bool:
+		return "boolean"
+	case reflect.String:
+		return "string"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return "whole number"
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "whole number, 0 or more"
+	case reflect.Float32, reflect.Float64:
+		return "number"
+	case reflect.Struct, reflect.Map:
+		return "JSON object"
+	case reflect.Slice, reflect.Array:
+		return "list"
+	}
+	return t.Kind().String()
+}
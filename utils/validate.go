@@ -0,0 +1,529 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// FieldError is what is wrong with one field of a request. Code is meant for programs
+// (required, min, max, minlen, maxlen, enum, pattern, type, unknown, or one a handler picks),
+// Message for people.
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// FieldErrors lists every invalid field of a request
+type FieldErrors []FieldError
+
+func (e *FieldErrors) Add(field string, code string, message string) {
+	*e = append(*e, FieldError{Field: field, Code: code, Message: message})
+}
+
+// RespondWithFieldErrors answers 400 with every invalid field, e.g.
+// {"error":"Invalid fields","fields":[{"field":"Price","code":"min","message":"must be at least 0"}]}
+func RespondWithFieldErrors(w http.ResponseWriter, errs FieldErrors) {
+	RespondWithJSON(w, http.StatusBadRequest, map[string]interface{}{"error": "Invalid fields", "fields": errs})
+}
+
+// Validator is implemented by requests with rules across fields, Validate runs after the
+// rules of the validate tags and adds to errs. Fields are named as in the struct, a nested
+// request's errors get its path in front.
+type Validator interface {
+	Validate(errs *FieldErrors)
+}
+
+// DecodeJSON reads a JSON body into v and validates it before the handler uses it. Members v
+// has no field for, values of the wrong type and fields breaking the rules of their tags all
+// come back in one 400 listing every field. ok is false when it answered.
+//
+// Rules go in the validate tag, separated by commas:
+//
+//	required          set, not blank, not zero, not empty
+//	min=N, max=N      numbers
+//	minlen=N, maxlen=N  characters of a string, items of a list
+//	enum=a|b|c        one of the values, an empty string is left to required
+//
+// and a regular expression for strings in the pattern tag, e.g. pattern:"^[A-Z]{2}$". Rules
+// other than required skip nil pointers and empty strings. Nested structs and lists of them
+// are validated too, their fields named like Lines[0].Quantity. Broken tags are found by
+// CheckRules at startup, a request type it wasn't given answers 500 for them.
+func DecodeJSON(w http.ResponseWriter, r *http.Request, v interface{}) (ok bool) {
+	return decodeJSON(w, r, v, false)
+}
+
+// DecodeOptionalJSON is DecodeJSON for requests whose body can be left out, v keeps its
+// values then
+func DecodeOptionalJSON(w http.ResponseWriter, r *http.Request, v interface{}) (ok bool) {
+	return decodeJSON(w, r, v, true)
+}
+
+func decodeJSON(w http.ResponseWriter, r *http.Request, v interface{}, optional bool) bool {
+	if err := CheckRules(v); err != nil {
+		RespondWithError(w, http.StatusInternalServerError, "The rules of this request are broken")
+		return false
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		RespondWithError(w, http.StatusBadRequest, "Invalid input")
+		return false
+	}
+	if len(bytes.TrimSpace(body)) == 0 {
+		if optional {
+			return true
+		}
+		RespondWithError(w, http.StatusBadRequest, "Invalid input, the request body is empty")
+		return false
+	}
+
+	// a first pass over the plain JSON finds every unknown member and wrong type at once,
+	// the decoder itself stops at the first
+	var document interface{}
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	if err := decoder.Decode(&document); err != nil || decoder.More() {
+		RespondWithError(w, http.StatusBadRequest, "Invalid input, the request body is not valid JSON")
+		return false
+	}
+	errs := FieldErrors{}
+	checkMembers(document, reflect.TypeOf(v).Elem(), "", &errs)
+	for _, fieldErr := range errs {
+		if fieldErr.Code == "type" {
+			RespondWithFieldErrors(w, errs)
+			return false
+		}
+	}
+
+	// unknown members are already listed, the rules of the known ones are checked too
+	decoder = json.NewDecoder(bytes.NewReader(body))
+	if len(errs) == 0 {
+		decoder.DisallowUnknownFields()
+	}
+	if err := decoder.Decode(v); err != nil {
+		var typeErr *json.UnmarshalTypeError
+		if errors.As(err, &typeErr) && typeErr.Field != "" {
+			errs.Add(typeErr.Field, "type", "must be a "+typeName(typeErr.Type))
+			RespondWithFieldErrors(w, errs)
+		} else {
+			RespondWithError(w, http.StatusBadRequest, "Invalid input")
+		}
+		return false
+	}
+
+	Validate(v, &errs)
+	if len(errs) > 0 {
+		RespondWithFieldErrors(w, errs)
+		return false
+	}
+	return true
+}
+
+// Validate checks v, a struct or a pointer to one, against the rules of its validate and
+// pattern tags and its Validate method, see DecodeJSON
+func Validate(v interface{}, errs *FieldErrors) {
+	validateValue(reflect.ValueOf(v), "", errs)
+}
+
+var unmarshalerType = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+
+// checkMembers compares the decoded JSON document with the type it is going to be decoded
+// into. Types reading their own JSON, like time.Time, are trusted to know what they accept.
+func checkMembers(document interface{}, t reflect.Type, path string, errs *FieldErrors) {
+	if document == nil {
+		return
+	}
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if reflect.PtrTo(t).Implements(unmarshalerType) {
+		return
+	}
+	wrongType := func() { errs.Add(fieldPath(path, ""), "type", "must be a "+typeName(t)) }
+
+	switch t.Kind() {
+	case reflect.Struct:
+		members, ok := document.(map[string]interface{})
+		if !ok {
+			wrongType()
+			return
+		}
+		names := make([]string, 0, len(members))
+		for name := range members {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		fields := jsonFields(t)
+		for _, name := range names {
+			field, ok := findField(fields, name)
+			if !ok {
+				errs.Add(fieldPath(path, name), "unknown", "is not a known field")
+				continue
+			}
+			checkMembers(members[name], field.Type, fieldPath(path, field.name), errs)
+		}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			if _, ok := document.(string); !ok {
+				wrongType()
+			}
+			return
+		}
+		items, ok := document.([]interface{})
+		if !ok {
+			wrongType()
+			return
+		}
+		for i, item := range items {
+			checkMembers(item, t.Elem(), path+"["+strconv.Itoa(i)+"]", errs)
+		}
+	case reflect.Map:
+		if _, ok := document.(map[string]interface{}); !ok {
+			wrongType()
+		}
+	case reflect.String:
+		if _, ok := document.(string); !ok {
+			wrongType()
+		}
+	case reflect.Bool:
+		if _, ok := document.(bool); !ok {
+			wrongType()
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if number, ok := document.(json.Number); !ok {
+			wrongType()
+		} else if _, err := strconv.ParseInt(number.String(), 10, t.Bits()); err != nil {
+			wrongType()
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if number, ok := document.(json.Number); !ok {
+			wrongType()
+		} else if _, err := strconv.ParseUint(number.String(), 10, t.Bits()); err != nil {
+			wrongType()
+		}
+	case reflect.Float32, reflect.Float64:
+		if _, ok := document.(json.Number); !ok {
+			wrongType()
+		}
+	}
+}
+
+type jsonField struct {
+	reflect.StructField
+	name string
+}
+
+// jsonFields are the fields of struct type t with the names encoding/json gives them,
+// including those of embedded structs
+func jsonFields(t reflect.Type) []jsonField {
+	var fields []jsonField
+	for _, field := range reflect.VisibleFields(t) {
+		if !field.IsExported() || field.Anonymous && field.Type.Kind() == reflect.Struct {
+			continue
+		}
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		fields = append(fields, jsonField{field, name})
+	}
+	return fields
+}
+
+// findField finds the field a JSON member goes into, like encoding/json an exact match first,
+// then regardless of case
+func findField(fields []jsonField, name string) (jsonField, bool) {
+	for _, field := range fields {
+		if field.name == name {
+			return field, true
+		}
+	}
+	for _, field := range fields {
+		if strings.EqualFold(field.name, name) {
+			return field, true
+		}
+	}
+	return jsonField{}, false
+}
+
+func fieldPath(path string, name string) string {
+	if path == "" {
+		return name
+	}
+	if name == "" {
+		return path
+	}
+	return path + "." + name
+}
+
+func validateValue(value reflect.Value, path string, errs *FieldErrors) {
+	for value.Kind() == reflect.Ptr || value.Kind() == reflect.Interface {
+		if value.IsNil() {
+			return
+		}
+		value = value.Elem()
+	}
+	switch value.Kind() {
+	case reflect.Struct:
+		t := value.Type()
+		rules, _ := rulesOf(t)
+		for _, field := range jsonFields(t) {
+			fieldValue := value.FieldByIndex(field.Index)
+			validateField(fieldValue, rules[field.Name], fieldPath(path, field.name), errs)
+			validateValue(fieldValue, fieldPath(path, field.name), errs)
+		}
+		if value.CanAddr() {
+			value = value.Addr()
+		}
+		if validator, ok := value.Interface().(Validator); ok {
+			var own FieldErrors
+			validator.Validate(&own)
+			for _, fieldErr := range own {
+				errs.Add(fieldPath(path, fieldErr.Field), fieldErr.Code, fieldErr.Message)
+			}
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < value.Len(); i++ {
+			validateValue(value.Index(i), path+"["+strconv.Itoa(i)+"]", errs)
+		}
+	}
+}
+
+// validateField applies the rules of one field's tags, the first broken rule is reported
+func validateField(value reflect.Value, rules fieldRules, path string, errs *FieldErrors) {
+	for _, rule := range rules.rules {
+		if rule.name == "required" {
+			if isBlank(value) {
+				errs.Add(path, "required", "is required")
+				return
+			}
+			continue
+		}
+		target, ok := indirect(value)
+		if !ok || target.Kind() == reflect.String && target.String() == "" {
+			return
+		}
+		if message := rule.check(target); message != "" {
+			errs.Add(path, rule.name, message)
+			return
+		}
+	}
+	if rules.pattern != nil {
+		if target, ok := indirect(value); ok && target.String() != "" && !rules.pattern.MatchString(target.String()) {
+			errs.Add(path, "pattern", "has an invalid format")
+		}
+	}
+}
+
+// indirect follows pointers to the value, ok is false for a nil pointer
+func indirect(value reflect.Value) (reflect.Value, bool) {
+	for value.Kind() == reflect.Ptr {
+		if value.IsNil() {
+			return value, false
+		}
+		value = value.Elem()
+	}
+	return value, true
+}
+
+// rule is one parsed rule of a validate tag
+type rule struct {
+	name     string
+	argument string
+	limit    float64  // of min, max, minlen and maxlen
+	allowed  []string // of enum
+}
+
+// fieldRules are the parsed validate and pattern tags of one field
+type fieldRules struct {
+	rules   []rule
+	pattern *regexp.Regexp
+}
+
+// check returns what is wrong when value breaks the rule, or ""
+func (rule rule) check(value reflect.Value) string {
+	switch rule.name {
+	case "min", "max":
+		number, _ := numberOf(value)
+		if rule.name == "min" && number < rule.limit {
+			return "must be at least " + rule.argument
+		}
+		if rule.name == "max" && number > rule.limit {
+			return "must be at most " + rule.argument
+		}
+	case "minlen", "maxlen":
+		length, unit := value.Len(), "items"
+		if value.Kind() == reflect.String {
+			length, unit = utf8.RuneCountInString(value.String()), "characters"
+		}
+		if rule.name == "minlen" && float64(length) < rule.limit {
+			return "must have at least " + rule.argument + " " + unit
+		}
+		if rule.name == "maxlen" && float64(length) > rule.limit {
+			return "must have at most " + rule.argument + " " + unit
+		}
+	case "enum":
+		for _, candidate := range rule.allowed {
+			if fmt.Sprint(value.Interface()) == candidate {
+				return ""
+			}
+		}
+		return "must be one of " + strings.Join(rule.allowed, ", ")
+	}
+	return ""
+}
+
+// parseRules reads the validate and pattern tags of field and checks they fit its type
+func parseRules(field reflect.StructField) (fieldRules, error) {
+	var parsed fieldRules
+	t := field.Type
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	for _, text := range strings.Split(field.Tag.Get("validate"), ",") {
+		name, argument, _ := strings.Cut(strings.TrimSpace(text), "=")
+		if name == "" {
+			continue
+		}
+		parsed.rules = append(parsed.rules, rule{name: name, argument: argument})
+		rule := &parsed.rules[len(parsed.rules)-1]
+		switch name {
+		case "required":
+		case "min", "max":
+			limit, err := strconv.ParseFloat(argument, 64)
+			if err != nil {
+				return parsed, fmt.Errorf("invalid %s rule", name)
+			}
+			if _, ok := numberOf(reflect.Zero(t)); !ok {
+				return parsed, fmt.Errorf("%s rule on a field that is not a number", name)
+			}
+			rule.limit = limit
+		case "minlen", "maxlen":
+			limit, err := strconv.Atoi(argument)
+			if err != nil || limit < 0 {
+				return parsed, fmt.Errorf("invalid %s rule", name)
+			}
+			switch t.Kind() {
+			case reflect.String, reflect.Slice, reflect.Array, reflect.Map:
+			default:
+				return parsed, fmt.Errorf("%s rule on a field without a length", name)
+			}
+			rule.limit = float64(limit)
+		case "enum":
+			if argument == "" {
+				return parsed, errors.New("enum rule without values")
+			}
+			rule.allowed = strings.Split(argument, "|")
+		default:
+			return parsed, fmt.Errorf("unknown validate rule %q", name)
+		}
+	}
+	if pattern := field.Tag.Get("pattern"); pattern != "" {
+		if t.Kind() != reflect.String {
+			return parsed, errors.New("pattern on a field that is not a string")
+		}
+		compiled, err := regexp.Compile(pattern)
+		if err != nil {
+			return parsed, fmt.Errorf("invalid pattern: %w", err)
+		}
+		parsed.pattern = compiled
+	}
+	return parsed, nil
+}
+
+type typeRules struct {
+	fields map[string]fieldRules
+	err    error
+}
+
+var parsedTypes sync.Map
+
+// rulesOf parses the rules of the fields of struct type t once, by field name. Fields whose
+// tags are broken are left out and the first problem is returned.
+func rulesOf(t reflect.Type) (map[string]fieldRules, error) {
+	if parsed, ok := parsedTypes.Load(t); ok {
+		return parsed.(typeRules).fields, parsed.(typeRules).err
+	}
+	parsed := typeRules{fields: map[string]fieldRules{}}
+	for _, field := range jsonFields(t) {
+		rules, err := parseRules(field.StructField)
+		if err != nil {
+			if parsed.err == nil {
+				parsed.err = fmt.Errorf("utils: %s.%s: %w", t.Name(), field.Name, err)
+			}
+			continue
+		}
+		parsed.fields[field.Name] = rules
+	}
+	parsedTypes.Store(t, parsed)
+	return parsed.fields, parsed.err
+}
+
+// CheckRules parses the validate and pattern tags of request types, nested structs and lists
+// of them included. main calls it with every request type, so a broken tag stops the program
+// at startup instead of failing requests.
+func CheckRules(requests ...interface{}) error {
+	for _, request := range requests {
+		if err := checkRules(reflect.TypeOf(request), map[reflect.Type]bool{}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func checkRules(t reflect.Type, seen map[reflect.Type]bool) error {
+	for t.Kind() == reflect.Ptr || t.Kind() == reflect.Slice || t.Kind() == reflect.Array {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct || seen[t] {
+		return nil
+	}
+	seen[t] = true
+	if _, err := rulesOf(t); err != nil {
+		return err
+	}
+	for _, field := range jsonFields(t) {
+		if err := checkRules(field.Type, seen); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func isBlank(value reflect.Value) bool {
+	switch value.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		return value.IsNil()
+	case reflect.String:
+		return strings.TrimSpace(value.String()) == ""
+	case reflect.Slice, reflect.Map, reflect.Array:
+		return value.Len() == 0
+	}
+	return value.IsZero()
+}
+
+func numberOf(value reflect.Value) (float64, bool) {
+	switch value.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(value.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(value.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return value.Float(), true
+	}
+	return 0, false
+}
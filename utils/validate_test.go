@@ -0,0 +1,182 @@
+package utils
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type testLine struct {
+	SKU      string `validate:"required"`
+	Quantity int    `validate:"min=1"`
+}
+
+func (line testLine) Validate(errs *FieldErrors) {
+	if line.SKU == "VOID" {
+		errs.Add("SKU", "reserved", "is reserved")
+	}
+}
+
+type testRequest struct {
+	Name  string   `validate:"required,maxlen=5"`
+	Code  string   `pattern:"^[A-Z]{2}$"`
+	Price *float64 `validate:"min=0,max=100"`
+	Kind  string   `validate:"enum=a|b"`
+	Tags  []string `validate:"minlen=1,maxlen=2"`
+	Lines []testLine
+	From  int
+	To    int
+}
+
+func (request testRequest) Validate(errs *FieldErrors) {
+	if request.To < request.From {
+		errs.Add("To", "after", "must not be before From")
+	}
+}
+
+// decode runs DecodeJSON on body and returns the field errors of the answer, "field:code" each
+func decode(t *testing.T, body string) (bool, int, []string) {
+	t.Helper()
+	var request testRequest
+	w := httptest.NewRecorder()
+	ok := DecodeJSON(w, httptest.NewRequest("POST", "/", strings.NewReader(body)), &request)
+	if ok {
+		return true, http.StatusOK, nil
+	}
+	var answer struct {
+		Fields []FieldError `json:"fields"`
+	}
+	json.NewDecoder(w.Body).Decode(&answer)
+	var fields []string
+	for _, field := range answer.Fields {
+		fields = append(fields, field.Field+":"+field.Code)
+	}
+	return false, w.Code, fields
+}
+
+func TestDecodeJSON(t *testing.T) {
+	tests := []struct {
+		name   string
+		body   string
+		status int
+		fields []string
+	}{
+		{"valid", `{"Name":"desk","Code":"AB","Price":10,"Kind":"a","Tags":["x"],"Lines":[{"SKU":"D-1","Quantity":2}]}`, http.StatusOK, nil},
+		{"optional fields left out", `{"Name":"desk","Tags":["x"]}`, http.StatusOK, nil},
+		{"null pointer skips its rules", `{"Name":"desk","Tags":["x"],"Price":null}`, http.StatusOK, nil},
+		{"member names ignore case", `{"name":"desk","tags":["x"]}`, http.StatusOK, nil},
+		{"required missing", `{"Tags":["x"]}`, http.StatusBadRequest, []string{"Name:required"}},
+		{"required blank", `{"Name":"  ","Tags":["x"]}`, http.StatusBadRequest, []string{"Name:required"}},
+		{"maxlen counts characters", `{"Name":"ééééé","Tags":["x"]}`, http.StatusOK, nil},
+		{"maxlen", `{"Name":"bookcase","Tags":["x"]}`, http.StatusBadRequest, []string{"Name:maxlen"}},
+		{"min", `{"Name":"desk","Tags":["x"],"Price":-1}`, http.StatusBadRequest, []string{"Price:min"}},
+		{"max", `{"Name":"desk","Tags":["x"],"Price":101}`, http.StatusBadRequest, []string{"Price:max"}},
+		{"enum", `{"Name":"desk","Tags":["x"],"Kind":"c"}`, http.StatusBadRequest, []string{"Kind:enum"}},
+		{"pattern", `{"Name":"desk","Tags":["x"],"Code":"abc"}`, http.StatusBadRequest, []string{"Code:pattern"}},
+		{"minlen of a list", `{"Name":"desk","Tags":[]}`, http.StatusBadRequest, []string{"Tags:minlen"}},
+		{"maxlen of a list", `{"Name":"desk","Tags":["x","y","z"]}`, http.StatusBadRequest, []string{"Tags:maxlen"}},
+		{"nested path", `{"Name":"desk","Tags":["x"],"Lines":[{"SKU":"D-1","Quantity":1},{"Quantity":0}]}`, http.StatusBadRequest, []string{"Lines[1].SKU:required", "Lines[1].Quantity:min"}},
+		{"nested Validate gets the path", `{"Name":"desk","Tags":["x"],"Lines":[{"SKU":"VOID","Quantity":1}]}`, http.StatusBadRequest, []string{"Lines[0].SKU:reserved"}},
+		{"Validate across fields", `{"Name":"desk","Tags":["x"],"From":5,"To":2}`, http.StatusBadRequest, []string{"To:after"}},
+		{"every broken field at once", `{"Tags":["x"],"Price":-1,"Kind":"c"}`, http.StatusBadRequest, []string{"Name:required", "Price:min", "Kind:enum"}},
+		{"unknown fields with the broken rules", `{"ID":4,"Version":2,"Tags":["x"]}`, http.StatusBadRequest, []string{"ID:unknown", "Version:unknown", "Name:required"}},
+		{"unknown nested field", `{"Name":"desk","Tags":["x"],"Lines":[{"SKU":"D-1","Quantity":1,"Price":3}]}`, http.StatusBadRequest, []string{"Lines[0].Price:unknown"}},
+		{"wrong types", `{"Name":5,"Tags":"x","Price":"ten"}`, http.StatusBadRequest, []string{"Name:type", "Price:type", "Tags:type"}},
+		{"fraction for an integer", `{"Name":"desk","Tags":["x"],"From":1.5}`, http.StatusBadRequest, []string{"From:type"}},
+		{"not an object", `[1]`, http.StatusBadRequest, []string{":type"}},
+		{"empty body", ``, http.StatusBadRequest, nil},
+		{"invalid JSON", `{"Name":`, http.StatusBadRequest, nil},
+		{"trailing data", `{"Name":"desk","Tags":["x"]} {}`, http.StatusBadRequest, nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ok, status, fields := decode(t, test.body)
+			if ok != (test.status == http.StatusOK) || status != test.status {
+				t.Fatalf("DecodeJSON() = %v with status %d, want status %d", ok, status, test.status)
+			}
+			if !reflect.DeepEqual(fields, test.fields) {
+				t.Errorf("fields = %v, want %v", fields, test.fields)
+			}
+		})
+	}
+}
+
+func TestDecodeOptionalJSON(t *testing.T) {
+	request := struct {
+		DueInDays *int `validate:"min=0"`
+	}{}
+	w := httptest.NewRecorder()
+	if !DecodeOptionalJSON(w, httptest.NewRequest("POST", "/", nil), &request) {
+		t.Fatalf("empty body answered %d", w.Code)
+	}
+	w = httptest.NewRecorder()
+	if DecodeOptionalJSON(w, httptest.NewRequest("POST", "/", strings.NewReader(`{"DueInDays":-1}`)), &request) {
+		t.Fatal("a body that was sent is not validated")
+	}
+}
+
+func TestDecodeJSONBrokenRules(t *testing.T) {
+	var request struct {
+		Quantity int `validate:"minimum=1"`
+	}
+	w := httptest.NewRecorder()
+	if DecodeJSON(w, httptest.NewRequest("POST", "/", strings.NewReader(`{"Quantity":1}`)), &request) {
+		t.Fatal("a request with a broken tag was accepted")
+	}
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("status = %d, want 500", w.Code)
+	}
+}
+
+type brokenLine struct {
+	Quantity int `validate:"maxlen=3"`
+}
+
+func TestCheckRules(t *testing.T) {
+	tests := []struct {
+		name    string
+		request interface{}
+		err     string
+	}{
+		{"valid", testRequest{}, ""},
+		{"pointer to a valid request", &testRequest{}, ""},
+		{"unknown rule", struct {
+			Name string `validate:"required,minimum=1"`
+		}{}, `unknown validate rule "minimum"`},
+		{"limit is not a number", struct {
+			Quantity int `validate:"min=one"`
+		}{}, "invalid min rule"},
+		{"min on a string", struct {
+			Name string `validate:"min=1"`
+		}{}, "min rule on a field that is not a number"},
+		{"negative length", struct {
+			Name string `validate:"maxlen=-1"`
+		}{}, "invalid maxlen rule"},
+		{"enum without values", struct {
+			Kind string `validate:"enum="`
+		}{}, "enum rule without values"},
+		{"pattern on a number", struct {
+			Quantity int `pattern:"^[0-9]+$"`
+		}{}, "pattern on a field that is not a string"},
+		{"invalid pattern", struct {
+			Code string `pattern:"[A-Z"`
+		}{}, "invalid pattern"},
+		{"broken nested list", struct {
+			Lines []brokenLine
+		}{}, "brokenLine.Quantity: maxlen rule on a field without a length"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := CheckRules(test.request)
+			switch {
+			case test.err == "" && err != nil:
+				t.Errorf("CheckRules() = %v, want nil", err)
+			case test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)):
+				t.Errorf("CheckRules() = %v, want %q", err, test.err)
+			}
+		})
+	}
+}